@@ -0,0 +1,183 @@
+package apiserver
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"net/http"
+	"time"
+)
+
+// RegisterAdminRoutes 注册管理端点
+func RegisterAdminRoutes(e *echo.Echo, manager *config.Manager) {
+	// 健康检查端点
+	e.GET("/health", func(c echo.Context) error {
+		healthy, total := jetbrains.GetBalancerStats()
+		cfg := manager.GetConfig()
+		activity := jetbrains.GetUpstreamActivity()
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"status":         "ok",
+			"healthy_tokens": healthy,
+			"total_tokens":   total,
+			"strategy":       cfg.LoadBalanceStrategy,
+			"upstream":       upstreamActivityInfo(activity),
+			"server_info": map[string]interface{}{
+				"host": cfg.ServerHost,
+				"port": cfg.ServerPort,
+			},
+		})
+	})
+
+	// 就绪检查端点：没有健康token，或上游超过readiness_max_staleness没有成功响应时返回503
+	e.GET("/readyz", func(c echo.Context) error {
+		healthy, total := jetbrains.GetBalancerStats()
+		cfg := manager.GetConfig()
+		activity := jetbrains.GetUpstreamActivity()
+
+		status, reason := http.StatusOK, ""
+		if healthy == 0 {
+			status, reason = http.StatusServiceUnavailable, "no healthy tokens"
+		} else if activity.Stale(cfg.ReadinessMaxStaleness) {
+			status, reason = http.StatusServiceUnavailable, "no successful upstream response within "+cfg.ReadinessMaxStaleness.String()
+		}
+
+		body := map[string]interface{}{
+			"ready":          status == http.StatusOK,
+			"healthy_tokens": healthy,
+			"total_tokens":   total,
+			"upstream":       upstreamActivityInfo(activity),
+		}
+		if reason != "" {
+			body["reason"] = reason
+		}
+		return c.JSON(status, body)
+	})
+
+	// 配置信息端点
+	e.GET("/config", func(c echo.Context) error {
+		discovery := config.NewConfigDiscovery(manager)
+		summary := discovery.GetConfigSummary()
+		return c.JSON(http.StatusOK, summary)
+	})
+
+	// 重载配置端点
+	e.POST("/reload", func(c echo.Context) error {
+		if err := jetbrains.ReloadConfig(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message": "Configuration reloaded successfully",
+		})
+	})
+
+	// 手动重置token错误状态端点
+	e.POST("/admin/tokens/:name/reset", func(c echo.Context) error {
+		name := c.Param("name")
+		stats, ok := jetbrains.ResetToken(name)
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "token not found: " + name,
+			})
+		}
+
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	// 轮换Bearer token端点：新token立即生效，只将bearer_token字段写入配置文件，旧token在宽限期内仍然有效
+	e.POST("/admin/bearer-token", func(c echo.Context) error {
+		var body struct {
+			BearerToken string `json:"bearer_token"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "Invalid request payload",
+			})
+		}
+
+		grace := manager.GetConfig().BearerTokenGracePeriod
+		previousValidUntil, err := manager.RotateBearerToken(body.BearerToken, grace)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		if err := manager.SaveBearerToken(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "bearer token rotated but not persisted: " + err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":              "Bearer token rotated successfully",
+			"previous_valid_until": previousValidUntil,
+		})
+	})
+
+	// 负载均衡器统计端点
+	e.GET("/stats", func(c echo.Context) error {
+		healthy, total := jetbrains.GetBalancerStats()
+		cfg := manager.GetConfig()
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"balancer": map[string]interface{}{
+				"healthy_tokens":   healthy,
+				"total_tokens":     total,
+				"duplicate_tokens": manager.GetDuplicateTokenCount(),
+				"strategy":         cfg.LoadBalanceStrategy,
+				"tokens":           jetbrains.GetTokenStats(),
+			},
+			"endpoints": jetbrains.GetEndpointStats(),
+			"config": map[string]interface{}{
+				"health_check_interval": cfg.HealthCheckInterval.String(),
+				"server_host":           cfg.ServerHost,
+				"server_port":           cfg.ServerPort,
+			},
+		})
+	})
+
+	// 指标快照端点：一次返回所有运行统计，供不使用Prometheus的自定义仪表盘读取
+	e.GET("/admin/metrics.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, metricsSnapshot(manager))
+	})
+}
+
+// metricsSnapshot 管理端点 /admin/metrics.json 返回的完整运行数据，与 /stats 使用相同的统计来源
+func metricsSnapshot(manager *config.Manager) map[string]interface{} {
+	healthy, total := jetbrains.GetBalancerStats()
+	cfg := manager.GetConfig()
+
+	return map[string]interface{}{
+		"generated_at": time.Now(),
+		"counters":     jetbrains.GetRequestCounters(),
+		"balancer": map[string]interface{}{
+			"healthy_tokens":   healthy,
+			"total_tokens":     total,
+			"duplicate_tokens": manager.GetDuplicateTokenCount(),
+			"strategy":         cfg.LoadBalanceStrategy,
+		},
+		"tokens":    jetbrains.GetTokenStats(),
+		"endpoints": jetbrains.GetEndpointStats(),
+		"upstream":  upstreamActivityInfo(jetbrains.GetUpstreamActivity()),
+	}
+}
+
+// upstreamActivityInfo 距上游最近一次成功的秒数，尚未成功过时为null
+func upstreamActivityInfo(activity jetbrains.UpstreamActivity) map[string]interface{} {
+	return map[string]interface{}{
+		"seconds_since_last_success":              secondsSince(activity.LastSuccess()),
+		"seconds_since_last_request_success":      secondsSince(activity.LastRequestSuccess),
+		"seconds_since_last_health_check_success": secondsSince(activity.LastHealthCheckSuccess),
+	}
+}
+
+// secondsSince 距t的整秒数，t为零值时返回nil
+func secondsSince(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return int64(time.Since(t).Seconds())
+}
@@ -0,0 +1,101 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"jetbrains-ai-proxy/internal/types"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// completionResult 一次非流式补全的结果，合并的请求共享同一个结果
+type completionResult struct {
+	response  openai.ChatCompletionResponse
+	tokenName string
+	err       error
+}
+
+// coalescedCall 一次进行中的上游调用，完成后关闭done
+type coalescedCall struct {
+	done   chan struct{}
+	result completionResult
+}
+
+// requestCoalescer 合并相同的在途请求：相同key的并发调用只执行一次
+type requestCoalescer struct {
+	calls map[string]*coalescedCall
+	mutex sync.Mutex
+}
+
+// newRequestCoalescer 创建请求合并器
+func newRequestCoalescer() *requestCoalescer {
+	return &requestCoalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// coalescer 全局的请求合并器
+var coalescer = newRequestCoalescer()
+
+// Do 在后台执行fn；相同key的调用进行中时等待并共享其结果，shared表示结果来自其他调用方。
+// 每个调用方只等待到自己的ctx结束，fn不随任何调用方取消，应自行限制执行时间
+func (rc *requestCoalescer) Do(ctx context.Context, key string, fn func() completionResult) (result completionResult, shared bool) {
+	rc.mutex.Lock()
+	call, shared := rc.calls[key]
+	if !shared {
+		call = &coalescedCall{done: make(chan struct{})}
+		rc.calls[key] = call
+		go func() {
+			call.result = fn()
+			rc.mutex.Lock()
+			delete(rc.calls, key)
+			rc.mutex.Unlock()
+			close(call.done)
+		}()
+	}
+	rc.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, shared
+	case <-ctx.Done():
+		return completionResult{err: ctx.Err()}, shared
+	}
+}
+
+// sharedCallContext 合并的上游调用使用的上下文：保留ctx中的值，但不随发起请求的客户端断开或超时而取消，
+// 由timeout限制执行时间（为0时不限制）
+func sharedCallContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if timeout <= 0 {
+		return context.WithCancel(detached)
+	}
+	return context.WithTimeout(detached, timeout)
+}
+
+// coalesceKey 确定性请求（非流式且显式设置temperature为0）的合并键，由转换后的上游请求、
+// 原始请求和service_tier计算；其他请求不合并
+func coalesceKey(req openai.ChatCompletionRequest, present map[string]bool, tier string, jetbrainsReq *types.JetbrainsRequest) (string, bool) {
+	if req.Stream || !present["temperature"] || req.Temperature != 0 {
+		return "", false
+	}
+	upstream, err := json.Marshal(jetbrainsReq)
+	if err != nil {
+		return "", false
+	}
+	original, err := json.Marshal(req)
+	if err != nil {
+		return "", false
+	}
+
+	hash := sha256.New()
+	hash.Write(upstream)
+	hash.Write([]byte{0})
+	hash.Write(original)
+	hash.Write([]byte{0})
+	hash.Write([]byte(tier))
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
@@ -0,0 +1,151 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"jetbrains-ai-proxy/internal/middleware"
+	"jetbrains-ai-proxy/internal/types"
+)
+
+// gatedUpstream 返回在gate关闭前阻塞的模拟上游，便于让并发请求同时处于进行中
+func gatedUpstream(gate chan struct{}) *jetbrains.MockUpstreamClient {
+	return jetbrains.NewMockUpstreamClient(func(*types.JetbrainsRequest) (int, string) {
+		<-gate
+		return http.StatusOK, jetbrains.BuildMockSSEStream("shared answer")
+	})
+}
+
+// fireConcurrently 并发发送n个相同的请求，等第一个请求到达上游后再放行
+func fireConcurrently(t *testing.T, body string, n int, coalesce bool) ([]*httptest.ResponseRecorder, *jetbrains.MockUpstreamClient) {
+	t.Helper()
+
+	withConfig(t, func(cfg *config.Config) {
+		cfg.CoalesceRequests = coalesce
+	})
+	gate := make(chan struct{})
+	mock := gatedUpstream(gate)
+	e := setupTestServer(t, mock)
+
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = doChatRequest(e, body)
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(mock.Requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// 留出时间让其余请求到达合并点
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+	return recs, mock
+}
+
+func TestIdenticalDeterministicRequestsAreCoalesced(t *testing.T) {
+	recs, mock := fireConcurrently(t, `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`, 5, true)
+
+	if got := len(mock.Requests()); got != 1 {
+		t.Fatalf("Expected exactly one upstream call, got %d", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d: %s", i, rec.Code, rec.Body.String())
+		}
+		if rec.Body.String() != recs[0].Body.String() {
+			t.Errorf("Request %d: expected the shared response, got %s", i, rec.Body.String())
+		}
+	}
+}
+
+func TestNonDeterministicRequestsAreNotCoalesced(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		coalesce bool
+	}{
+		{"disabled", `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`, false},
+		{"temperature omitted", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, true},
+		{"temperature nonzero", `{"model":"gpt-4o","temperature":0.7,"messages":[{"role":"user","content":"hi"}]}`, true},
+		{"streaming", `{"model":"gpt-4o","stream":true,"temperature":0,"messages":[{"role":"user","content":"hi"}]}`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recs, mock := fireConcurrently(t, tc.body, 3, tc.coalesce)
+
+			if got := len(mock.Requests()); got != 3 {
+				t.Errorf("Expected one upstream call per request, got %d", got)
+			}
+			for i, rec := range recs {
+				if rec.Code != http.StatusOK {
+					t.Errorf("Request %d: expected 200, got %d: %s", i, rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescedFollowerSurvivesLeaderTimeout(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.CoalesceRequests = true
+	})
+	gate := make(chan struct{})
+	mock := gatedUpstream(gate)
+	e := setupTestServer(t, mock)
+	body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+
+	send := func(timeout string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testBearerToken)
+		if timeout != "" {
+			req.Header.Set(middleware.RequestTimeoutHeader, timeout)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 首个请求发起共享的上游调用后超时，等待同一结果的请求仍然成功
+	var leader, follower *httptest.ResponseRecorder
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		leader = send("50ms")
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(mock.Requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		follower = send("")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	if leader.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected leader to time out with 504, got %d: %s", leader.Code, leader.Body.String())
+	}
+	if follower.Code != http.StatusOK || !strings.Contains(follower.Body.String(), "shared answer") {
+		t.Errorf("Expected follower to receive the shared answer, got %d: %s", follower.Code, follower.Body.String())
+	}
+	if got := len(mock.Requests()); got != 1 {
+		t.Errorf("Expected exactly one upstream call, got %d", got)
+	}
+}
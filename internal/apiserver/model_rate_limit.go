@@ -0,0 +1,99 @@
+package apiserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket 令牌桶：容量为每分钟请求数，按每分钟请求数/60的速度匀速补充
+type tokenBucket struct {
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+// takeAt 尝试取出一个令牌，不足时返回还需等待的时间
+func (b *tokenBucket) takeAt(now time.Time) (bool, time.Duration) {
+	rate := float64(b.perMinute) / 60
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if capacity := float64(b.perMinute); b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimitStatus 令牌桶的当前状态，用于X-RateLimit-*响应头
+type rateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// statusAt 返回补充后的剩余请求数和令牌桶重新充满的时间，不消耗令牌
+func (b *tokenBucket) statusAt(now time.Time) rateLimitStatus {
+	rate := float64(b.perMinute) / 60
+	capacity := float64(b.perMinute)
+	tokens := math.Min(b.tokens+now.Sub(b.last).Seconds()*rate, capacity)
+	return rateLimitStatus{
+		Limit:     b.perMinute,
+		Remaining: int(tokens),
+		Reset:     now.Add(time.Duration((capacity - tokens) / rate * float64(time.Second))),
+	}
+}
+
+// modelRateLimiter 按实际使用的模型分别限流，所有客户端共享同一个令牌桶
+type modelRateLimiter struct {
+	buckets map[string]*tokenBucket
+	mutex   sync.Mutex
+}
+
+// newModelRateLimiter 创建按模型限流器
+func newModelRateLimiter() *modelRateLimiter {
+	return &modelRateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// modelLimiter 全局的按模型限流器
+var modelLimiter = newModelRateLimiter()
+
+// Allow 检查模型是否还有配额，limits中未配置的模型不限流；超限时返回建议的重试等待时间。
+// 同时返回取令牌后的令牌桶状态，未限流的模型状态为nil
+func (l *modelRateLimiter) Allow(model string, limits map[string]int) (bool, time.Duration, *rateLimitStatus) {
+	return l.allowAt(model, limits, time.Now())
+}
+
+func (l *modelRateLimiter) allowAt(model string, limits map[string]int, now time.Time) (bool, time.Duration, *rateLimitStatus) {
+	perMinute := limits[model]
+	if perMinute <= 0 {
+		return true, 0, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// 首次使用或重载配置后限额变化时重建令牌桶
+	bucket, exists := l.buckets[model]
+	if !exists || bucket.perMinute != perMinute {
+		bucket = &tokenBucket{perMinute: perMinute, tokens: float64(perMinute), last: now}
+		l.buckets[model] = bucket
+	}
+	// 在同一把锁内读取状态，避免并发请求在取令牌和读取状态之间改变剩余配额
+	allowed, retryAfter := bucket.takeAt(now)
+	status := bucket.statusAt(now)
+	return allowed, retryAfter, &status
+}
+
+// setRateLimitHeaders 设置X-RateLimit-*响应头，Reset为令牌桶重新充满的Unix时间（秒）
+func setRateLimitHeaders(header http.Header, status rateLimitStatus) {
+	header.Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(status.Reset.UnixNano())/float64(time.Second))), 10))
+}
@@ -0,0 +1,170 @@
+package apiserver
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestModelRateLimiterRefill(t *testing.T) {
+	limiter := newModelRateLimiter()
+	limits := map[string]int{"o1": 60}
+	now := time.Now()
+
+	// 初始容量为每分钟限额
+	for i := 0; i < 60; i++ {
+		if allowed, _, _ := limiter.allowAt("o1", limits, now); !allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+	allowed, retryAfter, _ := limiter.allowAt("o1", limits, now)
+	if allowed {
+		t.Fatal("Expected request over capacity to be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected retry after within 1s, got %v", retryAfter)
+	}
+
+	// 每秒补充一个令牌
+	if allowed, _, _ := limiter.allowAt("o1", limits, now.Add(time.Second)); !allowed {
+		t.Error("Expected request to be allowed after refill")
+	}
+	if allowed, _, _ := limiter.allowAt("o1", limits, now.Add(time.Second)); allowed {
+		t.Error("Expected only one token to be refilled")
+	}
+
+	// 未配置的模型不限流
+	if allowed, _, _ := limiter.allowAt("gpt-4o", limits, now); !allowed {
+		t.Error("Expected unlimited model to be allowed")
+	}
+}
+
+func TestModelRateLimiterRebuildsOnLimitChange(t *testing.T) {
+	limiter := newModelRateLimiter()
+	now := time.Now()
+
+	limiter.allowAt("o1", map[string]int{"o1": 1}, now)
+	if allowed, _, _ := limiter.allowAt("o1", map[string]int{"o1": 1}, now); allowed {
+		t.Fatal("Expected second request to be rejected")
+	}
+	// 重载配置提高限额后立即生效
+	if allowed, _, _ := limiter.allowAt("o1", map[string]int{"o1": 5}, now); !allowed {
+		t.Error("Expected request to be allowed after limit change")
+	}
+}
+
+func TestModelRateLimitReturns429(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ModelRateLimits = map[string]int{"o1": 2}
+	})
+	modelLimiter = newModelRateLimiter()
+	t.Cleanup(func() {
+		modelLimiter = newModelRateLimiter()
+	})
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	for i := 0; i < 2; i++ {
+		if rec := doChatRequest(e, `{"model":"o1","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to succeed, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := doChatRequest(e, `{"model":"o1","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+
+	// 其他模型不受影响
+	if rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected other model to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestModelRateLimitSkipsInvalidRequests(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ModelRateLimits = map[string]int{"o1": 1}
+	})
+	modelLimiter = newModelRateLimiter()
+	t.Cleanup(func() {
+		modelLimiter = newModelRateLimiter()
+	})
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	// 校验失败的请求返回400且不消耗限流配额
+	for _, body := range []string{
+		`{"model":"o1","reasoning_effort":"extreme","messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"o1","logprobs":true,"messages":[{"role":"user","content":"hi"}]}`,
+	} {
+		if rec := doChatRequest(e, body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := doChatRequest(e, `{"model":"o1","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected valid request to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestModelRateLimiterStatus(t *testing.T) {
+	limiter := newModelRateLimiter()
+	limits := map[string]int{"o1": 60}
+	now := time.Now()
+
+	// 返回取令牌后的状态
+	if _, _, status := limiter.allowAt("o1", limits, now); status == nil || status.Limit != 60 || status.Remaining != 59 {
+		t.Errorf("Expected 59 of 60 remaining after first use, got %+v", status)
+	}
+	limiter.allowAt("o1", limits, now)
+	_, _, status := limiter.allowAt("o1", limits, now)
+	if status.Remaining != 57 {
+		t.Errorf("Expected 57 of 60 remaining, got %+v", status)
+	}
+	// 每秒补充一个令牌，3秒后重新充满
+	if !status.Reset.Equal(now.Add(3 * time.Second)) {
+		t.Errorf("Expected reset in 3s, got %v", status.Reset.Sub(now))
+	}
+	if _, _, status := limiter.allowAt("o1", limits, now.Add(time.Minute)); status.Remaining != 59 || !status.Reset.Equal(now.Add(time.Minute+time.Second)) {
+		t.Errorf("Expected the bucket to be refilled after reset, got %+v", status)
+	}
+
+	// 未配置的模型不报告
+	if _, _, status := limiter.allowAt("gpt-4o", limits, now); status != nil {
+		t.Error("Expected no status for unlimited model")
+	}
+}
+
+func TestModelRateLimitHeaders(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ModelRateLimits = map[string]int{"o1": 3}
+	})
+	modelLimiter = newModelRateLimiter()
+	t.Cleanup(func() {
+		modelLimiter = newModelRateLimiter()
+	})
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	for _, expected := range []string{"2", "1", "0", "0"} {
+		rec := doChatRequest(e, `{"model":"o1","messages":[{"role":"user","content":"hi"}]}`)
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("Expected limit 3, got %q", got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != expected {
+			t.Errorf("Expected %s remaining, got %q (status %d)", expected, got, rec.Code)
+		}
+		reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < time.Now().Unix() || reset > time.Now().Add(time.Minute).Unix()+1 {
+			t.Errorf("Expected reset within a minute, got %q", rec.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	// 未限流的模型不带限流响应头
+	if rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Error("Expected no rate limit headers for unlimited model")
+	}
+}
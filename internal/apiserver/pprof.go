@@ -0,0 +1,25 @@
+package apiserver
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/pprof"
+)
+
+// RegisterPprofRoutes 注册 /debug/pprof 调试端点，未启用时不注册任何路由
+func RegisterPprofRoutes(e *echo.Echo, enabled bool) {
+	if !enabled {
+		return
+	}
+
+	g := e.Group("/debug/pprof")
+	g.GET("", echo.WrapHandler(http.RedirectHandler("/debug/pprof/", http.StatusMovedPermanently)))
+	g.GET("/", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	g.GET("/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	g.GET("/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	g.GET("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	g.POST("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	g.GET("/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+	// heap、goroutine、block 等命名profile由 pprof.Index 根据路径分发
+	g.GET("/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+}
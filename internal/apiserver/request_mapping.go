@@ -0,0 +1,148 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// remapRequestBody 按配置的字段映射重命名请求体中的顶层字段（例如 input -> messages），在绑定前执行
+func remapRequestBody(r *http.Request, mapping map[string]string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %v", err)
+	}
+	r.Body.Close()
+
+	remapped, err := applyFieldMapping(body, mapping)
+	if err != nil {
+		return err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(remapped))
+	r.ContentLength = int64(len(remapped))
+	return nil
+}
+
+// applyFieldMapping 重命名JSON对象的顶层字段，目标字段已存在时保留客户端原值
+func applyFieldMapping(body []byte, mapping map[string]string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("request body must be a JSON object: %v", err)
+	}
+
+	changed := false
+	for from, to := range mapping {
+		value, ok := fields[from]
+		if !ok || from == to {
+			continue
+		}
+		if _, exists := fields[to]; !exists {
+			fields[to] = value
+		}
+		delete(fields, from)
+		changed = true
+	}
+
+	if !changed {
+		return body, nil
+	}
+	return json.Marshal(fields)
+}
+
+// requestFieldsKey echo.Context中缓存已解析请求体字段的key
+const requestFieldsKey = "request_fields"
+
+// requestFields 请求体及其顶层字段（原始JSON值），解析一次后供中间件和处理函数的各项检查复用
+type requestFields struct {
+	body   []byte
+	fields map[string]json.RawMessage
+}
+
+// readRequestFields 读取并解析请求体的顶层字段，并恢复请求体
+func readRequestFields(r *http.Request) (*requestFields, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("request body must be a JSON object: %v", err)
+	}
+	return &requestFields{body: body, fields: fields}, nil
+}
+
+// contextRequestFields 返回c中缓存的请求体字段，尚未解析时读取请求体并缓存；请求体被改写后需清除缓存
+func contextRequestFields(c echo.Context) (*requestFields, error) {
+	if fields, ok := c.Get(requestFieldsKey).(*requestFields); ok {
+		return fields, nil
+	}
+	fields, err := readRequestFields(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	c.Set(requestFieldsKey, fields)
+	return fields, nil
+}
+
+// present 返回请求体中显式设置（不为null）的字段，用于区分省略和零值
+func (f *requestFields) present(names ...string) map[string]bool {
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		value, ok := f.fields[name]
+		present[name] = ok && string(value) != "null"
+	}
+	return present
+}
+
+// extraBody 返回客户端通过extra_body传入的额外字段，未设置时返回nil
+func (f *requestFields) extraBody() (map[string]json.RawMessage, error) {
+	value, ok := f.fields["extra_body"]
+	if !ok || string(value) == "null" {
+		return nil, nil
+	}
+
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(value, &extra); err != nil {
+		return nil, fmt.Errorf("extra_body must be a JSON object")
+	}
+	return extra, nil
+}
+
+// serviceTier 返回请求体中的service_tier（go-openai不支持该字段），未设置时返回空字符串
+func (f *requestFields) serviceTier() (string, error) {
+	value, ok := f.fields["service_tier"]
+	if !ok || string(value) == "null" {
+		return "", nil
+	}
+
+	var tier string
+	if err := json.Unmarshal(value, &tier); err != nil {
+		return "", fmt.Errorf("service_tier must be a string")
+	}
+	return tier, nil
+}
+
+// modelAndStream 返回请求体中的model和stream（省略stream时使用defaultStream），类型不符时返回false，由处理函数返回错误
+func (f *requestFields) modelAndStream(defaultStream bool) (string, bool, bool) {
+	var model string
+	if value, ok := f.fields["model"]; ok {
+		if err := json.Unmarshal(value, &model); err != nil {
+			return "", false, false
+		}
+	}
+	stream := defaultStream
+	if value, ok := f.fields["stream"]; ok && string(value) != "null" {
+		if err := json.Unmarshal(value, &stream); err != nil {
+			return "", false, false
+		}
+	}
+	return model, stream, true
+}
@@ -0,0 +1,161 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestApplyFieldMapping(t *testing.T) {
+	mapping := map[string]string{"input": "messages", "engine": "model"}
+
+	body, err := applyFieldMapping([]byte(`{"engine":"gpt-4o","input":[{"role":"user","content":"hi"}]}`), mapping)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatalf("Remapped body is not valid JSON: %v", err)
+	}
+	if _, ok := fields["messages"]; !ok {
+		t.Errorf("Expected messages field after remap, got %s", body)
+	}
+	if _, ok := fields["input"]; ok {
+		t.Errorf("Expected input field to be removed, got %s", body)
+	}
+	if string(fields["model"]) != `"gpt-4o"` {
+		t.Errorf("Expected model gpt-4o, got %s", fields["model"])
+	}
+}
+
+func TestApplyFieldMappingKeepsExistingTarget(t *testing.T) {
+	body, err := applyFieldMapping([]byte(`{"model":"o1","engine":"gpt-4o"}`), map[string]string{"engine": "model"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+	if string(fields["model"]) != `"o1"` {
+		t.Errorf("Expected existing model to be kept, got %s", fields["model"])
+	}
+}
+
+func TestApplyFieldMappingRejectsNonObject(t *testing.T) {
+	if _, err := applyFieldMapping([]byte(`[1,2,3]`), map[string]string{"input": "messages"}); err == nil {
+		t.Error("Expected error for non-object body")
+	}
+}
+
+func TestRemappedBodyProducesValidRequest(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestFieldMapping = map[string]string{"input": "messages"}
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","input":[{"role":"user","content":"legacy client"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 upstream request, got %d", len(requests))
+	}
+	messages := requests[0].Body.Chat.MessageField
+	if len(messages) != 1 || messages[0].Content != "legacy client" {
+		t.Errorf("Expected remapped message to reach upstream, got %+v", messages)
+	}
+}
+
+func TestExtraBodyMergedIntoUpstreamRequest(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ExtraBodyMode = types.ExtraBodyLast
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],
+		"extra_body":{"parameters":{"temperature":0.2},"profile":"hijacked"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	data, err := json.Marshal(mock.Requests()[0].Body)
+	if err != nil {
+		t.Fatalf("Failed to marshal upstream request: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	if string(fields["parameters"]) != `{"temperature":0.2}` {
+		t.Errorf("Expected extra field to reach upstream, got %s", data)
+	}
+	if string(fields["profile"]) != `"openai-gpt-4o"` {
+		t.Errorf("Expected profile to be kept, got %s", fields["profile"])
+	}
+}
+
+func TestExtraBodyMustBeObject(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ExtraBodyMode = types.ExtraBodyFirst
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"extra_body":[1,2]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "extra_body") {
+		t.Errorf("Expected 400 mentioning extra_body, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestExtraBodyIgnoredByDefault(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"extra_body":{"parameters":{}}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if extra := mock.Requests()[0].Body.ExtraFields; len(extra) != 0 {
+		t.Errorf("Expected no extra fields when disabled, got %v", extra)
+	}
+}
+
+func TestRequestFieldsParsedOnce(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"o1","stream":null,"max_tokens":0,"service_tier":"flex","extra_body":{"top_k":5}}`))
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	fields, err := contextRequestFields(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cached, _ := contextRequestFields(c); cached != fields {
+		t.Error("Expected parsed fields to be cached in the context")
+	}
+
+	if present := fields.present("stream", "max_tokens", "temperature"); present["stream"] || !present["max_tokens"] || present["temperature"] {
+		t.Errorf("Unexpected present fields: %v", present)
+	}
+	if model, stream, ok := fields.modelAndStream(true); !ok || model != "o1" || !stream {
+		t.Errorf("Expected model o1 with default stream, got %q %v %v", model, stream, ok)
+	}
+	if tier, err := fields.serviceTier(); err != nil || tier != "flex" {
+		t.Errorf("Expected service tier flex, got %q, %v", tier, err)
+	}
+	if extra, err := fields.extraBody(); err != nil || string(extra["top_k"]) != "5" {
+		t.Errorf("Expected extra_body fields, got %v, %v", extra, err)
+	}
+}
@@ -1,83 +1,405 @@
 package apiserver
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
 	"jetbrains-ai-proxy/internal/jetbrains"
 	"jetbrains-ai-proxy/internal/middleware"
 	"jetbrains-ai-proxy/internal/types"
 	"jetbrains-ai-proxy/internal/utils"
+	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/sashabaranov/go-openai"
 )
 
 func RegisterRoutes(e *echo.Echo) {
+	e.Use(middleware.RequiredHeaders())
 	e.Use(middleware.BearerAuth())
-	e.POST("/v1/chat/completions", handleChatCompletion)
+	// 认证之后再检查，未认证的请求无法区分端点是否被禁用
+	e.Use(middleware.DisabledEndpoints())
+	e.POST("/v1/chat/completions", handleChatCompletion, middleware.JSONContentType(), idleTimeoutModels(), middleware.RequestTimeout())
 	e.GET("/v1/models", handleListModels)
 }
 
+// idleTimeoutModels 延迟较长的模型的非流式请求不受request_timeout限制：上游响应逐段读取，
+// 由stream_idle_timeout在每次读到数据时重置计时；客户端通过请求头指定超时时仍按请求头处理
+func idleTimeoutModels() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cfg := config.GetGlobalConfig().GetConfig()
+			if len(cfg.IdleTimeoutModels) == 0 || c.Request().Header.Get(middleware.RequestTimeoutHeader) != "" {
+				return next(c)
+			}
+			fields, err := contextRequestFields(c)
+			if err != nil {
+				return next(c)
+			}
+			model, stream, ok := fields.modelAndStream(cfg.DefaultStream)
+			if !ok || stream {
+				return next(c)
+			}
+			if served, err := types.ResolveModelName(model, cfg.ModelAliases); err == nil && slices.Contains(cfg.IdleTimeoutModels, served) {
+				c.Set(middleware.SkipRequestTimeoutKey, true)
+			}
+			return next(c)
+		}
+	}
+}
+
 func handleChatCompletion(c echo.Context) error {
 	var req openai.ChatCompletionRequest
+	cfg := config.GetGlobalConfig().GetConfig()
+	markDegraded(c, cfg.DegradedThreshold)
+
+	// 兼容非标准客户端：解析前重命名请求体字段，中间件按原始请求体解析的结果随之失效
+	if len(cfg.RequestFieldMapping) > 0 {
+		if err := remapRequestBody(c.Request(), cfg.RequestFieldMapping); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "Invalid request payload",
+			})
+		}
+		c.Set(requestFieldsKey, nil)
+	}
 
-	if err := c.Bind(&req); err != nil {
+	// 请求体的顶层字段只解析一次，供以下各项检查复用
+	fields, err := contextRequestFields(c)
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"error": "Invalid request payload",
 		})
 	}
 
-	_, err := types.GetModelByName(req.Model)
+	// 客户端通过extra_body传入的额外字段合并到上游请求中，便于试用新的上游参数
+	var extraBody map[string]json.RawMessage
+	if cfg.ExtraBodyMode == types.ExtraBodyFirst || cfg.ExtraBodyMode == types.ExtraBodyLast {
+		extra, err := fields.extraBody()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		extraBody = extra
+	}
+
+	// 绑定后无法区分省略和零值，需检查原始请求体：省略stream时使用配置的默认值，显式设置的max_tokens为0时拒绝
+	present := fields.present("stream", "max_tokens", "max_completion_tokens", "temperature")
+
+	tier, err := fields.serviceTier()
 	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewOpenAIParamErrorResponse(types.ErrorTypeInvalidRequest,
+			"invalid_request_body", "service_tier", err.Error()))
+	}
+
+	// Content-Type已由JSONContentType检查，直接按JSON解码：echo的Bind只接受区分大小写的application/json前缀，
+	// 会拒绝检查允许的Application/JSON、+json类型和缺失的Content-Type
+	if err := json.Unmarshal(fields.body, &req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": fmt.Sprintf("Model '%s' not supported", req.Model),
+			"error": "Invalid request payload",
 		})
 	}
+	if cfg.DefaultStream && !present["stream"] {
+		req.Stream = true
+	}
+	// 校验必需字段，错误信息指明出错的字段
+	var validationErr *types.ValidationError
+	if err := types.ValidateChatRequest(req, present); errors.As(err, &validationErr) {
+		return c.JSON(http.StatusBadRequest, types.NewOpenAIParamErrorResponse(types.ErrorTypeInvalidRequest,
+			"invalid_request_body", validationErr.Param, err.Error()))
+	}
+	if !req.Stream && cfg.DisableNonStreaming {
+		return c.JSON(http.StatusNotFound, middleware.Localizer(c).ErrorResponse(types.ErrorTypeInvalidRequest,
+			"non_streaming_disabled", "Non-streaming completions are disabled, set stream to true"))
+	}
 
-	if len(req.Messages) == 0 {
+	servedModel, err := types.ResolveModelName(req.Model, cfg.ModelAliases)
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": "No messages found",
+			"error": middleware.Localizer(c).Message("model_not_found", "Model '%s' not supported", req.Model),
 		})
 	}
 
-	jetbrainsReq, err := types.ChatGPTToJetbrainsAI(req)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+	// 请求按实际使用的模型转换，响应默认报告实际使用的模型
+	requestedModel := req.Model
+	req.Model = servedModel
+	respReq := req
+	if cfg.EchoRequestedModel {
+		respReq.Model = requestedModel
+	}
+
+	if err := types.ValidateReasoningEffort(req.ReasoningEffort); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
 
-	stream, err := jetbrains.SendJetbrainsRequest(c.Request().Context(), jetbrainsReq)
+	// 上游不返回logprobs，明确拒绝而不是静默忽略
+	if err := types.CheckLogprobs(req, cfg.LogprobsMode); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	// 多条系统消息按配置合并或只保留一条，使上游行为可预期
+	req.Messages = types.ApplySystemMessageMode(req.Messages, cfg.SystemMessageMode)
+
+	// 长对话滑动窗口：保留系统消息和预算内最近的消息，在长度限制检查之前执行
+	if cfg.HistoryWindowTokens > 0 {
+		before := len(req.Messages)
+		req.Messages = types.TruncateConversation(req.Messages, 0, cfg.HistoryWindowTokens)
+		if dropped := before - len(req.Messages); dropped > 0 {
+			log.Printf("History window: dropped %d of %d messages to fit %d tokens", dropped, before, cfg.HistoryWindowTokens)
+		}
+	}
+
+	// 对话长度限制：拒绝或截断最早的非系统消息
+	promptTokens := -1
+	if cfg.MaxMessages > 0 || cfg.MaxPromptTokens > 0 {
+		if cfg.ConversationLimitMode == types.ConversationLimitTruncate {
+			req.Messages = types.TruncateConversation(req.Messages, cfg.MaxMessages, cfg.MaxPromptTokens)
+		} else {
+			if err := types.CheckConversationLimits(req.Messages, cfg.MaxMessages, 0); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			// 按token数限制prompt，在消耗配额前快速失败；统计结果复用于用量估算
+			tokens, err := types.CheckPromptTokens(req.Messages, cfg.MaxPromptTokens)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, types.NewOpenAIErrorResponse(types.ErrorTypeInvalidRequest,
+					"context_length_exceeded", err.Error()))
+			}
+			promptTokens = tokens
+		}
+	}
+
+	paramFilter := types.ParamFilter{Allow: cfg.ForwardParams, Deny: cfg.DenyParams, Mode: cfg.ParamFilterMode}
+	jetbrainsReq, err := types.ChatGPTToJetbrainsAI(req, cfg.PenaltyMode, cfg.SystemAsUserModels, paramFilter)
+	if errors.Is(err, types.ErrUnsupportedParameter) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
-	defer stream.RawBody().Close()
+	jetbrainsReq.Prompt = cfg.PromptFor(req.Model)
+	if jetbrainsReq.ExtraFields, err = paramFilter.FilterExtraFields(extraBody); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	jetbrainsReq.ExtraBodyMode = cfg.ExtraBodyMode
+
+	// 按实际使用的模型限流，保护消耗配额较快的模型；所有校验通过后才消耗限流配额，无效请求不占用配额；限流的模型在响应头中报告剩余配额，便于客户端自行控制速率
+	allowed, retryAfter, status := modelLimiter.Allow(servedModel, cfg.ModelRateLimits)
+	if status != nil {
+		setRateLimitHeaders(c.Response().Header(), *status)
+	}
+	if !allowed {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return c.JSON(http.StatusTooManyRequests, middleware.Localizer(c).ErrorResponse(types.ErrorTypeRateLimit,
+			"model_rate_limit_exceeded", "Rate limit exceeded for model '%s', please retry later", servedModel))
+	}
+
+	// 以user字段作为会话键，一致性哈希策略据此固定选择token
+	ctx := jetbrains.WithSessionKey(c.Request().Context(), req.User)
+	ctx = jetbrains.WithPromptTokens(ctx, promptTokens)
+	// service_tier为flex时优先使用低优先级（溢出）token，未启用优先级分层时忽略
+	if tier == string(balancer.ServiceTierFlex) {
+		ctx = jetbrains.WithServiceTier(ctx, balancer.ServiceTierFlex)
+	}
 
-	// 根据请求的 stream 参数决定使用哪种处理方式
+	// 末尾的assistant消息作为预填充：要求模型接着续写，echo模式下响应以预填充内容开头
+	if prefill := types.TrailingPrefill(req.Messages); prefill != "" {
+		switch cfg.AssistantPrefill {
+		case types.PrefillContinue:
+			types.ApplyPrefill(jetbrainsReq)
+		case types.PrefillEcho:
+			types.ApplyPrefill(jetbrainsReq)
+			ctx = jetbrains.WithResponsePrefix(ctx, prefill)
+		}
+	}
+
+	// 相同的确定性非流式请求并发到达时只调用一次上游，共享结果以节省配额；
+	// 共享的调用不随首个请求断开或超时而取消，由request_timeout限制
+	if cfg.CoalesceRequests {
+		if key, ok := coalesceKey(respReq, present, tier, jetbrainsReq); ok {
+			result, shared := coalescer.Do(ctx, key, func() completionResult {
+				sharedCtx, cancel := sharedCallContext(ctx, cfg.RequestTimeout)
+				defer cancel()
+				return completeNonStreaming(sharedCtx, respReq, jetbrainsReq)
+			})
+			if shared {
+				log.Printf("Coalesced identical in-flight request for model %s", servedModel)
+			}
+			return writeCompletion(c, cfg, jetbrainsReq, result)
+		}
+	}
+
+	stream, err := jetbrains.SendJetbrainsRequest(ctx, jetbrainsReq)
+	if err != nil {
+		return upstreamError(c, cfg, err)
+	}
+	defer stream.Body.Close()
+	ctx = jetbrains.WithResponseToken(ctx, stream)
+
+	// 根据请求的 stream 参数决定使用哪种处理方式；响应无法逐个刷新时可按配置降级为非流式响应，
+	// 无法解析SSE的客户端也可以通过请求头要求缓冲为非流式响应
 	fingerprint := utils.RandStringUsingMathRand(10)
-	if req.Stream {
+	streaming := req.Stream
+	if streaming && bufferStreamRequested(c.Request()) {
+		c.Response().Header().Set(jetbrains.HeaderStreamDegraded, "buffered")
+		streaming = false
+	}
+	if streaming && cfg.NonFlushingStreamMode == jetbrains.NonFlushingBuffer && !jetbrains.CanFlush(c.Response().Writer) {
+		log.Printf("Response writer cannot flush, serving streaming request as a buffered response")
+		c.Response().Header().Set(jetbrains.HeaderStreamDegraded, "buffered")
+		streaming = false
+	}
+	if streaming {
 		// 流式处理
-		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
-		c.Response().Header().Set("Cache-Control", "no-cache")
-		c.Response().Header().Set("Transfer-Encoding", "chunked")
+		setStreamHeaders(c.Response().Header(), c.Request())
 		c.Response().WriteHeader(http.StatusOK)
 
-		return jetbrains.StreamJetbrainsAISSEToClient(c.Request().Context(), req, c.Response().Writer, stream.RawBody(), fingerprint)
+		return jetbrains.StreamJetbrainsAISSEToClient(ctx, respReq, c.Response().Writer, stream.Body, fingerprint)
 	} else {
 		// 非流式处理
-		response, err := jetbrains.ResponseJetbrainsAIToClient(c.Request().Context(), req, stream.RawBody(), fingerprint)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-				"error": err.Error(),
-			})
-		}
-		return c.JSON(http.StatusOK, response)
+		response, err := jetbrains.ResponseJetbrainsAIToClient(ctx, respReq, stream.Body, fingerprint)
+		return writeCompletion(c, cfg, jetbrainsReq, completionResult{
+			response:  response,
+			tokenName: jetbrains.ResponseTokenName(stream),
+			err:       err,
+		})
 	}
 }
 
+// setStreamHeaders 设置SSE响应头；HTTP/2按帧传输，不允许Transfer-Encoding头，只在HTTP/1.x下声明分块传输，
+// 两种协议都通过http.Flusher逐个刷新事件
+func setStreamHeaders(header http.Header, r *http.Request) {
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	if r.ProtoMajor < 2 {
+		header.Set("Transfer-Encoding", "chunked")
+	}
+	header.Set("Trailer", jetbrains.UsageTrailer)
+}
+
+// completeNonStreaming 发送上游请求并读取完整的非流式响应
+func completeNonStreaming(ctx context.Context, respReq openai.ChatCompletionRequest, jetbrainsReq *types.JetbrainsRequest) completionResult {
+	stream, err := jetbrains.SendJetbrainsRequest(ctx, jetbrainsReq)
+	if err != nil {
+		return completionResult{err: err}
+	}
+	defer stream.Body.Close()
+
+	fingerprint := utils.RandStringUsingMathRand(10)
+	response, err := jetbrains.ResponseJetbrainsAIToClient(jetbrains.WithResponseToken(ctx, stream), respReq, stream.Body, fingerprint)
+	return completionResult{response: response, tokenName: jetbrains.ResponseTokenName(stream), err: err}
+}
+
+// writeCompletion 写出非流式补全的结果
+func writeCompletion(c echo.Context, cfg *config.Config, jetbrainsReq *types.JetbrainsRequest, result completionResult) error {
+	if result.err != nil {
+		return upstreamError(c, cfg, result.err)
+	}
+	jetbrains.SetUsageHeaders(c.Response().Header(), result.response.Usage)
+	if cfg.EnableDebugResponses && debugRequested(c.Request()) {
+		return c.JSON(http.StatusOK, debugResponse{
+			ChatCompletionResponse: result.response,
+			Debug: debugInfo{
+				Profile:      jetbrainsReq.Profile,
+				MessageCount: len(jetbrainsReq.Chat.MessageField),
+				TokenName:    result.tokenName,
+			},
+		})
+	}
+	return c.JSON(http.StatusOK, result.response)
+}
+
+// upstreamError 写出上游请求失败的响应：没有token能使用请求的模型时返回404，没有可用token时返回503，
+// 上游拒绝请求的4xx原样返回，其他错误返回500
+func upstreamError(c echo.Context, cfg *config.Config, err error) error {
+	if errors.Is(err, balancer.ErrProfileUnavailable) || errors.Is(err, jetbrains.ErrProfileRejected) {
+		return c.JSON(http.StatusNotFound, middleware.Localizer(c).ErrorResponse(types.ErrorTypeInvalidRequest,
+			"model_not_available", "The requested model is not available to any upstream token"))
+	}
+	if errors.Is(err, balancer.ErrNoHealthyTokens) {
+		return noHealthyTokens(c, cfg)
+	}
+	var statusErr *jetbrains.UpstreamStatusError
+	if errors.As(err, &statusErr) {
+		return c.JSON(statusErr.StatusCode, types.NewOpenAIErrorResponse(types.ErrorTypeInvalidRequest,
+			"upstream_rejected_request", err.Error()))
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+// noHealthyTokens 所有token暂时不可用，让客户端稍后重试而不是当作服务器错误
+func noHealthyTokens(c echo.Context, cfg *config.Config) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cfg.NoTokensRetryAfter.Seconds()))))
+	return c.JSON(http.StatusServiceUnavailable, middleware.Localizer(c).ErrorResponse(types.ErrorTypeServer,
+		"no_healthy_tokens", "No healthy upstream tokens are available, please retry later"))
+}
+
+// HeaderProxyDebug 请求在非流式响应中附加_debug字段的请求头，需启用enable_debug_responses
+const HeaderProxyDebug = "X-Proxy-Debug"
+
+// debugInfo 请求转换的调试信息，不包含token和提示内容
+type debugInfo struct {
+	Profile      string `json:"profile"`
+	MessageCount int    `json:"message_count"`
+	TokenName    string `json:"token_name"`
+}
+
+// debugResponse 附加了调试信息的非流式响应，_debug是非标准扩展字段
+type debugResponse struct {
+	openai.ChatCompletionResponse
+	Debug debugInfo `json:"_debug"`
+}
+
+// headerProxyDegraded 健康token比例低于degraded_threshold时设置的响应头
+const headerProxyDegraded = "X-Proxy-Degraded"
+
+// markDegraded 健康token占比低于阈值时设置降级响应头，阈值为0时关闭；不影响响应内容
+func markDegraded(c echo.Context, threshold float64) {
+	if threshold <= 0 {
+		return
+	}
+	healthy, total := jetbrains.GetBalancerStats()
+	if total > 0 && float64(healthy) < threshold*float64(total) {
+		c.Response().Header().Set(headerProxyDegraded, "true")
+	}
+}
+
+// debugRequested 请求是否要求附加调试信息
+func debugRequested(r *http.Request) bool {
+	enabled, _ := strconv.ParseBool(r.Header.Get(HeaderProxyDebug))
+	return enabled
+}
+
+// HeaderBufferStream 要求将流式请求缓冲为单个非流式JSON响应的请求头，供无法解析SSE的客户端使用
+const HeaderBufferStream = "X-Proxy-Buffer-Stream"
+
+// bufferStreamRequested 请求是否要求缓冲流式响应
+func bufferStreamRequested(r *http.Request) bool {
+	enabled, _ := strconv.ParseBool(r.Header.Get(HeaderBufferStream))
+	return enabled
+}
+
 func handleListModels(c echo.Context) error {
-	models := types.GetSupportedModels()
+	// 支持按提供方过滤，如 /v1/models?owned_by=anthropic
+	models := types.GetSupportedModelsByOwner(c.QueryParam("owned_by"))
 	return c.JSON(http.StatusOK, models)
 }
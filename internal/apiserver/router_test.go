@@ -0,0 +1,1297 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo"
+	"io"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"jetbrains-ai-proxy/internal/middleware"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const testBearerToken = "test-bearer-token"
+
+// setupTestServer 使用模拟上游搭建完整的请求链路（路由、认证、负载均衡、SSE转换）
+func setupTestServer(t *testing.T, mock jetbrains.UpstreamClient, tokens ...string) *echo.Echo {
+	t.Helper()
+
+	if len(tokens) == 0 {
+		tokens = []string{"jwt-token-1"}
+	}
+
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+	jetbrains.SetBalancer(balancer.NewJWTBalancer(tokens, config.RoundRobin))
+	prev := jetbrains.SetUpstreamClient(mock)
+	t.Cleanup(func() {
+		jetbrains.SetUpstreamClient(prev)
+	})
+
+	e := echo.New()
+	RegisterRoutes(e)
+	return e
+}
+
+// doChatRequest 发送聊天补全请求
+func doChatRequest(e *echo.Echo, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStreamingCompletionAgainstMockUpstream(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello", ", world"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","stream":true,"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+
+	body := rec.Body.String()
+	for _, expected := range []string{`"content":"Hello"`, `"content":", world"`, `"finish_reason":"stop"`, "data: [DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected stream to contain %s, got:\n%s", expected, body)
+		}
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 upstream request, got %d", len(requests))
+	}
+	if requests[0].Headers[types.JwtTokenKey] != "jwt-token-1" {
+		t.Errorf("Expected JWT header to be set, got %v", requests[0].Headers)
+	}
+	if requests[0].Body.Profile != "openai-gpt-4o" {
+		t.Errorf("Expected profile openai-gpt-4o, got %s", requests[0].Body.Profile)
+	}
+	if len(requests[0].Body.Chat.MessageField) != 2 {
+		t.Errorf("Expected 2 converted messages, got %d", len(requests[0].Body.Chat.MessageField))
+	}
+}
+
+func TestNonStreamingCompletionAgainstEchoMock(t *testing.T) {
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"ping"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"content":"[mock] ping"`) {
+		t.Errorf("Expected echoed content, got %s", rec.Body.String())
+	}
+}
+
+func TestCompletionRequiresBearerToken(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("x"))
+	e := setupTestServer(t, mock)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rec.Code)
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream requests, got %d", len(mock.Requests()))
+	}
+}
+
+// withConfig 临时修改全局配置，测试结束后恢复
+func withConfig(t *testing.T, fn func(cfg *config.Config)) {
+	t.Helper()
+
+	original := *config.GetGlobalConfig().GetConfig()
+	config.GetGlobalConfig().UpdateConfig(fn)
+	t.Cleanup(func() {
+		config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+			*cfg = original
+		})
+	})
+}
+
+func TestResponseReportsServedModel(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ModelAliases = map[string]string{"o1-fallback": "gpt-4o"}
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	// 别名回退：响应报告实际使用的模型
+	rec := doChatRequest(e, `{"model":"o1-fallback","messages":[{"role":"user","content":"hi"}]}`)
+	if !strings.Contains(rec.Body.String(), `"model":"gpt-4o"`) {
+		t.Errorf("Expected served model gpt-4o, got %s", rec.Body.String())
+	}
+	if profile := mock.Requests()[0].Body.Profile; profile != "openai-gpt-4o" {
+		t.Errorf("Expected upstream profile openai-gpt-4o, got %s", profile)
+	}
+
+	// 名称规范化同样报告规范后的模型
+	rec = doChatRequest(e, `{"model":"GPT-4O","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if !strings.Contains(rec.Body.String(), `"model":"gpt-4o"`) {
+		t.Errorf("Expected normalized model gpt-4o in stream, got %s", rec.Body.String())
+	}
+
+	// 未发生映射时回显请求的模型
+	rec = doChatRequest(e, `{"model":"o1","messages":[{"role":"user","content":"hi"}]}`)
+	if !strings.Contains(rec.Body.String(), `"model":"o1"`) {
+		t.Errorf("Expected model o1, got %s", rec.Body.String())
+	}
+}
+
+func TestResponseEchoesRequestedModelWhenConfigured(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ModelAliases = map[string]string{"o1-fallback": "gpt-4o"}
+		cfg.EchoRequestedModel = true
+	})
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok")))
+
+	rec := doChatRequest(e, `{"model":"o1-fallback","messages":[{"role":"user","content":"hi"}]}`)
+	if !strings.Contains(rec.Body.String(), `"model":"o1-fallback"`) {
+		t.Errorf("Expected requested model to be echoed, got %s", rec.Body.String())
+	}
+}
+
+func TestConversationLimitReject(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.MaxMessages = 2
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestConversationLimitTruncate(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.MaxMessages = 2
+		cfg.ConversationLimitMode = types.ConversationLimitTruncate
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"system","content":"sys"},{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	messages := mock.Requests()[0].Body.Chat.MessageField
+	if len(messages) != 2 || messages[0].Type != "system_message" || messages[1].Content != "c" {
+		t.Errorf("Expected system message and latest user message, got %+v", messages)
+	}
+}
+
+func TestMaxPromptTokens(t *testing.T) {
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"tell me a long story about the sea"}]}`
+	tokens := types.CountPromptTokens([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "tell me a long story about the sea"},
+	})
+
+	t.Run("under limit", func(t *testing.T) {
+		withConfig(t, func(cfg *config.Config) {
+			cfg.MaxPromptTokens = tokens
+		})
+		mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+		e := setupTestServer(t, mock)
+
+		rec := doChatRequest(e, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		// 估算用量复用已统计的prompt token数
+		var resp openai.ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Usage.PromptTokens != tokens {
+			t.Errorf("Expected %d prompt tokens, got %d", tokens, resp.Usage.PromptTokens)
+		}
+	})
+
+	t.Run("over limit", func(t *testing.T) {
+		withConfig(t, func(cfg *config.Config) {
+			cfg.MaxPromptTokens = tokens - 1
+		})
+		mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+		e := setupTestServer(t, mock)
+
+		rec := doChatRequest(e, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp types.OpenAIErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if resp.Error.Code == nil || *resp.Error.Code != "context_length_exceeded" {
+			t.Errorf("Expected context_length_exceeded code, got %+v", resp.Error)
+		}
+		if !strings.Contains(resp.Error.Message, strconv.Itoa(tokens)+" tokens") {
+			t.Errorf("Expected message to include the measured count %d, got %q", tokens, resp.Error.Message)
+		}
+		if len(mock.Requests()) != 0 {
+			t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		withConfig(t, func(cfg *config.Config) {
+			cfg.MaxPromptTokens = 0
+		})
+		mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+		e := setupTestServer(t, mock)
+
+		rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"`+strings.Repeat("word ", 5000)+`"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 without a limit, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestUsageHeadersNonStreaming(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello", ", world"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	// 响应头与响应体中的用量一致
+	expected := map[string]int{
+		jetbrains.HeaderPromptTokens:     resp.Usage.PromptTokens,
+		jetbrains.HeaderCompletionTokens: resp.Usage.CompletionTokens,
+		jetbrains.HeaderTotalTokens:      resp.Usage.TotalTokens,
+	}
+	for header, value := range expected {
+		if got := rec.Header().Get(header); got != strconv.Itoa(value) {
+			t.Errorf("Expected %s=%d, got %q", header, value, got)
+		}
+	}
+	if resp.Usage.TotalTokens == 0 {
+		t.Errorf("Expected non-zero usage, got %+v", resp.Usage)
+	}
+}
+
+func TestUsageTrailersStreaming(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello", ", world"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 流式响应在结束时以trailer发送用量
+	trailer := rec.Result().Trailer
+	if trailer.Get(jetbrains.HeaderTotalTokens) == "" || trailer.Get(jetbrains.HeaderCompletionTokens) == "" {
+		t.Errorf("Expected usage trailers, got %v", trailer)
+	}
+}
+
+func TestLogprobsRejectedByDefault(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	for _, body := range []string{
+		`{"model":"gpt-4o","logprobs":true,"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"gpt-4o","top_logprobs":3,"messages":[{"role":"user","content":"hi"}]}`,
+	} {
+		rec := doChatRequest(e, body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "logprobs") {
+			t.Errorf("Expected 400 mentioning logprobs for %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestLogprobsIgnoreMode(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.LogprobsMode = types.LogprobsIgnore
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","logprobs":true,"top_logprobs":3,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 in ignore mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), `"logprobs":{`) {
+		t.Errorf("Expected no logprobs in response, got %s", rec.Body.String())
+	}
+}
+
+func TestPenaltyRejectMode(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.PenaltyMode = types.PenaltyReject
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","presence_penalty":0.6,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "presence_penalty") {
+		t.Errorf("Expected 400 mentioning presence_penalty, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestPenaltyIgnoredByDefault(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","frequency_penalty":1.2,"presence_penalty":0.6,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 in ignore mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(mock.Requests()) != 1 {
+		t.Errorf("Expected one upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestReasoningEffortValidation(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"o3","reasoning_effort":"extreme","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doChatRequest(e, `{"model":"o3","reasoning_effort":"low","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if effort := mock.Requests()[0].Body.ReasoningEffort; effort != "low" {
+		t.Errorf("Expected reasoning effort low upstream, got %q", effort)
+	}
+}
+
+// slowUpstreamClient 在返回前等待，模拟卡住的上游
+type slowUpstreamClient struct {
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (s *slowUpstreamClient) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	select {
+	case <-ctx.Done():
+		close(s.cancelled)
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+		return nil, errors.New("slow upstream finished")
+	}
+}
+
+func TestRequestTimeoutReturns504(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = 50 * time.Millisecond
+	})
+	slow := &slowUpstreamClient{delay: 5 * time.Second, cancelled: make(chan struct{})}
+	e := setupTestServer(t, slow)
+
+	start := time.Now()
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected timeout to respond promptly, took %v", elapsed)
+	}
+
+	var resp types.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected OpenAI error envelope, got %s", rec.Body.String())
+	}
+	if resp.Error.Type != types.ErrorTypeTimeout || resp.Error.Message == "" {
+		t.Errorf("Unexpected error envelope: %+v", resp.Error)
+	}
+
+	// 上游请求的上下文必须被取消
+	select {
+	case <-slow.cancelled:
+	default:
+		t.Error("Expected upstream context to be cancelled")
+	}
+}
+
+func TestRequestTimeoutDoesNotAffectFastRequests(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = time.Second
+	})
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"ping"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Expected handler headers to pass through, got %s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `"content":"ping"`) {
+		t.Errorf("Expected streamed content, got %s", rec.Body.String())
+	}
+}
+
+// tricklingUpstreamClient 逐行缓慢返回SSE流，模拟持续输出但总耗时较长的推理模型
+type tricklingUpstreamClient struct {
+	stream string
+	delay  time.Duration
+}
+
+func (u *tricklingUpstreamClient) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, line := range strings.SplitAfter(u.stream, "\n") {
+			select {
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			case <-time.After(u.delay):
+			}
+			pw.Write([]byte(line))
+		}
+		pw.Close()
+	}()
+	return &http.Response{StatusCode: http.StatusOK, Body: pr}, nil
+}
+
+func TestIdleTimeoutModelsOutliveRequestTimeout(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = 50 * time.Millisecond
+		cfg.StreamIdleTimeout = time.Second
+		cfg.IdleTimeoutModels = []string{"o3"}
+	})
+	// 总耗时远超request_timeout，但每行之间的间隔都在空闲超时之内
+	e := setupTestServer(t, &tricklingUpstreamClient{stream: jetbrains.BuildMockSSEStream("thinking", " done"), delay: 20 * time.Millisecond})
+
+	rec := doChatRequest(e, `{"model":"o3","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected slow reasoning model to complete, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Choices[0].Message.Content != "thinking done" {
+		t.Errorf("Expected full content, got %s", rec.Body.String())
+	}
+
+	// 未配置的模型仍受固定超时限制
+	if rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected request_timeout to apply to other models, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 客户端指定超时时按请求头处理
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"o3","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	req.Header.Set(middleware.RequestTimeoutHeader, "50ms")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected the timeout header to apply, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// doChatRequestWithTimeout 发送带有单请求超时请求头的聊天请求
+func doChatRequestWithTimeout(e *echo.Echo, timeout string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	req.Header.Set(middleware.RequestTimeoutHeader, timeout)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestTimeoutHeaderShortensDeadline(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = time.Minute
+	})
+	slow := &slowUpstreamClient{delay: 5 * time.Second, cancelled: make(chan struct{})}
+	e := setupTestServer(t, slow)
+
+	// 客户端要求快速失败，不等待默认的1分钟
+	start := time.Now()
+	rec := doChatRequestWithTimeout(e, "50ms")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the header timeout to apply, took %v", elapsed)
+	}
+}
+
+func TestRequestTimeoutHeaderClampedToMax(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = 20 * time.Millisecond
+		cfg.MaxRequestTimeout = 100 * time.Millisecond
+	})
+	slow := &slowUpstreamClient{delay: 5 * time.Second, cancelled: make(chan struct{})}
+	e := setupTestServer(t, slow)
+
+	// 请求的10秒超过上限，按上限100ms处理
+	start := time.Now()
+	rec := doChatRequestWithTimeout(e, "10")
+	elapsed := time.Since(start)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the longer header timeout to override the default, took %v", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Expected the header timeout to be clamped, took %v", elapsed)
+	}
+}
+
+func TestRequestTimeoutHeaderInvalid(t *testing.T) {
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	rec := doChatRequestWithTimeout(e, "soon")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp types.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Type != types.ErrorTypeInvalidRequest {
+		t.Errorf("Expected invalid request error envelope, got %s", rec.Body.String())
+	}
+}
+
+func TestRequiredHeadersCheckedBeforeBearerAuth(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.RequiredHeaders = []config.HeaderMatcher{{Name: "X-Proxy-Secret", Value: "s3cret"}}
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 without required header, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	req.Header.Set("X-Proxy-Secret", "s3cret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with required header, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestListModelsFilteredByOwner(t *testing.T) {
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	get := func(path string) types.OpenAIModelList {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+testBearerToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", path, rec.Code)
+		}
+		var list types.OpenAIModelList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("Failed to decode model list: %v", err)
+		}
+		return list
+	}
+
+	anthropic := get("/v1/models?owned_by=anthropic")
+	if len(anthropic.Data) == 0 {
+		t.Fatal("Expected anthropic models")
+	}
+	for _, model := range anthropic.Data {
+		if model.OwnedBy != "anthropic" {
+			t.Errorf("Expected only anthropic models, got %s owned by %s", model.ID, model.OwnedBy)
+		}
+	}
+
+	if unknown := get("/v1/models?owned_by=unknown"); len(unknown.Data) != 0 {
+		t.Errorf("Expected no models for unknown owner, got %d", len(unknown.Data))
+	}
+	if all := get("/v1/models"); len(all.Data) <= len(anthropic.Data) {
+		t.Errorf("Expected unfiltered list to contain all models, got %d", len(all.Data))
+	}
+}
+
+// doDebugChatRequest 发送带调试请求头的聊天补全请求
+func doDebugChatRequest(e *echo.Echo, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	req.Header.Set(HeaderProxyDebug, "true")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDebugFieldWhenRequestedAndEnabled(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.EnableDebugResponses = true
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doDebugChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"system","content":"secret system prompt"},{"role":"user","content":"secret question"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Choices []openai.ChatCompletionChoice `json:"choices"`
+		Debug   *debugInfo                    `json:"_debug"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Debug == nil {
+		t.Fatalf("Expected _debug field, got %s", rec.Body.String())
+	}
+	expected := debugInfo{Profile: "openai-gpt-4o", MessageCount: 2, TokenName: "JWT_1"}
+	if *resp.Debug != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *resp.Debug)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "ok" {
+		t.Errorf("Expected the regular response alongside _debug, got %s", rec.Body.String())
+	}
+	// 不泄露token和提示内容
+	for _, secret := range []string{"jwt-token-1", "secret"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("Expected response not to contain %q, got %s", secret, rec.Body.String())
+		}
+	}
+}
+
+func TestDebugFieldOnlyWhenRequestedAndEnabled(t *testing.T) {
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+	// 未启用时忽略请求头
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+	if rec := doDebugChatRequest(e, body); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "_debug") {
+		t.Errorf("Expected no _debug field when disabled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 启用但未请求
+	withConfig(t, func(cfg *config.Config) {
+		cfg.EnableDebugResponses = true
+	})
+	mock = jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e = setupTestServer(t, mock)
+	if rec := doChatRequest(e, body); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "_debug") {
+		t.Errorf("Expected no _debug field without the header, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// nonFlushingWriter 隐藏底层ResponseRecorder的Flush，模拟不支持刷新的代理环境
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestStreamingBehindNonFlushingWriter(t *testing.T) {
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	serve := func(e *echo.Echo) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testBearerToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(nonFlushingWriter{rec}, req)
+		return rec
+	}
+
+	// 默认只记录警告，仍以SSE响应
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello")))
+	rec := serve(e)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Expected warn mode to keep streaming, got %s", ct)
+	}
+	if rec.Header().Get(jetbrains.HeaderStreamDegraded) != "" {
+		t.Errorf("Expected no degradation header in warn mode")
+	}
+
+	// buffer模式下返回非流式响应并标记降级
+	withConfig(t, func(cfg *config.Config) {
+		cfg.NonFlushingStreamMode = jetbrains.NonFlushingBuffer
+	})
+	rec = serve(e)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(jetbrains.HeaderStreamDegraded); got != "buffered" {
+		t.Errorf("Expected degradation header, got %q", got)
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected JSON completion, got %s", rec.Body.String())
+	}
+	if resp.Choices[0].Message.Content != "Hello" {
+		t.Errorf("Expected buffered content, got %q", resp.Choices[0].Message.Content)
+	}
+
+	// 支持刷新时buffer模式不影响流式响应
+	rec = doChatRequest(e, body)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Expected flushable writer to keep streaming, got %s", ct)
+	}
+}
+
+func TestBufferStreamHeader(t *testing.T) {
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hel", "lo")))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	req.Header.Set(HeaderBufferStream, "true")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("Expected a JSON response, got %s", ct)
+	}
+	if got := rec.Header().Get(jetbrains.HeaderStreamDegraded); got != "buffered" {
+		t.Errorf("Expected degradation header, got %q", got)
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected JSON completion, got %s", rec.Body.String())
+	}
+	if resp.Choices[0].Message.Content != "Hello" {
+		t.Errorf("Expected aggregated content, got %q", resp.Choices[0].Message.Content)
+	}
+
+	// 未带请求头时照常以SSE响应
+	rec = doChatRequest(e, body)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Expected streaming without the header, got %s", ct)
+	}
+}
+
+func TestAssistantPrefill(t *testing.T) {
+	transcript := `"messages":[{"role":"user","content":"List three colors"},{"role":"assistant","content":"1. Red\n"}]`
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("2. Green\n", "3. Blue"))
+	e := setupTestServer(t, mock)
+
+	// 默认预填充作为普通assistant消息转发
+	doChatRequest(e, `{"model":"gpt-4o",`+transcript+`}`)
+	if messages := mock.Requests()[0].Body.Chat.MessageField; len(messages) != 2 || messages[1].Type != "assistant_message" {
+		t.Errorf("Expected prefill to be forwarded unchanged, got %+v", messages)
+	}
+
+	// continue模式下追加续写提示，响应只包含续写部分
+	withConfig(t, func(cfg *config.Config) {
+		cfg.AssistantPrefill = types.PrefillContinue
+	})
+	rec := doChatRequest(e, `{"model":"gpt-4o",`+transcript+`}`)
+	messages := mock.Requests()[1].Body.Chat.MessageField
+	if len(messages) != 3 || messages[1].Content != "1. Red\n" || messages[2].Content != types.PrefillInstruction {
+		t.Errorf("Expected prefill followed by continuation instruction, got %+v", messages)
+	}
+	var resp openai.ChatCompletionResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if got := resp.Choices[0].Message.Content; got != "2. Green\n3. Blue" {
+		t.Errorf("Expected continuation only, got %q", got)
+	}
+
+	// echo模式下响应以预填充内容开头
+	withConfig(t, func(cfg *config.Config) {
+		cfg.AssistantPrefill = types.PrefillEcho
+	})
+	rec = doChatRequest(e, `{"model":"gpt-4o",`+transcript+`}`)
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if got := resp.Choices[0].Message.Content; got != "1. Red\n2. Green\n3. Blue" {
+		t.Errorf("Expected response to start with the prefill, got %q", got)
+	}
+
+	rec = doChatRequest(e, `{"model":"gpt-4o","stream":true,`+transcript+`}`)
+	var content strings.Builder
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line || data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if content.String() != "1. Red\n2. Green\n3. Blue" {
+		t.Errorf("Expected streamed response to start with the prefill, got %q", content.String())
+	}
+}
+
+func TestUpstreamClientErrorPassedThrough(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusBadRequest, "")
+	e := setupTestServer(t, mock, "jwt-token-1", "jwt-token-2")
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected upstream 400 to be passed through, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var errResp types.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Error.Code == nil || *errResp.Error.Code != "upstream_rejected_request" {
+		t.Errorf("Expected OpenAI error with code upstream_rejected_request, got %s", rec.Body.String())
+	}
+	if len(mock.Requests()) != 1 {
+		t.Errorf("Expected no retries, got %d upstream requests", len(mock.Requests()))
+	}
+}
+
+func TestProfileRejectedByAllTokensReturns404(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusForbidden, `{"error":"Profile openai-gpt-4o is not available for this user"}`)
+	e := setupTestServer(t, mock, "jwt-token-1", "jwt-token-2")
+
+	// 首次请求在所有token上被拒绝，之后的请求不再发往上游
+	for i := 0; i < 2; i++ {
+		rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected 404 when no token can serve the model, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var errResp types.OpenAIErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Error.Code == nil || *errResp.Error.Code != "model_not_available" {
+			t.Errorf("Expected OpenAI error with code model_not_available, got %s", rec.Body.String())
+		}
+	}
+	if len(mock.Requests()) != 2 {
+		t.Errorf("Expected each token to be tried once, got %d upstream requests", len(mock.Requests()))
+	}
+	if healthy, _ := jetbrains.GetBalancerStats(); healthy != 2 {
+		t.Errorf("Expected profile rejections to keep tokens healthy, got %d healthy", healthy)
+	}
+}
+
+func TestNoHealthyTokensReturns503(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.NoTokensRetryAfter = 90 * time.Second
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+	b := balancer.NewJWTBalancer([]string{"jwt-token-1", "jwt-token-2"}, config.RoundRobin)
+	b.MarkTokenUnhealthy("jwt-token-1")
+	b.MarkTokenUnhealthy("jwt-token-2")
+	jetbrains.SetBalancer(b)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "90" {
+		t.Errorf("Expected Retry-After 90, got %q", got)
+	}
+	var errResp types.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Error.Code == nil || *errResp.Error.Code != "no_healthy_tokens" {
+		t.Errorf("Expected OpenAI error with code no_healthy_tokens, got %s", rec.Body.String())
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream requests, got %d", len(mock.Requests()))
+	}
+}
+
+func TestDefaultStream(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.DefaultStream = true
+	})
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok")))
+
+	cases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"omitted", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, "text/event-stream"},
+		{"null", `{"model":"gpt-4o","stream":null,"messages":[{"role":"user","content":"hi"}]}`, "text/event-stream"},
+		{"explicit true", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`, "text/event-stream"},
+		{"explicit false", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"hi"}]}`, echo.MIMEApplicationJSONCharsetUTF8},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doChatRequest(e, tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get(echo.HeaderContentType); ct != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, ct)
+			}
+		})
+	}
+}
+
+func TestDisableNonStreaming(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.DisableNonStreaming = true
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for non-streaming request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "non_streaming_disabled") {
+		t.Errorf("Expected non_streaming_disabled error code, got %s", rec.Body.String())
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+
+	rec = doChatRequest(e, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Hello") {
+		t.Errorf("Expected streaming request to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMalformedRequestReturnsFieldError(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"},{"role":"bot","content":"hello"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp types.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if resp.Error.Type != types.ErrorTypeInvalidRequest || resp.Error.Param == nil || *resp.Error.Param != "messages[1].role" {
+		t.Errorf("Expected invalid_request_error for messages[1].role, got %+v", resp.Error)
+	}
+	if !strings.Contains(resp.Error.Message, "invalid role 'bot'") {
+		t.Errorf("Expected message to name the invalid role, got %q", resp.Error.Message)
+	}
+	if len(mock.Requests()) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(mock.Requests()))
+	}
+}
+
+func TestMaxTokensValidation(t *testing.T) {
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok")))
+
+	cases := []struct {
+		maxTokens string
+		expected  int
+	}{
+		{`"max_tokens":0,`, http.StatusBadRequest},
+		{`"max_tokens":-10,`, http.StatusBadRequest},
+		{`"max_tokens":null,`, http.StatusOK},
+		{`"max_tokens":128,`, http.StatusOK},
+		{``, http.StatusOK},
+	}
+	for _, tc := range cases {
+		rec := doChatRequest(e, `{"model":"gpt-4o",`+tc.maxTokens+`"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != tc.expected {
+			t.Errorf("Expected %d for %q, got %d: %s", tc.expected, tc.maxTokens, rec.Code, rec.Body.String())
+		}
+		if tc.expected == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "max_tokens: must be a positive integer") {
+			t.Errorf("Expected max_tokens error message, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestHistoryWindowKeepsSystemAndRecentMessages(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "you are a helpful assistant"},
+		{Role: openai.ChatMessageRoleUser, Content: "an early question about the weather in a distant city"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "an early answer describing the weather"},
+		{Role: openai.ChatMessageRoleUser, Content: "latest question"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "latest answer"},
+		{Role: openai.ChatMessageRoleUser, Content: "follow up"},
+	}
+	// 预算刚好容纳系统消息和最近三条消息
+	budget := types.CountPromptTokens(append([]openai.ChatCompletionMessage{messages[0]}, messages[3:]...))
+	withConfig(t, func(cfg *config.Config) {
+		cfg.HistoryWindowTokens = budget
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	body, _ := json.Marshal(openai.ChatCompletionRequest{Model: "gpt-4o", Messages: messages})
+	rec := doChatRequest(e, string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	sent := mock.Requests()[0].Body.Chat.MessageField
+	var contents []string
+	for _, msg := range sent {
+		contents = append(contents, msg.Content)
+	}
+	expected := []string{"you are a helpful assistant", "latest question", "latest answer", "follow up"}
+	if strings.Join(contents, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected system message and recent messages %v, got %v", expected, contents)
+	}
+}
+
+func TestServiceTierRoutesFlexToOverflowTokens(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+	jetbrains.SetBalancer(balancer.NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "jwt-primary", Name: "Primary", Priority: 1},
+		{Token: "jwt-overflow", Name: "Overflow", Priority: 2},
+	}, balancer.NewPriorityTierStrategy(balancer.NewRoundRobinStrategy())))
+
+	cases := []struct {
+		tier     string
+		expected string
+	}{
+		{`"service_tier":"flex",`, "jwt-overflow"},
+		{`"service_tier":"default",`, "jwt-primary"},
+		{`"service_tier":"auto",`, "jwt-primary"},
+		{``, "jwt-primary"},
+	}
+	for i, tc := range cases {
+		rec := doChatRequest(e, `{"model":"gpt-4o",`+tc.tier+`"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %q, got %d: %s", tc.tier, rec.Code, rec.Body.String())
+		}
+		if token := mock.Requests()[i].Headers[types.JwtTokenKey]; token != tc.expected {
+			t.Errorf("Expected %q to use %s, got %s", tc.tier, tc.expected, token)
+		}
+	}
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","service_tier":1,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"param":"service_tier"`) {
+		t.Errorf("Expected 400 for non-string service_tier, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpstreamPromptConfigurable(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.UpstreamPrompt = "ij.chat.request.custom"
+		cfg.ModelPrompts = map[string]string{"o1": "ij.chat.request.reasoning"}
+		cfg.ModelAliases = map[string]string{"thinker": "o1"}
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	// 按别名解析后的实际模型选择prompt标识
+	cases := []struct {
+		model    string
+		expected string
+	}{
+		{"gpt-4o", "ij.chat.request.custom"},
+		{"o1", "ij.chat.request.reasoning"},
+		{"thinker", "ij.chat.request.reasoning"},
+	}
+	for i, tc := range cases {
+		rec := doChatRequest(e, `{"model":"`+tc.model+`","messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", tc.model, rec.Code, rec.Body.String())
+		}
+		if prompt := mock.Requests()[i].Body.Prompt; prompt != tc.expected {
+			t.Errorf("Expected prompt %s for %s, got %s", tc.expected, tc.model, prompt)
+		}
+	}
+}
+
+func TestDegradedHeader(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.DegradedThreshold = 0.5
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+	tokens := []string{"jwt-token-1", "jwt-token-2", "jwt-token-3", "jwt-token-4"}
+	b := balancer.NewJWTBalancer(tokens, config.RoundRobin)
+	jetbrains.SetBalancer(b)
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+	// 健康比例等于阈值时不视为降级
+	b.MarkTokenUnhealthy("jwt-token-1")
+	b.MarkTokenUnhealthy("jwt-token-2")
+	rec := doChatRequest(e, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Proxy-Degraded"); got != "" {
+		t.Errorf("Expected no degraded header at the threshold, got %q", got)
+	}
+
+	b.MarkTokenUnhealthy("jwt-token-3")
+	rec = doChatRequest(e, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Proxy-Degraded"); got != "true" {
+		t.Errorf("Expected degraded header below the threshold, got %q", got)
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) == 0 || resp.Choices[0].Message.Content != "ok" {
+		t.Errorf("Expected response content to be unchanged, got %s", rec.Body.String())
+	}
+}
+
+func TestStreamingOverHTTP1AndHTTP2(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("Hello", ", world"))
+	e := setupTestServer(t, mock)
+
+	for _, http2 := range []bool{false, true} {
+		server := httptest.NewUnstartedServer(e)
+		server.EnableHTTP2 = http2
+		server.StartTLS()
+
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions",
+			strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testBearerToken)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			server.Close()
+			t.Fatalf("HTTP/2 %v: request failed: %v", http2, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+
+		expectedProto := 1
+		if http2 {
+			expectedProto = 2
+		}
+		if resp.ProtoMajor != expectedProto {
+			t.Fatalf("Expected HTTP/%d, got %s", expectedProto, resp.Proto)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "data: [DONE]") {
+			t.Errorf("%s: expected a complete stream, got %d: %s", resp.Proto, resp.StatusCode, body)
+		}
+		// HTTP/1.1下分块传输，HTTP/2不应声明Transfer-Encoding
+		if http2 && len(resp.TransferEncoding) > 0 {
+			t.Errorf("Expected no Transfer-Encoding over HTTP/2, got %v", resp.TransferEncoding)
+		}
+		if !http2 && (len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked") {
+			t.Errorf("Expected chunked Transfer-Encoding over HTTP/1.1, got %v", resp.TransferEncoding)
+		}
+		if resp.Trailer.Get(jetbrains.HeaderTotalTokens) == "" {
+			t.Errorf("%s: expected usage trailer, got %v", resp.Proto, resp.Trailer)
+		}
+	}
+}
+
+func TestSetStreamHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		protoMajor int
+		chunked    bool
+	}{
+		{1, true},
+		{2, false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.ProtoMajor = tc.protoMajor
+		header := http.Header{}
+		setStreamHeaders(header, req)
+
+		if header.Get(echo.HeaderContentType) != "text/event-stream" {
+			t.Errorf("HTTP/%d: expected SSE content type, got %q", tc.protoMajor, header.Get(echo.HeaderContentType))
+		}
+		if got := header.Get("Transfer-Encoding") == "chunked"; got != tc.chunked {
+			t.Errorf("HTTP/%d: expected chunked %v, got header %q", tc.protoMajor, tc.chunked, header.Get("Transfer-Encoding"))
+		}
+	}
+}
+
+func TestLocalizedErrorMessages(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ErrorLanguage = "zh"
+	})
+	e := setupTestServer(t, jetbrains.NewEchoMockUpstreamClient())
+
+	rec := doChatRequest(e, `{"model":"gpt-99","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "不支持模型 'gpt-99'") {
+		t.Errorf("Expected translated model error, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Accept-Language中支持的语言优先于配置的默认语言
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "invalid token") {
+		t.Errorf("Expected English auth error, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req.Header.Set("Accept-Language", "zh-CN")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "无效的token") {
+		t.Errorf("Expected translated auth error, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMultipleSystemMessagesConcatenatedByDefault(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"system","content":"Be brief."},{"role":"system","content":"Answer in French."},{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	expected := []types.MessageField{
+		{Type: "system_message", Content: "Be brief.\n\nAnswer in French."},
+		{Type: "user_message", Content: "hi"},
+	}
+	if got := mock.Requests()[0].Body.Chat.MessageField; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected system messages to be merged upstream, got %+v", got)
+	}
+}
+
+func TestChatCompletionAcceptsJSONContentTypeVariants(t *testing.T) {
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+
+	for _, contentType := range []string{"application/json; charset=utf-8", "Application/JSON", "application/vnd.api+json", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		if contentType != "" {
+			req.Header.Set(echo.HeaderContentType, contentType)
+		}
+		req.Header.Set("Authorization", "Bearer "+testBearerToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Content-Type %q: expected 200, got %d: %s", contentType, rec.Code, rec.Body.String())
+			continue
+		}
+		var resp openai.ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Choices[0].Message.Content != "ok" {
+			t.Errorf("Content-Type %q: expected completion, got %s", contentType, rec.Body.String())
+		}
+	}
+}
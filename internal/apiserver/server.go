@@ -0,0 +1,39 @@
+package apiserver
+
+import (
+	"github.com/labstack/echo"
+	echomw "github.com/labstack/echo/middleware"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/middleware"
+)
+
+// NewServers 创建API服务实例；配置了AdminPort时管理端点和调试端点使用单独的实例，否则admin为nil
+func NewServers(manager *config.Manager) (api *echo.Echo, admin *echo.Echo) {
+	cfg := manager.GetConfig()
+
+	api = newEcho()
+	// BearerAuth 为全局中间件，同样保护同一实例上的管理端点和调试端点
+	RegisterRoutes(api)
+
+	if cfg.AdminPort <= 0 {
+		RegisterAdminRoutes(api, manager)
+		RegisterPprofRoutes(api, cfg.EnablePprof)
+		return api, nil
+	}
+
+	admin = newEcho()
+	admin.Use(middleware.RequiredHeaders())
+	admin.Use(middleware.BearerAuth())
+	admin.Use(middleware.DisabledEndpoints())
+	RegisterAdminRoutes(admin, manager)
+	RegisterPprofRoutes(admin, cfg.EnablePprof)
+	return api, admin
+}
+
+// newEcho 创建带日志和panic恢复中间件的Echo实例
+func newEcho() *echo.Echo {
+	e := echo.New()
+	e.Use(echomw.Logger())
+	e.Use(echomw.Recover())
+	return e
+}
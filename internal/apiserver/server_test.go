@@ -0,0 +1,353 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/jetbrains"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doAuthorizedGet 发送带Bearer认证的GET请求
+func doAuthorizedGet(e *echo.Echo, path string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAdminEndpointsOnSinglePort(t *testing.T) {
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+
+	api, admin := NewServers(config.GetGlobalConfig())
+	if admin != nil {
+		t.Fatal("Expected no separate admin server by default")
+	}
+	for _, path := range []string{"/health", "/stats", "/config"} {
+		if code := doAuthorizedGet(api, path); code != http.StatusOK {
+			t.Errorf("Expected 200 for %s on API server, got %d", path, code)
+		}
+	}
+}
+
+func TestAdminEndpointsOnSeparatePort(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.AdminPort = 9090
+		cfg.EnablePprof = true
+	})
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+
+	api, admin := NewServers(config.GetGlobalConfig())
+	if admin == nil {
+		t.Fatal("Expected separate admin server when admin port is set")
+	}
+
+	for _, path := range []string{"/health", "/stats", "/config", "/debug/pprof/"} {
+		if code := doAuthorizedGet(admin, path); code != http.StatusOK {
+			t.Errorf("Expected 200 for %s on admin server, got %d", path, code)
+		}
+		if code := doAuthorizedGet(api, path); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s on API server, got %d", path, code)
+		}
+	}
+
+	// 管理端口不提供API
+	if code := doAuthorizedGet(admin, "/v1/models"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for /v1/models on admin server, got %d", code)
+	}
+	if code := doAuthorizedGet(api, "/v1/models"); code != http.StatusOK {
+		t.Errorf("Expected 200 for /v1/models on API server, got %d", code)
+	}
+
+	// 管理端口同样需要认证
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without bearer token on admin server, got %d", rec.Code)
+	}
+}
+
+// doAuthorizedPost 发送带Bearer认证的POST请求
+func doAuthorizedPost(e *echo.Echo, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResetTokenEndpoint(t *testing.T) {
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+	b := balancer.NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "jwt-token-1", Name: "Primary"},
+	}, balancer.NewRoundRobinStrategy())
+	jetbrains.SetBalancer(b)
+	b.MarkTokenUnhealthy("jwt-token-1")
+
+	api, _ := NewServers(config.GetGlobalConfig())
+
+	rec := doAuthorizedPost(api, "/admin/tokens/Primary/reset")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats balancer.TokenStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if stats.Name != "Primary" || !stats.Healthy || stats.ErrorCount != 0 {
+		t.Errorf("Expected Primary to be reset, got %+v", stats)
+	}
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token to be healthy after reset, got %d healthy", b.GetHealthyTokenCount())
+	}
+
+	if rec := doAuthorizedPost(api, "/admin/tokens/Unknown/reset"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown token, got %d", rec.Code)
+	}
+}
+
+func TestStatsReportsDuplicateTokens(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {})
+	manager := config.GetGlobalConfig()
+	manager.SetBearerToken(testBearerToken)
+	manager.SetJWTTokens("jwt-a,jwt-b,jwt-a,jwt-c,jwt-b")
+	t.Cleanup(func() {
+		manager.SetJWTTokens("jwt-token-1")
+	})
+	jetbrains.SetBalancer(balancer.NewJWTBalancerWithStrategy(manager.GetConfig().JetbrainsTokens, balancer.NewRoundRobinStrategy()))
+
+	api, _ := NewServers(manager)
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+
+	var resp struct {
+		Balancer struct {
+			HealthyTokens   int `json:"healthy_tokens"`
+			TotalTokens     int `json:"total_tokens"`
+			DuplicateTokens int `json:"duplicate_tokens"`
+		} `json:"balancer"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if resp.Balancer.DuplicateTokens != 2 {
+		t.Errorf("Expected 2 duplicate tokens, got %d", resp.Balancer.DuplicateTokens)
+	}
+	// 健康和总数只统计去重后的token
+	if resp.Balancer.HealthyTokens != 3 || resp.Balancer.TotalTokens != 3 {
+		t.Errorf("Expected 3 healthy of 3 unique tokens, got %d of %d", resp.Balancer.HealthyTokens, resp.Balancer.TotalTokens)
+	}
+}
+
+func TestReadinessFlipsWhenUpstreamIsStale(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ReadinessMaxStaleness = 200 * time.Millisecond
+	})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock)
+	RegisterAdminRoutes(e, config.GetGlobalConfig())
+
+	// 成功的请求之后就绪
+	if rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for chat request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if code := doAuthorizedGet(e, "/readyz"); code != http.StatusOK {
+		t.Fatalf("Expected 200 right after a successful request, got %d", code)
+	}
+
+	// 超过阈值没有成功的上游响应时不再就绪
+	time.Sleep(300 * time.Millisecond)
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 after staleness threshold, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Ready    bool   `json:"ready"`
+		Reason   string `json:"reason"`
+		Upstream struct {
+			SecondsSinceLastSuccess *int64 `json:"seconds_since_last_success"`
+		} `json:"upstream"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	if resp.Ready || resp.Reason == "" || resp.Upstream.SecondsSinceLastSuccess == nil {
+		t.Errorf("Expected not ready with a reason and last success time, got %s", rec.Body.String())
+	}
+}
+
+func TestReadinessIgnoresStalenessByDefault(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.ReadinessMaxStaleness = 0
+	})
+	e := setupTestServer(t, jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok")))
+	RegisterAdminRoutes(e, config.GetGlobalConfig())
+
+	if code := doAuthorizedGet(e, "/readyz"); code != http.StatusOK {
+		t.Errorf("Expected 200 without a staleness threshold, got %d", code)
+	}
+}
+
+func TestRotateBearerTokenEndpoint(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.BearerTokenGracePeriod = time.Hour
+	})
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+	t.Chdir(t.TempDir())
+	api, _ := NewServers(config.GetGlobalConfig())
+
+	doRequest := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const newToken = "rotated-bearer-token"
+	rec := doRequest(http.MethodPost, "/admin/bearer-token", testBearerToken, `{"bearer_token":"`+newToken+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if config.GetGlobalConfig().GetConfig().BearerToken != newToken {
+		t.Errorf("Expected rotated token to be active")
+	}
+
+	// 宽限期内新旧token均可访问
+	for _, token := range []string{newToken, testBearerToken} {
+		if rec := doRequest(http.MethodGet, "/v1/models", token, ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 with token %q during grace window, got %d", token, rec.Code)
+		}
+	}
+	if rec := doRequest(http.MethodGet, "/v1/models", "unknown-token", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown token, got %d", rec.Code)
+	}
+
+	// 新token已写入配置文件
+	data, err := os.ReadFile("config.json")
+	if err != nil {
+		t.Fatalf("Expected rotated config to be persisted: %v", err)
+	}
+	if !strings.Contains(string(data), newToken) {
+		t.Error("Expected persisted config to contain the rotated token")
+	}
+
+	if rec := doRequest(http.MethodPost, "/admin/bearer-token", newToken, `{"bearer_token":""}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty bearer token, got %d", rec.Code)
+	}
+}
+
+func TestDisabledEndpointsReturn404(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {
+		cfg.DisabledEndpoints = []string{"/v1/models", "/reload", "/admin/tokens/:name/reset"}
+	})
+	config.GetGlobalConfig().SetBearerToken(testBearerToken)
+	api, _ := NewServers(config.GetGlobalConfig())
+
+	if code := doAuthorizedGet(api, "/v1/models"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for disabled /v1/models, got %d", code)
+	}
+	for _, path := range []string{"/reload", "/admin/tokens/Primary/reset"} {
+		if rec := doAuthorizedPost(api, path); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for disabled %s, got %d", path, rec.Code)
+		}
+	}
+
+	// 未禁用的端点不受影响
+	for _, path := range []string{"/health", "/stats"} {
+		if code := doAuthorizedGet(api, path); code != http.StatusOK {
+			t.Errorf("Expected 200 for enabled %s, got %d", path, code)
+		}
+	}
+
+	// 未认证的请求仍然返回401
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without bearer token, got %d", rec.Code)
+	}
+}
+
+func TestMetricsSnapshot(t *testing.T) {
+	withConfig(t, func(cfg *config.Config) {})
+	mock := jetbrains.NewScriptedMockUpstreamClient(http.StatusOK, jetbrains.BuildMockSSEStream("ok"))
+	e := setupTestServer(t, mock, "jwt-a", "jwt-b")
+	RegisterAdminRoutes(e, config.GetGlobalConfig())
+
+	// 全局计数在测试之间共享，按差值判断
+	before := jetbrains.GetRequestCounters()
+	for i := 0; i < 3; i++ {
+		if rec := doChatRequest(e, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for chat request, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/metrics.json", nil)
+	req.Header.Set("Authorization", "Bearer "+testBearerToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &sections); err != nil {
+		t.Fatalf("Failed to decode metrics: %v", err)
+	}
+	for _, section := range []string{"generated_at", "counters", "balancer", "tokens", "endpoints", "upstream"} {
+		if _, ok := sections[section]; !ok {
+			t.Errorf("Expected section %q in metrics, got %s", section, rec.Body.String())
+		}
+	}
+
+	var resp struct {
+		Counters jetbrains.RequestCounters `json:"counters"`
+		Balancer struct {
+			HealthyTokens int `json:"healthy_tokens"`
+			TotalTokens   int `json:"total_tokens"`
+		} `json:"balancer"`
+		Tokens   []balancer.TokenStats `json:"tokens"`
+		Upstream struct {
+			SecondsSinceLastRequestSuccess *int64 `json:"seconds_since_last_request_success"`
+		} `json:"upstream"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode metrics: %v", err)
+	}
+	if got := resp.Counters.Requests - before.Requests; got != 3 {
+		t.Errorf("Expected 3 requests counted, got %d", got)
+	}
+	if got := resp.Counters.UpstreamAttempts - before.UpstreamAttempts; got != 3 {
+		t.Errorf("Expected 3 upstream attempts counted, got %d", got)
+	}
+	if resp.Balancer.HealthyTokens != 2 || resp.Balancer.TotalTokens != 2 {
+		t.Errorf("Expected 2 healthy of 2 tokens, got %d of %d", resp.Balancer.HealthyTokens, resp.Balancer.TotalTokens)
+	}
+	var requests int64
+	for _, token := range resp.Tokens {
+		requests += token.Requests
+		if token.TotalLatency.Count == 0 {
+			t.Errorf("Expected latency samples for token %s, got %+v", token.Name, token.TotalLatency)
+		}
+	}
+	if len(resp.Tokens) != 2 || requests != 3 {
+		t.Errorf("Expected 3 requests across 2 tokens, got %d across %+v", requests, resp.Tokens)
+	}
+	if resp.Upstream.SecondsSinceLastRequestSuccess == nil {
+		t.Error("Expected last request success to be reported")
+	}
+}
@@ -0,0 +1,131 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// consistentHashReplicas 每个token在哈希环上的虚拟节点数，越多分布越均匀
+const consistentHashReplicas = 160
+
+// KeyedSelectionStrategy 支持按请求键选择token的策略，同一个键在token集合不变时总是选中同一个token
+type KeyedSelectionStrategy interface {
+	SelectionStrategy
+	SelectByKey(candidates []*TokenStatus, key string) *TokenStatus
+}
+
+// selectWithKey 策略支持按键选择且键非空时按键选择，否则使用普通选择
+func selectWithKey(s SelectionStrategy, candidates []*TokenStatus, key string) *TokenStatus {
+	if keyed, ok := s.(KeyedSelectionStrategy); ok && key != "" {
+		return keyed.SelectByKey(candidates, key)
+	}
+	return s.Select(candidates)
+}
+
+// hashRing 由token虚拟节点组成的哈希环
+type hashRing struct {
+	signature string   // 构建时的token集合，用于判断是否需要重建
+	hashes    []uint64 // 升序排列的虚拟节点哈希
+	owners    []string // 与hashes一一对应的token
+}
+
+// consistentHashStrategy 一致性哈希策略：健康token集合变化时只有约1/N的键被重新分配
+type consistentHashStrategy struct {
+	fallback SelectionStrategy
+	ring     *hashRing
+	mutex    sync.Mutex
+}
+
+// NewConsistentHashStrategy 创建一致性哈希策略，没有请求键时按轮询选择
+func NewConsistentHashStrategy() SelectionStrategy {
+	return &consistentHashStrategy{fallback: NewRoundRobinStrategy()}
+}
+
+// Select 没有请求键时交给后备策略
+func (s *consistentHashStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	return s.fallback.Select(candidates)
+}
+
+// SelectByKey 在哈希环上顺时针找到键对应的第一个token
+func (s *consistentHashStrategy) SelectByKey(candidates []*TokenStatus, key string) *TokenStatus {
+	ring := s.ringFor(candidates)
+
+	hash := hashKey(key)
+	index := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= hash
+	})
+	if index == len(ring.hashes) {
+		index = 0
+	}
+
+	owner := ring.owners[index]
+	for _, status := range candidates {
+		if status.Token == owner {
+			return status
+		}
+	}
+	return candidates[0]
+}
+
+// ringFor 返回候选token对应的哈希环，token集合变化时重建
+func (s *consistentHashStrategy) ringFor(candidates []*TokenStatus) *hashRing {
+	tokens := make([]string, len(candidates))
+	for i, status := range candidates {
+		tokens[i] = status.Token
+	}
+	signature := strings.Join(tokens, "\x00")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ring == nil || s.ring.signature != signature {
+		s.ring = newHashRing(tokens, signature)
+	}
+	return s.ring
+}
+
+// newHashRing 为每个token生成虚拟节点并排序
+func newHashRing(tokens []string, signature string) *hashRing {
+	type node struct {
+		hash  uint64
+		owner string
+	}
+
+	nodes := make([]node, 0, len(tokens)*consistentHashReplicas)
+	for _, token := range tokens {
+		for i := 0; i < consistentHashReplicas; i++ {
+			nodes = append(nodes, node{hash: hashKey(token + "#" + strconv.Itoa(i)), owner: token})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].hash < nodes[j].hash
+	})
+
+	ring := &hashRing{
+		signature: signature,
+		hashes:    make([]uint64, len(nodes)),
+		owners:    make([]string, len(nodes)),
+	}
+	for i, n := range nodes {
+		ring.hashes[i] = n.hash
+		ring.owners[i] = n.owner
+	}
+	return ring
+}
+
+// hashKey 计算键在哈希环上的位置；FNV对只有末尾不同的短字符串分散较差，再做一次混淆使其均匀分布
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
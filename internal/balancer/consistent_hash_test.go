@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"fmt"
+	"jetbrains-ai-proxy/internal/config"
+	"testing"
+)
+
+// assignKeys 记录每个键在给定token集合下被分配到的token
+func assignKeys(t *testing.T, tokens []string, keys int) map[string]string {
+	t.Helper()
+
+	b := NewJWTBalancer(tokens, config.ConsistentHash)
+	assignment := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		token, err := b.GetTokenForKey(key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assignment[key] = token
+	}
+	return assignment
+}
+
+func TestConsistentHashStableAssignment(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1", "token2", "token3"}, config.ConsistentHash)
+
+	first, _ := b.GetTokenForKey("alice")
+	for i := 0; i < 10; i++ {
+		if token, _ := b.GetTokenForKey("alice"); token != first {
+			t.Fatalf("Expected alice to stay on %s, got %s", first, token)
+		}
+	}
+}
+
+func TestConsistentHashChurnOnTokenAdded(t *testing.T) {
+	const keys = 10000
+	before := assignKeys(t, []string{"token1", "token2", "token3", "token4"}, keys)
+	after := assignKeys(t, []string{"token1", "token2", "token3", "token4", "token5"}, keys)
+
+	moved := 0
+	for key, token := range after {
+		if token != before[key] {
+			moved++
+			// 只应迁移到新增的token，已有token之间不互相迁移
+			if token != "token5" {
+				t.Errorf("Expected %s to move only to the new token, moved from %s to %s", key, before[key], token)
+			}
+		}
+	}
+
+	// 理想的迁移比例为1/5
+	ratio := float64(moved) / keys
+	if ratio < 0.1 || ratio > 0.3 {
+		t.Errorf("Expected roughly 1/5 of keys to be remapped, got %.3f", ratio)
+	}
+	t.Logf("Remapped %.1f%% of keys after adding a fifth token", ratio*100)
+}
+
+func TestConsistentHashChurnOnTokenUnhealthy(t *testing.T) {
+	const keys = 2000
+	b := NewJWTBalancer([]string{"token1", "token2", "token3", "token4"}, config.ConsistentHash)
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		before[key], _ = b.GetTokenForKey(key)
+	}
+
+	// 不健康的token被移出哈希环，只有原本分配给它的键迁移
+	b.MarkTokenUnhealthy("token2")
+	for key, previous := range before {
+		token, _ := b.GetTokenForKey(key)
+		if previous != "token2" && token != previous {
+			t.Errorf("Expected %s to stay on %s, got %s", key, previous, token)
+		}
+		if token == "token2" {
+			t.Errorf("Expected unhealthy token2 not to be selected for %s", key)
+		}
+	}
+}
+
+func TestConsistentHashWithoutKey(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1", "token2"}, config.ConsistentHash)
+
+	// 没有请求键时按轮询选择
+	expectedOrder := []string{"token1", "token2", "token1"}
+	for i, expected := range expectedOrder {
+		if token, _ := b.GetToken(); token != expected {
+			t.Errorf("At iteration %d, expected %s, got %s", i, expected, token)
+		}
+	}
+}
+
+func TestConsistentHashWithinPriorityTier(t *testing.T) {
+	tokens := []config.JWTTokenConfig{
+		{Token: "primary1", Priority: 1},
+		{Token: "overflow1", Priority: 2},
+		{Token: "primary2", Priority: 1},
+	}
+	b := NewJWTBalancerWithStrategy(tokens, BuildSelectionStrategy(&config.Config{
+		LoadBalanceStrategy: config.ConsistentHash,
+		PriorityTiers:       true,
+	}))
+
+	// 包装策略同样按键选择，且只在最高优先级层内
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		first, _ := b.GetTokenForKey(key)
+		second, _ := b.GetTokenForKey(key)
+		if first != second {
+			t.Errorf("Expected %s to be sticky, got %s then %s", key, first, second)
+		}
+		if first == "overflow1" {
+			t.Errorf("Expected %s to stay within tier 1, got %s", key, first)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"jetbrains-ai-proxy/internal/types"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,12 +15,23 @@ type HealthChecker struct {
 	balancer      JWTBalancer
 	client        *resty.Client
 	checkInterval time.Duration
+	initialDelay  time.Duration
+	skipInitial   bool
 	timeout       time.Duration
 	maxRetries    int
+	stateFile     string
+	prompt        string
 	stopChan      chan struct{}
-	wg            sync.WaitGroup
-	running       bool
-	mutex         sync.RWMutex
+	// ctx 在Stop时取消，进行中的检查请求随之中止
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	running bool
+	mutex   sync.RWMutex
+	// lastSuccess 最近一次有token检查成功的时间（UnixNano）；Stop持有锁等待检查结束，因此不使用mutex
+	lastSuccess atomic.Int64
+	// check 执行一次检查，测试中可替换
+	check func()
 }
 
 // NewHealthChecker 创建健康检查器
@@ -30,14 +42,18 @@ func NewHealthChecker(balancer JWTBalancer) *HealthChecker {
 			"Content-Type": "application/json",
 		})
 
-	return &HealthChecker{
+	hc := &HealthChecker{
 		balancer:      balancer,
 		client:        client,
 		checkInterval: 30 * time.Second, // 每30秒检查一次
 		timeout:       10 * time.Second,
 		maxRetries:    3,
+		prompt:        types.PROMPT,
 		stopChan:      make(chan struct{}),
 	}
+	hc.ctx, hc.cancel = context.WithCancel(context.Background())
+	hc.check = hc.performHealthCheck
+	return hc
 }
 
 // Start 启动健康检查
@@ -52,39 +68,55 @@ func (hc *HealthChecker) Start() {
 	hc.running = true
 	hc.wg.Add(1)
 
-	go hc.healthCheckLoop()
+	// 启动参数在持有锁时读取
+	go hc.healthCheckLoop(hc.initialDelay, hc.skipInitial)
 	log.Println("JWT health checker started")
 }
 
 // Stop 停止健康检查
 func (hc *HealthChecker) Stop() {
 	hc.mutex.Lock()
-	defer hc.mutex.Unlock()
-
 	if !hc.running {
+		hc.mutex.Unlock()
 		return
 	}
 
 	hc.running = false
 	close(hc.stopChan)
+	// 中止进行中的检查请求，不等待其超时
+	hc.cancel()
+	hc.mutex.Unlock()
+
+	// 检查过程中需要读锁，等待时不能持有锁
 	hc.wg.Wait()
 	log.Println("JWT health checker stopped")
 }
 
 // healthCheckLoop 健康检查循环
-func (hc *HealthChecker) healthCheckLoop() {
+func (hc *HealthChecker) healthCheckLoop(initialDelay time.Duration, skipInitial bool) {
 	defer hc.wg.Done()
 
+	// 启动时默认立即执行一次检查；可延迟执行，或跳过并等待第一个检查周期
+	if !skipInitial {
+		if initialDelay > 0 {
+			timer := time.NewTimer(initialDelay)
+			select {
+			case <-timer.C:
+			case <-hc.stopChan:
+				timer.Stop()
+				return
+			}
+		}
+		hc.check()
+	}
+
 	ticker := time.NewTicker(hc.checkInterval)
 	defer ticker.Stop()
 
-	// 启动时立即执行一次检查
-	hc.performHealthCheck()
-
 	for {
 		select {
 		case <-ticker.C:
-			hc.performHealthCheck()
+			hc.check()
 		case <-hc.stopChan:
 			return
 		}
@@ -96,7 +128,8 @@ func (hc *HealthChecker) performHealthCheck() {
 	log.Println("Performing JWT health check...")
 
 	// 获取所有tokens进行检查
-	baseBalancer, ok := hc.balancer.(*BaseBalancer)
+	jwtBalancer := hc.getBalancer()
+	baseBalancer, ok := jwtBalancer.(*BaseBalancer)
 	if !ok {
 		log.Println("Warning: Cannot access tokens for health check")
 		return
@@ -104,7 +137,11 @@ func (hc *HealthChecker) performHealthCheck() {
 
 	baseBalancer.mutex.RLock()
 	tokens := make([]string, 0, len(baseBalancer.tokens))
-	for token := range baseBalancer.tokens {
+	for token, status := range baseBalancer.tokens {
+		// 禁用和等待移除的token不做健康检查
+		if status.Disabled || status.Draining {
+			continue
+		}
 		tokens = append(tokens, token)
 	}
 	baseBalancer.mutex.RUnlock()
@@ -120,19 +157,44 @@ func (hc *HealthChecker) performHealthCheck() {
 	}
 	wg.Wait()
 
-	healthyCount := hc.balancer.GetHealthyTokenCount()
-	totalCount := hc.balancer.GetTotalTokenCount()
+	// 停止时中止的检查没有结果，不记录也不持久化
+	if hc.ctx.Err() != nil {
+		return
+	}
+
+	healthyCount := jwtBalancer.GetHealthyTokenCount()
+	totalCount := jwtBalancer.GetTotalTokenCount()
 	log.Printf("Health check completed: %d/%d tokens healthy", healthyCount, totalCount)
+
+	hc.saveState()
+}
+
+// saveState 持久化最新的健康检查结果（未配置状态文件时跳过）
+func (hc *HealthChecker) saveState() {
+	hc.mutex.RLock()
+	stateFile := hc.stateFile
+	hc.mutex.RUnlock()
+
+	if stateFile == "" {
+		return
+	}
+	if err := SaveHealthState(hc.getBalancer(), stateFile); err != nil {
+		log.Printf("Warning: failed to persist health state: %v", err)
+	}
 }
 
 // checkTokenHealth 检查单个token的健康状态
 func (hc *HealthChecker) checkTokenHealth(token string) {
-	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	ctx, cancel := context.WithTimeout(hc.ctx, hc.timeout)
 	defer cancel()
 
+	hc.mutex.RLock()
+	prompt := hc.prompt
+	hc.mutex.RUnlock()
+
 	// 创建一个简单的测试请求
 	testRequest := &types.JetbrainsRequest{
-		Prompt:  types.PROMPT,
+		Prompt:  prompt,
 		Profile: "openai-gpt-4o", // 使用一个通用的profile进行测试
 		Chat: types.ChatField{
 			MessageField: []types.MessageField{
@@ -151,16 +213,24 @@ func (hc *HealthChecker) checkTokenHealth(token string) {
 			break
 		}
 
-		// 重试前等待一小段时间
+		// 重试前等待一小段时间，停止时立即放弃
 		if retry < hc.maxRetries-1 {
-			time.Sleep(time.Second)
+			select {
+			case <-time.After(time.Second):
+			case <-hc.ctx.Done():
+			}
+		}
+		if hc.ctx.Err() != nil {
+			// 检查被中止，保持token原有状态
+			return
 		}
 	}
 
 	if success {
-		hc.balancer.MarkTokenHealthy(token)
+		hc.getBalancer().MarkTokenHealthy(token)
+		hc.lastSuccess.Store(time.Now().UnixNano())
 	} else {
-		hc.balancer.MarkTokenUnhealthy(token)
+		hc.getBalancer().MarkTokenUnhealthy(token)
 		log.Printf("JWT token health check failed: %s...", token[:min(len(token), 10)])
 	}
 }
@@ -194,6 +264,28 @@ func (hc *HealthChecker) testTokenRequest(ctx context.Context, token string, req
 	return false
 }
 
+// LastSuccess 最近一次有token检查成功的时间，尚未成功过时返回零值
+func (hc *HealthChecker) LastSuccess() time.Time {
+	if nanos := hc.lastSuccess.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// SetBalancer 替换检查的负载均衡器（配置重载时使用）
+func (hc *HealthChecker) SetBalancer(balancer JWTBalancer) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.balancer = balancer
+}
+
+// getBalancer 获取当前检查的负载均衡器
+func (hc *HealthChecker) getBalancer() JWTBalancer {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	return hc.balancer
+}
+
 // SetCheckInterval 设置检查间隔
 func (hc *HealthChecker) SetCheckInterval(interval time.Duration) {
 	hc.mutex.Lock()
@@ -201,6 +293,41 @@ func (hc *HealthChecker) SetCheckInterval(interval time.Duration) {
 	hc.checkInterval = interval
 }
 
+// SetPrompt 设置健康检查请求的prompt标识，为空时使用默认值
+func (hc *HealthChecker) SetPrompt(prompt string) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	if prompt == "" {
+		prompt = types.PROMPT
+	}
+	hc.prompt = prompt
+}
+
+// SetUserAgent 设置健康检查请求的User-Agent，为空时使用resty默认值；需在Start之前调用
+func (hc *HealthChecker) SetUserAgent(userAgent string) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	if userAgent == "" {
+		hc.client.Header.Del("User-Agent")
+		return
+	}
+	hc.client.SetHeader("User-Agent", userAgent)
+}
+
+// SetInitialDelay 设置启动后第一次检查前的等待时间，需在Start之前调用
+func (hc *HealthChecker) SetInitialDelay(delay time.Duration) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.initialDelay = delay
+}
+
+// SetSkipInitialCheck 设置是否跳过启动时的检查，需在Start之前调用
+func (hc *HealthChecker) SetSkipInitialCheck(skip bool) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.skipInitial = skip
+}
+
 // SetTimeout 设置请求超时
 func (hc *HealthChecker) SetTimeout(timeout time.Duration) {
 	hc.mutex.Lock()
@@ -208,6 +335,13 @@ func (hc *HealthChecker) SetTimeout(timeout time.Duration) {
 	hc.timeout = timeout
 }
 
+// SetStateFile 设置健康状态持久化文件，为空时不持久化
+func (hc *HealthChecker) SetStateFile(path string) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.stateFile = path
+}
+
 // SetMaxRetries 设置最大重试次数
 func (hc *HealthChecker) SetMaxRetries(retries int) {
 	hc.mutex.Lock()
@@ -0,0 +1,170 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingHealthChecker 创建只计数、不发送请求的健康检查器
+func newCountingHealthChecker(t *testing.T) (*HealthChecker, *int32) {
+	t.Helper()
+
+	var checks int32
+	hc := NewHealthChecker(NewJWTBalancer([]string{"token1"}, config.RoundRobin))
+	hc.check = func() {
+		atomic.AddInt32(&checks, 1)
+	}
+	hc.SetCheckInterval(time.Hour)
+	t.Cleanup(hc.Stop)
+	return hc, &checks
+}
+
+// waitForChecks 等待检查次数达到预期，超时返回false
+func waitForChecks(checks *int32, expected int32, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt32(checks) >= expected {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestHealthCheckRunsImmediatelyByDefault(t *testing.T) {
+	hc, checks := newCountingHealthChecker(t)
+	hc.Start()
+
+	if !waitForChecks(checks, 1, time.Second) {
+		t.Error("Expected initial check to run immediately")
+	}
+}
+
+func TestHealthCheckStartDelay(t *testing.T) {
+	hc, checks := newCountingHealthChecker(t)
+	hc.SetInitialDelay(200 * time.Millisecond)
+	hc.Start()
+
+	// 延迟期间不应执行检查
+	time.Sleep(50 * time.Millisecond)
+	if count := atomic.LoadInt32(checks); count != 0 {
+		t.Fatalf("Expected no check during initial delay, got %d", count)
+	}
+	if !waitForChecks(checks, 1, time.Second) {
+		t.Error("Expected check to run after initial delay")
+	}
+}
+
+func TestHealthCheckSkipInitial(t *testing.T) {
+	hc, checks := newCountingHealthChecker(t)
+	hc.SetCheckInterval(100 * time.Millisecond)
+	hc.SetSkipInitialCheck(true)
+	hc.Start()
+
+	time.Sleep(30 * time.Millisecond)
+	if count := atomic.LoadInt32(checks); count != 0 {
+		t.Fatalf("Expected initial check to be skipped, got %d", count)
+	}
+	if !waitForChecks(checks, 1, time.Second) {
+		t.Error("Expected check to run after first interval")
+	}
+}
+
+func TestHealthCheckStopDuringInitialDelay(t *testing.T) {
+	hc, checks := newCountingHealthChecker(t)
+	hc.SetInitialDelay(time.Hour)
+	hc.Start()
+
+	// 延迟期间停止不应阻塞
+	done := make(chan struct{})
+	go func() {
+		hc.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop to return during initial delay")
+	}
+	if count := atomic.LoadInt32(checks); count != 0 {
+		t.Errorf("Expected no checks, got %d", count)
+	}
+}
+
+// headerRecorder 记录请求头并返回200的RoundTripper
+type headerRecorder struct {
+	userAgent string
+}
+
+func (r *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.userAgent = req.Header.Get("User-Agent")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestHealthCheckUserAgent(t *testing.T) {
+	hc := NewHealthChecker(NewJWTBalancer([]string{"token1"}, config.RoundRobin))
+	recorder := &headerRecorder{}
+	hc.client.SetTransport(recorder)
+	hc.SetUserAgent("JetBrains-IDE/2025.1")
+
+	if !hc.testTokenRequest(context.Background(), "token1", &types.JetbrainsRequest{}) {
+		t.Fatal("Expected health check request to succeed")
+	}
+	if recorder.userAgent != "JetBrains-IDE/2025.1" {
+		t.Errorf("Expected configured User-Agent on health check requests, got %q", recorder.userAgent)
+	}
+}
+
+// slowTransport 模拟响应很慢的上游，直到请求被取消才返回
+type slowTransport struct {
+	started chan struct{}
+	once    sync.Once
+}
+
+func (s *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.once.Do(func() { close(s.started) })
+	select {
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-time.After(10 * time.Second):
+		return nil, errors.New("slow upstream timed out")
+	}
+}
+
+func TestHealthCheckStopCancelsInFlightProbes(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1", "token2"}, config.RoundRobin)
+	hc := NewHealthChecker(b)
+	transport := &slowTransport{started: make(chan struct{})}
+	hc.client.SetTransport(transport)
+	hc.SetCheckInterval(time.Hour)
+	hc.Start()
+
+	select {
+	case <-transport.started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected health check probe to start")
+	}
+
+	start := time.Now()
+	hc.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Stop to abort in-flight probes promptly, took %v", elapsed)
+	}
+	// 中止的检查不影响token状态
+	if b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected aborted probes to leave tokens healthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
@@ -0,0 +1,159 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrPersistenceUnsupported 负载均衡器实现不支持导出和恢复健康状态
+var ErrPersistenceUnsupported = errors.New("balancer does not support health state persistence")
+
+// TokenHealthState 持久化的token健康状态（只保存token指纹，不保存原始token）
+type TokenHealthState struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name,omitempty"`
+	Healthy     bool      `json:"healthy"`
+	ErrorCount  int64     `json:"error_count"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// QuotaResetAt 配额耗尽token的配额重置时间
+	QuotaResetAt *time.Time `json:"quota_reset_at,omitempty"`
+	// UnsupportedProfiles 上游拒绝该token使用的profile及停用的到期时间
+	UnsupportedProfiles map[string]time.Time `json:"unsupported_profiles,omitempty"`
+}
+
+// tokenFingerprint 计算token指纹
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExportHealthState 导出当前所有token的健康状态
+func (b *BaseBalancer) ExportHealthState() []TokenHealthState {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	now := time.Now()
+	states := make([]TokenHealthState, 0, len(b.order))
+	for _, token := range b.order {
+		status := b.tokens[token]
+		// draining token已从配置中移除，不再持久化
+		if status.Draining {
+			continue
+		}
+		state := TokenHealthState{
+			Fingerprint: tokenFingerprint(token),
+			Name:        status.Name,
+			Healthy:     status.Healthy,
+			ErrorCount:  status.ErrorCount,
+			UpdatedAt:   now,
+		}
+		if status.quotaExhausted(now) {
+			resetAt := time.Unix(0, status.QuotaResetAt)
+			state.QuotaResetAt = &resetAt
+		}
+		for profile, until := range status.UnsupportedProfiles {
+			if status.profileUnsupported(profile, now) {
+				if state.UnsupportedProfiles == nil {
+					state.UnsupportedProfiles = make(map[string]time.Time)
+				}
+				state.UnsupportedProfiles[profile] = until
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// ImportHealthState 按指纹恢复token健康状态，返回恢复的token数量
+func (b *BaseBalancer) ImportHealthState(states []TokenHealthState) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	byFingerprint := make(map[string]TokenHealthState, len(states))
+	for _, state := range states {
+		byFingerprint[state.Fingerprint] = state
+	}
+
+	restored := 0
+	for token, status := range b.tokens {
+		if state, ok := byFingerprint[tokenFingerprint(token)]; ok {
+			status.Healthy = state.Healthy
+			status.ErrorCount = state.ErrorCount
+			if state.QuotaResetAt != nil {
+				status.QuotaResetAt = state.QuotaResetAt.UnixNano()
+			}
+			if len(state.UnsupportedProfiles) > 0 {
+				status.UnsupportedProfiles = maps.Clone(state.UnsupportedProfiles)
+			}
+			restored++
+		}
+	}
+	return restored
+}
+
+// CopyHealthState 将from中token的健康状态复制到to中相同的token，用于替换负载均衡器时保留状态
+func CopyHealthState(from, to JWTBalancer) int {
+	source, ok := from.(*BaseBalancer)
+	if !ok {
+		return 0
+	}
+	target, ok := to.(*BaseBalancer)
+	if !ok {
+		return 0
+	}
+	return target.ImportHealthState(source.ExportHealthState())
+}
+
+// SaveHealthState 将负载均衡器的token健康状态写入状态文件
+func SaveHealthState(b JWTBalancer, path string) error {
+	baseBalancer, ok := b.(*BaseBalancer)
+	if !ok {
+		return ErrPersistenceUnsupported
+	}
+
+	data, err := json.MarshalIndent(baseBalancer.ExportHealthState(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal health state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create health state directory: %w", err)
+	}
+
+	// 先写临时文件再重命名，避免留下不完整的状态文件
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write health state: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace health state: %w", err)
+	}
+	return nil
+}
+
+// LoadHealthState 从状态文件恢复token健康状态，返回恢复的token数量
+func LoadHealthState(b JWTBalancer, path string) (int, error) {
+	baseBalancer, ok := b.(*BaseBalancer)
+	if !ok {
+		return 0, ErrPersistenceUnsupported
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read health state: %w", err)
+	}
+
+	var states []TokenHealthState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return 0, fmt.Errorf("failed to parse health state: %w", err)
+	}
+
+	return baseBalancer.ImportHealthState(states), nil
+}
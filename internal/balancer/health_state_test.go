@@ -0,0 +1,153 @@
+package balancer
+
+import (
+	"errors"
+	"io/fs"
+	"jetbrains-ai-proxy/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "health.json")
+	tokens := []string{"token1", "token2", "token3"}
+
+	balancer := NewJWTBalancer(tokens, config.RoundRobin)
+	balancer.MarkTokenUnhealthy("token2")
+	balancer.MarkTokenUnhealthy("token2")
+
+	if err := SaveHealthState(balancer, path); err != nil {
+		t.Fatalf("Unexpected error saving state: %v", err)
+	}
+
+	// 状态文件中不能出现原始token
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state: %v", err)
+	}
+	for _, token := range tokens {
+		if strings.Contains(string(data), `"`+token+`"`) {
+			t.Errorf("State file leaks raw token %s", token)
+		}
+	}
+
+	restoredBalancer := NewJWTBalancer(tokens, config.RoundRobin)
+	restored, err := LoadHealthState(restoredBalancer, path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading state: %v", err)
+	}
+	if restored != 3 {
+		t.Errorf("Expected 3 restored tokens, got %d", restored)
+	}
+	if restoredBalancer.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected 2 healthy tokens after restore, got %d", restoredBalancer.GetHealthyTokenCount())
+	}
+
+	status := restoredBalancer.(*BaseBalancer).tokens["token2"]
+	if status.Healthy || status.ErrorCount != 2 {
+		t.Errorf("Expected token2 unhealthy with 2 errors, got healthy=%v errors=%d", status.Healthy, status.ErrorCount)
+	}
+}
+
+func TestPersistedUnhealthyTokenNotSelected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.json")
+	tokens := []string{"token1", "dead-token"}
+
+	previous := NewJWTBalancer(tokens, config.RoundRobin)
+	previous.MarkTokenUnhealthy("dead-token")
+	if err := SaveHealthState(previous, path); err != nil {
+		t.Fatalf("Unexpected error saving state: %v", err)
+	}
+
+	// 模拟重启：新的负载均衡器在首次健康检查前加载持久化状态
+	balancer := NewJWTBalancer(tokens, config.RoundRobin)
+	if _, err := LoadHealthState(balancer, path); err != nil {
+		t.Fatalf("Unexpected error loading state: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		token, err := balancer.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if token == "dead-token" {
+			t.Fatal("Persisted unhealthy token was selected before the first health check")
+		}
+	}
+}
+
+func TestLoadHealthStateIgnoresUnknownTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.json")
+
+	previous := NewJWTBalancer([]string{"removed-token"}, config.RoundRobin)
+	previous.MarkTokenUnhealthy("removed-token")
+	if err := SaveHealthState(previous, path); err != nil {
+		t.Fatalf("Unexpected error saving state: %v", err)
+	}
+
+	balancer := NewJWTBalancer([]string{"new-token"}, config.RoundRobin)
+	restored, err := LoadHealthState(balancer, path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading state: %v", err)
+	}
+	if restored != 0 || balancer.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected no restored tokens and 1 healthy, got restored=%d healthy=%d", restored, balancer.GetHealthyTokenCount())
+	}
+}
+
+func TestLoadHealthStateMissingFile(t *testing.T) {
+	balancer := NewJWTBalancer([]string{"token1"}, config.RoundRobin)
+	if _, err := LoadHealthState(balancer, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing state file")
+	}
+}
+
+func TestHealthStateErrors(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1"}, config.RoundRobin)
+
+	if _, err := LoadHealthState(b, filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected wrapped fs.ErrNotExist, got %v", err)
+	}
+	if _, err := LoadHealthState(nil, "state.json"); !errors.Is(err, ErrPersistenceUnsupported) {
+		t.Errorf("Expected ErrPersistenceUnsupported, got %v", err)
+	}
+	if err := SaveHealthState(nil, "state.json"); !errors.Is(err, ErrPersistenceUnsupported) {
+		t.Errorf("Expected ErrPersistenceUnsupported, got %v", err)
+	}
+}
+
+func TestProfileRejectionCarriedAcrossSwapAndExpires(t *testing.T) {
+	const profile = "anthropic-claude-3.7-sonnet"
+	from := NewJWTBalancer([]string{"token1", "token2"}, config.RoundRobin).(*BaseBalancer)
+	from.MarkProfileUnsupported("token1", profile)
+
+	// 替换负载均衡器（如/reload）后仍不为该profile选择被拒绝的token
+	to := NewJWTBalancer([]string{"token1", "token2"}, config.RoundRobin).(*BaseBalancer)
+	CopyHealthState(from, to)
+	for i := 0; i < 4; i++ {
+		if token, _ := to.GetTokenForProfile("", ServiceTierDefault, profile); token != "token2" {
+			t.Fatalf("Expected rejected token to stay excluded after swap, got %s", token)
+		}
+	}
+
+	// 所有可用token都被拒绝时报告模型不可用，而不是没有健康token
+	to.MarkProfileUnsupported("token2", profile)
+	if _, err := to.GetTokenForProfile("", ServiceTierDefault, profile); !errors.Is(err, ErrProfileUnavailable) {
+		t.Errorf("Expected ErrProfileUnavailable, got %v", err)
+	}
+	if _, err := to.GetTokenForProfile("", ServiceTierDefault, "openai-gpt-4o"); err != nil {
+		t.Errorf("Expected other profiles to be served, got %v", err)
+	}
+
+	// 到期后重新尝试该token
+	to.tokens["token1"].UnsupportedProfiles[profile] = time.Now().Add(-time.Second)
+	if token, err := to.GetTokenForProfile("", ServiceTierDefault, profile); err != nil || token != "token1" {
+		t.Errorf("Expected expired rejection to be retried, got %s, %v", token, err)
+	}
+	if stats := to.GetTokenStats(); len(stats[0].UnsupportedProfiles) != 0 {
+		t.Errorf("Expected expired rejection to be hidden from stats, got %v", stats[0].UnsupportedProfiles)
+	}
+}
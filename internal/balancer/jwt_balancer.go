@@ -1,99 +1,285 @@
 package balancer
 
 import (
+	"errors"
 	"fmt"
 	"jetbrains-ai-proxy/internal/config"
-	"math/rand"
+	"maps"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrNoHealthyTokens 没有健康可用的token，属于暂时性的容量问题
+var ErrNoHealthyTokens = errors.New("no healthy JWT tokens available")
+
+// ErrProfileUnavailable 可用的token都被上游拒绝使用请求的profile，属于模型不可用而不是暂时性的容量问题
+var ErrProfileUnavailable = errors.New("no JWT token can serve the requested profile")
+
+// ProfileRejectionTTL 上游拒绝token使用某profile后停用该组合的时长，到期后重新尝试（token的权限可能已经开通）
+const ProfileRejectionTTL = 30 * time.Minute
+
 // JWTBalancer JWT负载均衡器接口
 type JWTBalancer interface {
 	GetToken() (string, error)
+	GetTokenForKey(key string) (string, error)
 	MarkTokenUnhealthy(token string)
 	MarkTokenHealthy(token string)
+	ReleaseToken(token string)
 	GetHealthyTokenCount() int
 	GetTotalTokenCount() int
 	RefreshTokens(tokens []string)
+	RefreshTokenConfigs(tokens []config.JWTTokenConfig)
 }
 
 // TokenStatus token状态
 type TokenStatus struct {
-	Token     string
-	Healthy   bool
-	LastUsed  time.Time
+	Token      string
+	Name       string
+	Priority   int
+	Metadata   map[string]string // 配置中的元数据
+	Healthy    bool
+	Disabled   bool  // 配置中禁用，不参与选择和健康检查
+	Draining   bool  // 已从配置中移除但仍有在途请求，不参与选择，在途请求结束后删除
+	LastUsed   int64 // 最后使用时间（UnixNano），GetToken只持有读锁，需原子读写
 	ErrorCount int64
+	Requests   int64          // 被选中处理请求的总次数
+	InFlight   int64          // 已选出但尚未释放的请求数
+	Rate       *RateWindow    // 最近的请求速率
+	Errors     *RateWindow    // 最近的请求失败次数，与Rate一起计算错误率
+	FirstByte  *LatencyWindow // 最近的首字节延迟
+	Latency    *LatencyWindow // 最近的完整请求延迟
+	// QuotaResetAt 配额耗尽时已知的配额重置时间（UnixNano），在此之前即使健康检查通过也不参与选择
+	QuotaResetAt int64
+	// UnsupportedProfiles 上游拒绝该token使用的profile及停用的到期时间，不影响token处理其他模型的请求
+	UnsupportedProfiles map[string]time.Time
+}
+
+// TokenStats token的运行统计（不包含原始token）
+type TokenStats struct {
+	Name       string  `json:"name"`
+	Priority   int     `json:"priority"`
+	Healthy    bool    `json:"healthy"`
+	Status     string  `json:"status"`
+	ErrorCount int64   `json:"error_count"`
+	Requests   int64   `json:"requests"`
+	InFlight   int64   `json:"in_flight"`
+	RPS        float64 `json:"rps"`
+	ErrorRate  float64 `json:"error_rate"`
+	// QuotaResetAt 配额耗尽时已知的配额重置时间，配额未耗尽时省略
+	QuotaResetAt *time.Time `json:"quota_reset_at,omitempty"`
+	// UnsupportedProfiles 上游拒绝该token使用的profile
+	UnsupportedProfiles []string `json:"unsupported_profiles,omitempty"`
+	// FirstByteLatency 从发送请求到收到首个事件的延迟分位数
+	FirstByteLatency LatencyPercentiles `json:"first_byte_latency"`
+	// TotalLatency 从发送请求到响应体读取完毕的延迟分位数
+	TotalLatency LatencyPercentiles `json:"total_latency"`
+}
+
+// LastUsedAt 返回token最后被选择的时间
+func (s *TokenStatus) LastUsedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.LastUsed))
+}
+
+// stats 生成token的运行统计
+func (s *TokenStatus) stats() TokenStats {
+	stats := TokenStats{
+		Name:       s.Name,
+		Priority:   s.Priority,
+		Healthy:    s.Healthy,
+		Status:     s.state(),
+		ErrorCount: atomic.LoadInt64(&s.ErrorCount),
+		Requests:   atomic.LoadInt64(&s.Requests),
+		InFlight:   atomic.LoadInt64(&s.InFlight),
+		RPS:        s.Rate.Rate(),
+		ErrorRate:  s.errorRateAt(time.Now()),
+
+		FirstByteLatency: s.FirstByte.Percentiles(),
+		TotalLatency:     s.Latency.Percentiles(),
+	}
+	if s.quotaExhausted(time.Now()) {
+		resetAt := time.Unix(0, s.QuotaResetAt)
+		stats.QuotaResetAt = &resetAt
+	}
+	for profile := range s.UnsupportedProfiles {
+		if s.profileUnsupported(profile, time.Now()) {
+			stats.UnsupportedProfiles = append(stats.UnsupportedProfiles, profile)
+		}
+	}
+	sort.Strings(stats.UnsupportedProfiles)
+	return stats
+}
+
+// errorRateAt 最近窗口内失败次数与请求数之比，没有请求时为0
+func (s *TokenStatus) errorRateAt(now time.Time) float64 {
+	requests := s.Rate.countAt(now)
+	if requests == 0 {
+		return 0
+	}
+	return math.Min(float64(s.Errors.countAt(now))/float64(requests), 1)
+}
+
+// quotaExhausted 配额是否耗尽且尚未到重置时间
+func (s *TokenStatus) quotaExhausted(now time.Time) bool {
+	return s.QuotaResetAt > now.UnixNano()
+}
+
+// profileUnsupported 上游拒绝该token使用profile且尚未到期
+func (s *TokenStatus) profileUnsupported(profile string, now time.Time) bool {
+	return now.Before(s.UnsupportedProfiles[profile])
+}
+
+// available token是否可以被选择
+func (s *TokenStatus) available(now time.Time) bool {
+	return s.Healthy && !s.Disabled && !s.Draining && !s.quotaExhausted(now)
+}
+
+// state 返回token的展示状态：draining、disabled、quota_exhausted、healthy或unhealthy
+func (s *TokenStatus) state() string {
+	switch {
+	case s.Draining:
+		return "draining"
+	case s.Disabled:
+		return "disabled"
+	case s.quotaExhausted(time.Now()):
+		return "quota_exhausted"
+	case s.Healthy:
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
 }
 
 // BaseBalancer 基础负载均衡器
 type BaseBalancer struct {
 	tokens   map[string]*TokenStatus
-	strategy config.LoadBalanceStrategy
+	order    []string // 保持配置中的token顺序，保证选择结果可预期
+	selector SelectionStrategy
 	mutex    sync.RWMutex
-	counter  int64 // 用于轮询计数
-	rand     *rand.Rand
+	// selectMutex 保证选择与在途计数更新原子完成，否则并发请求会基于相同的在途数选中同一个token
+	selectMutex sync.Mutex
 }
 
 // NewJWTBalancer 创建JWT负载均衡器
 func NewJWTBalancer(tokens []string, strategy config.LoadBalanceStrategy) JWTBalancer {
+	return NewJWTBalancerWithStrategy(tokenConfigsFromStrings(tokens), NewSelectionStrategy(strategy))
+}
+
+// NewJWTBalancerWithStrategy 使用token配置和选择策略创建JWT负载均衡器
+func NewJWTBalancerWithStrategy(tokens []config.JWTTokenConfig, selector SelectionStrategy) JWTBalancer {
 	balancer := &BaseBalancer{
-		tokens:   make(map[string]*TokenStatus),
-		strategy: strategy,
-		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
-	}
-	
-	// 初始化tokens
-	for _, token := range tokens {
-		balancer.tokens[token] = &TokenStatus{
-			Token:     token,
-			Healthy:   true,
-			LastUsed:  time.Now(),
+		selector: selector,
+	}
+	balancer.setTokens(tokens)
+	return balancer
+}
+
+// tokenConfigsFromStrings 将token字符串转换为默认配置
+func tokenConfigsFromStrings(tokens []string) []config.JWTTokenConfig {
+	configs := make([]config.JWTTokenConfig, len(tokens))
+	for i, token := range tokens {
+		configs[i] = config.JWTTokenConfig{
+			Token:    token,
+			Name:     fmt.Sprintf("JWT_%d", i+1),
+			Priority: 1,
+		}
+	}
+	return configs
+}
+
+// setTokens 重建token表，调用方需持有写锁或处于构造阶段
+func (b *BaseBalancer) setTokens(tokens []config.JWTTokenConfig) {
+	unique, collisions := config.DedupeJWTTokens(tokens)
+	for _, collision := range collisions {
+		fmt.Printf("Warning: JWT token %s duplicates %s and was ignored\n", collision.Name, collision.Kept)
+	}
+
+	b.tokens = make(map[string]*TokenStatus, len(unique))
+	b.order = make([]string, 0, len(unique))
+
+	for _, tokenConfig := range unique {
+		b.order = append(b.order, tokenConfig.Token)
+		b.tokens[tokenConfig.Token] = &TokenStatus{
+			Token:      tokenConfig.Token,
+			Name:       tokenConfig.Name,
+			Priority:   normalizePriority(tokenConfig.Priority),
+			Metadata:   tokenConfig.Metadata,
+			Healthy:    true,
+			Disabled:   !tokenConfig.IsEnabled(),
+			LastUsed:   time.Now().UnixNano(),
 			ErrorCount: 0,
+			Rate:       NewRateWindow(defaultRateWindowSeconds),
+			Errors:     NewRateWindow(defaultRateWindowSeconds),
+			FirstByte:  NewLatencyWindow(defaultLatencyWindowSlots),
+			Latency:    NewLatencyWindow(defaultLatencyWindowSlots),
 		}
 	}
-	
-	return balancer
+}
+
+// normalizePriority 未设置的优先级视为第1层
+func normalizePriority(priority int) int {
+	if priority <= 0 {
+		return 1
+	}
+	return priority
 }
 
 // GetToken 获取一个可用的token
 func (b *BaseBalancer) GetToken() (string, error) {
+	return b.GetTokenForKey("")
+}
+
+// GetTokenForKey 按请求键获取一个可用的token，策略支持时同一个键固定选中同一个token
+func (b *BaseBalancer) GetTokenForKey(key string) (string, error) {
+	return b.GetTokenForTier(key, ServiceTierDefault)
+}
+
+// GetTokenForTier 按请求键和服务等级获取一个可用的token，策略不支持服务等级（未启用优先级分层）时忽略等级
+func (b *BaseBalancer) GetTokenForTier(key string, tier ServiceTier) (string, error) {
+	return b.GetTokenForProfile(key, tier, "")
+}
+
+// GetTokenForProfile 与GetTokenForTier相同，但跳过上游拒绝过该profile的token；profile为空时不过滤。
+// 可用的token都不支持该profile时返回ErrProfileUnavailable
+func (b *BaseBalancer) GetTokenForProfile(key string, tier ServiceTier, profile string) (string, error) {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
-	
-	// 获取所有健康的tokens
-	healthyTokens := make([]*TokenStatus, 0)
-	for _, status := range b.tokens {
-		if status.Healthy {
-			healthyTokens = append(healthyTokens, status)
+
+	// 按配置顺序获取所有启用、健康、配额未耗尽且支持该profile的tokens
+	now := time.Now()
+	healthyTokens := make([]*TokenStatus, 0, len(b.order))
+	rejected := 0
+	for _, token := range b.order {
+		status := b.tokens[token]
+		if !status.available(now) {
+			continue
 		}
+		if status.profileUnsupported(profile, now) {
+			rejected++
+			continue
+		}
+		healthyTokens = append(healthyTokens, status)
 	}
-	
+
 	if len(healthyTokens) == 0 {
-		return "", fmt.Errorf("no healthy JWT tokens available")
-	}
-	
-	var selectedToken *TokenStatus
-	
-	switch b.strategy {
-	case config.RoundRobin:
-		// 轮询策略
-		index := atomic.AddInt64(&b.counter, 1) % int64(len(healthyTokens))
-		selectedToken = healthyTokens[index]
-	case config.Random:
-		// 随机策略
-		index := b.rand.Intn(len(healthyTokens))
-		selectedToken = healthyTokens[index]
-	default:
-		// 默认使用轮询
-		index := atomic.AddInt64(&b.counter, 1) % int64(len(healthyTokens))
-		selectedToken = healthyTokens[index]
+		if rejected > 0 {
+			return "", ErrProfileUnavailable
+		}
+		return "", ErrNoHealthyTokens
 	}
-	
+
+	b.selectMutex.Lock()
+	selectedToken := selectForTier(b.selector, healthyTokens, key, tier)
+	atomic.AddInt64(&selectedToken.InFlight, 1)
+	b.selectMutex.Unlock()
+
 	// 更新最后使用时间
-	selectedToken.LastUsed = time.Now()
-	
+	atomic.StoreInt64(&selectedToken.LastUsed, time.Now().UnixNano())
+	atomic.AddInt64(&selectedToken.Requests, 1)
+	selectedToken.Rate.Record()
+
 	return selectedToken.Token, nil
 }
 
@@ -101,69 +287,297 @@ func (b *BaseBalancer) GetToken() (string, error) {
 func (b *BaseBalancer) MarkTokenUnhealthy(token string) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	
+
 	if status, exists := b.tokens[token]; exists {
 		status.Healthy = false
 		atomic.AddInt64(&status.ErrorCount, 1)
-		fmt.Printf("JWT token marked as unhealthy: %s (errors: %d)\n", 
+		status.Errors.Record()
+		fmt.Printf("JWT token marked as unhealthy: %s (errors: %d)\n",
 			token[:min(len(token), 10)]+"...", status.ErrorCount)
 	}
 }
 
+// MarkTokenQuotaExhausted 标记token配额耗尽：移出轮换，且在resetAt之前健康检查也不会恢复它；
+// resetAt为零值时与MarkTokenUnhealthy相同，由健康检查恢复
+func (b *BaseBalancer) MarkTokenQuotaExhausted(token string, resetAt time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if status, exists := b.tokens[token]; exists {
+		status.Healthy = false
+		atomic.AddInt64(&status.ErrorCount, 1)
+		status.Errors.Record()
+		if !resetAt.IsZero() {
+			status.QuotaResetAt = resetAt.UnixNano()
+		}
+		fmt.Printf("JWT token quota exhausted: %s (reset at: %v)\n",
+			token[:min(len(token), 10)]+"...", resetAt)
+	}
+}
+
+// MarkProfileUnsupported 记录上游拒绝token使用该profile，ProfileRejectionTTL内该profile的请求不再选择它；token仍然健康
+func (b *BaseBalancer) MarkProfileUnsupported(token, profile string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if status, exists := b.tokens[token]; exists && profile != "" {
+		now := time.Now()
+		if status.UnsupportedProfiles == nil {
+			status.UnsupportedProfiles = make(map[string]time.Time)
+		}
+		// 顺便删除已到期的记录
+		for rejected := range status.UnsupportedProfiles {
+			if !status.profileUnsupported(rejected, now) {
+				delete(status.UnsupportedProfiles, rejected)
+			}
+		}
+		status.UnsupportedProfiles[profile] = now.Add(ProfileRejectionTTL)
+		fmt.Printf("JWT token does not support profile %s: %s\n",
+			profile, token[:min(len(token), 10)]+"...")
+	}
+}
+
 // MarkTokenHealthy 标记token为健康
 func (b *BaseBalancer) MarkTokenHealthy(token string) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	
+
 	if status, exists := b.tokens[token]; exists {
 		status.Healthy = true
 		atomic.StoreInt64(&status.ErrorCount, 0)
-		fmt.Printf("JWT token marked as healthy: %s\n", 
+		fmt.Printf("JWT token marked as healthy: %s\n",
 			token[:min(len(token), 10)]+"...")
 	}
 }
 
-// GetHealthyTokenCount 获取健康token数量
+// ReleaseToken 请求结束后释放GetToken选出的token，减少其在途请求数；draining token的最后一个请求结束时删除该token
+func (b *BaseBalancer) ReleaseToken(token string) {
+	b.mutex.RLock()
+	drained := false
+	if status, exists := b.tokens[token]; exists && atomic.LoadInt64(&status.InFlight) > 0 {
+		drained = atomic.AddInt64(&status.InFlight, -1) == 0 && status.Draining
+	}
+	b.mutex.RUnlock()
+
+	if drained {
+		b.removeDrained(token)
+	}
+}
+
+// removeDrained 删除在途请求已经全部结束的draining token
+func (b *BaseBalancer) removeDrained(token string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status, exists := b.tokens[token]
+	if !exists || !status.Draining || atomic.LoadInt64(&status.InFlight) > 0 {
+		return
+	}
+	delete(b.tokens, token)
+	for i, t := range b.order {
+		if t == token {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	fmt.Printf("Drained JWT token removed: %s\n", status.Name)
+}
+
+// HasToken token是否仍在负载均衡器中（包括draining token）
+func (b *BaseBalancer) HasToken(token string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	_, exists := b.tokens[token]
+	return exists
+}
+
+// ReapDrained 删除在途请求已经结束但未被释放流程删除的draining token，返回删除的数量
+func (b *BaseBalancer) ReapDrained() int {
+	b.mutex.RLock()
+	var drained []string
+	for _, token := range b.order {
+		if status := b.tokens[token]; status.Draining && atomic.LoadInt64(&status.InFlight) == 0 {
+			drained = append(drained, token)
+		}
+	}
+	b.mutex.RUnlock()
+
+	for _, token := range drained {
+		b.removeDrained(token)
+	}
+	return len(drained)
+}
+
+// GetHealthyTokenCount 获取健康token数量（不含禁用和配额耗尽的token）
 func (b *BaseBalancer) GetHealthyTokenCount() int {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
-	
+
+	now := time.Now()
 	count := 0
 	for _, status := range b.tokens {
-		if status.Healthy {
+		if status.available(now) {
 			count++
 		}
 	}
 	return count
 }
 
-// GetTotalTokenCount 获取总token数量
+// GetTotalTokenCount 获取总token数量（不含等待在途请求结束的draining token）
 func (b *BaseBalancer) GetTotalTokenCount() int {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
-	
-	return len(b.tokens)
+
+	count := 0
+	for _, status := range b.tokens {
+		if !status.Draining {
+			count++
+		}
+	}
+	return count
+}
+
+// GetTokenStats 按配置顺序获取每个token的运行统计
+func (b *BaseBalancer) GetTokenStats() []TokenStats {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	stats := make([]TokenStats, 0, len(b.order))
+	for _, token := range b.order {
+		stats = append(stats, b.tokens[token].stats())
+	}
+	return stats
+}
+
+// RecordLatency 记录一次成功请求的首字节延迟和完整延迟，token已被移除时忽略
+func (b *BaseBalancer) RecordLatency(token string, firstByte, total time.Duration) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	status, exists := b.tokens[token]
+	if !exists {
+		return
+	}
+	status.FirstByte.Record(firstByte)
+	status.Latency.Record(total)
+}
+
+// ResetTokenByName 按名称查找token，清除错误计数并立即标记为健康，返回更新后的统计
+func (b *BaseBalancer) ResetTokenByName(name string) (TokenStats, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := b.findByNameLocked(name)
+	if status == nil {
+		return TokenStats{}, false
+	}
+
+	status.Healthy = true
+	status.QuotaResetAt = 0
+	status.UnsupportedProfiles = nil
+	atomic.StoreInt64(&status.ErrorCount, 0)
+	fmt.Printf("JWT token reset by operator: %s\n", status.Name)
+	return status.stats(), true
+}
+
+// TokenName 返回token配置的名称，token不存在时返回空字符串
+func (b *BaseBalancer) TokenName(token string) string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if status, exists := b.tokens[token]; exists {
+		return status.Name
+	}
+	return ""
+}
+
+// TokenMetadata 获取token配置中的元数据，token不存在时返回nil；返回值为副本，可安全修改
+func (b *BaseBalancer) TokenMetadata(token string) map[string]string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	status, exists := b.tokens[token]
+	if !exists || len(status.Metadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(status.Metadata))
+	for key, value := range status.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// findByNameLocked 按配置顺序查找第一个名称匹配的token，调用方需持有锁
+func (b *BaseBalancer) findByNameLocked(name string) *TokenStatus {
+	for _, token := range b.order {
+		if status := b.tokens[token]; status.Name == name {
+			return status
+		}
+	}
+	return nil
 }
 
 // RefreshTokens 刷新token列表
 func (b *BaseBalancer) RefreshTokens(tokens []string) {
+	b.RefreshTokenConfigs(tokenConfigsFromStrings(tokens))
+}
+
+// RefreshTokenConfigs 使用token配置刷新token列表，被移除但仍有在途请求的token进入draining状态
+func (b *BaseBalancer) RefreshTokenConfigs(tokens []config.JWTTokenConfig) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	
-	// 清空现有tokens
-	b.tokens = make(map[string]*TokenStatus)
-	
-	// 添加新tokens
-	for _, token := range tokens {
-		b.tokens[token] = &TokenStatus{
-			Token:     token,
-			Healthy:   true,
-			LastUsed:  time.Now(),
-			ErrorCount: 0,
+
+	previousOrder, previous := b.order, b.tokens
+	b.setTokens(tokens)
+	draining := b.carryOverInFlightLocked(previousOrder, previous)
+
+	fmt.Printf("JWT tokens refreshed, total: %d, draining: %d\n", len(b.tokens)-draining, draining)
+}
+
+// TransferInFlight 替换负载均衡器时将from的在途请求转移到to：仍在配置中的token继承在途计数，
+// 已移除但仍有在途请求的token在to中进入draining状态，返回draining token数量
+func TransferInFlight(from, to JWTBalancer) int {
+	source, ok := from.(*BaseBalancer)
+	if !ok {
+		return 0
+	}
+	target, ok := to.(*BaseBalancer)
+	if !ok || source == target {
+		return 0
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+
+	return target.carryOverInFlightLocked(source.order, source.tokens)
+}
+
+// carryOverInFlightLocked 保留旧token表中的在途请求，返回draining token数量，调用方需持有写锁
+func (b *BaseBalancer) carryOverInFlightLocked(order []string, tokens map[string]*TokenStatus) int {
+	draining := 0
+	for _, token := range order {
+		previous := tokens[token]
+		inFlight := atomic.LoadInt64(&previous.InFlight)
+		if status, exists := b.tokens[token]; exists {
+			atomic.AddInt64(&status.InFlight, inFlight)
+			continue
+		}
+		if inFlight > 0 {
+			// 复制原来的状态，释放时仍能找到该token，直到在途请求全部结束；
+			// 不修改原状态，仍持有旧实例的读取方不会与这里的写入竞争
+			status := *previous
+			status.Draining = true
+			status.InFlight = inFlight
+			status.UnsupportedProfiles = maps.Clone(previous.UnsupportedProfiles)
+			b.tokens[token] = &status
+			b.order = append(b.order, token)
+			draining++
 		}
 	}
-	
-	fmt.Printf("JWT tokens refreshed, total: %d\n", len(tokens))
+	return draining
 }
 
 // min 辅助函数
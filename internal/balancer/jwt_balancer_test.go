@@ -1,8 +1,10 @@
 package balancer
 
 import (
+	"errors"
 	"jetbrains-ai-proxy/internal/config"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -159,12 +161,12 @@ func TestConcurrentAccess(t *testing.T) {
 		}()
 	}
 	
-	// 并发标记tokens健康状态
+	// 并发标记tokens健康状态（最后一个token始终健康，否则所有token可能同时不健康导致偶发失败）
 	for i := 0; i < numGoroutines; i++ {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-			token := tokens[index%len(tokens)]
+			token := tokens[index%(len(tokens)-1)]
 			for j := 0; j < 10; j++ {
 				if j%2 == 0 {
 					balancer.MarkTokenUnhealthy(token)
@@ -224,3 +226,234 @@ func TestRefreshTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestDisabledTokensExcluded(t *testing.T) {
+	disabled := false
+	tokens := []config.JWTTokenConfig{
+		{Token: "token1", Name: "Active"},
+		{Token: "token2", Name: "Maintenance", Enabled: &disabled},
+	}
+	balancer := NewJWTBalancerWithStrategy(tokens, NewRoundRobinStrategy())
+
+	// 禁用的token不会被选择
+	for i := 0; i < 4; i++ {
+		if token, _ := balancer.GetToken(); token != "token1" {
+			t.Errorf("Expected only enabled token1, got %s", token)
+		}
+	}
+	if balancer.GetHealthyTokenCount() != 1 || balancer.GetTotalTokenCount() != 2 {
+		t.Errorf("Expected 1/2 available tokens, got %d/%d", balancer.GetHealthyTokenCount(), balancer.GetTotalTokenCount())
+	}
+
+	stats := balancer.(*BaseBalancer).GetTokenStats()
+	if stats[1].Status != "disabled" {
+		t.Errorf("Expected disabled status, got %s", stats[1].Status)
+	}
+
+	// 全部禁用时没有可用token
+	balancer.RefreshTokenConfigs([]config.JWTTokenConfig{{Token: "token1", Enabled: &disabled}})
+	if _, err := balancer.GetToken(); err == nil {
+		t.Error("Expected error when all tokens are disabled")
+	}
+}
+
+func TestReenableTokenViaReload(t *testing.T) {
+	disabled := false
+	balancer := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{{Token: "token1", Enabled: &disabled}}, NewRoundRobinStrategy())
+	if _, err := balancer.GetToken(); err == nil {
+		t.Fatal("Expected disabled token to be unavailable")
+	}
+
+	// 重新加载配置后恢复
+	balancer.RefreshTokenConfigs([]config.JWTTokenConfig{{Token: "token1"}})
+	if token, err := balancer.GetToken(); err != nil || token != "token1" {
+		t.Errorf("Expected re-enabled token1, got %s (%v)", token, err)
+	}
+}
+
+func TestConcurrentSelectionOfSameToken(t *testing.T) {
+	// 只有一个token时所有goroutine都会选中它，并发更新最后使用时间（配合 -race 运行）
+	balancer := NewJWTBalancer([]string{"only-token"}, config.RoundRobin).(*BaseBalancer)
+	before := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if token, err := balancer.GetToken(); err != nil || token != "only-token" {
+					t.Errorf("Unexpected selection: %s (%v)", token, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lastUsed := balancer.tokens["only-token"].LastUsedAt(); lastUsed.Before(before) {
+		t.Errorf("Expected last used time to be updated, got %v", lastUsed)
+	}
+}
+
+func TestResetTokenByName(t *testing.T) {
+	tokens := []config.JWTTokenConfig{
+		{Token: "token1", Name: "Primary"},
+		{Token: "token2", Name: "Backup"},
+	}
+	b := NewJWTBalancerWithStrategy(tokens, NewRoundRobinStrategy()).(*BaseBalancer)
+
+	b.MarkTokenUnhealthy("token2")
+	b.MarkTokenUnhealthy("token2")
+
+	stats, ok := b.ResetTokenByName("Backup")
+	if !ok {
+		t.Fatal("Expected Backup to be found")
+	}
+	if !stats.Healthy || stats.Status != "healthy" || stats.ErrorCount != 0 {
+		t.Errorf("Expected reset token to be healthy without errors, got %+v", stats)
+	}
+	if b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected 2 healthy tokens after reset, got %d", b.GetHealthyTokenCount())
+	}
+
+	if _, ok := b.ResetTokenByName("Missing"); ok {
+		t.Error("Expected unknown name not to be found")
+	}
+}
+
+func TestMarkTokenQuotaExhausted(t *testing.T) {
+	tokens := []config.JWTTokenConfig{
+		{Token: "token1", Name: "Primary"},
+		{Token: "token2", Name: "Backup"},
+	}
+	b := NewJWTBalancerWithStrategy(tokens, NewRoundRobinStrategy()).(*BaseBalancer)
+
+	b.MarkTokenQuotaExhausted("token1", time.Now().Add(time.Hour))
+	// 健康检查把403视为健康，但配额重置前仍不参与选择
+	b.MarkTokenHealthy("token1")
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected quota exhausted token to be excluded, got %d healthy", b.GetHealthyTokenCount())
+	}
+	for i := 0; i < 4; i++ {
+		if token, err := b.GetToken(); err != nil || token != "token2" {
+			t.Fatalf("Expected token2, got %s, %v", token, err)
+		}
+	}
+	if status := b.GetTokenStats()[0].Status; status != "quota_exhausted" {
+		t.Errorf("Expected quota_exhausted status, got %s", status)
+	}
+
+	// 手动重置清除配额状态
+	if _, ok := b.ResetTokenByName("Primary"); !ok || b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected reset to restore the token, got %d healthy", b.GetHealthyTokenCount())
+	}
+
+	// 重置时间已过或未知时，健康检查可以恢复token
+	b.MarkTokenQuotaExhausted("token1", time.Now().Add(-time.Second))
+	b.MarkTokenQuotaExhausted("token2", time.Time{})
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected both tokens to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+	b.MarkTokenHealthy("token1")
+	b.MarkTokenHealthy("token2")
+	if b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected health check to restore both tokens, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestDuplicateTokensDeduplicated(t *testing.T) {
+	tokens := []config.JWTTokenConfig{
+		{Token: "token1", Name: "First"},
+		{Token: "token2", Name: "Second"},
+		{Token: "token1", Name: "Duplicate"},
+	}
+	b := NewJWTBalancerWithStrategy(tokens, NewRoundRobinStrategy()).(*BaseBalancer)
+
+	if b.GetTotalTokenCount() != 2 {
+		t.Errorf("Expected 2 unique tokens, got %d", b.GetTotalTokenCount())
+	}
+	// 重复的token保留第一次出现的配置
+	stats := b.GetTokenStats()
+	if len(stats) != 2 || stats[0].Name != "First" || stats[1].Name != "Second" {
+		t.Errorf("Expected first occurrence to be kept, got %+v", stats)
+	}
+}
+
+func TestRefreshDrainsTokensWithInFlightRequests(t *testing.T) {
+	b := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "token1", Name: "Removed"},
+		{Token: "token2", Name: "Kept"},
+		{Token: "token3", Name: "Idle"},
+	}, NewRoundRobinStrategy()).(*BaseBalancer)
+
+	// token1和token2各有一个在途请求
+	b.GetToken()
+	b.GetToken()
+
+	b.RefreshTokenConfigs([]config.JWTTokenConfig{{Token: "token2", Name: "Kept"}})
+
+	stats := b.GetTokenStats()
+	if len(stats) != 2 || stats[1].Name != "Removed" || stats[1].Status != "draining" {
+		t.Fatalf("Expected only the busy removed token to be draining, got %+v", stats)
+	}
+	if stats[0].InFlight != 1 {
+		t.Errorf("Expected kept token to keep its in-flight count, got %d", stats[0].InFlight)
+	}
+	if b.GetTotalTokenCount() != 1 || b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected draining token to be excluded from counts, got %d/%d", b.GetHealthyTokenCount(), b.GetTotalTokenCount())
+	}
+	if token, _ := b.GetToken(); token != "token2" {
+		t.Errorf("Expected draining token not to be selected, got %s", token)
+	}
+
+	// 最后一个在途请求结束后删除
+	b.ReleaseToken("token1")
+	if stats := b.GetTokenStats(); len(stats) != 1 || stats[0].Name != "Kept" {
+		t.Errorf("Expected drained token to be removed, got %+v", stats)
+	}
+}
+
+func TestReapDrainedRemovesLeftoverDrainingTokens(t *testing.T) {
+	from := NewJWTBalancer([]string{"token1", "token2"}, config.RoundRobin).(*BaseBalancer)
+	from.GetToken()
+	to := NewJWTBalancer([]string{"token2"}, config.RoundRobin).(*BaseBalancer)
+	TransferInFlight(from, to)
+
+	// 新实例复制draining token的状态，在旧实例上释放不影响新实例
+	from.ReleaseToken("token1")
+	if stats := to.GetTokenStats(); len(stats) != 2 || stats[1].InFlight != 1 {
+		t.Fatalf("Expected draining token to keep its in-flight count, got %+v", stats)
+	}
+
+	// 在途计数归零但没有经过释放流程时，draining token不会再被删除
+	atomic.StoreInt64(&to.tokens["token1"].InFlight, 0)
+	if !to.HasToken("token1") {
+		t.Fatal("Expected draining token to remain in the new balancer")
+	}
+
+	if reaped := to.ReapDrained(); reaped != 1 {
+		t.Errorf("Expected one drained token to be reaped, got %d", reaped)
+	}
+	if to.HasToken("token1") || !to.HasToken("token2") {
+		t.Errorf("Expected only the drained token to be removed, got %+v", to.GetTokenStats())
+	}
+}
+
+func TestNoHealthyTokensSentinel(t *testing.T) {
+	disabled := false
+	b := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "token1"},
+		{Token: "token2", Enabled: &disabled},
+	}, NewRoundRobinStrategy())
+
+	b.MarkTokenUnhealthy("token1")
+	if _, err := b.GetToken(); !errors.Is(err, ErrNoHealthyTokens) {
+		t.Errorf("Expected ErrNoHealthyTokens when tokens are unhealthy or disabled, got %v", err)
+	}
+
+	b.MarkTokenHealthy("token1")
+	if _, err := b.GetToken(); err != nil {
+		t.Errorf("Expected healthy token, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLatencyWindowSlots 延迟统计的滑动窗口槽数，每槽一分钟
+	defaultLatencyWindowSlots = 5
+	latencySlotSeconds        = 60
+
+	// 对数分桶：第0桶为1ms以内，之后每桶上界是前一桶的1.25倍，覆盖到约20分钟，相对误差约12%
+	latencyBucketCount = 64
+	latencyMinMillis   = 1.0
+	latencyGrowth      = 1.25
+)
+
+// latencySlot 一个时间槽内的延迟直方图
+type latencySlot struct {
+	period int64
+	counts [latencyBucketCount]int64
+}
+
+// LatencyPercentiles 延迟分位数（毫秒）
+type LatencyPercentiles struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// LatencyWindow 基于固定对数分桶直方图的滑动窗口延迟统计，内存占用与请求量无关
+type LatencyWindow struct {
+	slots []latencySlot
+	mutex sync.Mutex
+}
+
+// NewLatencyWindow 创建指定槽数（每槽一分钟）的延迟统计
+func NewLatencyWindow(slots int) *LatencyWindow {
+	if slots <= 0 {
+		slots = defaultLatencyWindowSlots
+	}
+	return &LatencyWindow{slots: make([]latencySlot, slots)}
+}
+
+// Record 记录一次请求延迟
+func (w *LatencyWindow) Record(latency time.Duration) {
+	w.recordAt(latency, time.Now())
+}
+
+// Percentiles 返回窗口内的p50/p95/p99估计值
+func (w *LatencyWindow) Percentiles() LatencyPercentiles {
+	return w.percentilesAt(time.Now())
+}
+
+func (w *LatencyWindow) recordAt(latency time.Duration, now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	period := now.Unix() / latencySlotSeconds
+	slot := &w.slots[period%int64(len(w.slots))]
+	// 槽属于更早的窗口周期时重置
+	if slot.period != period {
+		slot.period = period
+		slot.counts = [latencyBucketCount]int64{}
+	}
+	slot.counts[latencyBucket(latency)]++
+}
+
+func (w *LatencyWindow) percentilesAt(now time.Time) LatencyPercentiles {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	period := now.Unix() / latencySlotSeconds
+	size := int64(len(w.slots))
+	var counts [latencyBucketCount]int64
+	var total int64
+	for i := range w.slots {
+		slot := &w.slots[i]
+		if slot.period <= period-size || slot.period > period {
+			continue
+		}
+		for bucket, count := range slot.counts {
+			counts[bucket] += count
+			total += count
+		}
+	}
+
+	if total == 0 {
+		return LatencyPercentiles{}
+	}
+	return LatencyPercentiles{
+		Count: total,
+		P50:   latencyQuantile(&counts, total, 0.50),
+		P95:   latencyQuantile(&counts, total, 0.95),
+		P99:   latencyQuantile(&counts, total, 0.99),
+	}
+}
+
+// latencyBucket 计算延迟所属的桶
+func latencyBucket(latency time.Duration) int {
+	millis := float64(latency) / float64(time.Millisecond)
+	if millis <= latencyMinMillis {
+		return 0
+	}
+	bucket := int(math.Ceil(math.Log(millis/latencyMinMillis) / math.Log(latencyGrowth)))
+	if bucket >= latencyBucketCount {
+		return latencyBucketCount - 1
+	}
+	return bucket
+}
+
+// latencyQuantile 找到累计计数达到分位的桶，返回桶上下界的几何中点（毫秒）
+func latencyQuantile(counts *[latencyBucketCount]int64, total int64, q float64) float64 {
+	rank := int64(math.Ceil(q * float64(total)))
+	var cumulative int64
+	for bucket, count := range counts {
+		cumulative += count
+		if cumulative >= rank {
+			if bucket == 0 {
+				return latencyMinMillis
+			}
+			upper := latencyMinMillis * math.Pow(latencyGrowth, float64(bucket))
+			return upper / math.Sqrt(latencyGrowth)
+		}
+	}
+	return latencyMinMillis * math.Pow(latencyGrowth, latencyBucketCount-1)
+}
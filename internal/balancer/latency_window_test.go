@@ -0,0 +1,109 @@
+package balancer
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"math"
+	"testing"
+	"time"
+)
+
+// assertWithin 检查估计值与期望值的相对误差不超过tolerance
+func assertWithin(t *testing.T, name string, got, expected, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-expected)/expected > tolerance {
+		t.Errorf("Expected %s ≈ %v (±%.0f%%), got %v", name, expected, tolerance*100, got)
+	}
+}
+
+func TestLatencyWindowPercentiles(t *testing.T) {
+	window := NewLatencyWindow(5)
+	now := time.Unix(6000, 0)
+
+	// 1ms到1000ms均匀分布
+	for i := 1; i <= 1000; i++ {
+		window.recordAt(time.Duration(i)*time.Millisecond, now)
+	}
+
+	p := window.percentilesAt(now)
+	if p.Count != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", p.Count)
+	}
+	assertWithin(t, "p50", p.P50, 500, 0.15)
+	assertWithin(t, "p95", p.P95, 950, 0.15)
+	assertWithin(t, "p99", p.P99, 990, 0.15)
+}
+
+func TestLatencyWindowSkewedDistribution(t *testing.T) {
+	window := NewLatencyWindow(5)
+	now := time.Unix(6000, 0)
+
+	// 90%的请求200ms，10%的慢请求5s
+	for i := 0; i < 90; i++ {
+		window.recordAt(200*time.Millisecond, now)
+	}
+	for i := 0; i < 10; i++ {
+		window.recordAt(5*time.Second, now)
+	}
+
+	p := window.percentilesAt(now)
+	assertWithin(t, "p50", p.P50, 200, 0.15)
+	assertWithin(t, "p95", p.P95, 5000, 0.15)
+	assertWithin(t, "p99", p.P99, 5000, 0.15)
+}
+
+func TestLatencyWindowExpiresOldSamples(t *testing.T) {
+	window := NewLatencyWindow(5)
+	start := time.Unix(6000, 0)
+
+	window.recordAt(10*time.Second, start)
+	window.recordAt(100*time.Millisecond, start.Add(3*time.Minute))
+
+	// 窗口内两个样本都计入
+	if p := window.percentilesAt(start.Add(3 * time.Minute)); p.Count != 2 {
+		t.Errorf("Expected 2 samples within window, got %d", p.Count)
+	}
+
+	// 5分钟后旧样本移出窗口
+	p := window.percentilesAt(start.Add(5 * time.Minute))
+	if p.Count != 1 {
+		t.Fatalf("Expected 1 sample after old slot expired, got %d", p.Count)
+	}
+	assertWithin(t, "p99", p.P99, 100, 0.15)
+
+	if p := window.percentilesAt(start.Add(time.Hour)); p.Count != 0 || p.P50 != 0 {
+		t.Errorf("Expected empty percentiles after window elapsed, got %+v", p)
+	}
+}
+
+func TestLatencyWindowClampsExtremes(t *testing.T) {
+	window := NewLatencyWindow(5)
+	now := time.Unix(6000, 0)
+
+	window.recordAt(0, now)
+	window.recordAt(24*time.Hour, now)
+
+	p := window.percentilesAt(now)
+	if p.P50 != latencyMinMillis {
+		t.Errorf("Expected sub-millisecond latency in the first bucket, got %v", p.P50)
+	}
+	if p.P99 < float64(10*time.Minute/time.Millisecond) {
+		t.Errorf("Expected very slow request in the last bucket, got %v", p.P99)
+	}
+}
+
+func TestRecordLatencyPerToken(t *testing.T) {
+	b := NewJWTBalancer([]string{"fast", "slow"}, config.RoundRobin).(*BaseBalancer)
+
+	for i := 0; i < 20; i++ {
+		b.RecordLatency("fast", 50*time.Millisecond, 300*time.Millisecond)
+		b.RecordLatency("slow", 2*time.Second, 8*time.Second)
+	}
+	// 已移除的token被忽略
+	b.RecordLatency("unknown", time.Second, time.Second)
+
+	stats := b.GetTokenStats()
+	assertWithin(t, "fast first byte p50", stats[0].FirstByteLatency.P50, 50, 0.15)
+	assertWithin(t, "fast total p95", stats[0].TotalLatency.P95, 300, 0.15)
+	assertWithin(t, "slow first byte p50", stats[1].FirstByteLatency.P50, 2000, 0.15)
+	assertWithin(t, "slow total p99", stats[1].TotalLatency.P99, 8000, 0.15)
+}
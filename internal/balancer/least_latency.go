@@ -0,0 +1,57 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLatencyExploration 随机选择token的概率，使变慢的token恢复后能被重新探测
+	defaultLatencyExploration = 0.1
+	// minLatencySamples 延迟样本不足时优先选择该token以积累数据
+	minLatencySamples = 5
+)
+
+// leastLatencyStrategy 延迟优先策略：选择最近首字节延迟p95最低的token，并以一定概率随机探测其他token
+type leastLatencyStrategy struct {
+	exploration float64
+	rand        *rand.Rand
+	mutex       sync.Mutex
+}
+
+// NewLeastLatencyStrategy 创建延迟优先策略
+func NewLeastLatencyStrategy() SelectionStrategy {
+	return newLeastLatencyStrategy(defaultLatencyExploration, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+func newLeastLatencyStrategy(exploration float64, r *rand.Rand) *leastLatencyStrategy {
+	return &leastLatencyStrategy{exploration: exploration, rand: r}
+}
+
+// Select 按最近延迟选择token；使用首字节延迟而不是完整延迟，后者主要取决于回复长度
+func (s *leastLatencyStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	s.mutex.Lock()
+	explore := s.rand.Float64() < s.exploration
+	index := s.rand.Intn(len(candidates))
+	s.mutex.Unlock()
+
+	if explore {
+		return candidates[index]
+	}
+
+	var best *TokenStatus
+	var bestP95 float64
+	for _, status := range candidates {
+		latency := status.FirstByte.Percentiles()
+		// 样本不足（新token或长时间未被选中）时优先选择，避免一直沿用过期的判断
+		if latency.Count < minLatencySamples {
+			return status
+		}
+		if best == nil || latency.P95 < bestP95 {
+			best = status
+			bestP95 = latency.P95
+		}
+	}
+	return best
+}
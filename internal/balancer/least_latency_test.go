@@ -0,0 +1,88 @@
+package balancer
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// newLatencyTestBalancer 创建使用延迟优先策略的负载均衡器，并为每个token写入延迟样本
+func newLatencyTestBalancer(exploration float64, latencies map[string]time.Duration) *BaseBalancer {
+	tokens := []config.JWTTokenConfig{
+		{Token: "fast", Name: "fast", Priority: 1},
+		{Token: "slow", Name: "slow", Priority: 1},
+	}
+	selector := newLeastLatencyStrategy(exploration, rand.New(rand.NewSource(1)))
+	b := NewJWTBalancerWithStrategy(tokens, selector).(*BaseBalancer)
+	for token, latency := range latencies {
+		for i := 0; i < 20; i++ {
+			b.RecordLatency(token, latency, latency)
+		}
+	}
+	return b
+}
+
+// countSelections 选择n次并统计每个token被选中的次数
+func countSelections(t *testing.T, b *BaseBalancer, n int) map[string]int {
+	t.Helper()
+
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		token, err := b.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		b.ReleaseToken(token)
+		counts[token]++
+	}
+	return counts
+}
+
+func TestLeastLatencyRoutesAwayFromSlowToken(t *testing.T) {
+	b := newLatencyTestBalancer(0.1, map[string]time.Duration{
+		"fast": 100 * time.Millisecond,
+		"slow": 3 * time.Second,
+	})
+
+	counts := countSelections(t, b, 1000)
+	// 探测时两个token各半，慢token约占5%
+	if counts["fast"] < 900 {
+		t.Errorf("Expected most requests on the fast token, got %v", counts)
+	}
+	if counts["slow"] == 0 {
+		t.Errorf("Expected slow token to be probed occasionally, got %v", counts)
+	}
+}
+
+func TestLeastLatencyWithoutExploration(t *testing.T) {
+	b := newLatencyTestBalancer(0, map[string]time.Duration{
+		"fast": 100 * time.Millisecond,
+		"slow": 3 * time.Second,
+	})
+
+	if counts := countSelections(t, b, 100); counts["fast"] != 100 {
+		t.Errorf("Expected all requests on the fast token, got %v", counts)
+	}
+}
+
+func TestLeastLatencyPrefersTokensWithoutSamples(t *testing.T) {
+	// slow没有样本，应优先被选中以积累数据
+	b := newLatencyTestBalancer(0, map[string]time.Duration{
+		"fast": 100 * time.Millisecond,
+	})
+
+	token, err := b.GetToken()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "slow" {
+		t.Errorf("Expected token without samples to be selected, got %s", token)
+	}
+}
+
+func TestLeastLatencyStrategyFromConfig(t *testing.T) {
+	if _, ok := NewSelectionStrategy(config.LeastLatency).(*leastLatencyStrategy); !ok {
+		t.Error("Expected least_latency to create a leastLatencyStrategy")
+	}
+}
@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRateWindowSeconds 请求速率统计的滑动窗口长度（秒）
+const defaultRateWindowSeconds = 10
+
+// rateBucket 每秒一个计数桶
+type rateBucket struct {
+	second int64
+	count  int64
+}
+
+// RateWindow 基于固定数量秒级桶的滑动窗口请求速率统计，内存占用与请求量无关
+type RateWindow struct {
+	buckets []rateBucket
+	mutex   sync.Mutex
+}
+
+// NewRateWindow 创建指定窗口长度（秒）的速率统计
+func NewRateWindow(seconds int) *RateWindow {
+	if seconds <= 0 {
+		seconds = defaultRateWindowSeconds
+	}
+	return &RateWindow{buckets: make([]rateBucket, seconds)}
+}
+
+// Record 记录一次请求
+func (w *RateWindow) Record() {
+	w.recordAt(time.Now())
+}
+
+// Rate 返回窗口内的平均每秒请求数
+func (w *RateWindow) Rate() float64 {
+	return w.rateAt(time.Now())
+}
+
+func (w *RateWindow) recordAt(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	second := now.Unix()
+	bucket := &w.buckets[second%int64(len(w.buckets))]
+	// 桶属于更早的窗口周期时重置
+	if bucket.second != second {
+		bucket.second = second
+		bucket.count = 0
+	}
+	bucket.count++
+}
+
+func (w *RateWindow) rateAt(now time.Time) float64 {
+	return float64(w.countAt(now)) / float64(len(w.buckets))
+}
+
+func (w *RateWindow) countAt(now time.Time) int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	second := now.Unix()
+	size := int64(len(w.buckets))
+	var total int64
+	for _, bucket := range w.buckets {
+		if bucket.second > second-size && bucket.second <= second {
+			total += bucket.count
+		}
+	}
+	return total
+}
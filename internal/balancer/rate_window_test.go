@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"testing"
+	"time"
+)
+
+func TestRateWindowReflectsBurst(t *testing.T) {
+	window := NewRateWindow(10)
+	start := time.Unix(1000, 0)
+
+	// 1秒内20次请求，10秒窗口平均2 RPS
+	for i := 0; i < 20; i++ {
+		window.recordAt(start)
+	}
+	if rate := window.rateAt(start); rate != 2 {
+		t.Errorf("Expected rate 2, got %v", rate)
+	}
+
+	// 窗口内仍然计入
+	if rate := window.rateAt(start.Add(9 * time.Second)); rate != 2 {
+		t.Errorf("Expected rate 2 within window, got %v", rate)
+	}
+}
+
+func TestRateWindowDecays(t *testing.T) {
+	window := NewRateWindow(10)
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < 10; i++ {
+		window.recordAt(start)
+	}
+	window.recordAt(start.Add(5 * time.Second))
+
+	// 超出窗口的请求不再计入
+	if rate := window.rateAt(start.Add(10 * time.Second)); rate != 0.1 {
+		t.Errorf("Expected rate 0.1 after burst left window, got %v", rate)
+	}
+	if rate := window.rateAt(start.Add(time.Minute)); rate != 0 {
+		t.Errorf("Expected rate 0 after window elapsed, got %v", rate)
+	}
+
+	// 复用的桶会被重置
+	window.recordAt(start.Add(20 * time.Second))
+	if rate := window.rateAt(start.Add(20 * time.Second)); rate != 0.1 {
+		t.Errorf("Expected reused bucket to be reset, got %v", rate)
+	}
+}
+
+func TestTokenStatsReportRPS(t *testing.T) {
+	balancer := NewJWTBalancer([]string{"token1", "token2"}, config.RoundRobin).(*BaseBalancer)
+
+	for i := 0; i < 4; i++ {
+		if _, err := balancer.GetToken(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	stats := balancer.GetTokenStats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 token stats, got %d", len(stats))
+	}
+	for _, stat := range stats {
+		if stat.RPS <= 0 {
+			t.Errorf("Expected positive RPS for %s, got %v", stat.Name, stat.RPS)
+		}
+	}
+	if stats[0].Name != "JWT_1" {
+		t.Errorf("Expected stats in config order, got %s", stats[0].Name)
+	}
+}
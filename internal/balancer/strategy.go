@@ -0,0 +1,248 @@
+package balancer
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SelectionStrategy token选择策略，从非空的健康token列表中选出一个
+type SelectionStrategy interface {
+	Select(candidates []*TokenStatus) *TokenStatus
+}
+
+// NewSelectionStrategy 根据负载均衡策略名称创建选择策略
+func NewSelectionStrategy(strategy config.LoadBalanceStrategy) SelectionStrategy {
+	switch strategy {
+	case config.Random:
+		return NewRandomStrategy()
+	case config.RoundRobin:
+		return NewRoundRobinStrategy()
+	case config.ConsistentHash:
+		return NewConsistentHashStrategy()
+	case config.LeastLatency:
+		return NewLeastLatencyStrategy()
+	default:
+		// 默认使用轮询
+		return NewRoundRobinStrategy()
+	}
+}
+
+// BuildSelectionStrategy 根据应用配置组合选择策略
+func BuildSelectionStrategy(cfg *config.Config) SelectionStrategy {
+	selector := NewSelectionStrategy(cfg.LoadBalanceStrategy)
+	if cfg.LoadAwareSelection {
+		selector = NewLeastInFlightStrategy(selector)
+	}
+	if cfg.PriorityTiers {
+		selector = NewPriorityTierStrategy(selector)
+	}
+	// 最后包装，先排除错误率过高的token，再分层和按负载选择
+	if cfg.ErrorRateThreshold > 0 {
+		selector = NewErrorRateStrategy(selector, cfg.ErrorRateThreshold, cfg.ErrorRateMinRequests)
+	}
+	return selector
+}
+
+// roundRobinStrategy 轮询策略
+type roundRobinStrategy struct {
+	counter int64
+}
+
+// NewRoundRobinStrategy 创建轮询策略
+func NewRoundRobinStrategy() SelectionStrategy {
+	return &roundRobinStrategy{}
+}
+
+// Select 按顺序轮流选择token
+func (s *roundRobinStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	index := (atomic.AddInt64(&s.counter, 1) - 1) % int64(len(candidates))
+	return candidates[index]
+}
+
+// randomStrategy 随机策略
+type randomStrategy struct {
+	rand  *rand.Rand
+	mutex sync.Mutex
+}
+
+// NewRandomStrategy 创建随机策略
+func NewRandomStrategy() SelectionStrategy {
+	return &randomStrategy{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Select 随机选择token
+func (s *randomStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	s.mutex.Lock()
+	index := s.rand.Intn(len(candidates))
+	s.mutex.Unlock()
+	return candidates[index]
+}
+
+// ServiceTier 请求的服务等级（OpenAI的service_tier），作为按优先级分层选择token的提示
+type ServiceTier string
+
+const (
+	ServiceTierDefault ServiceTier = ""     // 优先使用高优先级（主力）token
+	ServiceTierFlex    ServiceTier = "flex" // 对延迟不敏感，优先使用低优先级（溢出）token，把主力token留给其他请求
+)
+
+// ServiceTierStrategy 支持按服务等级选择token的策略
+type ServiceTierStrategy interface {
+	SelectionStrategy
+	SelectForTier(candidates []*TokenStatus, key string, tier ServiceTier) *TokenStatus
+}
+
+// selectForTier 策略支持服务等级且不是默认等级时按等级选择，否则按键选择
+func selectForTier(s SelectionStrategy, candidates []*TokenStatus, key string, tier ServiceTier) *TokenStatus {
+	if tiered, ok := s.(ServiceTierStrategy); ok && tier != ServiceTierDefault {
+		return tiered.SelectForTier(candidates, key, tier)
+	}
+	return selectWithKey(s, candidates, key)
+}
+
+// priorityTierStrategy 优先级分层策略：只在优先级最高（Priority最小）的非空层内应用基础策略
+type priorityTierStrategy struct {
+	base SelectionStrategy
+}
+
+// NewPriorityTierStrategy 创建优先级分层策略
+func NewPriorityTierStrategy(base SelectionStrategy) SelectionStrategy {
+	return &priorityTierStrategy{base: base}
+}
+
+// Select 选出最高优先级层后交给基础策略
+func (s *priorityTierStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	return s.base.Select(topPriorityTier(candidates))
+}
+
+// SelectByKey 选出最高优先级层后按键交给基础策略
+func (s *priorityTierStrategy) SelectByKey(candidates []*TokenStatus, key string) *TokenStatus {
+	return selectWithKey(s.base, topPriorityTier(candidates), key)
+}
+
+// SelectForTier flex请求从优先级最低的一层中选择，其他等级与SelectByKey相同
+func (s *priorityTierStrategy) SelectForTier(candidates []*TokenStatus, key string, tier ServiceTier) *TokenStatus {
+	if tier == ServiceTierFlex {
+		return selectWithKey(s.base, bottomPriorityTier(candidates), key)
+	}
+	return s.SelectByKey(candidates, key)
+}
+
+// topPriorityTier 返回优先级最高的一层token，保持原有顺序
+func topPriorityTier(candidates []*TokenStatus) []*TokenStatus {
+	best := candidates[0].Priority
+	for _, status := range candidates[1:] {
+		if status.Priority < best {
+			best = status.Priority
+		}
+	}
+	return priorityTier(candidates, best)
+}
+
+// bottomPriorityTier 返回优先级最低的一层token，保持原有顺序
+func bottomPriorityTier(candidates []*TokenStatus) []*TokenStatus {
+	worst := candidates[0].Priority
+	for _, status := range candidates[1:] {
+		if status.Priority > worst {
+			worst = status.Priority
+		}
+	}
+	return priorityTier(candidates, worst)
+}
+
+// priorityTier 返回指定优先级的token，保持原有顺序
+func priorityTier(candidates []*TokenStatus, priority int) []*TokenStatus {
+	tier := make([]*TokenStatus, 0, len(candidates))
+	for _, status := range candidates {
+		if status.Priority == priority {
+			tier = append(tier, status)
+		}
+	}
+	return tier
+}
+
+// leastInFlightStrategy 在途感知策略：只在在途请求最少的token中应用基础策略
+type leastInFlightStrategy struct {
+	base SelectionStrategy
+}
+
+// NewLeastInFlightStrategy 创建在途感知策略
+func NewLeastInFlightStrategy(base SelectionStrategy) SelectionStrategy {
+	return &leastInFlightStrategy{base: base}
+}
+
+// Select 选出在途请求最少的token后交给基础策略
+func (s *leastInFlightStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	return s.base.Select(leastInFlight(candidates))
+}
+
+// SelectByKey 选出在途请求最少的token后按键交给基础策略
+func (s *leastInFlightStrategy) SelectByKey(candidates []*TokenStatus, key string) *TokenStatus {
+	return selectWithKey(s.base, leastInFlight(candidates), key)
+}
+
+// leastInFlight 返回在途请求数最少的token，保持原有顺序
+func leastInFlight(candidates []*TokenStatus) []*TokenStatus {
+	best := atomic.LoadInt64(&candidates[0].InFlight)
+	least := make([]*TokenStatus, 0, len(candidates))
+	for _, status := range candidates {
+		inFlight := atomic.LoadInt64(&status.InFlight)
+		if inFlight < best {
+			best = inFlight
+			least = least[:0]
+		}
+		if inFlight == best {
+			least = append(least, status)
+		}
+	}
+	return least
+}
+
+// errorRateStrategy 错误率感知策略：跳过最近错误率超过阈值的token，错误率回落后自动恢复；
+// 所有token都超过阈值时不排除，避免请求全部失败
+type errorRateStrategy struct {
+	base        SelectionStrategy
+	threshold   float64
+	minRequests int64
+	now         func() time.Time
+}
+
+// NewErrorRateStrategy 创建错误率感知策略，请求数少于minRequests的token样本不足，不参与判断
+func NewErrorRateStrategy(base SelectionStrategy, threshold float64, minRequests int) SelectionStrategy {
+	return &errorRateStrategy{base: base, threshold: threshold, minRequests: int64(minRequests), now: time.Now}
+}
+
+// Select 排除错误率过高的token后交给基础策略
+func (s *errorRateStrategy) Select(candidates []*TokenStatus) *TokenStatus {
+	return s.base.Select(s.belowThreshold(candidates))
+}
+
+// SelectByKey 排除错误率过高的token后按键交给基础策略
+func (s *errorRateStrategy) SelectByKey(candidates []*TokenStatus, key string) *TokenStatus {
+	return selectWithKey(s.base, s.belowThreshold(candidates), key)
+}
+
+// SelectForTier 排除错误率过高的token后按服务等级交给基础策略
+func (s *errorRateStrategy) SelectForTier(candidates []*TokenStatus, key string, tier ServiceTier) *TokenStatus {
+	return selectForTier(s.base, s.belowThreshold(candidates), key, tier)
+}
+
+// belowThreshold 返回错误率未超过阈值的token，保持原有顺序；全部超过时返回原列表
+func (s *errorRateStrategy) belowThreshold(candidates []*TokenStatus) []*TokenStatus {
+	now := s.now()
+	kept := make([]*TokenStatus, 0, len(candidates))
+	for _, status := range candidates {
+		if status.Rate.countAt(now) < s.minRequests || status.errorRateAt(now) <= s.threshold {
+			kept = append(kept, status)
+		}
+	}
+	if len(kept) == 0 {
+		return candidates
+	}
+	return kept
+}
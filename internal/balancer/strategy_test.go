@@ -0,0 +1,332 @@
+package balancer
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTieredBalancer() JWTBalancer {
+	tokens := []config.JWTTokenConfig{
+		{Token: "primary1", Name: "Primary_1", Priority: 1},
+		{Token: "overflow1", Name: "Overflow_1", Priority: 2},
+		{Token: "primary2", Name: "Primary_2", Priority: 1},
+		{Token: "reserve1", Name: "Reserve_1", Priority: 3},
+	}
+	return NewJWTBalancerWithStrategy(tokens, NewPriorityTierStrategy(NewRoundRobinStrategy()))
+}
+
+func TestPriorityTierPrefersTierOne(t *testing.T) {
+	balancer := newTieredBalancer()
+
+	// 第1层内轮询
+	expectedOrder := []string{"primary1", "primary2", "primary1", "primary2"}
+	for i, expected := range expectedOrder {
+		token, err := balancer.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error at iteration %d: %v", i, err)
+		}
+		if token != expected {
+			t.Errorf("At iteration %d, expected %s, got %s", i, expected, token)
+		}
+	}
+}
+
+func TestPriorityTierFailover(t *testing.T) {
+	balancer := newTieredBalancer()
+
+	// 第1层全部不健康时切换到第2层
+	balancer.MarkTokenUnhealthy("primary1")
+	balancer.MarkTokenUnhealthy("primary2")
+	for i := 0; i < 4; i++ {
+		token, err := balancer.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if token != "overflow1" {
+			t.Errorf("Expected failover to overflow1, got %s", token)
+		}
+	}
+
+	// 第2层也不可用时切换到第3层
+	balancer.MarkTokenUnhealthy("overflow1")
+	if token, _ := balancer.GetToken(); token != "reserve1" {
+		t.Errorf("Expected failover to reserve1, got %s", token)
+	}
+
+	// 第1层恢复后立即回到第1层
+	balancer.MarkTokenHealthy("primary2")
+	if token, _ := balancer.GetToken(); token != "primary2" {
+		t.Errorf("Expected recovered primary2, got %s", token)
+	}
+}
+
+func TestPriorityTierServiceTier(t *testing.T) {
+	b := newTieredBalancer().(*BaseBalancer)
+
+	// flex请求使用优先级最低的一层，默认请求仍使用第1层
+	if token, _ := b.GetTokenForTier("", ServiceTierFlex); token != "reserve1" {
+		t.Errorf("Expected flex request on reserve1, got %s", token)
+	}
+	if token, _ := b.GetTokenForTier("", ServiceTierDefault); token != "primary1" && token != "primary2" {
+		t.Errorf("Expected default request on tier 1, got %s", token)
+	}
+
+	// 最低层不可用时使用其上一层
+	b.MarkTokenUnhealthy("reserve1")
+	if token, _ := b.GetTokenForTier("", ServiceTierFlex); token != "overflow1" {
+		t.Errorf("Expected flex request on overflow1, got %s", token)
+	}
+
+	// 错误率策略包装分层策略时等级照常生效
+	wrapped := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "primary1", Priority: 1},
+		{Token: "overflow1", Priority: 2},
+	}, NewErrorRateStrategy(NewPriorityTierStrategy(NewRoundRobinStrategy()), 0.5, 5)).(*BaseBalancer)
+	if token, _ := wrapped.GetTokenForTier("", ServiceTierFlex); token != "overflow1" {
+		t.Errorf("Expected flex request on overflow1 through the error rate strategy, got %s", token)
+	}
+}
+
+func TestServiceTierIgnoredWithoutTiers(t *testing.T) {
+	b := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "primary1", Priority: 1},
+		{Token: "overflow1", Priority: 2},
+	}, NewRoundRobinStrategy()).(*BaseBalancer)
+
+	// 未启用优先级分层时按基础策略轮询
+	for i, expected := range []string{"primary1", "overflow1", "primary1"} {
+		if token, _ := b.GetTokenForTier("", ServiceTierFlex); token != expected {
+			t.Errorf("At iteration %d, expected %s, got %s", i, expected, token)
+		}
+	}
+}
+
+func TestPriorityTierPartialOutage(t *testing.T) {
+	balancer := newTieredBalancer()
+
+	// 第1层仍有健康token时不使用其他层
+	balancer.MarkTokenUnhealthy("primary1")
+	for i := 0; i < 5; i++ {
+		token, err := balancer.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if token != "primary2" {
+			t.Errorf("Expected primary2 while tier 1 is partially up, got %s", token)
+		}
+	}
+}
+
+func TestPriorityTierUnsetPriority(t *testing.T) {
+	tokens := []config.JWTTokenConfig{
+		{Token: "tier2", Priority: 2},
+		{Token: "unset"},
+	}
+	balancer := NewJWTBalancerWithStrategy(tokens, NewPriorityTierStrategy(NewRoundRobinStrategy()))
+
+	// 未设置的优先级视为第1层
+	if token, _ := balancer.GetToken(); token != "unset" {
+		t.Errorf("Expected token with unset priority to be tier 1, got %s", token)
+	}
+}
+
+func TestBuildSelectionStrategy(t *testing.T) {
+	selector := BuildSelectionStrategy(&config.Config{LoadBalanceStrategy: config.Random, PriorityTiers: true})
+	if _, ok := selector.(*priorityTierStrategy); !ok {
+		t.Errorf("Expected priority tier strategy, got %T", selector)
+	}
+
+	selector = BuildSelectionStrategy(&config.Config{LoadBalanceStrategy: config.Random})
+	if _, ok := selector.(*randomStrategy); !ok {
+		t.Errorf("Expected random strategy, got %T", selector)
+	}
+}
+
+func TestBuildSelectionStrategyLoadAware(t *testing.T) {
+	selector := BuildSelectionStrategy(&config.Config{LoadAwareSelection: true})
+	if _, ok := selector.(*leastInFlightStrategy); !ok {
+		t.Errorf("Expected least in-flight strategy, got %T", selector)
+	}
+}
+
+// burstInFlightOnSlowToken 分多轮并发发出请求，选中slow的请求一直不结束，其余请求每轮结束后释放，
+// 返回最终压在slow上的在途请求数
+func burstInFlightOnSlowToken(t *testing.T, selector SelectionStrategy, rounds, burst int) int64 {
+	t.Helper()
+
+	b := NewJWTBalancerWithStrategy(tokenConfigsFromStrings([]string{"slow", "fast1", "fast2"}), selector)
+	for round := 0; round < rounds; round++ {
+		selected := make(chan string, burst)
+		var wg sync.WaitGroup
+		for i := 0; i < burst; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := b.GetToken()
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+					return
+				}
+				selected <- token
+			}()
+		}
+		wg.Wait()
+		close(selected)
+
+		for token := range selected {
+			if token != "slow" {
+				b.ReleaseToken(token)
+			}
+		}
+	}
+
+	for _, stats := range b.(*BaseBalancer).GetTokenStats() {
+		if stats.Name == "JWT_1" {
+			return stats.InFlight
+		}
+	}
+	t.Fatal("Expected stats for the slow token")
+	return 0
+}
+
+func TestLoadAwareSpreadsConcurrentBursts(t *testing.T) {
+	const rounds, burst = 10, 3
+
+	// 普通轮询不感知负载，每轮都会把一个请求分给已经积压的slow
+	plain := burstInFlightOnSlowToken(t, NewRoundRobinStrategy(), rounds, burst)
+	if plain != rounds {
+		t.Errorf("Expected plain round robin to pile %d requests on the slow token, got %d", rounds, plain)
+	}
+
+	// 在途感知时只有在其他token同样繁忙时才会继续选择slow
+	aware := burstInFlightOnSlowToken(t, NewLeastInFlightStrategy(NewRoundRobinStrategy()), rounds, burst)
+	if aware > 2 {
+		t.Errorf("Expected at most 2 in-flight requests on the slow token, got %d", aware)
+	}
+	if aware >= plain {
+		t.Errorf("Expected load aware spread (%d) to be tighter than round robin (%d)", aware, plain)
+	}
+}
+
+func TestLoadAwareEvenUnderConcurrency(t *testing.T) {
+	b := NewJWTBalancerWithStrategy(tokenConfigsFromStrings([]string{"a", "b", "c"}),
+		NewLeastInFlightStrategy(NewRandomStrategy()))
+
+	// 所有请求同时在途，随机策略本身分布不均，在途感知后各token的差距不超过1
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.GetToken(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, stats := range b.(*BaseBalancer).GetTokenStats() {
+		if stats.InFlight != 10 {
+			t.Errorf("Expected 10 in-flight requests on %s, got %d", stats.Name, stats.InFlight)
+		}
+	}
+}
+
+func TestReleaseToken(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1"}, config.RoundRobin)
+	token, _ := b.GetToken()
+
+	b.ReleaseToken(token)
+	// 重复释放或释放未知token不会出现负数
+	b.ReleaseToken(token)
+	b.ReleaseToken("unknown")
+
+	if inFlight := b.(*BaseBalancer).GetTokenStats()[0].InFlight; inFlight != 0 {
+		t.Errorf("Expected 0 in-flight requests, got %d", inFlight)
+	}
+}
+
+// recordOutcomes 在now时刻为token记录requests次请求，其中failures次失败
+func recordOutcomes(status *TokenStatus, now time.Time, requests, failures int) {
+	for i := 0; i < requests; i++ {
+		status.Rate.recordAt(now)
+	}
+	for i := 0; i < failures; i++ {
+		status.Errors.recordAt(now)
+	}
+}
+
+func TestErrorRateStrategyExcludesAndRecovers(t *testing.T) {
+	// 选择时按真实时间记录请求，模拟时间需与其一致，否则会落入同一个桶互相覆盖
+	now := time.Now()
+	strategy := NewErrorRateStrategy(NewRoundRobinStrategy(), 0.3, 5).(*errorRateStrategy)
+	strategy.now = func() time.Time { return now }
+	b := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "flaky", Name: "Flaky"},
+		{Token: "steady", Name: "Steady"},
+		{Token: "new", Name: "New"},
+	}, strategy).(*BaseBalancer)
+
+	// 间歇失败：每次健康检查都能通过，但最近一半的请求失败
+	recordOutcomes(b.tokens["flaky"], now, 10, 5)
+	recordOutcomes(b.tokens["steady"], now, 10, 1)
+	// 样本不足时不判断
+	recordOutcomes(b.tokens["new"], now, 2, 2)
+
+	for i := 0; i < 6; i++ {
+		if token, _ := b.GetToken(); token == "flaky" {
+			t.Fatalf("Expected flaky token to be excluded at iteration %d", i)
+		}
+	}
+
+	// 窗口内的失败过期后错误率回落，重新参与选择
+	now = now.Add(defaultRateWindowSeconds * time.Second)
+	selected := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		token, _ := b.GetToken()
+		selected[token] = true
+	}
+	if !selected["flaky"] {
+		t.Errorf("Expected flaky token to recover after its errors expired, got %v", selected)
+	}
+}
+
+func TestErrorRateStrategyKeepsAllWhenAllOverThreshold(t *testing.T) {
+	now := time.Now()
+	strategy := NewErrorRateStrategy(NewRoundRobinStrategy(), 0.3, 5).(*errorRateStrategy)
+	strategy.now = func() time.Time { return now }
+	b := NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "token1", Name: "Token_1"},
+		{Token: "token2", Name: "Token_2"},
+	}, strategy).(*BaseBalancer)
+	recordOutcomes(b.tokens["token1"], now, 10, 8)
+	recordOutcomes(b.tokens["token2"], now, 10, 6)
+
+	// 全部超过阈值时仍按基础策略选择，而不是拒绝请求
+	selected := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		token, err := b.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		selected[token] = true
+	}
+	if len(selected) != 2 {
+		t.Errorf("Expected both tokens to stay selectable, got %v", selected)
+	}
+}
+
+func TestTokenStatsErrorRate(t *testing.T) {
+	b := NewJWTBalancer([]string{"token1"}, config.RoundRobin).(*BaseBalancer)
+	for i := 0; i < 4; i++ {
+		token, _ := b.GetToken()
+		b.ReleaseToken(token)
+	}
+	b.MarkTokenUnhealthy("token1")
+
+	if rate := b.GetTokenStats()[0].ErrorRate; rate != 0.25 {
+		t.Errorf("Expected error rate 0.25, got %v", rate)
+	}
+}
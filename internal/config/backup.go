@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupTimeFormat 备份文件名中的时间戳格式，字典序与时间顺序一致
+const backupTimeFormat = "20060102-150405.000000000"
+
+// backupSuffix 备份文件名中配置文件路径之后的部分
+const backupSuffix = ".bak-"
+
+// writeFileAtomic 先写入临时文件并fsync，再重命名替换目标文件，写入中途失败或崩溃不会破坏原文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		// 重命名前确保数据已落盘，否则崩溃后可能得到一个已替换但内容为空的文件
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// backupConfigFile 将现有配置文件复制为带时间戳的备份，并只保留最近keep个备份；keep不大于0或文件不存在时跳过
+func backupConfigFile(path string, keep int, now time.Time) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 备份可能包含token，只允许所有者读取
+	if err := os.WriteFile(path+backupSuffix+now.Format(backupTimeFormat), data, 0600); err != nil {
+		return err
+	}
+	return pruneConfigBackups(path, keep)
+}
+
+// pruneConfigBackups 删除最旧的备份，只保留最近keep个
+func pruneConfigBackups(path string, keep int) error {
+	backups, err := filepath.Glob(path + backupSuffix + "*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-keep] {
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("failed to remove old backup %s: %v", backup, err)
+		}
+	}
+	return nil
+}
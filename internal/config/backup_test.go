@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveConfigWritesAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	manager := NewManager()
+	manager.configPath = path
+	manager.config.BearerToken = "bearer-1"
+
+	if err := manager.SaveConfig(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), `"bearer_token": "bearer-1"`) {
+		t.Errorf("Expected saved config, got %s (%v)", data, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected temp file to be renamed away, got %v", err)
+	}
+	// 默认不保留备份
+	if backups, _ := filepath.Glob(path + backupSuffix + "*"); len(backups) != 0 {
+		t.Errorf("Expected no backups by default, got %v", backups)
+	}
+}
+
+func TestSaveConfigKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	manager := NewManager()
+	manager.configPath = path
+	manager.config.ConfigBackupCount = 2
+
+	for _, bearer := range []string{"v1", "v2", "v3", "v4"} {
+		manager.config.BearerToken = bearer
+		if err := manager.SaveConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// 第一次保存时没有旧文件，之后三次各产生一个备份，只保留最近两个
+	backups, _ := filepath.Glob(path + backupSuffix + "*")
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %v", backups)
+	}
+	for i, expected := range []string{`"v2"`, `"v3"`} {
+		data, _ := os.ReadFile(backups[i])
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected backup %d to contain %s, got %s", i, expected, data)
+		}
+		if info, _ := os.Stat(backups[i]); info.Mode().Perm() != 0600 {
+			t.Errorf("Expected backup to be private, got %v", info.Mode().Perm())
+		}
+	}
+}
+
+func TestPruneConfigBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		os.WriteFile(path+backupSuffix+base.Add(time.Duration(i)*time.Second).Format(backupTimeFormat), []byte("{}"), 0600)
+	}
+
+	if err := pruneConfigBackups(path, 3); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	backups, _ := filepath.Glob(path + backupSuffix + "*")
+	if len(backups) != 3 || filepath.Base(backups[0]) != "config.json"+backupSuffix+base.Add(2*time.Second).Format(backupTimeFormat) {
+		t.Errorf("Expected the 3 newest backups to be kept, got %v", backups)
+	}
+}
+
+func TestSaveConfigWriteErrorKeepsOriginal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := []byte(`{"bearer_token": "original"}`)
+	os.WriteFile(path, original, 0644)
+
+	// 临时文件路径被目录占用，写入失败
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager()
+	manager.configPath = path
+	manager.config.BearerToken = "changed"
+	if err := manager.SaveConfig(); err == nil {
+		t.Fatal("Expected write error")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != string(original) {
+		t.Errorf("Expected original config to be intact, got %s", data)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RotateBearerToken 更换Bearer token，旧token在grace时间内仍然有效，便于客户端迁移；
+// 宽限期内再次轮换时，更早的token立即失效。返回旧token的失效时间
+func (m *Manager) RotateBearerToken(token string, grace time.Duration) (time.Time, error) {
+	return m.rotateBearerTokenAt(token, grace, time.Now())
+}
+
+func (m *Manager) rotateBearerTokenAt(token string, grace time.Duration, now time.Time) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, errors.New("bearer token must not be empty")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if token == m.config.BearerToken {
+		return time.Time{}, errors.New("new bearer token must differ from the current one")
+	}
+	m.previousBearerToken = m.config.BearerToken
+	m.previousBearerUntil = now.Add(grace)
+	m.config.BearerToken = token
+	return m.previousBearerUntil, nil
+}
+
+// SaveBearerToken 将当前Bearer token写入配置文件，只修改文件中的bearer_token字段并保留文件权限；
+// 合并后的配置含有环境变量、远程配置和${VAR}展开后的密钥，不能整体写回文件
+func (m *Manager) SaveBearerToken() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.configPath == "" {
+		m.configPath = "config.json"
+	}
+
+	fields := make(map[string]json.RawMessage)
+	// 新建的配置文件包含Bearer token，只允许所有者读写
+	perm := os.FileMode(0600)
+	data, err := os.ReadFile(m.configPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("failed to parse config file: %v", err)
+		}
+		if stat, err := os.Stat(m.configPath); err == nil {
+			perm = stat.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(m.configPath), 0755); err != nil {
+			return fmt.Errorf("failed to create config directory: %v", err)
+		}
+	default:
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	token, err := json.Marshal(m.config.BearerToken)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bearer token: %v", err)
+	}
+	fields["bearer_token"] = token
+	if data, err = json.MarshalIndent(fields, "", "  "); err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	// 覆盖前保留上一版本，运行时的错误修改可以从备份恢复
+	if err := backupConfigFile(m.configPath, m.config.ConfigBackupCount, time.Now()); err != nil {
+		return fmt.Errorf("failed to back up config file: %v", err)
+	}
+	if err := writeFileAtomic(m.configPath, data, perm); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+
+	log.Printf("Bearer token saved to: %s", m.configPath)
+	return nil
+}
+
+// BearerTokenValid 判断客户端token是否为当前Bearer token，或仍在宽限期内的旧token
+func (m *Manager) BearerTokenValid(token string) bool {
+	return m.bearerTokenValidAt(token, time.Now())
+}
+
+func (m *Manager) bearerTokenValidAt(token string, now time.Time) bool {
+	if token == "" {
+		return false
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if token == m.config.BearerToken {
+		return true
+	}
+	return token == m.previousBearerToken && now.Before(m.previousBearerUntil)
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateBearerTokenGraceWindow(t *testing.T) {
+	manager := NewManager()
+	manager.config.BearerToken = "old-bearer-token"
+	now := time.Now()
+
+	until, err := manager.rotateBearerTokenAt("new-bearer-token", time.Minute, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected old token to stay valid for the grace period, got %v", until)
+	}
+	if manager.GetConfig().BearerToken != "new-bearer-token" {
+		t.Errorf("Expected new token to be active, got %q", manager.GetConfig().BearerToken)
+	}
+
+	// 宽限期内新旧token都有效
+	if !manager.bearerTokenValidAt("new-bearer-token", now) || !manager.bearerTokenValidAt("old-bearer-token", now.Add(30*time.Second)) {
+		t.Error("Expected both tokens to be accepted during the grace window")
+	}
+	// 宽限期结束后旧token失效
+	if manager.bearerTokenValidAt("old-bearer-token", now.Add(time.Minute)) {
+		t.Error("Expected old token to be rejected after the grace window")
+	}
+	if !manager.bearerTokenValidAt("new-bearer-token", now.Add(time.Hour)) {
+		t.Error("Expected new token to stay valid")
+	}
+
+	// 再次轮换时更早的token立即失效
+	if _, err := manager.rotateBearerTokenAt("newest-bearer-token", time.Minute, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if manager.bearerTokenValidAt("old-bearer-token", now) {
+		t.Error("Expected the token from two rotations ago to be rejected")
+	}
+	if !manager.bearerTokenValidAt("new-bearer-token", now) {
+		t.Error("Expected the previous token to be accepted during the new grace window")
+	}
+}
+
+func TestRotateBearerTokenRejectsInvalidTokens(t *testing.T) {
+	manager := NewManager()
+	manager.config.BearerToken = "current-bearer-token"
+
+	if _, err := manager.RotateBearerToken("", time.Minute); err == nil {
+		t.Error("Expected error for empty bearer token")
+	}
+	if _, err := manager.RotateBearerToken("current-bearer-token", time.Minute); err == nil {
+		t.Error("Expected error when rotating to the current token")
+	}
+	if manager.BearerTokenValid("") {
+		t.Error("Expected empty token to be rejected")
+	}
+}
+
+func TestSaveBearerTokenOnlyWritesBearerToken(t *testing.T) {
+	t.Setenv("JWT_TOKENS", "env-jwt-secret")
+	t.Setenv("TEST_FILE_JWT", "expanded-jwt-secret")
+	manager := loadFromDir(t, `{"bearer_token":"file-bearer-token","jetbrains_tokens":[{"token":"${TEST_FILE_JWT}"}]}`)
+	if err := os.Chmod("config.json", 0640); err != nil {
+		t.Fatalf("Failed to chmod config file: %v", err)
+	}
+
+	if _, err := manager.RotateBearerToken("rotated-bearer-token", time.Minute); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := manager.SaveBearerToken(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile("config.json")
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	saved := string(data)
+	if !strings.Contains(saved, `"bearer_token": "rotated-bearer-token"`) {
+		t.Errorf("Expected rotated bearer token to be saved, got %s", saved)
+	}
+	// 环境变量中的token和展开后的密钥不写入文件，${VAR}引用原样保留
+	if strings.Contains(saved, "env-jwt-secret") || strings.Contains(saved, "expanded-jwt-secret") {
+		t.Errorf("Expected secrets from other layers not to be saved, got %s", saved)
+	}
+	if !strings.Contains(saved, "${TEST_FILE_JWT}") {
+		t.Errorf("Expected ${VAR} reference to be kept, got %s", saved)
+	}
+	// 其余默认值不写入文件
+	if strings.Contains(saved, "bearer_token_grace_period") {
+		t.Errorf("Expected only the bearer token to change, got %s", saved)
+	}
+	if stat, err := os.Stat("config.json"); err != nil || stat.Mode().Perm() != 0640 {
+		t.Errorf("Expected file permissions to be kept, got %v (%v)", stat.Mode().Perm(), err)
+	}
+}
@@ -2,15 +2,21 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"jetbrains-ai-proxy/internal/types"
+
 	"github.com/joho/godotenv"
 )
 
@@ -23,34 +29,188 @@ var (
 type LoadBalanceStrategy string
 
 const (
-	RoundRobin LoadBalanceStrategy = "round_robin"
-	Random     LoadBalanceStrategy = "random"
+	RoundRobin     LoadBalanceStrategy = "round_robin"
+	Random         LoadBalanceStrategy = "random"
+	ConsistentHash LoadBalanceStrategy = "consistent_hash"
+	LeastLatency   LoadBalanceStrategy = "least_latency"
 )
 
+// isValidStrategy 检查负载均衡策略名称是否受支持
+func isValidStrategy(strategy string) bool {
+	switch LoadBalanceStrategy(strategy) {
+	case RoundRobin, Random, ConsistentHash, LeastLatency:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultCompletionIDLength 补全ID随机后缀的默认长度
+const DefaultCompletionIDLength = 24
+
+// DefaultMaxSSELineSize 上游单行SSE数据的默认最大字节数
+const DefaultMaxSSELineSize = 1024 * 1024
+
+// DefaultUpstreamMaxRetries 上游请求失败时默认的重试次数
+const DefaultUpstreamMaxRetries = 2
+
 // JWTTokenConfig JWT token配置
 type JWTTokenConfig struct {
 	Token       string            `json:"token"`
 	Name        string            `json:"name,omitempty"`
 	Description string            `json:"description,omitempty"`
 	Priority    int               `json:"priority,omitempty"`
+	Enabled     *bool             `json:"enabled,omitempty"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
+// IsEnabled token是否参与轮换，未设置时默认启用
+func (t JWTTokenConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// HeaderMatcher 请求必须携带的header：Value精确匹配，Pattern正则匹配，都为空时只要求header存在
+type HeaderMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// ModelDefaults 按模型配置的默认请求参数
+type ModelDefaults map[string]types.ModelDefaults
+
+// MessageCatalog 自定义错误信息，按语言和错误码索引
+type MessageCatalog map[string]map[string]string
+
 // Config 应用配置
 type Config struct {
-	JetbrainsTokens     []JWTTokenConfig    `json:"jetbrains_tokens"`
-	BearerToken         string              `json:"bearer_token"`
-	LoadBalanceStrategy LoadBalanceStrategy `json:"load_balance_strategy"`
-	HealthCheckInterval time.Duration       `json:"health_check_interval"`
-	ServerPort          int                 `json:"server_port"`
-	ServerHost          string              `json:"server_host"`
+	JetbrainsTokens        []JWTTokenConfig    `json:"jetbrains_tokens"`
+	MaxJWTTokens           int                 `json:"max_jwt_tokens,omitempty"`
+	BearerToken            string              `json:"bearer_token"`
+	BearerTokenGracePeriod time.Duration       `json:"bearer_token_grace_period,omitempty"`
+	RequiredHeaders        []HeaderMatcher     `json:"required_headers,omitempty"`
+	DisabledEndpoints      []string            `json:"disabled_endpoints,omitempty"`
+	DisableNonStreaming    bool                `json:"disable_non_streaming,omitempty"`
+	CoalesceRequests       bool                `json:"coalesce_requests,omitempty"`
+	LoadBalanceStrategy    LoadBalanceStrategy `json:"load_balance_strategy"`
+	HealthCheckInterval    time.Duration       `json:"health_check_interval"`
+	StateReapInterval      time.Duration       `json:"state_reap_interval,omitempty"`
+	ServerPort             int                 `json:"server_port"`
+	ServerHost             string              `json:"server_host"`
+	AdminPort              int                 `json:"admin_port,omitempty"`
+	AdminHost              string              `json:"admin_host,omitempty"`
+	EnablePprof            bool                `json:"enable_pprof,omitempty"`
+	EnableDebugResponses   bool                `json:"enable_debug_responses,omitempty"`
+	MockUpstream           bool                `json:"mock_upstream,omitempty"`
+	WarmUpOnStart          bool                `json:"warm_up_on_start,omitempty"`
+	PriorityTiers          bool                `json:"priority_tiers,omitempty"`
+	LoadAwareSelection     bool                `json:"load_aware_selection,omitempty"`
+	ErrorRateThreshold     float64             `json:"error_rate_threshold,omitempty"`
+	ErrorRateMinRequests   int                 `json:"error_rate_min_requests,omitempty"`
+	DegradedThreshold      float64             `json:"degraded_threshold,omitempty"`
+	ModelAliases           map[string]string   `json:"model_aliases,omitempty"`
+	ModelRateLimits        map[string]int      `json:"model_rate_limits,omitempty"`
+	SystemAsUserModels     []string            `json:"system_as_user_models,omitempty"`
+	UpstreamPrompt         string              `json:"upstream_prompt,omitempty"`
+	ModelPrompts           map[string]string   `json:"model_prompts,omitempty"`
+	ModelDefaults          ModelDefaults       `json:"model_defaults,omitempty"`
+	EchoRequestedModel     bool                `json:"echo_requested_model,omitempty"`
+	HealthStateFile        string              `json:"health_state_file,omitempty"`
+	HealthCheckStartDelay  time.Duration       `json:"health_check_start_delay,omitempty"`
+	HealthCheckSkipInitial bool                `json:"health_check_skip_initial,omitempty"`
+	ReadinessMaxStaleness  time.Duration       `json:"readiness_max_staleness,omitempty"`
+	RequestFieldMapping    map[string]string   `json:"request_field_mapping,omitempty"`
+	FinishReasonMapping    map[string]string   `json:"finish_reason_mapping,omitempty"`
+	ExtraBodyMode          string              `json:"extra_body_mode,omitempty"`
+	MaxMessages            int                 `json:"max_messages,omitempty"`
+	MaxPromptTokens        int                 `json:"max_prompt_tokens,omitempty"`
+	ConversationLimitMode  string              `json:"conversation_limit_mode,omitempty"`
+	SystemMessageMode      string              `json:"system_message_mode,omitempty"`
+	HistoryWindowTokens    int                 `json:"history_window_tokens,omitempty"`
+	LogprobsMode           string              `json:"logprobs_mode,omitempty"`
+	PenaltyMode            string              `json:"penalty_mode,omitempty"`
+	ErrorLanguage          string              `json:"error_language,omitempty"`
+	ErrorMessages          MessageCatalog      `json:"error_messages,omitempty"`
+	ForwardParams          []string            `json:"forward_params,omitempty"`
+	DenyParams             []string            `json:"deny_params,omitempty"`
+	ParamFilterMode        string              `json:"param_filter_mode,omitempty"`
+	ContentTypeCheck       string              `json:"content_type_check,omitempty"`
+	UpstreamMaxRetries     *int                `json:"upstream_max_retries,omitempty"`
+	NoTokensRetryAfter     time.Duration       `json:"no_tokens_retry_after,omitempty"`
+	UpstreamRetryBudget    time.Duration       `json:"upstream_retry_budget,omitempty"`
+	RetryableErrorPatterns []string            `json:"retryable_error_patterns,omitempty"`
+	UpstreamURLs           []string            `json:"upstream_urls,omitempty"`
+	UpstreamConnectTimeout time.Duration       `json:"upstream_connect_timeout,omitempty"`
+	UpstreamMinTLSVersion  string              `json:"upstream_min_tls_version,omitempty"`
+	UpstreamUserAgent      string              `json:"upstream_user_agent,omitempty"`
+	StreamIdleTimeout      time.Duration       `json:"stream_idle_timeout,omitempty"`
+	MaxSSELineSize         int                 `json:"max_sse_line_size,omitempty"`
+	RequestTimeout         time.Duration       `json:"request_timeout,omitempty"`
+	IdleTimeoutModels      []string            `json:"idle_timeout_models,omitempty"`
+	MaxRequestTimeout      time.Duration       `json:"max_request_timeout,omitempty"`
+	TokenEncoding          string              `json:"token_encoding,omitempty"`
+	HeartbeatInterval      time.Duration       `json:"heartbeat_interval,omitempty"`
+	CompletionIDPrefix     string              `json:"completion_id_prefix,omitempty"`
+	CompletionIDLength     int                 `json:"completion_id_length,omitempty"`
+	StreamProgress         bool                `json:"stream_progress,omitempty"`
+	DefaultStream          bool                `json:"default_stream,omitempty"`
+	StreamCostUpdates      bool                `json:"stream_cost_updates,omitempty"`
+	SkipEmptyContent       bool                `json:"skip_empty_content,omitempty"`
+	NonFlushingStreamMode  string              `json:"non_flushing_stream_mode,omitempty"`
+	StreamTimeoutMode      string              `json:"stream_timeout_mode,omitempty"`
+	AssistantPrefill       string              `json:"assistant_prefill,omitempty"`
+	StrictConfig           bool                `json:"strict_config,omitempty"`
+	ConfigURL              string              `json:"config_url,omitempty"`
+	ConfigURLAuthorization string              `json:"config_url_authorization,omitempty"`
+	ConfigURLPollInterval  time.Duration       `json:"config_url_poll_interval,omitempty"`
+	UnsetEnvVars           string              `json:"unset_env_vars,omitempty"`
+	ConfigBackupCount      int                 `json:"config_backup_count,omitempty"`
+}
+
+var (
+	// ErrInvalidConfigFile 找到的配置文件无法读取或解析
+	ErrInvalidConfigFile = errors.New("invalid config file")
+	// errNoConfigFile 搜索路径中没有配置文件
+	errNoConfigFile = errors.New("no config file found in search paths")
+)
+
+// PromptFor 返回模型使用的上游prompt标识：model_prompts中有该模型时使用其配置，否则使用upstream_prompt
+func (c *Config) PromptFor(model string) string {
+	if prompt, ok := c.ModelPrompts[model]; ok {
+		return prompt
+	}
+	if c.UpstreamPrompt == "" {
+		return types.PROMPT
+	}
+	return c.UpstreamPrompt
+}
+
+// MaxRetries 返回上游请求失败时的重试次数，未设置时使用默认值
+func (c *Config) MaxRetries() int {
+	if c.UpstreamMaxRetries == nil {
+		return DefaultUpstreamMaxRetries
+	}
+	return *c.UpstreamMaxRetries
+}
+
+// intPtr 返回指向v的指针，用于需要区分未设置和0的配置项
+func intPtr(v int) *int {
+	return &v
 }
 
 // Manager 配置管理器
 type Manager struct {
-	config     *Config
-	configPath string
-	mutex      sync.RWMutex
+	config          *Config
+	configPath      string
+	duplicateTokens int                     // 最近一次加载时丢弃的重复token数量
+	sources         map[string]ConfigSource // 被覆盖过的配置项（json名称）的最后来源
+	// 轮换前的Bearer token及其失效时间，只保存在内存中
+	previousBearerToken string
+	previousBearerUntil time.Time
+	// 最近一次有效的远程配置，拉取失败时沿用
+	remoteConfigData []byte
+	remoteConfig     *Config
+	mutex            sync.RWMutex
 }
 
 // GetGlobalConfig 获取全局配置管理器（单例）
@@ -65,31 +225,75 @@ func GetGlobalConfig() *Manager {
 func NewManager() *Manager {
 	return &Manager{
 		config: &Config{
-			LoadBalanceStrategy: RoundRobin,
-			HealthCheckInterval: 30 * time.Second,
-			ServerPort:          8080,
-			ServerHost:          "0.0.0.0",
+			LoadBalanceStrategy:    RoundRobin,
+			HealthCheckInterval:    30 * time.Second,
+			StateReapInterval:      10 * time.Minute,
+			ServerPort:             8080,
+			ServerHost:             "0.0.0.0",
+			AdminHost:              "127.0.0.1",
+			UpstreamMaxRetries:     intPtr(DefaultUpstreamMaxRetries),
+			NoTokensRetryAfter:     30 * time.Second,
+			BearerTokenGracePeriod: 10 * time.Minute,
+			UpstreamConnectTimeout: 30 * time.Second,
+			UpstreamMinTLSVersion:  "1.2",
+			StreamIdleTimeout:      60 * time.Second,
+			MaxSSELineSize:         DefaultMaxSSELineSize,
+			RequestTimeout:         5 * time.Minute,
+			MaxRequestTimeout:      30 * time.Minute,
+			TokenEncoding:          "cl100k_base",
+			UpstreamPrompt:         types.PROMPT,
+			HeartbeatInterval:      30 * time.Second,
+			ErrorRateMinRequests:   5,
+			CompletionIDPrefix:     "chatcmpl-",
+			CompletionIDLength:     DefaultCompletionIDLength,
+			RetryableErrorPatterns: []string{
+				"rate limit", "quota", "overloaded", "unavailable", "timeout", "try again",
+			},
 		},
 	}
 }
 
 // LoadConfig 加载配置
 func (m *Manager) LoadConfig() error {
+	remote := m.prefetchRemoteConfig()
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// 1. 首先尝试加载 .env 文件
 	_ = godotenv.Load()
 
-	// 2. 自动发现并加载配置文件
-	if err := m.loadConfigFile(); err != nil {
-		log.Printf("Warning: Failed to load config file: %v", err)
+	// 2. 自动发现并加载配置文件；严格模式下配置文件存在但无效时直接失败，而不是带着错误的配置运行
+	var fileErr error
+	m.trackSourcesLocked(SourceFile, func() {
+		fileErr = m.loadConfigFile()
+	})
+	if fileErr != nil {
+		if m.strictConfigLocked() && !errors.Is(fileErr, errNoConfigFile) {
+			return fileErr
+		}
+		log.Printf("Warning: Failed to load config file: %v", fileErr)
+	}
+
+	// 3. 合并远程配置（覆盖配置文件），拉取失败时沿用最近一次有效的远程配置
+	var remoteErr error
+	m.trackSourcesLocked(SourceRemote, func() {
+		remoteErr = m.loadRemoteConfigLocked(remote)
+	})
+	if remoteErr != nil {
+		if m.strictConfigLocked() {
+			return remoteErr
+		}
+		log.Printf("Warning: Failed to load remote config: %v", remoteErr)
 	}
 
-	// 3. 从环境变量加载配置
-	m.loadFromEnv()
+	// 4. 从环境变量加载配置
+	m.trackSourcesLocked(SourceEnv, m.loadFromEnv)
+
+	// 5. 去除重复token并应用数量上限
+	m.normalizeJWTTokens()
 
-	// 4. 验证配置
+	// 6. 验证配置
 	return m.validateConfig()
 }
 
@@ -111,19 +315,43 @@ func (m *Manager) loadConfigFile() error {
 		}
 	}
 
-	return fmt.Errorf("no config file found in search paths")
+	return errNoConfigFile
+}
+
+// unsetEnvModeLocked 未设置环境变量的处理方式：环境变量UNSET_ENV_VARS优先，其次是配置文件和已加载的配置，调用方需持有锁
+func (m *Manager) unsetEnvModeLocked(fileConfig *Config) string {
+	if mode := os.Getenv("UNSET_ENV_VARS"); mode != "" {
+		return mode
+	}
+	if fileConfig.UnsetEnvVars != "" {
+		return fileConfig.UnsetEnvVars
+	}
+	return m.config.UnsetEnvVars
+}
+
+// strictConfigLocked 是否启用严格模式：环境变量STRICT_CONFIG优先，否则沿用已加载的配置，调用方需持有锁
+func (m *Manager) strictConfigLocked() bool {
+	if enabled, err := strconv.ParseBool(os.Getenv("STRICT_CONFIG")); err == nil {
+		return enabled
+	}
+	return m.config.StrictConfig
 }
 
 // loadFromFile 从文件加载配置
 func (m *Manager) loadFromFile(path string) error {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read config file %s: %v", path, err)
+		return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidConfigFile, path, err)
 	}
 
 	var fileConfig Config
 	if err := json.Unmarshal(data, &fileConfig); err != nil {
-		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		return fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidConfigFile, path, err)
+	}
+
+	// 展开配置值中的${VAR}引用，便于将密钥放在环境变量或密钥管理服务中
+	if err := expandConfigEnv(&fileConfig, m.unsetEnvModeLocked(&fileConfig) == UnsetEnvError); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfigFile, path, err)
 	}
 
 	// 合并配置
@@ -149,17 +377,74 @@ func (m *Manager) loadFromEnv() {
 		}
 	}
 
+	if limit, err := strconv.Atoi(os.Getenv("MAX_JWT_TOKENS")); err == nil && limit >= 0 {
+		m.config.MaxJWTTokens = limit
+	}
+
 	// Bearer Token
 	if bearerToken := os.Getenv("BEARER_TOKEN"); bearerToken != "" {
 		m.config.BearerToken = bearerToken
 	}
+	if grace, err := time.ParseDuration(os.Getenv("BEARER_TOKEN_GRACE_PERIOD")); err == nil && grace >= 0 {
+		m.config.BearerTokenGracePeriod = grace
+	}
+	// 远程配置
+	configURL, authorization := remoteConfigFromEnv()
+	if configURL != "" {
+		m.config.ConfigURL = configURL
+	}
+	if authorization != "" {
+		m.config.ConfigURLAuthorization = authorization
+	}
+	if interval, err := time.ParseDuration(os.Getenv("CONFIG_URL_POLL_INTERVAL")); err == nil && interval >= 0 {
+		m.config.ConfigURLPollInterval = interval
+	}
+	if endpoints := os.Getenv("DISABLED_ENDPOINTS"); endpoints != "" {
+		m.config.DisabledEndpoints = parseList(endpoints)
+	}
+	if disabled, err := strconv.ParseBool(os.Getenv("DISABLE_NON_STREAMING")); err == nil {
+		m.config.DisableNonStreaming = disabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("COALESCE_REQUESTS")); err == nil {
+		m.config.CoalesceRequests = enabled
+	}
 
 	// Load Balance Strategy
 	if strategy := os.Getenv("LOAD_BALANCE_STRATEGY"); strategy != "" {
-		if strategy == string(RoundRobin) || strategy == string(Random) {
+		if isValidStrategy(strategy) {
 			m.config.LoadBalanceStrategy = LoadBalanceStrategy(strategy)
 		}
 	}
+	if enabled, err := strconv.ParseBool(os.Getenv("PRIORITY_TIERS")); err == nil {
+		m.config.PriorityTiers = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("LOAD_AWARE_SELECTION")); err == nil {
+		m.config.LoadAwareSelection = enabled
+	}
+	if threshold, err := strconv.ParseFloat(os.Getenv("ERROR_RATE_THRESHOLD"), 64); err == nil {
+		m.config.ErrorRateThreshold = threshold
+	}
+	if requests, err := strconv.Atoi(os.Getenv("ERROR_RATE_MIN_REQUESTS")); err == nil {
+		m.config.ErrorRateMinRequests = requests
+	}
+	if threshold, err := strconv.ParseFloat(os.Getenv("DEGRADED_THRESHOLD"), 64); err == nil {
+		m.config.DegradedThreshold = threshold
+	}
+	if stateFile := os.Getenv("HEALTH_STATE_FILE"); stateFile != "" {
+		m.config.HealthStateFile = stateFile
+	}
+	if delay, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_START_DELAY")); err == nil && delay > 0 {
+		m.config.HealthCheckStartDelay = delay
+	}
+	if interval, err := time.ParseDuration(os.Getenv("STATE_REAP_INTERVAL")); err == nil && interval > 0 {
+		m.config.StateReapInterval = interval
+	}
+	if skip, err := strconv.ParseBool(os.Getenv("HEALTH_CHECK_SKIP_INITIAL")); err == nil {
+		m.config.HealthCheckSkipInitial = skip
+	}
+	if staleness, err := time.ParseDuration(os.Getenv("READINESS_MAX_STALENESS")); err == nil && staleness > 0 {
+		m.config.ReadinessMaxStaleness = staleness
+	}
 
 	// Server configuration
 	if port := os.Getenv("SERVER_PORT"); port != "" {
@@ -171,6 +456,164 @@ func (m *Manager) loadFromEnv() {
 	if host := os.Getenv("SERVER_HOST"); host != "" {
 		m.config.ServerHost = host
 	}
+	if port := os.Getenv("ADMIN_PORT"); port != "" {
+		if p, err := parsePort(port); err == nil {
+			m.config.AdminPort = p
+		}
+	}
+	if host := os.Getenv("ADMIN_HOST"); host != "" {
+		m.config.AdminHost = host
+	}
+
+	// Request limits
+	if maxMessages, err := strconv.Atoi(os.Getenv("MAX_MESSAGES")); err == nil && maxMessages >= 0 {
+		m.config.MaxMessages = maxMessages
+	}
+	if maxTokens, err := strconv.Atoi(os.Getenv("MAX_PROMPT_TOKENS")); err == nil && maxTokens >= 0 {
+		m.config.MaxPromptTokens = maxTokens
+	}
+	if mode := os.Getenv("CONVERSATION_LIMIT_MODE"); mode != "" {
+		m.config.ConversationLimitMode = mode
+	}
+	if mode := os.Getenv("SYSTEM_MESSAGE_MODE"); mode != "" {
+		m.config.SystemMessageMode = mode
+	}
+	if tokens, err := strconv.Atoi(os.Getenv("HISTORY_WINDOW_TOKENS")); err == nil && tokens >= 0 {
+		m.config.HistoryWindowTokens = tokens
+	}
+	if mode := os.Getenv("LOGPROBS_MODE"); mode != "" {
+		m.config.LogprobsMode = mode
+	}
+	if mode := os.Getenv("PENALTY_MODE"); mode != "" {
+		m.config.PenaltyMode = mode
+	}
+	if lang := os.Getenv("ERROR_LANGUAGE"); lang != "" {
+		m.config.ErrorLanguage = lang
+	}
+	if params := os.Getenv("FORWARD_PARAMS"); params != "" {
+		m.config.ForwardParams = parseList(params)
+	}
+	if params := os.Getenv("DENY_PARAMS"); params != "" {
+		m.config.DenyParams = parseList(params)
+	}
+	if mode := os.Getenv("PARAM_FILTER_MODE"); mode != "" {
+		m.config.ParamFilterMode = mode
+	}
+	if mode := os.Getenv("EXTRA_BODY_MODE"); mode != "" {
+		m.config.ExtraBodyMode = mode
+	}
+	if mode := os.Getenv("CONTENT_TYPE_CHECK"); mode != "" {
+		m.config.ContentTypeCheck = mode
+	}
+	if mode := os.Getenv("NON_FLUSHING_STREAM_MODE"); mode != "" {
+		m.config.NonFlushingStreamMode = mode
+	}
+	if mode := os.Getenv("STREAM_TIMEOUT_MODE"); mode != "" {
+		m.config.StreamTimeoutMode = mode
+	}
+	if mode := os.Getenv("ASSISTANT_PREFILL"); mode != "" {
+		m.config.AssistantPrefill = mode
+	}
+
+	if encoding := os.Getenv("TOKEN_ENCODING"); encoding != "" {
+		m.config.TokenEncoding = encoding
+	}
+	if prompt := os.Getenv("UPSTREAM_PROMPT"); prompt != "" {
+		m.config.UpstreamPrompt = prompt
+	}
+
+	// Upstream retry
+	if retries, err := strconv.Atoi(os.Getenv("UPSTREAM_MAX_RETRIES")); err == nil && retries >= 0 {
+		m.config.UpstreamMaxRetries = &retries
+	}
+	if budget, err := time.ParseDuration(os.Getenv("UPSTREAM_RETRY_BUDGET")); err == nil && budget > 0 {
+		m.config.UpstreamRetryBudget = budget
+	}
+	if retryAfter, err := time.ParseDuration(os.Getenv("NO_TOKENS_RETRY_AFTER")); err == nil && retryAfter > 0 {
+		m.config.NoTokensRetryAfter = retryAfter
+	}
+	if urls := os.Getenv("UPSTREAM_URLS"); urls != "" {
+		m.config.UpstreamURLs = parseList(urls)
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("UPSTREAM_CONNECT_TIMEOUT")); err == nil && timeout > 0 {
+		m.config.UpstreamConnectTimeout = timeout
+	}
+	if version := os.Getenv("UPSTREAM_MIN_TLS_VERSION"); version != "" {
+		m.config.UpstreamMinTLSVersion = version
+	}
+	if userAgent := os.Getenv("UPSTREAM_USER_AGENT"); userAgent != "" {
+		m.config.UpstreamUserAgent = userAgent
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("STREAM_IDLE_TIMEOUT")); err == nil && timeout > 0 {
+		m.config.StreamIdleTimeout = timeout
+	}
+	if size, err := strconv.Atoi(os.Getenv("MAX_SSE_LINE_SIZE")); err == nil && size > 0 {
+		m.config.MaxSSELineSize = size
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT")); err == nil && timeout > 0 {
+		m.config.RequestTimeout = timeout
+	}
+	if models := os.Getenv("IDLE_TIMEOUT_MODELS"); models != "" {
+		m.config.IdleTimeoutModels = parseList(models)
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("MAX_REQUEST_TIMEOUT")); err == nil && timeout > 0 {
+		m.config.MaxRequestTimeout = timeout
+	}
+	if interval, err := time.ParseDuration(os.Getenv("HEARTBEAT_INTERVAL")); err == nil && interval > 0 {
+		m.config.HeartbeatInterval = interval
+	}
+	if prefix := os.Getenv("COMPLETION_ID_PREFIX"); prefix != "" {
+		m.config.CompletionIDPrefix = prefix
+	}
+	if length, err := strconv.Atoi(os.Getenv("COMPLETION_ID_LENGTH")); err == nil && length > 0 {
+		m.config.CompletionIDLength = length
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("STREAM_PROGRESS")); err == nil {
+		m.config.StreamProgress = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("DEFAULT_STREAM")); err == nil {
+		m.config.DefaultStream = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("STREAM_COST_UPDATES")); err == nil {
+		m.config.StreamCostUpdates = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("SKIP_EMPTY_CONTENT")); err == nil {
+		m.config.SkipEmptyContent = enabled
+	}
+
+	// Debug
+	if enabled, err := strconv.ParseBool(os.Getenv("ENABLE_PPROF")); err == nil {
+		m.config.EnablePprof = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("ENABLE_DEBUG_RESPONSES")); err == nil {
+		m.config.EnableDebugResponses = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("MOCK_UPSTREAM")); err == nil {
+		m.config.MockUpstream = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("WARM_UP_ON_START")); err == nil {
+		m.config.WarmUpOnStart = enabled
+	}
+	if enabled, err := strconv.ParseBool(os.Getenv("STRICT_CONFIG")); err == nil {
+		m.config.StrictConfig = enabled
+	}
+	if mode := os.Getenv("UNSET_ENV_VARS"); mode != "" {
+		m.config.UnsetEnvVars = mode
+	}
+	if count, err := strconv.Atoi(os.Getenv("CONFIG_BACKUP_COUNT")); err == nil && count >= 0 {
+		m.config.ConfigBackupCount = count
+	}
+}
+
+// parseList 解析逗号分隔的列表，忽略空项
+func parseList(listStr string) []string {
+	var items []string
+	for _, item := range strings.Split(listStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // parseJWTTokens 解析JWT tokens字符串
@@ -192,26 +635,314 @@ func (m *Manager) parseJWTTokens(tokensStr string) []JWTTokenConfig {
 	return tokens
 }
 
+// TokenCollision 被丢弃的重复token及与之重复的token名称
+type TokenCollision struct {
+	Name string // 被丢弃的token
+	Kept string // 保留的（第一次出现的）token
+}
+
+// DedupeJWTTokens 去除重复的token（保留第一次出现的配置），返回去重后的列表和被丢弃的重复项
+func DedupeJWTTokens(tokens []JWTTokenConfig) ([]JWTTokenConfig, []TokenCollision) {
+	firstIndex := make(map[string]int, len(tokens))
+	unique := make([]JWTTokenConfig, 0, len(tokens))
+	var collisions []TokenCollision
+	for i, token := range tokens {
+		if kept, exists := firstIndex[token.Token]; exists {
+			collisions = append(collisions, TokenCollision{
+				Name: tokenLabel(token, i),
+				Kept: tokenLabel(tokens[kept], kept),
+			})
+			continue
+		}
+		firstIndex[token.Token] = i
+		unique = append(unique, token)
+	}
+	return unique, collisions
+}
+
+// tokenLabel 返回用于日志的token名称，未命名时使用配置中的序号
+func tokenLabel(token JWTTokenConfig, index int) string {
+	if token.Name != "" {
+		return token.Name
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+// normalizeJWTTokens 去除重复token并按MaxJWTTokens截断，有token被丢弃时记录警告
+func (m *Manager) normalizeJWTTokens() {
+	tokens, collisions := DedupeJWTTokens(m.config.JetbrainsTokens)
+	for _, collision := range collisions {
+		log.Printf("Warning: JWT token %s duplicates %s and was ignored", collision.Name, collision.Kept)
+	}
+	if len(collisions) > 0 {
+		log.Printf("Warning: ignored %d duplicate JWT tokens (%d configured, %d unique)",
+			len(collisions), len(m.config.JetbrainsTokens), len(tokens))
+	}
+	m.duplicateTokens = len(collisions)
+
+	if limit := m.config.MaxJWTTokens; limit > 0 && len(tokens) > limit {
+		log.Printf("Warning: %d JWT tokens configured, exceeding max_jwt_tokens (%d); only the first %d are used",
+			len(tokens), limit, limit)
+		tokens = tokens[:limit]
+	}
+
+	m.config.JetbrainsTokens = tokens
+}
+
+// GetDuplicateTokenCount 获取最近一次加载配置时丢弃的重复token数量
+func (m *Manager) GetDuplicateTokenCount() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.duplicateTokens
+}
+
 // mergeConfig 合并配置
 func (m *Manager) mergeConfig(other *Config) {
 	if len(other.JetbrainsTokens) > 0 {
 		m.config.JetbrainsTokens = other.JetbrainsTokens
 	}
+	if other.MaxJWTTokens > 0 {
+		m.config.MaxJWTTokens = other.MaxJWTTokens
+	}
 	if other.BearerToken != "" {
 		m.config.BearerToken = other.BearerToken
 	}
+	if other.BearerTokenGracePeriod > 0 {
+		m.config.BearerTokenGracePeriod = other.BearerTokenGracePeriod
+	}
+	if len(other.RequiredHeaders) > 0 {
+		m.config.RequiredHeaders = other.RequiredHeaders
+	}
+	if len(other.DisabledEndpoints) > 0 {
+		m.config.DisabledEndpoints = other.DisabledEndpoints
+	}
+	if other.DisableNonStreaming {
+		m.config.DisableNonStreaming = true
+	}
+	if other.CoalesceRequests {
+		m.config.CoalesceRequests = true
+	}
 	if other.LoadBalanceStrategy != "" {
 		m.config.LoadBalanceStrategy = other.LoadBalanceStrategy
 	}
 	if other.HealthCheckInterval > 0 {
 		m.config.HealthCheckInterval = other.HealthCheckInterval
 	}
+	if other.StateReapInterval > 0 {
+		m.config.StateReapInterval = other.StateReapInterval
+	}
 	if other.ServerPort > 0 {
 		m.config.ServerPort = other.ServerPort
 	}
 	if other.ServerHost != "" {
 		m.config.ServerHost = other.ServerHost
 	}
+	if other.AdminPort > 0 {
+		m.config.AdminPort = other.AdminPort
+	}
+	if other.AdminHost != "" {
+		m.config.AdminHost = other.AdminHost
+	}
+	if other.EnablePprof {
+		m.config.EnablePprof = true
+	}
+	if other.EnableDebugResponses {
+		m.config.EnableDebugResponses = true
+	}
+	if other.MockUpstream {
+		m.config.MockUpstream = true
+	}
+	if other.WarmUpOnStart {
+		m.config.WarmUpOnStart = true
+	}
+	if other.PriorityTiers {
+		m.config.PriorityTiers = true
+	}
+	if other.LoadAwareSelection {
+		m.config.LoadAwareSelection = true
+	}
+	if other.ErrorRateThreshold > 0 {
+		m.config.ErrorRateThreshold = other.ErrorRateThreshold
+	}
+	if other.ErrorRateMinRequests > 0 {
+		m.config.ErrorRateMinRequests = other.ErrorRateMinRequests
+	}
+	if other.DegradedThreshold > 0 {
+		m.config.DegradedThreshold = other.DegradedThreshold
+	}
+	if len(other.ModelAliases) > 0 {
+		m.config.ModelAliases = other.ModelAliases
+	}
+	if len(other.ModelRateLimits) > 0 {
+		m.config.ModelRateLimits = other.ModelRateLimits
+	}
+	if len(other.SystemAsUserModels) > 0 {
+		m.config.SystemAsUserModels = other.SystemAsUserModels
+	}
+	if other.UpstreamPrompt != "" {
+		m.config.UpstreamPrompt = other.UpstreamPrompt
+	}
+	if len(other.ModelPrompts) > 0 {
+		m.config.ModelPrompts = other.ModelPrompts
+	}
+	if len(other.ModelDefaults) > 0 {
+		m.config.ModelDefaults = other.ModelDefaults
+	}
+	if other.EchoRequestedModel {
+		m.config.EchoRequestedModel = true
+	}
+	if other.HealthStateFile != "" {
+		m.config.HealthStateFile = other.HealthStateFile
+	}
+	if other.HealthCheckStartDelay > 0 {
+		m.config.HealthCheckStartDelay = other.HealthCheckStartDelay
+	}
+	if other.HealthCheckSkipInitial {
+		m.config.HealthCheckSkipInitial = true
+	}
+	if other.ReadinessMaxStaleness > 0 {
+		m.config.ReadinessMaxStaleness = other.ReadinessMaxStaleness
+	}
+	if len(other.RequestFieldMapping) > 0 {
+		m.config.RequestFieldMapping = other.RequestFieldMapping
+	}
+	if len(other.FinishReasonMapping) > 0 {
+		m.config.FinishReasonMapping = other.FinishReasonMapping
+	}
+	if other.MaxMessages > 0 {
+		m.config.MaxMessages = other.MaxMessages
+	}
+	if other.MaxPromptTokens > 0 {
+		m.config.MaxPromptTokens = other.MaxPromptTokens
+	}
+	if other.ConversationLimitMode != "" {
+		m.config.ConversationLimitMode = other.ConversationLimitMode
+	}
+	if other.SystemMessageMode != "" {
+		m.config.SystemMessageMode = other.SystemMessageMode
+	}
+	if other.HistoryWindowTokens > 0 {
+		m.config.HistoryWindowTokens = other.HistoryWindowTokens
+	}
+	if other.LogprobsMode != "" {
+		m.config.LogprobsMode = other.LogprobsMode
+	}
+	if other.PenaltyMode != "" {
+		m.config.PenaltyMode = other.PenaltyMode
+	}
+	if other.ErrorLanguage != "" {
+		m.config.ErrorLanguage = other.ErrorLanguage
+	}
+	if len(other.ErrorMessages) > 0 {
+		m.config.ErrorMessages = other.ErrorMessages
+	}
+	if len(other.ForwardParams) > 0 {
+		m.config.ForwardParams = other.ForwardParams
+	}
+	if len(other.DenyParams) > 0 {
+		m.config.DenyParams = other.DenyParams
+	}
+	if other.ParamFilterMode != "" {
+		m.config.ParamFilterMode = other.ParamFilterMode
+	}
+	if other.ExtraBodyMode != "" {
+		m.config.ExtraBodyMode = other.ExtraBodyMode
+	}
+	if other.ContentTypeCheck != "" {
+		m.config.ContentTypeCheck = other.ContentTypeCheck
+	}
+	// 配置文件中显式设置为0时关闭重试，因此按是否设置而不是是否为0判断
+	if other.UpstreamMaxRetries != nil {
+		m.config.UpstreamMaxRetries = other.UpstreamMaxRetries
+	}
+	if other.UpstreamRetryBudget > 0 {
+		m.config.UpstreamRetryBudget = other.UpstreamRetryBudget
+	}
+	if len(other.RetryableErrorPatterns) > 0 {
+		m.config.RetryableErrorPatterns = other.RetryableErrorPatterns
+	}
+	if other.NoTokensRetryAfter > 0 {
+		m.config.NoTokensRetryAfter = other.NoTokensRetryAfter
+	}
+	if other.UpstreamConnectTimeout > 0 {
+		m.config.UpstreamConnectTimeout = other.UpstreamConnectTimeout
+	}
+	if len(other.UpstreamURLs) > 0 {
+		m.config.UpstreamURLs = other.UpstreamURLs
+	}
+	if other.UpstreamMinTLSVersion != "" {
+		m.config.UpstreamMinTLSVersion = other.UpstreamMinTLSVersion
+	}
+	if other.UpstreamUserAgent != "" {
+		m.config.UpstreamUserAgent = other.UpstreamUserAgent
+	}
+	if other.StreamIdleTimeout > 0 {
+		m.config.StreamIdleTimeout = other.StreamIdleTimeout
+	}
+	if other.MaxSSELineSize > 0 {
+		m.config.MaxSSELineSize = other.MaxSSELineSize
+	}
+	if other.RequestTimeout > 0 {
+		m.config.RequestTimeout = other.RequestTimeout
+	}
+	if len(other.IdleTimeoutModels) > 0 {
+		m.config.IdleTimeoutModels = other.IdleTimeoutModels
+	}
+	if other.MaxRequestTimeout > 0 {
+		m.config.MaxRequestTimeout = other.MaxRequestTimeout
+	}
+	if other.TokenEncoding != "" {
+		m.config.TokenEncoding = other.TokenEncoding
+	}
+	if other.HeartbeatInterval > 0 {
+		m.config.HeartbeatInterval = other.HeartbeatInterval
+	}
+	if other.CompletionIDPrefix != "" {
+		m.config.CompletionIDPrefix = other.CompletionIDPrefix
+	}
+	if other.CompletionIDLength > 0 {
+		m.config.CompletionIDLength = other.CompletionIDLength
+	}
+	if other.StreamProgress {
+		m.config.StreamProgress = true
+	}
+	if other.DefaultStream {
+		m.config.DefaultStream = true
+	}
+	if other.StreamCostUpdates {
+		m.config.StreamCostUpdates = true
+	}
+	if other.SkipEmptyContent {
+		m.config.SkipEmptyContent = true
+	}
+	if other.NonFlushingStreamMode != "" {
+		m.config.NonFlushingStreamMode = other.NonFlushingStreamMode
+	}
+	if other.StreamTimeoutMode != "" {
+		m.config.StreamTimeoutMode = other.StreamTimeoutMode
+	}
+	if other.AssistantPrefill != "" {
+		m.config.AssistantPrefill = other.AssistantPrefill
+	}
+	if other.StrictConfig {
+		m.config.StrictConfig = true
+	}
+	if other.ConfigURL != "" {
+		m.config.ConfigURL = other.ConfigURL
+	}
+	if other.ConfigURLAuthorization != "" {
+		m.config.ConfigURLAuthorization = other.ConfigURLAuthorization
+	}
+	if other.ConfigURLPollInterval > 0 {
+		m.config.ConfigURLPollInterval = other.ConfigURLPollInterval
+	}
+	if other.UnsetEnvVars != "" {
+		m.config.UnsetEnvVars = other.UnsetEnvVars
+	}
+	if other.ConfigBackupCount > 0 {
+		m.config.ConfigBackupCount = other.ConfigBackupCount
+	}
 }
 
 // validateConfig 验证配置
@@ -228,6 +959,74 @@ func (m *Manager) validateConfig() error {
 		return fmt.Errorf("invalid server port: %d", m.config.ServerPort)
 	}
 
+	if m.config.ErrorRateThreshold < 0 || m.config.ErrorRateThreshold > 1 {
+		return fmt.Errorf("invalid error_rate_threshold: %v, must be between 0 and 1", m.config.ErrorRateThreshold)
+	}
+	if m.config.DegradedThreshold < 0 || m.config.DegradedThreshold > 1 {
+		return fmt.Errorf("invalid degraded_threshold: %v, must be between 0 and 1", m.config.DegradedThreshold)
+	}
+
+	for _, matcher := range m.config.RequiredHeaders {
+		if matcher.Name == "" {
+			return fmt.Errorf("required header matcher must have a name")
+		}
+		if matcher.Pattern != "" {
+			if _, err := regexp.Compile(matcher.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern for required header %s: %v", matcher.Name, err)
+			}
+		}
+	}
+
+	if _, err := ParseTLSVersion(m.config.UpstreamMinTLSVersion); err != nil {
+		return err
+	}
+
+	if m.config.ConfigURL != "" {
+		parsed, err := url.Parse(m.config.ConfigURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid config URL: %s", m.config.ConfigURL)
+		}
+	}
+
+	for _, upstreamURL := range m.config.UpstreamURLs {
+		parsed, err := url.Parse(upstreamURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid upstream URL: %s", upstreamURL)
+		}
+	}
+
+	if strings.TrimSpace(m.config.UpstreamPrompt) == "" {
+		return fmt.Errorf("upstream_prompt must not be empty")
+	}
+	for model, prompt := range m.config.ModelPrompts {
+		if strings.TrimSpace(prompt) == "" {
+			return fmt.Errorf("model_prompts: prompt for %s must not be empty", model)
+		}
+	}
+	for model, defaults := range m.config.ModelDefaults {
+		if _, err := types.GetModelByName(model); err != nil {
+			return fmt.Errorf("model_defaults: %v", err)
+		}
+		if err := types.ValidateReasoningEffort(defaults.ReasoningEffort); err != nil {
+			return fmt.Errorf("model_defaults: %s: %v", model, err)
+		}
+	}
+
+	for reason, mapped := range m.config.FinishReasonMapping {
+		switch mapped {
+		case "stop", "length", "content_filter", "tool_calls":
+		default:
+			return fmt.Errorf("invalid finish reason mapping for %s: %s", reason, mapped)
+		}
+	}
+
+	if m.config.AdminPort < 0 || m.config.AdminPort > 65535 {
+		return fmt.Errorf("invalid admin port: %d", m.config.AdminPort)
+	}
+	if m.config.AdminPort > 0 && m.config.AdminPort == m.config.ServerPort {
+		return fmt.Errorf("admin port must differ from server port: %d", m.config.AdminPort)
+	}
+
 	return nil
 }
 
@@ -241,6 +1040,14 @@ func (m *Manager) GetConfig() *Config {
 	return &configCopy
 }
 
+// UpdateConfig 在写锁保护下修改当前配置
+func (m *Manager) UpdateConfig(fn func(cfg *Config)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fn(m.config)
+}
+
 // GetJWTTokens 获取JWT tokens字符串列表
 func (m *Manager) GetJWTTokens() []string {
 	m.mutex.RLock()
@@ -253,6 +1060,20 @@ func (m *Manager) GetJWTTokens() []string {
 	return tokens
 }
 
+// GetEnabledJWTTokens 获取未被禁用的JWT tokens
+func (m *Manager) GetEnabledJWTTokens() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tokens := make([]string, 0, len(m.config.JetbrainsTokens))
+	for _, tokenConfig := range m.config.JetbrainsTokens {
+		if tokenConfig.IsEnabled() {
+			tokens = append(tokens, tokenConfig.Token)
+		}
+	}
+	return tokens
+}
+
 // GetJWTTokenConfigs 获取JWT token配置列表
 func (m *Manager) GetJWTTokenConfigs() []JWTTokenConfig {
 	m.mutex.RLock()
@@ -270,6 +1091,7 @@ func (m *Manager) SetJWTTokens(tokensStr string) {
 
 	if tokensStr != "" {
 		m.config.JetbrainsTokens = m.parseJWTTokens(tokensStr)
+		m.normalizeJWTTokens()
 	}
 }
 
@@ -286,7 +1108,7 @@ func (m *Manager) SetLoadBalanceStrategy(strategy string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if strategy == string(RoundRobin) || strategy == string(Random) {
+	if isValidStrategy(strategy) {
 		m.config.LoadBalanceStrategy = LoadBalanceStrategy(strategy)
 	}
 }
@@ -301,8 +1123,9 @@ func (m *Manager) HasJWTTokens() bool {
 
 // SaveConfig 保存配置到文件
 func (m *Manager) SaveConfig() error {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	// 可能设置默认的configPath，需要写锁
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	if m.configPath == "" {
 		m.configPath = "config.json"
@@ -318,7 +1141,12 @@ func (m *Manager) SaveConfig() error {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
-	if err := ioutil.WriteFile(m.configPath, data, 0644); err != nil {
+	// 覆盖前保留上一版本，运行时的错误修改可以从备份恢复
+	if err := backupConfigFile(m.configPath, m.config.ConfigBackupCount, time.Now()); err != nil {
+		return fmt.Errorf("failed to back up config file: %v", err)
+	}
+
+	if err := writeFileAtomic(m.configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
 
@@ -388,8 +1216,37 @@ func (m *Manager) PrintConfig() {
 	}
 	fmt.Printf("Bearer Token: %s...\n", m.config.BearerToken[:min(len(m.config.BearerToken), 20)])
 	fmt.Printf("Load Balance Strategy: %s\n", m.config.LoadBalanceStrategy)
+	if m.config.PriorityTiers {
+		fmt.Println("Priority Tiers: enabled")
+	}
+	if m.config.LoadAwareSelection {
+		fmt.Println("Load Aware Selection: enabled")
+	}
+	if m.config.ErrorRateThreshold > 0 {
+		fmt.Printf("Error Rate Threshold: %v (min %d requests)\n", m.config.ErrorRateThreshold, m.config.ErrorRateMinRequests)
+	}
+	if m.config.DegradedThreshold > 0 {
+		fmt.Printf("Degraded Threshold: %v\n", m.config.DegradedThreshold)
+	}
 	fmt.Printf("Health Check Interval: %v\n", m.config.HealthCheckInterval)
+	if m.config.HealthCheckSkipInitial {
+		fmt.Println("Health Check On Start: skipped")
+	} else if m.config.HealthCheckStartDelay > 0 {
+		fmt.Printf("Health Check Start Delay: %v\n", m.config.HealthCheckStartDelay)
+	}
+	if m.config.HealthStateFile != "" {
+		fmt.Printf("Health State File: %s\n", m.config.HealthStateFile)
+	}
 	fmt.Printf("Server: %s:%d\n", m.config.ServerHost, m.config.ServerPort)
+	if m.config.AdminPort > 0 {
+		fmt.Printf("Admin Server: %s:%d\n", m.config.AdminHost, m.config.AdminPort)
+	}
+	if m.config.EnablePprof {
+		fmt.Println("Pprof: enabled (/debug/pprof)")
+	}
+	if m.config.MockUpstream {
+		fmt.Println("Mock Upstream: enabled (offline mode)")
+	}
 	if m.configPath != "" {
 		fmt.Printf("Config File: %s\n", m.configPath)
 	}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"jetbrains-ai-proxy/internal/types"
+)
+
+// captureLog 捕获测试期间的日志输出
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	original := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(original)
+	})
+	return &buf
+}
+
+func TestDedupeJWTTokens(t *testing.T) {
+	tokens := []JWTTokenConfig{
+		{Token: "a", Name: "first"},
+		{Token: "b"},
+		{Token: "a", Name: "second"},
+		{Token: "a"},
+	}
+
+	unique, collisions := DedupeJWTTokens(tokens)
+	if len(unique) != 2 || len(collisions) != 2 {
+		t.Fatalf("Expected 2 unique and 2 duplicates, got %d unique and %d duplicates", len(unique), len(collisions))
+	}
+	// 保留第一次出现的配置
+	if unique[0].Name != "first" || unique[1].Token != "b" {
+		t.Errorf("Expected first occurrence to be kept in order, got %+v", unique)
+	}
+}
+
+func TestSetJWTTokensWarnsOnDuplicates(t *testing.T) {
+	buf := captureLog(t)
+	manager := NewManager()
+
+	manager.SetJWTTokens("jwt1, jwt2, jwt1 ,jwt3,jwt2")
+	if count := len(manager.GetJWTTokens()); count != 3 {
+		t.Errorf("Expected 3 unique tokens, got %d", count)
+	}
+	if !strings.Contains(buf.String(), "ignored 2 duplicate JWT tokens") {
+		t.Errorf("Expected duplicate warning, got %q", buf.String())
+	}
+	// 日志中指明哪些token发生了重复
+	if !strings.Contains(buf.String(), "JWT_3 duplicates JWT_1") || !strings.Contains(buf.String(), "JWT_5 duplicates JWT_2") {
+		t.Errorf("Expected colliding names to be logged, got %q", buf.String())
+	}
+	if manager.GetDuplicateTokenCount() != 2 {
+		t.Errorf("Expected 2 duplicates to be reported, got %d", manager.GetDuplicateTokenCount())
+	}
+}
+
+func TestMaxJWTTokensCap(t *testing.T) {
+	buf := captureLog(t)
+	manager := NewManager()
+	manager.UpdateConfig(func(cfg *Config) {
+		cfg.MaxJWTTokens = 2
+	})
+
+	manager.SetJWTTokens("jwt1,jwt2,jwt3,jwt4")
+	tokens := manager.GetJWTTokens()
+	if len(tokens) != 2 || tokens[0] != "jwt1" || tokens[1] != "jwt2" {
+		t.Errorf("Expected the first 2 tokens to be kept, got %+v", tokens)
+	}
+	if !strings.Contains(buf.String(), "exceeding max_jwt_tokens") {
+		t.Errorf("Expected cap warning, got %q", buf.String())
+	}
+}
+
+func TestDedupeJWTTokensCollisionNames(t *testing.T) {
+	tokens := []JWTTokenConfig{
+		{Token: "a", Name: "Primary"},
+		{Token: "b"},
+		{Token: "a", Name: "Copy"},
+		{Token: "b"},
+	}
+
+	_, collisions := DedupeJWTTokens(tokens)
+	expected := []TokenCollision{{Name: "Copy", Kept: "Primary"}, {Name: "#4", Kept: "#2"}}
+	if len(collisions) != len(expected) {
+		t.Fatalf("Expected %d collisions, got %+v", len(expected), collisions)
+	}
+	for i := range expected {
+		if collisions[i] != expected[i] {
+			t.Errorf("Collision %d: expected %+v, got %+v", i, expected[i], collisions[i])
+		}
+	}
+}
+
+// withMalformedConfigFile 在临时目录中写入无法解析的config.json，并通过环境变量提供必需配置
+func withMalformedConfigFile(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"bearer_token": "x",`), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Chdir(dir)
+	t.Setenv("JWT_TOKENS", "jwt-1")
+	t.Setenv("BEARER_TOKEN", "bearer-from-env")
+}
+
+func TestMalformedConfigFileLenientByDefault(t *testing.T) {
+	withMalformedConfigFile(t)
+	buf := captureLog(t)
+
+	manager := NewManager()
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("Expected lenient mode to continue with env, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "Failed to load config file") {
+		t.Errorf("Expected warning about the config file, got %q", buf.String())
+	}
+	if manager.GetConfig().BearerToken != "bearer-from-env" {
+		t.Errorf("Expected env config to be used, got %q", manager.GetConfig().BearerToken)
+	}
+}
+
+func TestMalformedConfigFileFailsInStrictMode(t *testing.T) {
+	withMalformedConfigFile(t)
+	t.Setenv("STRICT_CONFIG", "true")
+
+	err := NewManager().LoadConfig()
+	if !errors.Is(err, ErrInvalidConfigFile) {
+		t.Fatalf("Expected ErrInvalidConfigFile, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "config.json") {
+		t.Errorf("Expected error to name the file, got %v", err)
+	}
+}
+
+func TestStrictModeWithoutConfigFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("STRICT_CONFIG", "true")
+	t.Setenv("JWT_TOKENS", "jwt-1")
+	t.Setenv("BEARER_TOKEN", "bearer-from-env")
+
+	// 没有配置文件不是错误
+	if err := NewManager().LoadConfig(); err != nil {
+		t.Errorf("Expected missing config file to be allowed in strict mode, got %v", err)
+	}
+}
+
+func TestUpstreamMaxRetriesFromFile(t *testing.T) {
+	t.Setenv("JWT_TOKENS", "jwt-1")
+	t.Setenv("BEARER_TOKEN", "bearer-from-env")
+
+	cases := []struct {
+		file     string
+		expected string
+	}{
+		{`{}`, "2"},
+		{`{"upstream_max_retries": 0}`, "0"},
+		{`{"upstream_max_retries": 5}`, "5"},
+	}
+	for _, tc := range cases {
+		manager := loadFromDir(t, tc.file)
+		// 配置文件中显式设置的0会关闭重试，摘要中显示数值而不是指针
+		if got := strconv.Itoa(manager.GetConfig().MaxRetries()); got != tc.expected {
+			t.Errorf("Config %s: expected %s retries, got %s", tc.file, tc.expected, got)
+		}
+		if got := findSetting(t, manager.Summary(), "upstream_max_retries").Value; got != tc.expected {
+			t.Errorf("Config %s: expected summary value %s, got %s", tc.file, tc.expected, got)
+		}
+	}
+}
+
+func TestValidateFinishReasonMapping(t *testing.T) {
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+
+	manager.config.FinishReasonMapping = map[string]string{"quota": "length", "tool_use": "tool_calls"}
+	if err := manager.validateConfig(); err != nil {
+		t.Errorf("Expected valid mapping, got %v", err)
+	}
+
+	manager.config.FinishReasonMapping = map[string]string{"quota": "out_of_quota"}
+	if err := manager.validateConfig(); err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Errorf("Expected error naming the invalid mapping, got %v", err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	cases := map[string]uint16{"": tls.VersionTLS12, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+	for version, expected := range cases {
+		if got, err := ParseTLSVersion(version); err != nil || got != expected {
+			t.Errorf("Expected %q to parse as %x, got %x (%v)", version, expected, got, err)
+		}
+	}
+	if _, err := ParseTLSVersion("TLS1.3"); err == nil {
+		t.Error("Expected error for invalid TLS version")
+	}
+
+	// 无效版本在配置校验时报错
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+	manager.config.UpstreamMinTLSVersion = "1.4"
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for invalid TLS version")
+	}
+}
+
+func TestUpstreamURLs(t *testing.T) {
+	if urls := parseList(" https://a.example/v7, ,https://b.example/v7 "); len(urls) != 2 || urls[1] != "https://b.example/v7" {
+		t.Errorf("Unexpected parsed upstream URLs: %v", urls)
+	}
+
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+	manager.config.UpstreamURLs = []string{"https://a.example/v7", "a.example/v7"}
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for upstream URL without scheme")
+	}
+}
+
+func TestErrorRateThresholdValidation(t *testing.T) {
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+	for threshold, valid := range map[float64]bool{0: true, 0.5: true, 1: true, -0.1: false, 1.5: false} {
+		manager.config.ErrorRateThreshold = threshold
+		if err := manager.validateConfig(); (err == nil) != valid {
+			t.Errorf("Threshold %v: expected valid=%v, got %v", threshold, valid, err)
+		}
+	}
+}
+
+func TestUpstreamPrompt(t *testing.T) {
+	cfg := &Config{ModelPrompts: map[string]string{"o1": "ij.chat.request.reasoning"}}
+	if prompt := cfg.PromptFor("gpt-4o"); prompt != types.PROMPT {
+		t.Errorf("Expected default prompt when unset, got %s", prompt)
+	}
+	cfg.UpstreamPrompt = "ij.chat.request.custom"
+	if prompt := cfg.PromptFor("gpt-4o"); prompt != "ij.chat.request.custom" {
+		t.Errorf("Expected global prompt, got %s", prompt)
+	}
+	if prompt := cfg.PromptFor("o1"); prompt != "ij.chat.request.reasoning" {
+		t.Errorf("Expected per-model prompt, got %s", prompt)
+	}
+
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+	if manager.config.UpstreamPrompt != types.PROMPT {
+		t.Errorf("Expected default upstream prompt, got %q", manager.config.UpstreamPrompt)
+	}
+	manager.config.UpstreamPrompt = " "
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for empty upstream prompt")
+	}
+	manager.config.UpstreamPrompt = types.PROMPT
+	manager.config.ModelPrompts = map[string]string{"o1": ""}
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for empty model prompt")
+	}
+}
+
+func TestModelDefaultsValidation(t *testing.T) {
+	manager := NewManager()
+	manager.config.JetbrainsTokens = []JWTTokenConfig{{Token: "jwt-1"}}
+	manager.config.BearerToken = "bearer"
+
+	manager.config.ModelDefaults = ModelDefaults{"o3": {ReasoningEffort: "high"}}
+	if err := manager.validateConfig(); err != nil {
+		t.Errorf("Expected valid model defaults, got %v", err)
+	}
+	manager.config.ModelDefaults = ModelDefaults{"o3": {ReasoningEffort: "extreme"}}
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for invalid reasoning_effort default")
+	}
+	manager.config.ModelDefaults = ModelDefaults{"gpt-99": {ReasoningEffort: "low"}}
+	if err := manager.validateConfig(); err == nil {
+		t.Error("Expected validation error for unknown model")
+	}
+}
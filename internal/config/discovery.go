@@ -2,24 +2,37 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
+// ErrNoConfigFound 没有找到任何配置文件，且未生成示例配置
+var ErrNoConfigFound = errors.New("no configuration file found")
+
 // ConfigDiscovery 配置发现器
 type ConfigDiscovery struct {
 	searchPaths []string
 	manager     *Manager
+	// autoGenerate 找不到配置时是否生成示例配置文件，只读容器中应关闭
+	autoGenerate bool
 }
 
-// NewConfigDiscovery 创建配置发现器
+// NewConfigDiscovery 创建配置发现器，环境变量CONFIG_AUTO_GENERATE=false时不生成示例配置
 func NewConfigDiscovery(manager *Manager) *ConfigDiscovery {
+	autoGenerate := true
+	if enabled, err := strconv.ParseBool(os.Getenv("CONFIG_AUTO_GENERATE")); err == nil {
+		autoGenerate = enabled
+	}
+
 	return &ConfigDiscovery{
-		manager: manager,
+		manager:      manager,
+		autoGenerate: autoGenerate,
 		searchPaths: []string{
 			// 当前目录
 			"config.json",
@@ -80,11 +93,19 @@ func (cd *ConfigDiscovery) DiscoverAndLoad() error {
 		return nil // .env 文件会在 LoadConfig 中自动加载
 	}
 
-	// 4. 如果没有找到配置文件，生成示例配置
+	// 4. 如果没有找到配置文件，生成示例配置；关闭自动生成时不写入任何文件
+	if !cd.autoGenerate {
+		return fmt.Errorf("%w: set CONFIG_FILE or provide one of the searched paths", ErrNoConfigFound)
+	}
 	log.Println("No configuration file found, generating example config...")
 	return cd.generateDefaultConfig()
 }
 
+// SetAutoGenerate 设置找不到配置时是否生成示例配置文件
+func (cd *ConfigDiscovery) SetAutoGenerate(enabled bool) {
+	cd.autoGenerate = enabled
+}
+
 // loadConfigFile 加载指定的配置文件
 func (cd *ConfigDiscovery) loadConfigFile(path string) error {
 	data, err := ioutil.ReadFile(path)
@@ -177,7 +198,7 @@ JWT_TOKENS=eyJ0eXAiOiJKV1QiLCJhbGciOiJIUzI1NiJ9...,eyJ0eXAiOiJKV1QiLCJhbGciOiJIU
 # Bearer token for API authentication
 BEARER_TOKEN=your_bearer_token_here
 
-# Load balancing strategy: round_robin or random
+# Load balancing strategy: round_robin, random, consistent_hash or least_latency
 LOAD_BALANCE_STRATEGY=round_robin
 
 # Server configuration
@@ -289,5 +310,6 @@ func (cd *ConfigDiscovery) GetConfigSummary() map[string]interface{} {
 		"server_host":           config.ServerHost,
 		"server_port":           config.ServerPort,
 		"config_file":           cd.manager.configPath,
+		"sources":               cd.manager.GetSources(),
 	}
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDiscovery 在空的临时目录中创建只搜索当前目录的配置发现器
+func newTestDiscovery(t *testing.T) (*ConfigDiscovery, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("CONFIG_FILE", "")
+
+	cd := NewConfigDiscovery(NewManager())
+	cd.searchPaths = []string{"config.json", "config/config.json"}
+	return cd, dir
+}
+
+func TestDiscoverAndLoadGeneratesExampleConfig(t *testing.T) {
+	cd, dir := newTestDiscovery(t)
+
+	err := cd.DiscoverAndLoad()
+	if err == nil || errors.Is(err, ErrNoConfigFound) {
+		t.Fatalf("Expected example generation error, got %v", err)
+	}
+	for _, name := range []string{"config/config.json", ".env.example"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be generated: %v", name, err)
+		}
+	}
+}
+
+func TestDiscoverAndLoadWithoutAutoGenerate(t *testing.T) {
+	t.Setenv("CONFIG_AUTO_GENERATE", "false")
+	cd, dir := newTestDiscovery(t)
+
+	if err := cd.DiscoverAndLoad(); !errors.Is(err, ErrNoConfigFound) {
+		t.Fatalf("Expected ErrNoConfigFound, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files to be written, found %d entries", len(entries))
+	}
+}
+
+func TestDiscoverAndLoadReadOnlyDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+	cd, dir := newTestDiscovery(t)
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	// 自动生成在只读目录中失败
+	if err := cd.DiscoverAndLoad(); err == nil || errors.Is(err, ErrNoConfigFound) {
+		t.Errorf("Expected generation to fail in a read-only directory, got %v", err)
+	}
+
+	// 关闭自动生成后只返回找不到配置的错误
+	cd.SetAutoGenerate(false)
+	if err := cd.DiscoverAndLoad(); !errors.Is(err, ErrNoConfigFound) {
+		t.Errorf("Expected ErrNoConfigFound in a read-only directory, got %v", err)
+	}
+}
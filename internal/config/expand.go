@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 配置文件中引用了未设置的环境变量时的处理方式
+const (
+	UnsetEnvKeep  = "keep"  // 保留原文（默认）
+	UnsetEnvError = "error" // 视为无效配置文件
+)
+
+// expandEnv 展开字符串中的${VAR}和$VAR引用，$$表示字面量$；
+// strict时引用未设置的变量返回错误，否则保留引用原文
+func expandEnv(s string, strict bool) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+
+		var name, ref string
+		switch next := s[i+1]; {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+			continue
+		case next == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 || !isEnvName(s[i+2:i+2+end]) {
+				out.WriteByte(s[i])
+				continue
+			}
+			name = s[i+2 : i+2+end]
+			ref = s[i : i+3+end]
+		default:
+			end := i + 1
+			for end < len(s) && isEnvNameChar(s[end], end == i+1) {
+				end++
+			}
+			if end == i+1 {
+				out.WriteByte(s[i])
+				continue
+			}
+			name = s[i+1 : end]
+			ref = s[i:end]
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			if strict {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			value = ref
+		}
+		out.WriteString(value)
+		i += len(ref) - 1
+	}
+	return out.String(), nil
+}
+
+// isEnvName 检查是否为合法的环境变量名
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// isEnvNameChar 环境变量名由字母、数字和下划线组成，且不以数字开头
+func isEnvNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	default:
+		return false
+	}
+}
+
+// expandConfigEnv 展开配置中所有字符串值（包括token、header规则和映射的值）里的环境变量引用
+func expandConfigEnv(cfg *Config, strict bool) error {
+	return expandValue(reflect.ValueOf(cfg).Elem(), "", strict)
+}
+
+// expandValue 递归展开字符串值，path用于在错误中指明配置项
+func expandValue(v reflect.Value, path string, strict bool) error {
+	switch v.Kind() {
+	case reflect.String:
+		expanded, err := expandEnv(v.String(), strict)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(expanded)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return expandValue(v.Elem(), path, strict)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := settingName(field)
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := expandValue(v.Field(i), name, strict); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), strict); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			expanded, err := expandEnv(v.MapIndex(key).String(), strict)
+			if err != nil {
+				return fmt.Errorf("%s.%v: %v", path, key, err)
+			}
+			v.SetMapIndex(key, reflect.ValueOf(expanded).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PROXY_TEST_TOKEN", "secret")
+	t.Setenv("PROXY_TEST_HOST", "10.0.0.1")
+
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"${PROXY_TEST_TOKEN}", "secret"},
+		{"$PROXY_TEST_TOKEN", "secret"},
+		{"http://$PROXY_TEST_HOST:8080", "http://10.0.0.1:8080"},
+		{"prefix-${PROXY_TEST_TOKEN}-suffix", "prefix-secret-suffix"},
+		{"no references", "no references"},
+		// 转义和不构成引用的$原样保留
+		{"pa$$word", "pa$word"},
+		{"$${PROXY_TEST_TOKEN}", "${PROXY_TEST_TOKEN}"},
+		{"cost: 5$", "cost: 5$"},
+		{"$1 and ${unclosed", "$1 and ${unclosed"},
+		// 未设置的变量默认保留原文
+		{"${PROXY_TEST_UNSET}", "${PROXY_TEST_UNSET}"},
+		{"$PROXY_TEST_UNSET/x", "$PROXY_TEST_UNSET/x"},
+	}
+	for _, c := range cases {
+		got, err := expandEnv(c.input, false)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.input, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.input, c.expected, got)
+		}
+	}
+}
+
+func TestExpandEnvStrictUnset(t *testing.T) {
+	if _, err := expandEnv("${PROXY_TEST_UNSET}", true); err == nil {
+		t.Error("Expected error for unset variable in strict mode")
+	}
+	// 设置为空字符串不算未设置
+	t.Setenv("PROXY_TEST_EMPTY", "")
+	if got, err := expandEnv("[${PROXY_TEST_EMPTY}]", true); err != nil || got != "[]" {
+		t.Errorf("Expected empty variable to expand to empty string, got %q, %v", got, err)
+	}
+	if got, err := expandEnv("pa$$word", true); err != nil || got != "pa$word" {
+		t.Errorf("Expected escape to work in strict mode, got %q, %v", got, err)
+	}
+}
+
+// writeConfigFile 写入临时配置文件并返回路径
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadFromFileExpandsEnv(t *testing.T) {
+	t.Setenv("PROXY_TEST_JWT", "jwt-from-env")
+	t.Setenv("PROXY_TEST_BEARER", "bearer-from-env")
+	t.Setenv("PROXY_TEST_HOST", "127.0.0.1")
+	t.Setenv("PROXY_TEST_SECRET", "s3cret")
+
+	path := writeConfigFile(t, `{
+		"jetbrains_tokens": [{"token": "${PROXY_TEST_JWT}", "name": "primary"}],
+		"bearer_token": "${PROXY_TEST_BEARER}",
+		"server_host": "$PROXY_TEST_HOST",
+		"required_headers": [{"name": "X-Proxy-Secret", "value": "${PROXY_TEST_SECRET}"}],
+		"model_aliases": {"fast": "${PROXY_TEST_UNSET}"}
+	}`)
+
+	manager := NewManager()
+	if err := manager.loadFromFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg := manager.GetConfig()
+	if cfg.JetbrainsTokens[0].Token != "jwt-from-env" || cfg.JetbrainsTokens[0].Name != "primary" {
+		t.Errorf("Expected token to be expanded, got %+v", cfg.JetbrainsTokens[0])
+	}
+	if cfg.BearerToken != "bearer-from-env" || cfg.ServerHost != "127.0.0.1" {
+		t.Errorf("Expected bearer and host to be expanded, got %q and %q", cfg.BearerToken, cfg.ServerHost)
+	}
+	if cfg.RequiredHeaders[0].Value != "s3cret" {
+		t.Errorf("Expected header value to be expanded, got %q", cfg.RequiredHeaders[0].Value)
+	}
+	if cfg.ModelAliases["fast"] != "${PROXY_TEST_UNSET}" {
+		t.Errorf("Expected unset reference to be kept, got %q", cfg.ModelAliases["fast"])
+	}
+}
+
+func TestLoadFromFileUnsetEnvError(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"unset_env_vars": "error",
+		"jetbrains_tokens": [{"token": "${PROXY_TEST_UNSET}"}]
+	}`)
+
+	err := NewManager().loadFromFile(path)
+	if !errors.Is(err, ErrInvalidConfigFile) {
+		t.Fatalf("Expected ErrInvalidConfigFile, got %v", err)
+	}
+	// 错误中指明配置项和变量名
+	for _, expected := range []string{"jetbrains_tokens[0].token", "PROXY_TEST_UNSET"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to mention %s, got %v", expected, err)
+		}
+	}
+
+	// 环境变量可以覆盖配置文件中的处理方式
+	t.Setenv("UNSET_ENV_VARS", UnsetEnvKeep)
+	if err := NewManager().loadFromFile(path); err != nil {
+		t.Errorf("Expected keep mode from env to allow unset variables, got %v", err)
+	}
+}
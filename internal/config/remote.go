@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// remoteFetchTimeout 拉取远程配置的超时时间
+const remoteFetchTimeout = 10 * time.Second
+
+// maxRemoteConfigSize 远程配置的最大字节数
+const maxRemoteConfigSize = 1 << 20
+
+// remoteHTTPClient 拉取远程配置使用的HTTP客户端
+var remoteHTTPClient = &http.Client{Timeout: remoteFetchTimeout}
+
+// remoteFetch 一次远程配置拉取的结果
+type remoteFetch struct {
+	url           string
+	authorization string
+	data          []byte
+	err           error
+}
+
+// fetchRemoteConfig 从URL拉取JSON配置，authorization非空时作为Authorization请求头发送
+func fetchRemoteConfig(ctx context.Context, url, authorization string) remoteFetch {
+	fetched := remoteFetch{url: url, authorization: authorization}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		fetched.err = fmt.Errorf("invalid config URL: %v", err)
+		return fetched
+	}
+	req.Header.Set("Accept", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := remoteHTTPClient.Do(req)
+	if err != nil {
+		fetched.err = fmt.Errorf("failed to fetch remote config: %v", err)
+		return fetched
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fetched.err = fmt.Errorf("failed to fetch remote config: status %d", resp.StatusCode)
+		return fetched
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		fetched.err = fmt.Errorf("failed to read remote config: %v", err)
+		return fetched
+	}
+	if len(data) > maxRemoteConfigSize {
+		fetched.err = fmt.Errorf("remote config exceeds %d bytes", maxRemoteConfigSize)
+		return fetched
+	}
+	fetched.data = data
+	return fetched
+}
+
+// remoteConfigFromEnv 读取环境变量CONFIG_URL和CONFIG_URL_AUTHORIZATION，未设置的项为空字符串
+func remoteConfigFromEnv() (string, string) {
+	return os.Getenv("CONFIG_URL"), os.Getenv("CONFIG_URL_AUTHORIZATION")
+}
+
+// remoteConfigSourceLocked 远程配置的地址和认证头：环境变量优先，否则沿用已加载的配置，调用方需持有锁；
+// 环境变量在加载配置的最后一步才合并，拉取远程配置时需要直接读取
+func (m *Manager) remoteConfigSourceLocked() (string, string) {
+	url, authorization := m.config.ConfigURL, m.config.ConfigURLAuthorization
+	envURL, envAuthorization := remoteConfigFromEnv()
+	if envURL != "" {
+		url = envURL
+	}
+	if envAuthorization != "" {
+		authorization = envAuthorization
+	}
+	return url, authorization
+}
+
+// prefetchRemoteConfig 在加锁前拉取远程配置，避免网络请求阻塞配置读取；未配置地址时返回空结果
+func (m *Manager) prefetchRemoteConfig() remoteFetch {
+	m.mutex.RLock()
+	url, authorization := m.remoteConfigSourceLocked()
+	m.mutex.RUnlock()
+
+	if url == "" {
+		return remoteFetch{}
+	}
+	return fetchRemoteConfig(context.Background(), url, authorization)
+}
+
+// storeRemoteConfigLocked 解析远程配置，内容有效时替换保存的最近一次有效配置，返回内容是否变化；调用方需持有写锁
+func (m *Manager) storeRemoteConfigLocked(data []byte) (bool, error) {
+	var remoteConfig Config
+	if err := json.Unmarshal(data, &remoteConfig); err != nil {
+		return false, fmt.Errorf("failed to parse remote config: %v", err)
+	}
+	if err := expandConfigEnv(&remoteConfig, m.unsetEnvModeLocked(&remoteConfig) == UnsetEnvError); err != nil {
+		return false, fmt.Errorf("remote config: %v", err)
+	}
+
+	changed := !bytes.Equal(data, m.remoteConfigData)
+	m.remoteConfigData = data
+	m.remoteConfig = &remoteConfig
+	return changed, nil
+}
+
+// loadRemoteConfigLocked 合并远程配置，拉取失败时沿用最近一次有效的远程配置，调用方需持有写锁
+func (m *Manager) loadRemoteConfigLocked(prefetched remoteFetch) error {
+	url, authorization := m.remoteConfigSourceLocked()
+	if url == "" {
+		return nil
+	}
+
+	fetched := prefetched
+	if fetched.url != url || fetched.authorization != authorization {
+		// 地址只在配置文件中设置时（如首次启动），加载配置文件后才能拉取
+		fetched = fetchRemoteConfig(context.Background(), url, authorization)
+	}
+
+	err := fetched.err
+	if err == nil {
+		_, err = m.storeRemoteConfigLocked(fetched.data)
+	}
+	if m.remoteConfig == nil {
+		return err
+	}
+	if err != nil {
+		log.Printf("Warning: %v, keeping last good remote config", err)
+	}
+	m.mergeConfig(m.remoteConfig)
+	return nil
+}
+
+// WatchRemoteConfig 按config_url_poll_interval轮询远程配置，内容变化时调用reload（与手动重载使用同一流程）；
+// 未配置config_url或轮询间隔时不启动，返回的函数用于停止轮询
+func (m *Manager) WatchRemoteConfig(reload func() error) (stop func()) {
+	m.mutex.RLock()
+	url, _ := m.remoteConfigSourceLocked()
+	interval := m.config.ConfigURLPollInterval
+	m.mutex.RUnlock()
+	if url == "" || interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			fetched := m.prefetchRemoteConfig()
+			if fetched.err != nil {
+				log.Printf("Warning: %v, keeping last good remote config", fetched.err)
+				continue
+			}
+			m.mutex.Lock()
+			changed, err := m.storeRemoteConfigLocked(fetched.data)
+			m.mutex.Unlock()
+			if err != nil {
+				log.Printf("Warning: %v, keeping last good remote config", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			log.Printf("Remote config changed, reloading: %s", fetched.url)
+			if err := reload(); err != nil {
+				log.Printf("Failed to reload config: %v", err)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
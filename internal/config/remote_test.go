@@ -0,0 +1,154 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubConfigServer 测试用远程配置服务，可随时修改返回的内容和状态码
+type stubConfigServer struct {
+	*httptest.Server
+	mu            sync.Mutex
+	body          string
+	status        int
+	authorization string
+}
+
+func newStubConfigServer(t *testing.T, body string) *stubConfigServer {
+	t.Helper()
+
+	stub := &stubConfigServer{body: body, status: http.StatusOK}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.authorization = r.Header.Get("Authorization")
+		w.WriteHeader(stub.status)
+		w.Write([]byte(stub.body))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *stubConfigServer) set(status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.body = status, body
+}
+
+// withRemoteConfig 在空的临时目录中通过环境变量指向远程配置服务
+func withRemoteConfig(t *testing.T, stub *stubConfigServer) *Manager {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("CONFIG_URL", stub.URL)
+	t.Setenv("CONFIG_URL_AUTHORIZATION", "Bearer config-token")
+	return NewManager()
+}
+
+const remoteConfigBody = `{"jetbrains_tokens": [{"token": "remote-jwt-token-1"}], "bearer_token": "remote-bearer-token"}`
+
+func TestRemoteConfigKeepsLastGoodOnFailure(t *testing.T) {
+	stub := newStubConfigServer(t, remoteConfigBody)
+	manager := withRemoteConfig(t, stub)
+
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stub.authorization != "Bearer config-token" {
+		t.Errorf("Expected Authorization header to be sent, got %q", stub.authorization)
+	}
+	if manager.GetConfig().BearerToken != "remote-bearer-token" {
+		t.Errorf("Expected bearer token from remote config, got %q", manager.GetConfig().BearerToken)
+	}
+	if source := manager.GetSource("bearer_token"); source != SourceRemote {
+		t.Errorf("Expected bearer_token source to be remote, got %s", source)
+	}
+
+	// 拉取失败或内容无效时沿用最近一次有效的远程配置
+	for _, failure := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusInternalServerError, "unavailable"},
+		{http.StatusOK, `{"bearer_token": `},
+	} {
+		stub.set(failure.status, failure.body)
+		if err := manager.LoadConfig(); err != nil {
+			t.Fatalf("Expected reload to keep the last good remote config, got %v", err)
+		}
+		if manager.GetConfig().BearerToken != "remote-bearer-token" {
+			t.Errorf("Expected last good bearer token to be kept, got %q", manager.GetConfig().BearerToken)
+		}
+	}
+}
+
+func TestRemoteConfigFailureWithoutLastGood(t *testing.T) {
+	stub := newStubConfigServer(t, "")
+	stub.set(http.StatusUnauthorized, "unauthorized")
+	manager := withRemoteConfig(t, stub)
+	t.Setenv("STRICT_CONFIG", "true")
+
+	if err := manager.LoadConfig(); err == nil {
+		t.Error("Expected error in strict mode when the remote config cannot be fetched")
+	}
+}
+
+func TestWatchRemoteConfigReloadsOnChange(t *testing.T) {
+	stub := newStubConfigServer(t, remoteConfigBody)
+	manager := withRemoteConfig(t, stub)
+	t.Setenv("CONFIG_URL_POLL_INTERVAL", "10ms")
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var reloads atomic.Int32
+	stop := manager.WatchRemoteConfig(func() error {
+		reloads.Add(1)
+		return manager.LoadConfig()
+	})
+	defer stop()
+
+	// 内容未变化或拉取失败时不重载
+	stub.set(http.StatusBadGateway, "")
+	time.Sleep(50 * time.Millisecond)
+	if reloads.Load() != 0 {
+		t.Fatalf("Expected no reload while the remote config is unchanged or failing, got %d", reloads.Load())
+	}
+
+	stub.set(http.StatusOK, `{"jetbrains_tokens": [{"token": "remote-jwt-token-1"}], "bearer_token": "rotated-remote-token"}`)
+	deadline := time.Now().Add(time.Second)
+	for manager.GetConfig().BearerToken != "rotated-remote-token" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if manager.GetConfig().BearerToken != "rotated-remote-token" {
+		t.Errorf("Expected changed remote config to be applied, got %q", manager.GetConfig().BearerToken)
+	}
+	if reloads.Load() == 0 {
+		t.Error("Expected reload to be triggered by the remote change")
+	}
+}
+
+func TestRemoteConfigEnvLoadedWithEnvSource(t *testing.T) {
+	stub := newStubConfigServer(t, remoteConfigBody)
+	manager := withRemoteConfig(t, stub)
+	t.Setenv("CONFIG_URL_POLL_INTERVAL", "1m")
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg := manager.GetConfig()
+	if cfg.ConfigURL != stub.URL || cfg.ConfigURLAuthorization != "Bearer config-token" || cfg.ConfigURLPollInterval != time.Minute {
+		t.Errorf("Expected remote config settings from the environment, got %q %q %v", cfg.ConfigURL, cfg.ConfigURLAuthorization, cfg.ConfigURLPollInterval)
+	}
+	for _, key := range []string{"config_url", "config_url_authorization", "config_url_poll_interval"} {
+		if source := manager.GetSource(key); source != SourceEnv {
+			t.Errorf("Expected %s source to be env, got %s", key, source)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// ConfigSource 配置项的来源
+type ConfigSource string
+
+const (
+	SourceDefault ConfigSource = "default"
+	SourceFile    ConfigSource = "file"
+	SourceRemote  ConfigSource = "remote"
+	SourceEnv     ConfigSource = "env"
+	SourceFlag    ConfigSource = "flag"
+)
+
+// minBearerTokenLength 短于该长度的Bearer token容易被猜到
+const minBearerTokenLength = 16
+
+// SettingSummary 一个配置项的生效值及来源，敏感值已隐藏
+type SettingSummary struct {
+	Name   string       `json:"name"`
+	Value  string       `json:"value"`
+	Source ConfigSource `json:"source"`
+}
+
+// StartupSummary 启动摘要：所有生效的配置项和安全相关的警告
+type StartupSummary struct {
+	Settings []SettingSummary `json:"settings"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// settingName 返回配置字段的json名称
+func settingName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// recordSourcesLocked 将before与after之间发生变化的配置项记为来自source，调用方需持有写锁
+func (m *Manager) recordSourcesLocked(before, after *Config, source ConfigSource) {
+	if m.sources == nil {
+		m.sources = make(map[string]ConfigSource)
+	}
+
+	beforeValue := reflect.ValueOf(before).Elem()
+	afterValue := reflect.ValueOf(after).Elem()
+	for i := 0; i < beforeValue.NumField(); i++ {
+		if !reflect.DeepEqual(beforeValue.Field(i).Interface(), afterValue.Field(i).Interface()) {
+			m.sources[settingName(beforeValue.Type().Field(i))] = source
+		}
+	}
+}
+
+// trackSourcesLocked 执行一次配置合并并记录被修改的配置项来源，调用方需持有写锁
+func (m *Manager) trackSourcesLocked(source ConfigSource, apply func()) {
+	before := *m.config
+	apply()
+	m.recordSourcesLocked(&before, m.config, source)
+}
+
+// ApplyOverrides 执行fn中的配置修改（如命令行参数），并将被修改的配置项记为来自source
+func (m *Manager) ApplyOverrides(source ConfigSource, fn func()) {
+	before := m.GetConfig()
+	fn()
+	after := m.GetConfig()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recordSourcesLocked(before, after, source)
+}
+
+// GetSource 返回配置项（json名称）的来源，未被覆盖的配置项为default
+func (m *Manager) GetSource(name string) ConfigSource {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.sourceLocked(name)
+}
+
+// GetSources 返回所有配置项（json名称）的来源
+func (m *Manager) GetSources() map[string]ConfigSource {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	configType := reflect.TypeOf(*m.config)
+	sources := make(map[string]ConfigSource, configType.NumField())
+	for i := 0; i < configType.NumField(); i++ {
+		name := settingName(configType.Field(i))
+		sources[name] = m.sourceLocked(name)
+	}
+	return sources
+}
+
+// sourceLocked 返回配置项的来源，调用方需持有锁
+func (m *Manager) sourceLocked(name string) ConfigSource {
+	if source, ok := m.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Summary 生成启动摘要
+func (m *Manager) Summary() StartupSummary {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	value := reflect.ValueOf(m.config).Elem()
+	settings := make([]SettingSummary, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		name := settingName(value.Type().Field(i))
+		settings = append(settings, SettingSummary{
+			Name:   name,
+			Value:  formatSettingValue(name, value.Field(i).Interface()),
+			Source: m.sourceLocked(name),
+		})
+	}
+
+	return StartupSummary{Settings: settings, Warnings: securityWarnings(m.config)}
+}
+
+// formatSettingValue 格式化配置值，token和header规则等敏感值只显示概要
+func formatSettingValue(name string, value interface{}) string {
+	switch name {
+	case "jetbrains_tokens":
+		return fmt.Sprintf("%d tokens", len(value.([]JWTTokenConfig)))
+	case "bearer_token":
+		if token := value.(string); token != "" {
+			return token[:min(len(token), 4)] + "***"
+		}
+		return ""
+	case "config_url_authorization":
+		if value.(string) != "" {
+			return "***"
+		}
+		return ""
+	case "required_headers":
+		return fmt.Sprintf("%d rules", len(value.([]HeaderMatcher)))
+	}
+	// 指针类型的配置项（区分未设置和0）显示指向的值
+	if pointer := reflect.ValueOf(value); pointer.Kind() == reflect.Ptr {
+		if pointer.IsNil() {
+			return ""
+		}
+		value = pointer.Elem().Interface()
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// securityWarnings 检查存在安全风险的配置
+func securityWarnings(cfg *Config) []string {
+	var warnings []string
+
+	if cfg.BearerToken != "" && len(cfg.BearerToken) < minBearerTokenLength {
+		warnings = append(warnings, fmt.Sprintf("bearer_token is shorter than %d characters and easy to guess", minBearerTokenLength))
+	}
+	if cfg.AdminPort <= 0 && !isLoopbackHost(cfg.ServerHost) {
+		warnings = append(warnings, fmt.Sprintf("management endpoints (/config, /reload, /stats) are served on the public API address %s; set admin_port to separate them", cfg.ServerHost))
+	}
+	if cfg.AdminPort > 0 && !isLoopbackHost(cfg.AdminHost) {
+		warnings = append(warnings, fmt.Sprintf("admin server listens on non-loopback address %s", cfg.AdminHost))
+	}
+	if cfg.EnablePprof {
+		host := cfg.ServerHost
+		if cfg.AdminPort > 0 {
+			host = cfg.AdminHost
+		}
+		if !isLoopbackHost(host) {
+			warnings = append(warnings, fmt.Sprintf("pprof is enabled and reachable on %s; profiles can expose request data", host))
+		}
+	}
+	if cfg.MockUpstream {
+		warnings = append(warnings, "mock_upstream is enabled, requests will not reach JetBrains AI")
+	}
+	return warnings
+}
+
+// isLoopbackHost 判断监听地址是否只接受本机连接
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// PrintSummary 打印启动摘要：每个配置项的生效值和来源，以及安全相关的警告
+func (m *Manager) PrintSummary() {
+	summary := m.Summary()
+
+	fmt.Println("=== Startup Summary ===")
+	for _, setting := range summary.Settings {
+		fmt.Printf("  %-28s %-24s (%s)\n", setting.Name, setting.Value, setting.Source)
+	}
+	fmt.Println("=======================")
+	for _, warning := range summary.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFromDir 在临时目录中写入config.json并加载配置
+func loadFromDir(t *testing.T, fileConfig string) *Manager {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(fileConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Chdir(dir)
+
+	manager := NewManager()
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return manager
+}
+
+// findSetting 按名称查找摘要中的配置项
+func findSetting(t *testing.T, summary StartupSummary, name string) SettingSummary {
+	t.Helper()
+	for _, setting := range summary.Settings {
+		if setting.Name == name {
+			return setting
+		}
+	}
+	t.Fatalf("Setting %s not found in summary", name)
+	return SettingSummary{}
+}
+
+func TestSummarySourceAttribution(t *testing.T) {
+	t.Setenv("SERVER_PORT", "9100")
+	manager := loadFromDir(t, `{
+		"jetbrains_tokens": [{"token": "jwt-1"}],
+		"bearer_token": "a-long-enough-bearer-token",
+		"server_port": 9000,
+		"health_check_interval": 60000000000
+	}`)
+
+	// 环境变量覆盖配置文件
+	summary := manager.Summary()
+	port := findSetting(t, summary, "server_port")
+	if port.Value != "9100" || port.Source != SourceEnv {
+		t.Errorf("Expected server_port 9100 from env, got %s from %s", port.Value, port.Source)
+	}
+
+	cases := map[string]ConfigSource{
+		"bearer_token":          SourceFile,
+		"jetbrains_tokens":      SourceFile,
+		"health_check_interval": SourceFile,
+		"server_host":           SourceDefault,
+		"upstream_max_retries":  SourceDefault,
+	}
+	for name, expected := range cases {
+		if source := manager.GetSource(name); source != expected {
+			t.Errorf("Expected %s from %s, got %s", name, expected, source)
+		}
+	}
+
+	// 命令行参数覆盖环境变量
+	manager.ApplyOverrides(SourceFlag, func() {
+		manager.UpdateConfig(func(cfg *Config) {
+			cfg.ServerPort = 9200
+		})
+	})
+	if source := manager.GetSource("server_port"); source != SourceFlag {
+		t.Errorf("Expected server_port from flag, got %s", source)
+	}
+}
+
+func TestSummaryHidesSecrets(t *testing.T) {
+	manager := loadFromDir(t, `{
+		"jetbrains_tokens": [{"token": "secret-jwt-1"}, {"token": "secret-jwt-2"}],
+		"bearer_token": "super-secret-bearer-token"
+	}`)
+
+	summary := manager.Summary()
+	if value := findSetting(t, summary, "jetbrains_tokens").Value; value != "2 tokens" {
+		t.Errorf("Expected token count only, got %s", value)
+	}
+	for _, setting := range summary.Settings {
+		if strings.Contains(setting.Value, "secret") {
+			t.Errorf("Expected %s to hide secrets, got %s", setting.Name, setting.Value)
+		}
+	}
+}
+
+func TestSummarySecurityWarnings(t *testing.T) {
+	manager := NewManager()
+	manager.UpdateConfig(func(cfg *Config) {
+		cfg.BearerToken = "short"
+		cfg.EnablePprof = true
+	})
+
+	warnings := strings.Join(manager.Summary().Warnings, "\n")
+	for _, expected := range []string{"bearer_token is shorter", "management endpoints", "pprof is enabled"} {
+		if !strings.Contains(warnings, expected) {
+			t.Errorf("Expected warning containing %q, got:\n%s", expected, warnings)
+		}
+	}
+
+	// 管理端点只监听本机时不再警告
+	manager.UpdateConfig(func(cfg *Config) {
+		cfg.BearerToken = "a-long-enough-bearer-token"
+		cfg.AdminPort = 9090
+	})
+	if warnings := manager.Summary().Warnings; len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+}
+
+func TestConfigSummaryReportsSources(t *testing.T) {
+	t.Setenv("LOAD_BALANCE_STRATEGY", "random")
+	manager := loadFromDir(t, `{
+		"jetbrains_tokens": [{"token": "jwt-1"}],
+		"bearer_token": "a-long-enough-bearer-token",
+		"load_balance_strategy": "consistent_hash"
+	}`)
+
+	sources, ok := NewConfigDiscovery(manager).GetConfigSummary()["sources"].(map[string]ConfigSource)
+	if !ok {
+		t.Fatal("Expected /config summary to include sources")
+	}
+	// 配置文件中设置、又被环境变量覆盖的值报告为env
+	if sources["load_balance_strategy"] != SourceEnv {
+		t.Errorf("Expected load_balance_strategy from env, got %s", sources["load_balance_strategy"])
+	}
+	if sources["bearer_token"] != SourceFile || sources["server_port"] != SourceDefault {
+		t.Errorf("Expected bearer_token from file and server_port default, got %s and %s",
+			sources["bearer_token"], sources["server_port"])
+	}
+	if len(sources) != len(manager.Summary().Settings) {
+		t.Errorf("Expected a source for every setting, got %d", len(sources))
+	}
+}
@@ -0,0 +1,78 @@
+package jetbrains
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// lastRequestSuccess 最近一次成功的上游请求时间（UnixNano），0表示尚未成功过
+	lastRequestSuccess atomic.Int64
+	// requestsTotal、requestsFailed、upstreamAttempts 进程启动以来的请求计数
+	requestsTotal    atomic.Int64
+	requestsFailed   atomic.Int64
+	upstreamAttempts atomic.Int64
+	// startedAt 进程启动时间，尚未有成功记录时以此判断是否过期
+	startedAt = time.Now()
+)
+
+// recordRequestSuccess 记录一次成功的上游请求
+func recordRequestSuccess() {
+	lastRequestSuccess.Store(time.Now().UnixNano())
+}
+
+// RequestCounters 进程启动以来的全局请求计数
+type RequestCounters struct {
+	Requests         int64 `json:"requests"`
+	FailedRequests   int64 `json:"failed_requests"`
+	UpstreamAttempts int64 `json:"upstream_attempts"` // 包含换token重试
+	UptimeSeconds    int64 `json:"uptime_seconds"`
+}
+
+// GetRequestCounters 获取全局请求计数
+func GetRequestCounters() RequestCounters {
+	return RequestCounters{
+		Requests:         requestsTotal.Load(),
+		FailedRequests:   requestsFailed.Load(),
+		UpstreamAttempts: upstreamAttempts.Load(),
+		UptimeSeconds:    int64(time.Since(startedAt).Seconds()),
+	}
+}
+
+// UpstreamActivity 上游最近一次成功的时间，零值表示尚未成功过
+type UpstreamActivity struct {
+	LastRequestSuccess     time.Time
+	LastHealthCheckSuccess time.Time
+}
+
+// GetUpstreamActivity 获取上游请求和健康检查最近一次成功的时间
+func GetUpstreamActivity() UpstreamActivity {
+	var activity UpstreamActivity
+	if nanos := lastRequestSuccess.Load(); nanos != 0 {
+		activity.LastRequestSuccess = time.Unix(0, nanos)
+	}
+	if healthChecker != nil {
+		activity.LastHealthCheckSuccess = healthChecker.LastSuccess()
+	}
+	return activity
+}
+
+// LastSuccess 请求和健康检查中较近的一次成功时间
+func (a UpstreamActivity) LastSuccess() time.Time {
+	if a.LastHealthCheckSuccess.After(a.LastRequestSuccess) {
+		return a.LastHealthCheckSuccess
+	}
+	return a.LastRequestSuccess
+}
+
+// Stale 距最近一次成功是否超过maxAge，尚未成功过时从启动时间算起；maxAge为0时不判断
+func (a UpstreamActivity) Stale(maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	last := a.LastSuccess()
+	if last.IsZero() {
+		last = startedAt
+	}
+	return time.Since(last) > maxAge
+}
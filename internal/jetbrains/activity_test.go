@@ -0,0 +1,57 @@
+package jetbrains
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpstreamActivityStale(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name     string
+		activity UpstreamActivity
+		maxAge   time.Duration
+		expected bool
+	}{
+		{"threshold disabled", UpstreamActivity{LastRequestSuccess: now.Add(-time.Hour)}, 0, false},
+		{"recent request", UpstreamActivity{LastRequestSuccess: now.Add(-time.Second)}, time.Minute, false},
+		{"old request", UpstreamActivity{LastRequestSuccess: now.Add(-time.Hour)}, time.Minute, true},
+		{"recent health check", UpstreamActivity{LastRequestSuccess: now.Add(-time.Hour), LastHealthCheckSuccess: now}, time.Minute, false},
+		// 尚未成功过时从启动时间算起
+		{"never succeeded", UpstreamActivity{}, time.Since(startedAt) + time.Hour, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.activity.Stale(tc.maxAge); got != tc.expected {
+				t.Errorf("Expected stale=%v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSendJetbrainsRequestRecordsSuccess(t *testing.T) {
+	lastRequestSuccess.Store(0)
+
+	// 失败的请求不记录
+	withFakeUpstream(t, &fakeUpstreamClient{statusCode: http.StatusInternalServerError})
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); err == nil {
+		t.Fatal("Expected error for failed upstream request")
+	}
+	if last := GetUpstreamActivity().LastRequestSuccess; !last.IsZero() {
+		t.Errorf("Expected no success after a failed request, got %v", last)
+	}
+
+	withFakeUpstream(t, &fakeUpstreamClient{statusCode: http.StatusOK})
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if last := GetUpstreamActivity().LastRequestSuccess; time.Since(last) > time.Second {
+		t.Errorf("Expected a recent request success, got %v", last)
+	}
+}
@@ -1,19 +1,57 @@
 package jetbrains
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/go-resty/resty/v2"
+	"github.com/bytedance/sonic"
+	"io"
 	"jetbrains-ai-proxy/internal/balancer"
 	"jetbrains-ai-proxy/internal/config"
 	"jetbrains-ai-proxy/internal/types"
 	"jetbrains-ai-proxy/internal/utils"
 	"log"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	jwtBalancer    balancer.JWTBalancer
+	// ErrTokenInvalid 上游返回401，JWT token无效
+	ErrTokenInvalid = errors.New("JWT token invalid")
+	// ErrUpstreamRateLimited 上游返回429，JWT token被限流
+	ErrUpstreamRateLimited = errors.New("upstream rate limited")
+	// ErrQuotaExhausted 上游返回403，JWT token的配额已用完
+	ErrQuotaExhausted = errors.New("JWT token quota exhausted")
+	// ErrProfileRejected 上游拒绝token使用请求的profile（token无权访问该模型）
+	ErrProfileRejected = errors.New("profile rejected for JWT token")
+	// ErrRetryBudgetExhausted 重试时间预算或请求截止时间不足以再尝试一次
+	ErrRetryBudgetExhausted = errors.New("exhausted retry budget")
+)
+
+// UpstreamStatusError 上游以401、403、429以外的4xx状态码拒绝了请求，通常由请求本身引起
+type UpstreamStatusError struct {
+	StatusCode int
+}
+
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("Jetbrains API error: status %d", e.StatusCode)
+}
+
+// balancerRef 包装负载均衡器接口，以便原子替换
+type balancerRef struct {
+	balancer.JWTBalancer
+}
+
+var (
+	// currentBalancer 当前使用的负载均衡器，重载配置时整体替换，进行中的请求仍使用一致的视图
+	currentBalancer atomic.Pointer[balancerRef]
+	// balancerSwapMu 选择和释放token时持有读锁，替换负载均衡器时持有写锁：转移在途计数与替换当前实例
+	// 之间没有选择或释放，每次释放都作用于持有对应在途计数的实例
+	balancerSwapMu sync.RWMutex
 	healthChecker  *balancer.HealthChecker
 	initOnce       sync.Once
 	configManager  *config.Manager
@@ -41,19 +79,59 @@ func InitializeFromConfig() error {
 			return
 		}
 
+		// 配置校验已保证版本有效
+		minTLSVersion, _ := config.ParseTLSVersion(cfg.UpstreamMinTLSVersion)
+		utils.ConfigureUpstreamTransport(cfg.UpstreamConnectTimeout, minTLSVersion)
+		utils.SetUpstreamUserAgent(cfg.UpstreamUserAgent)
+		utils.SetTokenEncoding(cfg.TokenEncoding)
+		types.SetModelDefaults(cfg.ModelDefaults)
+
 		// 创建负载均衡器
-		jwtBalancer = balancer.NewJWTBalancer(tokens, cfg.LoadBalanceStrategy)
+		jwtBalancer := balancer.NewJWTBalancerWithStrategy(configManager.GetJWTTokenConfigs(), balancer.BuildSelectionStrategy(cfg))
+		SetBalancer(jwtBalancer)
+
+		// 恢复上次运行时的token健康状态，避免启动后立即路由到已知失效的token
+		if cfg.HealthStateFile != "" {
+			if restored, err := balancer.LoadHealthState(jwtBalancer, cfg.HealthStateFile); err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				log.Printf("Restored health state for %d tokens from %s", restored, cfg.HealthStateFile)
+			}
+		}
 
-		// 创建并启动健康检查器
-		healthChecker = balancer.NewHealthChecker(jwtBalancer)
-		if cfg.HealthCheckInterval > 0 {
-			healthChecker.SetCheckInterval(cfg.HealthCheckInterval)
+		// 离线模式下使用模拟上游，不对真实服务做健康检查
+		if cfg.MockUpstream {
+			SetUpstreamClient(NewEchoMockUpstreamClient())
+			log.Printf("Mock upstream enabled, requests will not reach JetBrains AI")
+		} else {
+			// 创建并启动健康检查器
+			healthChecker = balancer.NewHealthChecker(jwtBalancer)
+			if cfg.HealthCheckInterval > 0 {
+				healthChecker.SetCheckInterval(cfg.HealthCheckInterval)
+			}
+			healthChecker.SetInitialDelay(cfg.HealthCheckStartDelay)
+			healthChecker.SetUserAgent(cfg.UpstreamUserAgent)
+			healthChecker.SetPrompt(cfg.PromptFor("gpt-4o"))
+			// 启用预热时由预热请求承担启动时的检查，不再重复检查一遍
+			healthChecker.SetSkipInitialCheck(cfg.HealthCheckSkipInitial || cfg.WarmUpOnStart)
+			healthChecker.SetStateFile(cfg.HealthStateFile)
+			healthChecker.Start()
+		}
+
+		startStateReaper(cfg.StateReapInterval)
+
+		// 在后台预热连接，避免第一个请求承担TLS握手和建连开销，不阻塞启动
+		if cfg.WarmUpOnStart {
+			go WarmUpConnections(context.Background(), configManager.GetEnabledJWTTokens())
 		}
-		healthChecker.Start()
 
 		log.Printf("JWT balancer initialized from config:")
 		log.Printf("  - Tokens: %d", len(tokens))
 		log.Printf("  - Strategy: %s", cfg.LoadBalanceStrategy)
+		log.Printf("  - Priority tiers: %v", cfg.PriorityTiers)
+		if cfg.ErrorRateThreshold > 0 {
+			log.Printf("  - Error rate threshold: %v", cfg.ErrorRateThreshold)
+		}
 		log.Printf("  - Health check interval: %v", cfg.HealthCheckInterval)
 	})
 
@@ -77,7 +155,8 @@ func InitializeBalancer(tokens []string, strategy string) error {
 	}
 
 	// 创建负载均衡器
-	jwtBalancer = balancer.NewJWTBalancer(tokens, balanceStrategy)
+	jwtBalancer := balancer.NewJWTBalancer(tokens, balanceStrategy)
+	SetBalancer(jwtBalancer)
 
 	// 创建并启动健康检查器
 	healthChecker = balancer.NewHealthChecker(jwtBalancer)
@@ -87,8 +166,56 @@ func InitializeBalancer(tokens []string, strategy string) error {
 	return nil
 }
 
-// ReloadConfig 重新加载配置
+// reloadCall 一次配置重载，同一次重载的所有调用方共享结果
+type reloadCall struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	// reloadRun 串行执行重载，避免并发重载交错地加载配置和替换负载均衡器
+	reloadRun sync.Mutex
+	// reloadMutex 保护pendingReload
+	reloadMutex sync.Mutex
+	// pendingReload 尚未开始执行的重载，重载进行中到达的调用合并到这里，在当前重载结束后执行一次
+	pendingReload *reloadCall
+)
+
+// ReloadConfig 重新加载配置；并发调用串行执行，重载进行中到达的调用合并为下一次重载并共享其结果，
+// 因此每个调用方得到的结果都反映了调用之后的配置
 func ReloadConfig() error {
+	reloadMutex.Lock()
+	call := pendingReload
+	if call == nil {
+		call = &reloadCall{done: make(chan struct{})}
+		pendingReload = call
+	}
+	reloadMutex.Unlock()
+
+	reloadRun.Lock()
+	defer reloadRun.Unlock()
+
+	// 其他调用方已经执行了这次重载
+	select {
+	case <-call.done:
+		return call.err
+	default:
+	}
+
+	// 开始执行后到达的调用需要等待下一次重载
+	reloadMutex.Lock()
+	if pendingReload == call {
+		pendingReload = nil
+	}
+	reloadMutex.Unlock()
+
+	call.err = reloadConfig()
+	close(call.done)
+	return call.err
+}
+
+// reloadConfig 重新加载配置并替换负载均衡器，调用方需持有reloadRun
+func reloadConfig() error {
 	if configManager == nil {
 		return fmt.Errorf("config manager not initialized")
 	}
@@ -106,15 +233,20 @@ func ReloadConfig() error {
 		return fmt.Errorf("no JWT tokens in reloaded config")
 	}
 
-	// 更新负载均衡器
-	if jwtBalancer != nil {
-		jwtBalancer.RefreshTokens(tokens)
-	}
+	// 构建新的负载均衡器并原子替换
+	swapBalancer(configManager.GetJWTTokenConfigs(), cfg)
 
-	// 更新健康检查间隔
+	utils.SetTokenEncoding(cfg.TokenEncoding)
+	types.SetModelDefaults(cfg.ModelDefaults)
+
+	// 更新健康检查间隔和prompt标识
 	if healthChecker != nil && cfg.HealthCheckInterval > 0 {
 		healthChecker.SetCheckInterval(cfg.HealthCheckInterval)
 	}
+	if healthChecker != nil {
+		healthChecker.SetPrompt(cfg.PromptFor("gpt-4o"))
+	}
+	startStateReaper(cfg.StateReapInterval)
 
 	log.Printf("Config reloaded successfully:")
 	log.Printf("  - Tokens: %d", len(tokens))
@@ -128,6 +260,40 @@ func StopBalancer() {
 	if healthChecker != nil {
 		healthChecker.Stop()
 	}
+	stopStateReaper()
+}
+
+// SetBalancer 直接设置JWT负载均衡器（用于测试或嵌入使用，不启动健康检查）
+func SetBalancer(b balancer.JWTBalancer) {
+	currentBalancer.Store(&balancerRef{b})
+}
+
+// getBalancer 获取当前的负载均衡器，未初始化时返回nil
+func getBalancer() balancer.JWTBalancer {
+	if ref := currentBalancer.Load(); ref != nil {
+		return ref.JWTBalancer
+	}
+	return nil
+}
+
+// swapBalancer 按新配置创建负载均衡器，保留已有token的健康状态后替换当前实例
+func swapBalancer(tokens []config.JWTTokenConfig, cfg *config.Config) balancer.JWTBalancer {
+	next := balancer.NewJWTBalancerWithStrategy(tokens, balancer.BuildSelectionStrategy(cfg))
+	balancerSwapMu.Lock()
+	if current := getBalancer(); current != nil {
+		balancer.CopyHealthState(current, next)
+		// 旧实例上的在途请求转移到新实例，被移除的token等在途请求结束后再删除
+		if draining := balancer.TransferInFlight(current, next); draining > 0 {
+			log.Printf("%d removed JWT tokens are draining in-flight requests", draining)
+		}
+	}
+	currentBalancer.Store(&balancerRef{next})
+	balancerSwapMu.Unlock()
+
+	if healthChecker != nil {
+		healthChecker.SetBalancer(next)
+	}
+	return next
 }
 
 // GetConfigManager 获取配置管理器
@@ -135,50 +301,434 @@ func GetConfigManager() *config.Manager {
 	return configManager
 }
 
-func SendJetbrainsRequest(ctx context.Context, req *types.JetbrainsRequest) (*resty.Response, error) {
+// SendJetbrainsRequest 发送请求到JetBrains AI，失败时按配置换用其他token重试
+func SendJetbrainsRequest(ctx context.Context, req *types.JetbrainsRequest) (*http.Response, error) {
+	requestsTotal.Add(1)
+	resp, err := sendJetbrainsRequestWithRetries(ctx, req, config.GetGlobalConfig().GetConfig())
+	if err != nil {
+		requestsFailed.Add(1)
+	}
+	return resp, err
+}
+
+// sendJetbrainsRequestWithRetries 依次使用不同的token发送请求，直到成功、不可重试或用完重试次数和预算
+func sendJetbrainsRequestWithRetries(ctx context.Context, req *types.JetbrainsRequest, cfg *config.Config) (*http.Response, error) {
+	attempts := cfg.MaxRetries() + 1
+
+	start := time.Now()
+	var lastErr error
+	var lastAttempt time.Duration
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			if reason := retryBudgetExceeded(ctx, cfg.UpstreamRetryBudget, start, lastAttempt); reason != "" {
+				log.Printf("Giving up upstream retries after %d attempts: %s", attempt, reason)
+				return nil, fmt.Errorf("%w (%s) after %d attempts: %w", ErrRetryBudgetExhausted, reason, attempt, lastErr)
+			}
+			log.Printf("Retrying upstream request with another token (attempt %d/%d): %v", attempt+1, attempts, lastErr)
+		}
+
+		attemptStart := time.Now()
+		upstreamAttempts.Add(1)
+		resp, retryable, err := sendJetbrainsRequestOnce(ctx, req, cfg)
+		lastAttempt = time.Since(attemptStart)
+		if err == nil {
+			recordRequestSuccess()
+			return resp, nil
+		}
+
+		// 没有可用token时返回上一次的上游错误，便于定位真正的失败原因
+		if errors.Is(err, errNoAvailableToken) && lastErr != nil {
+			return nil, lastErr
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// retryBudgetExceeded 以上一次尝试的耗时估计下一次尝试，超出重试预算或请求截止时间时返回原因
+func retryBudgetExceeded(ctx context.Context, budget time.Duration, start time.Time, lastAttempt time.Duration) string {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < lastAttempt {
+		return "request deadline is near"
+	}
+	if budget > 0 && time.Since(start)+lastAttempt > budget {
+		return fmt.Sprintf("retry budget of %v would be exceeded", budget)
+	}
+	return ""
+}
+
+// sessionKeyContextKey 请求上下文中会话键的key
+type sessionKeyContextKey struct{}
+
+// WithSessionKey 在上下文中附加会话键，一致性哈希策略据此为同一会话固定选择token
+func WithSessionKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKeyContextKey{}, key)
+}
+
+// sessionKey 获取上下文中的会话键，未设置时返回空字符串
+func sessionKey(ctx context.Context) string {
+	key, _ := ctx.Value(sessionKeyContextKey{}).(string)
+	return key
+}
+
+// serviceTierContextKey 请求上下文中服务等级的key
+type serviceTierContextKey struct{}
+
+// WithServiceTier 在上下文中附加服务等级，启用优先级分层时据此选择token所在的层
+func WithServiceTier(ctx context.Context, tier balancer.ServiceTier) context.Context {
+	if tier == balancer.ServiceTierDefault {
+		return ctx
+	}
+	return context.WithValue(ctx, serviceTierContextKey{}, tier)
+}
+
+// serviceTier 获取上下文中的服务等级，未设置时返回默认等级
+func serviceTier(ctx context.Context) balancer.ServiceTier {
+	tier, _ := ctx.Value(serviceTierContextKey{}).(balancer.ServiceTier)
+	return tier
+}
+
+// acquireToken 从当前负载均衡器选择token，与替换负载均衡器互斥；未初始化时返回的负载均衡器为nil
+func acquireToken(ctx context.Context, profile string) (balancer.JWTBalancer, string, error) {
+	balancerSwapMu.RLock()
+	defer balancerSwapMu.RUnlock()
+
+	jwtBalancer := getBalancer()
+	if jwtBalancer == nil {
+		return nil, "", nil
+	}
+	token, err := selectToken(ctx, jwtBalancer, profile)
+	return jwtBalancer, token, err
+}
+
+// selectToken 按会话键和服务等级选择支持该profile的token，负载均衡器不支持服务等级时只按会话键选择
+func selectToken(ctx context.Context, jwtBalancer balancer.JWTBalancer, profile string) (string, error) {
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		return baseBalancer.GetTokenForProfile(sessionKey(ctx), serviceTier(ctx), profile)
+	}
+	return jwtBalancer.GetTokenForKey(sessionKey(ctx))
+}
+
+// promptTokensContextKey 请求上下文中已统计的prompt token数的key
+type promptTokensContextKey struct{}
+
+// WithPromptTokens 在上下文中附加已统计的prompt token数（负数表示未统计），估算用量时不再重复统计
+func WithPromptTokens(ctx context.Context, tokens int) context.Context {
+	if tokens < 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, promptTokensContextKey{}, tokens)
+}
+
+// responsePrefixContextKey 请求上下文中响应前缀的key
+type responsePrefixContextKey struct{}
+
+// WithResponsePrefix 在上下文中附加响应前缀（如预填充内容），响应内容以它开头
+func WithResponsePrefix(ctx context.Context, prefix string) context.Context {
+	if prefix == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, responsePrefixContextKey{}, prefix)
+}
+
+// responsePrefix 获取上下文中的响应前缀，未设置时返回空字符串
+func responsePrefix(ctx context.Context) string {
+	prefix, _ := ctx.Value(responsePrefixContextKey{}).(string)
+	return prefix
+}
+
+// promptTokens 获取上下文中已统计的prompt token数
+func promptTokens(ctx context.Context) (int, bool) {
+	tokens, ok := ctx.Value(promptTokensContextKey{}).(int)
+	return tokens, ok
+}
+
+// errNoAvailableToken 负载均衡器中没有可用的token
+var errNoAvailableToken = errors.New("no available JWT tokens")
+
+// sendJetbrainsRequestOnce 使用一个token发送请求，返回的bool表示失败后是否可以换token重试
+func sendJetbrainsRequestOnce(ctx context.Context, req *types.JetbrainsRequest, cfg *config.Config) (*http.Response, bool, error) {
 	// 获取一个可用的JWT token
-	token, err := jwtBalancer.GetToken()
+	jwtBalancer, token, err := acquireToken(ctx, req.Profile)
+	if jwtBalancer == nil {
+		return nil, false, fmt.Errorf("%w: balancer not initialized", errNoAvailableToken)
+	}
 	if err != nil {
 		log.Printf("failed to get JWT token: %v", err)
-		return nil, fmt.Errorf("no available JWT tokens: %v", err)
+		return nil, false, fmt.Errorf("%w: %w", errNoAvailableToken, err)
 	}
 
-	resp, err := utils.RestySSEClient.R().
-		SetContext(ctx).
-		SetHeader(types.JwtTokenKey, token).
-		SetDoNotParseResponse(true).
-		SetBody(req).
-		Post(types.ChatStreamV7)
+	// 请求失败时立即释放token，成功时在响应体关闭后释放
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			releaseToken(token)
+		}
+	}()
+
+	headers := tokenHeaders(jwtBalancer, token)
+	headers[types.JwtTokenKey] = token
 
-	if err != nil {
+	start := time.Now()
+	resp, err := postToEndpoints(ctx, upstreamURLs(cfg), headers, req)
+
+	if resp == nil {
+		if err == nil {
+			err = fmt.Errorf("empty response from upstream")
+		}
 		log.Printf("jetbrains ai req error: %v", err)
+		// 请求被取消或超时不是token的问题，不影响token状态
+		if ctx.Err() != nil {
+			return nil, false, err
+		}
 		// 标记token为不健康
 		jwtBalancer.MarkTokenUnhealthy(token)
-		return nil, err
+		return nil, true, err
+	}
+
+	// token无权使用该profile时只对该profile停用token，换用其他token，不影响其他模型
+	if profileRejected(resp, req.Profile) {
+		resp.Body.Close()
+		markProfileUnsupported(jwtBalancer, token, req.Profile)
+		log.Printf("JWT token cannot use profile %s (%d): %s...", req.Profile, resp.StatusCode, token[:min(len(token), 10)])
+		return nil, true, fmt.Errorf("%w: %s", ErrProfileRejected, req.Profile)
 	}
 
 	// 检查响应状态码
-	if resp.StatusCode() == 401 {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// 成功响应，确保token标记为健康
+		jwtBalancer.MarkTokenHealthy(token)
+	case http.StatusUnauthorized:
 		// 401表示token无效，标记为不健康
+		resp.Body.Close()
 		jwtBalancer.MarkTokenUnhealthy(token)
 		log.Printf("JWT token invalid (401): %s...", token[:min(len(token), 10)])
-		return nil, fmt.Errorf("JWT token invalid")
-	} else if resp.StatusCode() == 200 {
-		// 成功响应，确保token标记为健康
-		jwtBalancer.MarkTokenHealthy(token)
+		return nil, true, ErrTokenInvalid
+	case http.StatusForbidden:
+		// 403表示token有效但配额已用完，移出轮换直到配额重置，换用其他token
+		resp.Body.Close()
+		markQuotaExhausted(jwtBalancer, token)
+		log.Printf("JWT token quota exhausted (403): %s...", token[:min(len(token), 10)])
+		return nil, true, ErrQuotaExhausted
+	case http.StatusTooManyRequests:
+		// 429表示token被限流，暂时移出轮换，等待健康检查恢复
+		resp.Body.Close()
+		jwtBalancer.MarkTokenUnhealthy(token)
+		log.Printf("JWT token rate limited (429): %s...", token[:min(len(token), 10)])
+		return nil, true, ErrUpstreamRateLimited
+	default:
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			// 其他4xx通常由请求本身引起，不是token的问题，不影响token状态也不重试
+			log.Printf("jetbrains ai req rejected: status %d", resp.StatusCode)
+			return nil, false, &UpstreamStatusError{StatusCode: resp.StatusCode}
+		}
+		if err == nil {
+			err = fmt.Errorf("Jetbrains API error: status %d", resp.StatusCode)
+		}
+		log.Printf("jetbrains ai req error: %v", err)
+		jwtBalancer.MarkTokenUnhealthy(token)
+		return nil, true, err
+	}
+
+	// 只限制上游连续无数据的时间，不限制流的总时长
+	resp.Body = newIdleTimeoutReader(resp.Body, cfg.StreamIdleTimeout)
+
+	// 部分错误以200状态码返回、错误信息位于SSE流中，在向客户端发送内容之前检测
+	body, streamErr, err := peekStreamError(resp.Body, cfg.RetryableErrorPatterns)
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("read upstream stream: %w", err)
+	}
+	if streamErr != nil {
+		resp.Body.Close()
+		if streamErr.Retryable {
+			jwtBalancer.MarkTokenUnhealthy(token)
+		}
+		log.Printf("Upstream stream error before content (retryable: %v): %v", streamErr.Retryable, streamErr)
+		return nil, streamErr.Retryable, streamErr
 	}
 
-	return resp, nil
+	// 首个事件已读到，记录首字节延迟；完整延迟在响应体关闭时记录
+	firstByte := time.Since(start)
+	resp.Body = &releasingBody{ReadCloser: body, token: token, tokenName: tokenName(jwtBalancer, token), release: func() {
+		recordLatency(jwtBalancer, token, firstByte, time.Since(start))
+		releaseToken(token)
+	}}
+	handedOff = true
+	return resp, false, nil
+}
+
+// profileRejectionBodyLimit 检测profile拒绝时最多读取的错误响应体字节数
+const profileRejectionBodyLimit = 4096
+
+// profileErrorBody 上游拒绝请求时的JSON错误响应体
+type profileErrorBody struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// profileRejected 上游是否因token无权使用请求的profile而拒绝：403或404，且JSON错误信息中包含请求的profile名称；
+// 已读取的响应体会放回resp.Body，便于后续按原状态码处理
+func profileRejected(resp *http.Response, profile string) bool {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusNotFound:
+	default:
+		return false
+	}
+	if resp.Body == nil || profile == "" {
+		return false
+	}
+	peeked, _ := io.ReadAll(io.LimitReader(resp.Body, profileRejectionBodyLimit))
+	resp.Body = readCloser{io.MultiReader(bytes.NewReader(peeked), resp.Body), resp.Body}
+
+	var body profileErrorBody
+	if err := sonic.Unmarshal(peeked, &body); err != nil {
+		return false
+	}
+	message := strings.ToLower(body.Error + "\n" + body.Message)
+	return strings.Contains(message, strings.ToLower(profile))
+}
+
+// readCloser 组合Reader和原始响应体的Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// markProfileUnsupported 记录token不支持该profile，负载均衡器不支持时按不健康处理
+func markProfileUnsupported(jwtBalancer balancer.JWTBalancer, token, profile string) {
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		baseBalancer.MarkProfileUnsupported(token, profile)
+		return
+	}
+	jwtBalancer.MarkTokenUnhealthy(token)
+}
+
+// releaseToken 释放token：请求期间负载均衡器可能已被替换，在途计数此时已转移到当前实例；
+// 与替换负载均衡器互斥，释放不会落在转移之后、替换之前的旧实例上
+func releaseToken(token string) {
+	balancerSwapMu.RLock()
+	defer balancerSwapMu.RUnlock()
+
+	if jwtBalancer := getBalancer(); jwtBalancer != nil {
+		jwtBalancer.ReleaseToken(token)
+	}
+}
+
+// releasingBody 响应体关闭时释放对应的token（只释放一次）
+type releasingBody struct {
+	io.ReadCloser
+	token     string // 响应所属的token，通过WithResponseToken传给响应读取
+	tokenName string // 响应所属token的配置名称
+	release   func()
+	once      sync.Once
+}
+
+// Close 关闭响应体并释放token
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// tokenName 获取token的配置名称，负载均衡器不支持时返回空字符串
+func tokenName(jwtBalancer balancer.JWTBalancer, token string) string {
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		return baseBalancer.TokenName(token)
+	}
+	return ""
+}
+
+// tokenHeaderPrefix 以此为前缀的token元数据作为请求头发送给上游
+const tokenHeaderPrefix = "header."
+
+// tokenHeaders 从token元数据中提取该token专属的上游请求头，负载均衡器不支持时返回空map
+func tokenHeaders(jwtBalancer balancer.JWTBalancer, token string) map[string]string {
+	headers := make(map[string]string)
+	baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer)
+	if !ok {
+		return headers
+	}
+	for key, value := range baseBalancer.TokenMetadata(token) {
+		if name := strings.TrimPrefix(key, tokenHeaderPrefix); name != key && name != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// ResponseTokenName 返回处理该响应的token名称（不含token本身），resp不是SendJetbrainsRequest的返回值时为空
+func ResponseTokenName(resp *http.Response) string {
+	if body, ok := resp.Body.(*releasingBody); ok {
+		return body.tokenName
+	}
+	return ""
+}
+
+// responseTokenContextKey 请求上下文中处理响应的token的key
+type responseTokenContextKey struct{}
+
+// WithResponseToken 在上下文中附加处理resp的token，读取响应时据此记录上游报告的配额信息；
+// resp不是SendJetbrainsRequest的返回值时返回原上下文
+func WithResponseToken(ctx context.Context, resp *http.Response) context.Context {
+	body, ok := resp.Body.(*releasingBody)
+	if !ok || body.token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, responseTokenContextKey{}, body.token)
+}
+
+// responseToken 获取上下文中处理响应的token，未设置时返回空字符串
+func responseToken(ctx context.Context) string {
+	token, _ := ctx.Value(responseTokenContextKey{}).(string)
+	return token
+}
+
+// recordLatency 记录token的请求延迟，负载均衡器不支持时忽略
+func recordLatency(jwtBalancer balancer.JWTBalancer, token string, firstByte, total time.Duration) {
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		baseBalancer.RecordLatency(token, firstByte, total)
+	}
 }
 
 // GetBalancerStats 获取负载均衡器统计信息
 func GetBalancerStats() (int, int) {
+	jwtBalancer := getBalancer()
 	if jwtBalancer == nil {
 		return 0, 0
 	}
 	return jwtBalancer.GetHealthyTokenCount(), jwtBalancer.GetTotalTokenCount()
 }
 
+// GetTokenStats 获取每个token的运行统计，负载均衡器不支持时返回nil
+func GetTokenStats() []balancer.TokenStats {
+	baseBalancer, ok := getBalancer().(*balancer.BaseBalancer)
+	if !ok {
+		return nil
+	}
+	return baseBalancer.GetTokenStats()
+}
+
+// ResetToken 按名称清除token的错误状态并标记为健康，找不到token时返回false
+func ResetToken(name string) (balancer.TokenStats, bool) {
+	baseBalancer, ok := getBalancer().(*balancer.BaseBalancer)
+	if !ok {
+		return balancer.TokenStats{}, false
+	}
+	return baseBalancer.ResetTokenByName(name)
+}
+
 // min 辅助函数
 func min(a, b int) int {
 	if a < b {
@@ -0,0 +1,511 @@
+package jetbrains
+
+import (
+	"context"
+	"errors"
+	"io"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeUpstreamClient 测试用上游客户端，返回预设的状态码或错误
+type fakeUpstreamClient struct {
+	statusCode int
+	err        error
+	calls      int
+	lastToken  string
+}
+
+func (f *fakeUpstreamClient) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	f.calls++
+	f.lastToken = headers[types.JwtTokenKey]
+	if f.statusCode == 0 {
+		return nil, f.err
+	}
+	return &http.Response{
+		StatusCode: f.statusCode,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, f.err
+}
+
+// withFakeUpstream 注入测试用上游客户端和单token负载均衡器
+func withFakeUpstream(t *testing.T, fake UpstreamClient) balancer.JWTBalancer {
+	t.Helper()
+
+	b := balancer.NewJWTBalancer([]string{"token-under-test"}, config.RoundRobin)
+	SetBalancer(b)
+	prev := SetUpstreamClient(fake)
+	t.Cleanup(func() {
+		SetUpstreamClient(prev)
+	})
+	return b
+}
+
+func testJetbrainsRequest() *types.JetbrainsRequest {
+	return &types.JetbrainsRequest{
+		Prompt:  types.PROMPT,
+		Profile: "openai-gpt-4o",
+		Chat: types.ChatField{
+			MessageField: []types.MessageField{{Type: "user_message", Content: "hi"}},
+		},
+	}
+}
+
+func TestSendJetbrainsRequestSuccess(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusOK}
+	b := withFakeUpstream(t, fake)
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if fake.lastToken != "token-under-test" {
+		t.Errorf("Expected JWT header to carry the selected token, got %q", fake.lastToken)
+	}
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token to stay healthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+	// 响应体关闭后释放token
+	if inFlight := b.(*balancer.BaseBalancer).GetTokenStats()[0].InFlight; inFlight != 0 {
+		t.Errorf("Expected token to be released after closing the body, got %d in flight", inFlight)
+	}
+}
+
+func TestSendJetbrainsRequestRecordsLatency(t *testing.T) {
+	b := withFakeUpstream(t, &fakeUpstreamClient{statusCode: http.StatusOK})
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 响应体关闭前不记录
+	if count := b.(*balancer.BaseBalancer).GetTokenStats()[0].TotalLatency.Count; count != 0 {
+		t.Errorf("Expected no latency before closing the body, got %d samples", count)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+
+	stats := b.(*balancer.BaseBalancer).GetTokenStats()[0]
+	if stats.FirstByteLatency.Count != 1 || stats.TotalLatency.Count != 1 {
+		t.Errorf("Expected one latency sample each, got first byte %d, total %d",
+			stats.FirstByteLatency.Count, stats.TotalLatency.Count)
+	}
+}
+
+func TestSendJetbrainsRequestFailureDoesNotRecordLatency(t *testing.T) {
+	b := withFakeUpstream(t, &fakeUpstreamClient{statusCode: http.StatusUnauthorized})
+
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); err == nil {
+		t.Fatal("Expected error")
+	}
+	if count := b.(*balancer.BaseBalancer).GetTokenStats()[0].TotalLatency.Count; count != 0 {
+		t.Errorf("Expected failed requests not to be recorded, got %d samples", count)
+	}
+}
+
+func TestSendJetbrainsRequestTransportError(t *testing.T) {
+	fake := &fakeUpstreamClient{err: errors.New("connection refused")}
+	b := withFakeUpstream(t, fake)
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected transport error, got %v", err)
+	}
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected token to be marked unhealthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestUnauthorized(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusUnauthorized}
+	b := withFakeUpstream(t, fake)
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("Expected ErrTokenInvalid, got %v", err)
+	}
+	if inFlight := b.(*balancer.BaseBalancer).GetTokenStats()[0].InFlight; inFlight != 0 {
+		t.Errorf("Expected token to be released after a failed request, got %d in flight", inFlight)
+	}
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected token to be marked unhealthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestRateLimited(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusTooManyRequests}
+	b := withFakeUpstream(t, fake)
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Fatalf("Expected ErrUpstreamRateLimited, got %v", err)
+	}
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected rate limited token to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestNoHealthyTokens(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusOK}
+	b := withFakeUpstream(t, fake)
+	b.MarkTokenUnhealthy("token-under-test")
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if !errors.Is(err, balancer.ErrNoHealthyTokens) {
+		t.Fatalf("Expected ErrNoHealthyTokens when no healthy tokens are available, got %v", err)
+	}
+	if fake.calls != 0 {
+		t.Errorf("Expected no upstream call, got %d", fake.calls)
+	}
+}
+
+// tokenScriptedUpstream 按token返回不同SSE流的测试上游
+type tokenScriptedUpstream struct {
+	streams map[string]string
+	calls   []string
+	headers []map[string]string
+}
+
+func (f *tokenScriptedUpstream) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	token := headers[types.JwtTokenKey]
+	f.calls = append(f.calls, token)
+	f.headers = append(f.headers, headers)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(f.streams[token])),
+	}, nil
+}
+
+func withTokens(t *testing.T, fake UpstreamClient, tokens ...string) balancer.JWTBalancer {
+	t.Helper()
+
+	b := balancer.NewJWTBalancer(tokens, config.RoundRobin)
+	SetBalancer(b)
+	prev := SetUpstreamClient(fake)
+	t.Cleanup(func() {
+		SetUpstreamClient(prev)
+	})
+	return b
+}
+
+func TestSendJetbrainsRequestRetriesEarlyStreamError(t *testing.T) {
+	fake := &tokenScriptedUpstream{streams: map[string]string{
+		"token-a": `data: {"type":"Error","content":"Rate limit exceeded, try again later"}` + "\n\n",
+		"token-b": BuildMockSSEStream("from token b"),
+	}}
+	b := withTokens(t, fake, "token-a", "token-b")
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(fake.calls) != 2 || fake.calls[1] != "token-b" {
+		t.Fatalf("Expected retry on token-b, got calls %v", fake.calls)
+	}
+
+	// 预读的数据必须完整地交给调用方
+	data, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(data), "from token b") || !strings.HasPrefix(string(data), "data: ") {
+		t.Errorf("Expected replayed stream from token-b, got %q", data)
+	}
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token-a to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestTokenMetadataHeaders(t *testing.T) {
+	fake := &tokenScriptedUpstream{streams: map[string]string{
+		"token-a": `data: {"type":"Error","content":"Rate limit exceeded, try again later"}` + "\n\n",
+		"token-b": BuildMockSSEStream("from token b"),
+	}}
+	SetBalancer(balancer.NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "token-a", Name: "a", Metadata: map[string]string{
+			"header.X-Client-Id": "client-a",
+			"header.":            "ignored",
+			"region":             "us-east-1",
+		}},
+		{Token: "token-b", Name: "b", Metadata: map[string]string{
+			"header.X-Client-Id": "client-b",
+			// 不能覆盖token本身
+			"header." + types.JwtTokenKey: "spoofed",
+		}},
+	}, balancer.NewSelectionStrategy(config.RoundRobin)))
+	prev := SetUpstreamClient(fake)
+	t.Cleanup(func() { SetUpstreamClient(prev) })
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(fake.headers) != 2 {
+		t.Fatalf("Expected 2 upstream calls, got %d", len(fake.headers))
+	}
+	first, second := fake.headers[0], fake.headers[1]
+	if first[types.JwtTokenKey] != "token-a" || first["X-Client-Id"] != "client-a" {
+		t.Errorf("Expected token-a's headers on the first call, got %v", first)
+	}
+	if _, ok := first["region"]; ok || len(first) != 2 {
+		t.Errorf("Expected only header.-prefixed metadata to be sent, got %v", first)
+	}
+	if second[types.JwtTokenKey] != "token-b" || second["X-Client-Id"] != "client-b" {
+		t.Errorf("Expected token-b's headers on the retry, got %v", second)
+	}
+}
+
+func TestSendJetbrainsRequestNonRetryableStreamError(t *testing.T) {
+	fake := &tokenScriptedUpstream{streams: map[string]string{
+		"token-a": `data: {"type":"Error","content":"Unsupported profile"}` + "\n\n",
+		"token-b": BuildMockSSEStream("unused"),
+	}}
+	b := withTokens(t, fake, "token-a", "token-b")
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	var streamErr *UpstreamStreamError
+	if !errors.As(err, &streamErr) || streamErr.Retryable {
+		t.Fatalf("Expected non-retryable stream error, got %v", err)
+	}
+	if len(fake.calls) != 1 {
+		t.Errorf("Expected no retry, got calls %v", fake.calls)
+	}
+	if b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected tokens to stay healthy, got %d", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestRetriesStatusFailures(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusTooManyRequests}
+	b := withTokens(t, fake, "token-a", "token-b", "token-c")
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Fatalf("Expected ErrUpstreamRateLimited, got %v", err)
+	}
+	// 默认重试2次，共3次请求
+	if fake.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", fake.calls)
+	}
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected all attempted tokens to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestClientErrorKeepsTokenHealthy(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusBadRequest}
+	b := withTokens(t, fake, "token-a", "token-b", "token-c")
+
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	var statusErr *UpstreamStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected UpstreamStatusError with status 400, got %v", err)
+	}
+	// 请求本身的问题不重试，也不影响token状态
+	if fake.calls != 1 {
+		t.Errorf("Expected a single attempt, got %d", fake.calls)
+	}
+	if b.GetHealthyTokenCount() != 3 {
+		t.Errorf("Expected all tokens to stay healthy, got %d", b.GetHealthyTokenCount())
+	}
+
+	// 5xx仍然换用其他token重试
+	fake = &fakeUpstreamClient{statusCode: http.StatusBadGateway}
+	b = withTokens(t, fake, "token-a", "token-b", "token-c")
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); err == nil || errors.As(err, &statusErr) {
+		t.Fatalf("Expected a server error, got %v", err)
+	}
+	if fake.calls != 3 || b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected 3 attempts leaving no healthy tokens, got %d attempts and %d healthy", fake.calls, b.GetHealthyTokenCount())
+	}
+}
+
+// slowFailingUpstream 每次请求耗时delay后返回500的测试上游
+type slowFailingUpstream struct {
+	delay time.Duration
+	calls int32
+}
+
+func (f *slowFailingUpstream) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	atomic.AddInt32(&f.calls, 1)
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func TestSendJetbrainsRequestStopsRetryingNearDeadline(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		retries := 4
+		cfg.UpstreamMaxRetries = &retries
+	})
+	fake := &slowFailingUpstream{delay: 100 * time.Millisecond}
+	withTokens(t, fake, "token-a", "token-b", "token-c", "token-d", "token-e")
+
+	// 两次尝试后剩余约50ms，不足以完成第三次尝试
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	_, err := SendJetbrainsRequest(ctx, testJetbrainsRequest())
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected ErrRetryBudgetExhausted, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected to give up before the request deadline")
+	}
+	if calls := atomic.LoadInt32(&fake.calls); calls != 2 {
+		t.Errorf("Expected 2 attempts before giving up, got %d", calls)
+	}
+}
+
+func TestSendJetbrainsRequestRetryBudget(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		retries := 4
+		cfg.UpstreamMaxRetries = &retries
+		cfg.UpstreamRetryBudget = 250 * time.Millisecond
+	})
+	fake := &slowFailingUpstream{delay: 100 * time.Millisecond}
+	withTokens(t, fake, "token-a", "token-b", "token-c", "token-d", "token-e")
+
+	start := time.Now()
+	_, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected ErrRetryBudgetExhausted, got %v", err)
+	}
+	// 保留最后一次上游错误，便于定位原因
+	if !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("Expected last upstream error in message, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&fake.calls); calls != 2 {
+		t.Errorf("Expected 2 attempts within budget, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected prompt give-up, took %v", elapsed)
+	}
+}
+
+func TestSendJetbrainsRequestRetriesWithinBudget(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.UpstreamRetryBudget = time.Minute
+	})
+	fake := &fakeUpstreamClient{statusCode: http.StatusTooManyRequests}
+	withTokens(t, fake, "token-a", "token-b", "token-c")
+
+	// 预算充足时仍按次数重试
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Fatalf("Expected ErrUpstreamRateLimited, got %v", err)
+	}
+	if fake.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", fake.calls)
+	}
+}
+
+// profileRestrictedUpstream 指定token请求受限profile时返回403和profile错误信息的测试上游
+type profileRestrictedUpstream struct {
+	restricted map[string]string // token -> 该token无权使用的profile
+	calls      []string
+}
+
+func (f *profileRestrictedUpstream) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	token := headers[types.JwtTokenKey]
+	f.calls = append(f.calls, token)
+	if profile := body.(*types.JetbrainsRequest).Profile; f.restricted[token] == profile {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Body:       io.NopCloser(strings.NewReader(`{"error":"Profile ` + profile + ` is not available for this user"}`)),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(BuildMockSSEStream("from " + token))),
+	}, nil
+}
+
+func TestSendJetbrainsRequestProfileRejection(t *testing.T) {
+	fake := &profileRestrictedUpstream{restricted: map[string]string{"token-a": "anthropic-claude-3.7-sonnet"}}
+	b := withTokens(t, fake, "token-a", "token-b")
+	claudeRequest := testJetbrainsRequest()
+	claudeRequest.Profile = "anthropic-claude-3.7-sonnet"
+
+	// 被拒绝后换用其他token，token仍然健康
+	resp, err := SendJetbrainsRequest(context.Background(), claudeRequest)
+	if err != nil {
+		t.Fatalf("Expected failover to token-b, got %v", err)
+	}
+	resp.Body.Close()
+	if len(fake.calls) != 2 || fake.calls[1] != "token-b" {
+		t.Fatalf("Expected retry on token-b, got %v", fake.calls)
+	}
+	if b.GetHealthyTokenCount() != 2 {
+		t.Errorf("Expected profile rejection not to mark the token unhealthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+
+	// 其他模型仍可使用该token，被拒绝的profile不再选择它
+	fake.calls = nil
+	for i := 0; i < 2; i++ {
+		resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := SendJetbrainsRequest(context.Background(), claudeRequest)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if got := strings.Join(fake.calls, ","); got != "token-a,token-b,token-b,token-b" {
+		t.Errorf("Expected token-a to serve other models only, got %s", got)
+	}
+	if stats := b.(*balancer.BaseBalancer).GetTokenStats(); len(stats[0].UnsupportedProfiles) != 1 || stats[0].Status != "healthy" {
+		t.Errorf("Expected token-a to report the unsupported profile and stay healthy, got %+v", stats[0])
+	}
+
+	// 所有token都被拒绝时返回profile拒绝错误
+	fake.restricted["token-b"] = claudeRequest.Profile
+	if _, err := SendJetbrainsRequest(context.Background(), claudeRequest); !errors.Is(err, ErrProfileRejected) {
+		t.Errorf("Expected ErrProfileRejected, got %v", err)
+	}
+}
+
+func TestProfileRejectedMatchesErrorShape(t *testing.T) {
+	const profile = "anthropic-claude-3.7-sonnet"
+	cases := []struct {
+		status   int
+		body     string
+		expected bool
+	}{
+		{http.StatusForbidden, `{"error":"Profile anthropic-claude-3.7-sonnet is not available for this user"}`, true},
+		{http.StatusNotFound, `{"message":"Unknown profile: Anthropic-Claude-3.7-Sonnet"}`, true},
+		// 只提到profile而没有请求的profile名称、非JSON响应体或其他状态码都不视为profile拒绝
+		{http.StatusForbidden, `{"error":"profile quota exceeded"}`, false},
+		{http.StatusForbidden, `profile anthropic-claude-3.7-sonnet is not available`, false},
+		{http.StatusBadRequest, `{"error":"temperature is not supported by profile anthropic-claude-3.7-sonnet"}`, false},
+	}
+	for _, tc := range cases {
+		resp := &http.Response{StatusCode: tc.status, Body: io.NopCloser(strings.NewReader(tc.body))}
+		if got := profileRejected(resp, profile); got != tc.expected {
+			t.Errorf("%d %s: expected %v, got %v", tc.status, tc.body, tc.expected, got)
+		}
+		// 已读取的响应体放回，后续仍可按原状态码处理
+		if data, _ := io.ReadAll(resp.Body); string(data) != tc.body {
+			t.Errorf("Expected body to be restored, got %q", data)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package jetbrains
+
+import (
+	"bytes"
+	"context"
+	"jetbrains-ai-proxy/internal/config"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCompletionIDsUniqueAcrossConcurrentRequests(t *testing.T) {
+	const requests = 200
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+
+	// 并发请求几乎都在同一秒内完成，ID仍需各不相同
+	ids := make(chan string, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(BuildMockSSEStream("hi")), "fp")
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			ids <- resp.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, requests)
+	for id := range ids {
+		if !strings.HasPrefix(id, "chatcmpl-") {
+			t.Errorf("Expected default chatcmpl- prefix, got %q", id)
+		}
+		if seen[id] {
+			t.Errorf("Duplicate completion ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestStreamChunksShareCompletionID(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.CompletionIDPrefix = "proxy-"
+		cfg.CompletionIDLength = 8
+	})
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(BuildMockSSEStream("a", "b", "c")), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	matches := regexp.MustCompile(`"id":"([^"]*)"`).FindAllStringSubmatch(out.String(), -1)
+	if len(matches) < 3 {
+		t.Fatalf("Expected at least 3 chunks, got:\n%s", out.String())
+	}
+	for _, match := range matches {
+		if match[1] != matches[0][1] {
+			t.Errorf("Expected all chunks to share %q, got %q", matches[0][1], match[1])
+		}
+	}
+	if !regexp.MustCompile(`^proxy-[a-zA-Z0-9]{8}$`).MatchString(matches[0][1]) {
+		t.Errorf("Expected configured ID format, got %q", matches[0][1])
+	}
+}
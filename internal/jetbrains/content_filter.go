@@ -0,0 +1,43 @@
+package jetbrains
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+// contentFilterEventTypes JetBrains 内容过滤/安全审核相关的事件类型
+var contentFilterEventTypes = map[string]bool{
+	"ContentFilter":   true,
+	"ContentFiltered": true,
+	"FilterResult":    true,
+	"Moderation":      true,
+}
+
+// isContentFilterEvent 判断SSE事件是否为内容过滤事件
+func isContentFilterEvent(sseData SSEData) bool {
+	return contentFilterEventTypes[sseData.Type] || strings.EqualFold(sseData.Reason, string(openai.FinishReasonContentFilter))
+}
+
+// buildContentFilterResults 根据事件中的类别（reason/event_type）构造过滤结果
+func buildContentFilterResults(sseData SSEData) openai.ContentFilterResults {
+	var results openai.ContentFilterResults
+
+	for _, category := range []string{sseData.Reason, sseData.EventType} {
+		switch strings.ToLower(strings.ReplaceAll(category, "-", "_")) {
+		case "hate":
+			results.Hate = openai.Hate{Filtered: true, Severity: "high"}
+		case "self_harm", "selfharm":
+			results.SelfHarm = openai.SelfHarm{Filtered: true, Severity: "high"}
+		case "sexual":
+			results.Sexual = openai.Sexual{Filtered: true, Severity: "high"}
+		case "violence":
+			results.Violence = openai.Violence{Filtered: true, Severity: "high"}
+		case "jailbreak":
+			results.JailBreak = openai.JailBreak{Filtered: true, Detected: true}
+		case "profanity":
+			results.Profanity = openai.Profanity{Filtered: true, Detected: true}
+		}
+	}
+
+	return results
+}
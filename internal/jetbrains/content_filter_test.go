@@ -0,0 +1,117 @@
+package jetbrains
+
+import (
+	"bytes"
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+	"testing"
+)
+
+const filteredStream = `data: {"type":"Content","content":"partial answer"}
+
+data: {"type":"ContentFilter","reason":"hate"}
+
+data: {"type":"QuotaMetadata","spent":{"amount":"5"}}
+
+data: end
+
+`
+
+// parseStreamChunks 解析客户端收到的SSE数据块（忽略[DONE]和注释）
+func parseStreamChunks(t *testing.T, output string) []openai.ChatCompletionStreamResponse {
+	t.Helper()
+
+	var chunks []openai.ChatCompletionStreamResponse
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "data: ") || strings.TrimPrefix(line, "data: ") == sseFinish {
+			continue
+		}
+		var chunk openai.ChatCompletionStreamResponse
+		if err := sonic.UnmarshalString(strings.TrimPrefix(line, "data: "), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", line, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestIsContentFilterEvent(t *testing.T) {
+	cases := []struct {
+		data     SSEData
+		expected bool
+	}{
+		{SSEData{Type: "ContentFilter"}, true},
+		{SSEData{Type: "Moderation", Reason: "violence"}, true},
+		{SSEData{Type: "FinishMetadata", Reason: "content_filter"}, true},
+		{SSEData{Type: "Content", Content: "hello"}, false},
+		{SSEData{Type: "QuotaMetadata"}, false},
+	}
+
+	for _, tc := range cases {
+		if got := isContentFilterEvent(tc.data); got != tc.expected {
+			t.Errorf("For %+v expected %v, got %v", tc.data, tc.expected, got)
+		}
+	}
+}
+
+func TestStreamContentFilterEvent(t *testing.T) {
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(filteredStream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chunks := parseStreamChunks(t, out.String())
+	if len(chunks) != 3 {
+		t.Fatalf("Expected role chunk, content chunk and finish chunk, got %d:\n%s", len(chunks), out.String())
+	}
+
+	final := chunks[len(chunks)-1].Choices[0]
+	if final.FinishReason != openai.FinishReasonContentFilter {
+		t.Errorf("Expected finish reason content_filter, got %s", final.FinishReason)
+	}
+	if !final.ContentFilterResults.Hate.Filtered {
+		t.Errorf("Expected hate filter result to be populated, got %+v", final.ContentFilterResults)
+	}
+}
+
+func TestNonStreamingContentFilterEvent(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(filteredStream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	choice := resp.Choices[0]
+	if choice.FinishReason != openai.FinishReasonContentFilter {
+		t.Errorf("Expected finish reason content_filter, got %s", choice.FinishReason)
+	}
+	if !choice.ContentFilterResults.Hate.Filtered {
+		t.Errorf("Expected hate filter result to be populated, got %+v", choice.ContentFilterResults)
+	}
+	if choice.Message.Content != "partial answer" {
+		t.Errorf("Expected content before the filter to be kept, got %q", choice.Message.Content)
+	}
+}
+
+func TestStreamWithoutFilterReportsStop(t *testing.T) {
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(BuildMockSSEStream("hi")), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chunks := parseStreamChunks(t, out.String())
+	final := chunks[len(chunks)-1].Choices[0]
+	if final.FinishReason != openai.FinishReasonStop {
+		t.Errorf("Expected finish reason stop, got %s", final.FinishReason)
+	}
+	if final.ContentFilterResults.Hate.Filtered {
+		t.Error("Expected no filter results for an unfiltered stream")
+	}
+}
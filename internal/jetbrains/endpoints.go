@@ -0,0 +1,117 @@
+package jetbrains
+
+import (
+	"context"
+	"fmt"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// endpointRetryInterval 端点连接失败后排到最后的时间，之后恢复原有顺序
+const endpointRetryInterval = 30 * time.Second
+
+// endpointHealth 上游端点的健康状态，与token健康状态分开跟踪
+type endpointHealth struct {
+	mu        sync.Mutex
+	downUntil map[string]time.Time
+	failures  map[string]int64
+}
+
+// endpoints 全局端点健康状态
+var endpoints = &endpointHealth{
+	downUntil: make(map[string]time.Time),
+	failures:  make(map[string]int64),
+}
+
+// upstreamURLs 配置的上游端点，未配置时使用默认端点
+func upstreamURLs(cfg *config.Config) []string {
+	if len(cfg.UpstreamURLs) == 0 {
+		return []string{types.ChatStreamV7}
+	}
+	return cfg.UpstreamURLs
+}
+
+// order 按配置顺序返回端点，近期连接失败的端点排在最后（全部失败时仍按配置顺序尝试）
+func (h *endpointHealth) order(urls []string, now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := make([]string, 0, len(urls))
+	var down []string
+	for _, url := range urls {
+		if now.Before(h.downUntil[url]) {
+			down = append(down, url)
+			continue
+		}
+		ordered = append(ordered, url)
+	}
+	return append(ordered, down...)
+}
+
+// markDown 记录端点连接失败
+func (h *endpointHealth) markDown(url string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.downUntil[url] = now.Add(endpointRetryInterval)
+	h.failures[url]++
+}
+
+// markUp 端点返回了响应，恢复其顺序
+func (h *endpointHealth) markUp(url string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.downUntil, url)
+}
+
+// EndpointStatus 上游端点的状态
+type EndpointStatus struct {
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Failures int64  `json:"failures"`
+}
+
+// GetEndpointStats 获取配置的上游端点状态
+func GetEndpointStats() []EndpointStatus {
+	urls := upstreamURLs(config.GetGlobalConfig().GetConfig())
+	now := time.Now()
+
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	stats := make([]EndpointStatus, 0, len(urls))
+	for _, url := range urls {
+		stats = append(stats, EndpointStatus{
+			URL:      url,
+			Healthy:  !now.Before(endpoints.downUntil[url]),
+			Failures: endpoints.failures[url],
+		})
+	}
+	return stats
+}
+
+// postToEndpoints 依次尝试上游端点：端点连接失败时换下一个端点，与换token重试分开；
+// 任一端点返回响应（无论状态码）即结束，状态码由调用方按token问题处理
+func postToEndpoints(ctx context.Context, urls []string, headers map[string]string, body interface{}) (*http.Response, error) {
+	var lastErr error
+	for _, url := range endpoints.order(urls, time.Now()) {
+		resp, err := upstreamClient.Post(ctx, url, headers, body)
+		if resp != nil {
+			endpoints.markUp(url)
+			return resp, err
+		}
+		// 请求被取消或超时不是端点的问题
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if err == nil {
+			err = fmt.Errorf("empty response from upstream")
+		}
+		endpoints.markDown(url, time.Now())
+		log.Printf("Upstream endpoint %s unreachable: %v", url, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,101 @@
+package jetbrains
+
+import (
+	"context"
+	"io"
+	"jetbrains-ai-proxy/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// unreachableURL 返回一个已关闭服务器的地址，连接会被拒绝
+func unreachableURL(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func TestSendJetbrainsRequestFailsOverToNextEndpoint(t *testing.T) {
+	var hits atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, BuildMockSSEStream("from second endpoint"))
+	}))
+	defer healthy.Close()
+
+	down := unreachableURL(t)
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.UpstreamURLs = []string{down, healthy.URL}
+	})
+	b := withFakeUpstream(t, NewRestyUpstreamClient(resty.New()))
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Expected failover to the second endpoint, got error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !strings.Contains(string(body), "from second endpoint") {
+		t.Errorf("Expected response from the second endpoint, got %q", body)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected one request to the second endpoint, got %d", hits.Load())
+	}
+	// 端点故障不是token的问题
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token to stay healthy after endpoint failover, got %d healthy", b.GetHealthyTokenCount())
+	}
+
+	stats := GetEndpointStats()
+	if len(stats) != 2 || stats[0].Healthy || stats[0].Failures != 1 || !stats[1].Healthy {
+		t.Errorf("Unexpected endpoint stats: %+v", stats)
+	}
+}
+
+func TestEndpointOrderSkipsRecentlyFailedEndpoints(t *testing.T) {
+	h := &endpointHealth{downUntil: make(map[string]time.Time), failures: make(map[string]int64)}
+	urls := []string{"https://a.example", "https://b.example", "https://c.example"}
+	now := time.Now()
+
+	h.markDown(urls[0], now)
+	if got := h.order(urls, now); strings.Join(got, ",") != "https://b.example,https://c.example,https://a.example" {
+		t.Errorf("Expected failed endpoint to be tried last, got %v", got)
+	}
+
+	// 超过重试间隔后恢复原有顺序
+	if got := h.order(urls, now.Add(endpointRetryInterval)); got[0] != urls[0] {
+		t.Errorf("Expected endpoint to be retried first after the interval, got %v", got)
+	}
+
+	h.markDown(urls[1], now)
+	h.markUp(urls[0])
+	if got := h.order(urls, now); strings.Join(got, ",") != "https://a.example,https://c.example,https://b.example" {
+		t.Errorf("Unexpected order after recovery: %v", got)
+	}
+}
+
+func TestSendJetbrainsRequestAllEndpointsUnreachable(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.UpstreamURLs = []string{unreachableURL(t), unreachableURL(t)}
+		retries := 0
+		cfg.UpstreamMaxRetries = &retries
+	})
+	b := withFakeUpstream(t, NewRestyUpstreamClient(resty.New()))
+
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); err == nil {
+		t.Fatal("Expected an error when every endpoint is unreachable")
+	}
+	// 所有端点都不可达时沿用原有的token处理
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected token to be marked unhealthy, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
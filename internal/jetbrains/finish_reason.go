@@ -0,0 +1,41 @@
+package jetbrains
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+// defaultFinishReasons JetBrains上游结束原因到OpenAI结束原因的默认映射（键为小写）
+var defaultFinishReasons = map[string]openai.FinishReason{
+	"stop":              openai.FinishReasonStop,
+	"end_turn":          openai.FinishReasonStop,
+	"stop_sequence":     openai.FinishReasonStop,
+	"complete":          openai.FinishReasonStop,
+	"length":            openai.FinishReasonLength,
+	"max_tokens":        openai.FinishReasonLength,
+	"max_output_tokens": openai.FinishReasonLength,
+	"token_limit":       openai.FinishReasonLength,
+	"content_filter":    openai.FinishReasonContentFilter,
+	"filtered":          openai.FinishReasonContentFilter,
+	"safety":            openai.FinishReasonContentFilter,
+	"tool_calls":        openai.FinishReasonToolCalls,
+	"tool_use":          openai.FinishReasonToolCalls,
+	"function_call":     openai.FinishReasonToolCalls,
+}
+
+// mapFinishReason 将上游结束原因映射为OpenAI结束原因，配置的映射优先于默认映射，为空或未知时返回stop
+func mapFinishReason(reason string, mapping map[string]string) openai.FinishReason {
+	key := strings.ToLower(strings.TrimSpace(reason))
+	if key == "" {
+		return openai.FinishReasonStop
+	}
+	for upstream, mapped := range mapping {
+		if strings.ToLower(upstream) == key && mapped != "" {
+			return openai.FinishReason(mapped)
+		}
+	}
+	if mapped, ok := defaultFinishReasons[key]; ok {
+		return mapped
+	}
+	return openai.FinishReasonStop
+}
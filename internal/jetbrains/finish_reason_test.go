@@ -0,0 +1,132 @@
+package jetbrains
+
+import (
+	"bytes"
+	"context"
+	"jetbrains-ai-proxy/internal/config"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMapFinishReason(t *testing.T) {
+	cases := []struct {
+		reason   string
+		expected openai.FinishReason
+	}{
+		{"", openai.FinishReasonStop},
+		{"stop", openai.FinishReasonStop},
+		{"end_turn", openai.FinishReasonStop},
+		{"length", openai.FinishReasonLength},
+		{"MAX_TOKENS", openai.FinishReasonLength},
+		{"content_filter", openai.FinishReasonContentFilter},
+		{"safety", openai.FinishReasonContentFilter},
+		{"tool_use", openai.FinishReasonToolCalls},
+		{"function_call", openai.FinishReasonToolCalls},
+		{"something_new", openai.FinishReasonStop},
+	}
+	for _, tc := range cases {
+		if got := mapFinishReason(tc.reason, nil); got != tc.expected {
+			t.Errorf("Expected %q to map to %q, got %q", tc.reason, tc.expected, got)
+		}
+	}
+
+	// 配置的映射优先于默认映射，键不区分大小写
+	mapping := map[string]string{"Quota": "length", "end_turn": "content_filter"}
+	if got := mapFinishReason("quota", mapping); got != openai.FinishReasonLength {
+		t.Errorf("Expected configured reason to map to length, got %q", got)
+	}
+	if got := mapFinishReason("end_turn", mapping); got != openai.FinishReasonContentFilter {
+		t.Errorf("Expected configured mapping to override default, got %q", got)
+	}
+	if got := mapFinishReason("length", mapping); got != openai.FinishReasonLength {
+		t.Errorf("Expected defaults to still apply, got %q", got)
+	}
+}
+
+// finishReasonStream 最后一个内容事件携带上游结束原因
+func finishReasonStream(reason string) string {
+	return `data: {"type":"Content","content":"Hello"}
+
+data: {"type":"Content","content":" world","reason":"` + reason + `"}
+
+data: {"type":"QuotaMetadata","spent":{"amount":"1"}}
+
+`
+}
+
+func TestFinishReasonFromUpstream(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.FinishReasonMapping = map[string]string{"quota": "length"}
+	})
+
+	cases := []struct {
+		reason   string
+		expected openai.FinishReason
+	}{
+		{"", openai.FinishReasonStop},
+		{"length", openai.FinishReasonLength},
+		{"max_tokens", openai.FinishReasonLength},
+		{"tool_use", openai.FinishReasonToolCalls},
+		{"safety", openai.FinishReasonContentFilter},
+		{"quota", openai.FinishReasonLength},
+		{"unknown", openai.FinishReasonStop},
+	}
+
+	for _, tc := range cases {
+		t.Run("reason="+tc.reason, func(t *testing.T) {
+			stream := finishReasonStream(tc.reason)
+
+			resp, err := ResponseJetbrainsAIToClient(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4o"}, strings.NewReader(stream), "fp")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Choices[0].FinishReason != tc.expected {
+				t.Errorf("Non-stream: expected %q, got %q", tc.expected, resp.Choices[0].FinishReason)
+			}
+			if resp.Choices[0].Message.Content != "Hello world" {
+				t.Errorf("Expected content to be kept, got %q", resp.Choices[0].Message.Content)
+			}
+
+			var out bytes.Buffer
+			req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+			if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			chunks := parseStreamChunks(t, out.String())
+			last := chunks[len(chunks)-1].Choices[0]
+			if last.FinishReason != tc.expected {
+				t.Errorf("Stream: expected %q, got %q", tc.expected, last.FinishReason)
+			}
+		})
+	}
+}
+
+func TestStopSequenceOverridesFinishReason(t *testing.T) {
+	cases := []struct {
+		reason   string
+		expected openai.FinishReason
+	}{
+		{"length", openai.FinishReasonStop},
+		{"tool_use", openai.FinishReasonStop},
+		{"safety", openai.FinishReasonContentFilter},
+	}
+
+	for _, tc := range cases {
+		t.Run("reason="+tc.reason, func(t *testing.T) {
+			req := openai.ChatCompletionRequest{Model: "gpt-4o", Stop: []string{" wor"}}
+			resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(finishReasonStream(tc.reason)), "fp")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Choices[0].Message.Content != "Hello" {
+				t.Errorf("Expected content to be cut at the stop sequence, got %q", resp.Choices[0].Message.Content)
+			}
+			// 命中停止序列时报告stop，内容过滤仍然优先
+			if resp.Choices[0].FinishReason != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, resp.Choices[0].FinishReason)
+			}
+		})
+	}
+}
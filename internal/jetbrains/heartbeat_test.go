@@ -0,0 +1,155 @@
+package jetbrains
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"jetbrains-ai-proxy/internal/config"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// withStreamConfig 临时修改全局配置，测试结束后恢复
+func withStreamConfig(t *testing.T, fn func(cfg *config.Config)) {
+	t.Helper()
+
+	original := *config.GetGlobalConfig().GetConfig()
+	config.GetGlobalConfig().UpdateConfig(fn)
+	t.Cleanup(func() {
+		config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+			*cfg = original
+		})
+	})
+}
+
+// streamSlowly 以固定间隔逐个写入SSE事件
+func streamSlowly(events []string, delay time.Duration) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, event := range events {
+			time.Sleep(delay)
+			pw.Write([]byte(event))
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestFormatProgressComment(t *testing.T) {
+	comment := formatProgressComment(12500*time.Millisecond, 340)
+	if comment != "progress elapsed=12s completion_tokens=340" {
+		t.Errorf("Unexpected progress comment: %q", comment)
+	}
+}
+
+func TestStreamProgressComments(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.HeartbeatInterval = 40 * time.Millisecond
+		cfg.StreamProgress = true
+	})
+
+	var events []string
+	for i := 0; i < 10; i++ {
+		events = append(events, `data: {"type":"Content","content":"chunk "}`+"\n\n")
+	}
+	events = append(events, BuildMockSSEStream())
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, streamSlowly(events, 20*time.Millisecond), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 每个SSE块要么是完整的data事件，要么是完整的注释
+	progress := regexp.MustCompile(`^: progress elapsed=\d+s completion_tokens=\d+$`)
+	comments := 0
+	for _, block := range strings.Split(strings.TrimSuffix(out.String(), "\n\n"), "\n\n") {
+		switch {
+		case strings.HasPrefix(block, ": "):
+			if !progress.MatchString(block) {
+				t.Errorf("Unexpected comment format: %q", block)
+			}
+			comments++
+		case !strings.HasPrefix(block, "data: "):
+			t.Errorf("Unexpected SSE block: %q", block)
+		}
+	}
+
+	// 约220ms的流，40ms一次进度注释
+	if comments < 2 || comments > 6 {
+		t.Errorf("Expected progress comments at the configured cadence, got %d", comments)
+	}
+	if !strings.HasSuffix(out.String(), "data: [DONE]\n\n") {
+		t.Errorf("Expected stream to end with [DONE], got:\n%s", out.String())
+	}
+}
+
+func TestStreamKeepaliveByDefault(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.HeartbeatInterval = 20 * time.Millisecond
+	})
+
+	events := []string{
+		`data: {"type":"Content","content":"a"}` + "\n\n",
+		`data: {"type":"Content","content":"b"}` + "\n\n",
+		BuildMockSSEStream(),
+	}
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, streamSlowly(events, 40*time.Millisecond), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), ": keepalive\n\n") || strings.Contains(out.String(), "progress") {
+		t.Errorf("Expected plain keepalive comments, got:\n%s", out.String())
+	}
+}
+
+func TestKeepaliveOnlyWhenIdle(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.HeartbeatInterval = 60 * time.Millisecond
+	})
+
+	// 持续有数据时不发送保活注释
+	var events []string
+	for i := 0; i < 10; i++ {
+		events = append(events, `data: {"type":"Content","content":"chunk "}`+"\n\n")
+	}
+	events = append(events, BuildMockSSEStream())
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, streamSlowly(events, 20*time.Millisecond), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), ": keepalive") {
+		t.Errorf("Expected no keepalive while data is flowing, got:\n%s", out.String())
+	}
+
+	// 上游停顿期间发送保活注释，停顿结束后继续转发内容
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`data: {"type":"Content","content":"before"}` + "\n\n"))
+		time.Sleep(100 * time.Millisecond)
+		pw.Write([]byte(`data: {"type":"Content","content":"after"}` + "\n\n"))
+		pw.Write([]byte(BuildMockSSEStream()))
+		pw.Close()
+	}()
+
+	out.Reset()
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, pr, "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body := out.String()
+	if count := strings.Count(body, ": keepalive\n\n"); count != 1 {
+		t.Errorf("Expected one keepalive during the idle gap, got %d:\n%s", count, body)
+	}
+	before, keepalive, after := strings.Index(body, "before"), strings.Index(body, ": keepalive"), strings.Index(body, "after")
+	if !(before < keepalive && keepalive < after) {
+		t.Errorf("Expected keepalive between the chunks around the idle gap, got:\n%s", body)
+	}
+}
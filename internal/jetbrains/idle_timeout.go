@@ -0,0 +1,60 @@
+package jetbrains
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrStreamIdleTimeout 上游流在空闲超时时间内没有任何数据
+var ErrStreamIdleTimeout = errors.New("upstream stream idle timeout")
+
+// idleTimeoutReader 每次读到数据时重置计时器，连续空闲超过时限则关闭上游连接
+type idleTimeoutReader struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	mutex    sync.Mutex
+	timedOut bool
+}
+
+// newIdleTimeoutReader 包装上游响应体，timeout<=0时不做限制
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+
+	r := &idleTimeoutReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, r.expire)
+	return r
+}
+
+// expire 空闲超时，关闭上游连接以中断阻塞中的读取
+func (r *idleTimeoutReader) expire() {
+	r.mutex.Lock()
+	r.timedOut = true
+	r.mutex.Unlock()
+	r.body.Close()
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	r.mutex.Lock()
+	timedOut := r.timedOut
+	r.mutex.Unlock()
+
+	if timedOut {
+		return n, ErrStreamIdleTimeout
+	}
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
@@ -0,0 +1,134 @@
+package jetbrains
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"jetbrains-ai-proxy/internal/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestIdleTimeoutReaderSlowButAliveStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		// 总时长远超空闲超时，但每次间隔都在超时以内
+		for i := 0; i < 8; i++ {
+			time.Sleep(20 * time.Millisecond)
+			pw.Write([]byte(`data: {"type":"Content","content":"x"}` + "\n\n"))
+		}
+		pw.Close()
+	}()
+
+	body := newIdleTimeoutReader(pr, 80*time.Millisecond)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Expected slow stream to complete, got %v", err)
+	}
+	if count := strings.Count(string(data), "Content"); count != 8 {
+		t.Errorf("Expected 8 events, got %d", count)
+	}
+}
+
+func TestIdleTimeoutReaderStalledStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte(`data: {"type":"Content","content":"x"}` + "\n\n"))
+
+	body := newIdleTimeoutReader(pr, 50*time.Millisecond)
+	defer body.Close()
+
+	start := time.Now()
+	_, err := io.ReadAll(body)
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Fatalf("Expected ErrStreamIdleTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected stalled stream to abort quickly, took %v", elapsed)
+	}
+}
+
+func TestStreamStalledUpstreamSendsError(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.StreamTimeoutMode = StreamTimeoutError
+	})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte(`data: {"type":"Content","content":"partial"}` + "\n\n"))
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	body := newIdleTimeoutReader(pr, 50*time.Millisecond)
+	defer body.Close()
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, body, "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, `"content":"partial"`) {
+		t.Errorf("Expected partial content before timeout, got:\n%s", output)
+	}
+	if !strings.Contains(output, "idle timeout") || !strings.HasSuffix(output, "data: [DONE]\n\n") {
+		t.Errorf("Expected idle timeout error event and [DONE], got:\n%s", output)
+	}
+}
+
+func TestStreamStalledUpstreamFinishesPartialResponse(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.StreamTimeoutMode = ""
+	})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte(`data: {"type":"Content","content":"partial"}` + "\n\n"))
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	body := newIdleTimeoutReader(pr, 50*time.Millisecond)
+	defer body.Close()
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, body, "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := out.String()
+	if strings.Contains(output, `"error"`) {
+		t.Errorf("Expected no error event by default, got:\n%s", output)
+	}
+	// 已发送的内容之后依次是截断说明、length结束分片和 [DONE]
+	content := strings.Index(output, `"content":"partial"`)
+	note := strings.Index(output, ": upstream idle timeout, response truncated\n\n")
+	finish := strings.Index(output, `"finish_reason":"length"`)
+	if content < 0 || note < content || finish < note || !strings.HasSuffix(output, "data: [DONE]\n\n") {
+		t.Errorf("Expected content, truncation note, length finish and [DONE] in order, got:\n%s", output)
+	}
+}
+
+func TestStreamStalledBeforeContentSendsError(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.StreamTimeoutMode = StreamTimeoutFinish
+	})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	body := newIdleTimeoutReader(pr, 50*time.Millisecond)
+	defer body.Close()
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, body, "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 没有可保留的内容时，空的正常结束会被误认为完整回复
+	output := out.String()
+	if !strings.Contains(output, "idle timeout") || strings.Contains(output, `"finish_reason":"length"`) {
+		t.Errorf("Expected idle timeout error event without content, got:\n%s", output)
+	}
+}
@@ -0,0 +1,110 @@
+package jetbrains
+
+import (
+	"context"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"io"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MockResponder 根据请求生成模拟响应的状态码和SSE流内容
+type MockResponder func(req *types.JetbrainsRequest) (statusCode int, stream string)
+
+// MockUpstreamRequest 模拟上游收到的请求记录
+type MockUpstreamRequest struct {
+	URL     string
+	Headers map[string]string
+	Body    *types.JetbrainsRequest
+}
+
+// MockUpstreamClient 模拟上游客户端，按脚本返回SSE流，用于离线运行和集成测试
+type MockUpstreamClient struct {
+	responder MockResponder
+	requests  []MockUpstreamRequest
+	mutex     sync.Mutex
+}
+
+// NewMockUpstreamClient 使用自定义响应函数创建模拟上游客户端
+func NewMockUpstreamClient(responder MockResponder) *MockUpstreamClient {
+	return &MockUpstreamClient{responder: responder}
+}
+
+// NewScriptedMockUpstreamClient 创建始终返回固定状态码和SSE流的模拟上游客户端
+func NewScriptedMockUpstreamClient(statusCode int, stream string) *MockUpstreamClient {
+	return NewMockUpstreamClient(func(*types.JetbrainsRequest) (int, string) {
+		return statusCode, stream
+	})
+}
+
+// NewEchoMockUpstreamClient 创建回显最后一条用户消息的模拟上游客户端（离线模式）
+func NewEchoMockUpstreamClient() *MockUpstreamClient {
+	return NewMockUpstreamClient(func(req *types.JetbrainsRequest) (int, string) {
+		var lastUser string
+		if req != nil {
+			for _, msg := range req.Chat.MessageField {
+				if msg.Type == "user_message" {
+					lastUser = msg.Content
+				}
+			}
+		}
+		return http.StatusOK, BuildMockSSEStream("[mock] ", lastUser)
+	})
+}
+
+// Post 记录请求并返回脚本化的响应，非200状态与默认客户端一样返回错误
+func (m *MockUpstreamClient) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	jbReq, _ := body.(*types.JetbrainsRequest)
+
+	headersCopy := make(map[string]string, len(headers))
+	for k, v := range headers {
+		headersCopy[k] = v
+	}
+
+	m.mutex.Lock()
+	m.requests = append(m.requests, MockUpstreamRequest{URL: url, Headers: headersCopy, Body: jbReq})
+	m.mutex.Unlock()
+
+	statusCode, stream := m.responder(jbReq)
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(stream)),
+	}
+
+	if statusCode != http.StatusOK {
+		return resp, fmt.Errorf("Jetbrains API error: status %d, body: %s", statusCode, stream)
+	}
+	return resp, nil
+}
+
+// Requests 返回已收到的请求记录
+func (m *MockUpstreamClient) Requests() []MockUpstreamRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	requests := make([]MockUpstreamRequest, len(m.requests))
+	copy(requests, m.requests)
+	return requests
+}
+
+// BuildMockSSEStream 将内容片段构造成JetBrains格式的SSE流（以QuotaMetadata和end结尾）
+func BuildMockSSEStream(chunks ...string) string {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		line, _ := sonic.MarshalString(SSEData{Type: "Content", Content: chunk})
+		sb.WriteString("data: " + line + "\n\n")
+	}
+	line, _ := sonic.MarshalString(SSEData{Type: "QuotaMetadata", Spent: &SpentData{Amount: "10"}})
+	sb.WriteString("data: " + line + "\n\n")
+	sb.WriteString("data: end\n\n")
+	return sb.String()
+}
@@ -0,0 +1,69 @@
+package jetbrains
+
+import (
+	"jetbrains-ai-proxy/internal/balancer"
+	"sync"
+	"time"
+)
+
+// quotaResets 从上游QuotaMetadata的updated数据中获知的各token配额重置时间
+var quotaResets sync.Map // token -> time.Time
+
+// quotaResetTime 将上游的until转换为时间：按Unix毫秒处理，数值较小时视为Unix秒
+func quotaResetTime(until int64) time.Time {
+	if until <= 0 {
+		return time.Time{}
+	}
+	if until < 1e12 {
+		return time.Unix(until, 0)
+	}
+	return time.UnixMilli(until)
+}
+
+// recordQuotaUpdate 记录响应所属token的配额重置时间，token未知时忽略
+func recordQuotaUpdate(token string, updated *UpdatedData) {
+	if updated == nil || token == "" {
+		return
+	}
+	if resetAt := quotaResetTime(updated.Until); !resetAt.IsZero() {
+		quotaResets.Store(token, resetAt)
+	}
+}
+
+// knownQuotaReset 返回token已知且尚未到达的配额重置时间，未知时返回零值
+func knownQuotaReset(token string) time.Time {
+	value, ok := quotaResets.Load(token)
+	if !ok {
+		return time.Time{}
+	}
+	resetAt := value.(time.Time)
+	if !resetAt.After(time.Now()) {
+		quotaResets.Delete(token)
+		return time.Time{}
+	}
+	return resetAt
+}
+
+// reapQuotaResets 删除已不在负载均衡器中的token和已过期的配额重置时间，返回删除的条目数
+func reapQuotaResets(jwtBalancer balancer.JWTBalancer, now time.Time) int {
+	baseBalancer, _ := jwtBalancer.(*balancer.BaseBalancer)
+	reaped := 0
+	quotaResets.Range(func(key, value any) bool {
+		removed := baseBalancer != nil && !baseBalancer.HasToken(key.(string))
+		if removed || !value.(time.Time).After(now) {
+			quotaResets.Delete(key)
+			reaped++
+		}
+		return true
+	})
+	return reaped
+}
+
+// markQuotaExhausted 将配额耗尽的token移出轮换，已知重置时间时在此之前不再选择它
+func markQuotaExhausted(jwtBalancer balancer.JWTBalancer, token string) {
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		baseBalancer.MarkTokenQuotaExhausted(token, knownQuotaReset(token))
+		return
+	}
+	jwtBalancer.MarkTokenUnhealthy(token)
+}
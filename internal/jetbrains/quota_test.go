@@ -0,0 +1,143 @@
+package jetbrains
+
+import (
+	"context"
+	"errors"
+	"io"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// statusByTokenUpstream 按token返回不同状态码的测试上游
+type statusByTokenUpstream struct {
+	statuses map[string]int
+	calls    []string
+}
+
+func (f *statusByTokenUpstream) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	token := headers[types.JwtTokenKey]
+	f.calls = append(f.calls, token)
+	return &http.Response{
+		StatusCode: f.statuses[token],
+		Body:       io.NopCloser(strings.NewReader(BuildMockSSEStream("from " + token))),
+	}, nil
+}
+
+func TestQuotaResetTime(t *testing.T) {
+	if !quotaResetTime(0).IsZero() {
+		t.Error("Expected zero time for missing until")
+	}
+	if got := quotaResetTime(1700000000); !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected seconds to be accepted, got %v", got)
+	}
+	if got := quotaResetTime(1700000000123); !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("Expected milliseconds to be accepted, got %v", got)
+	}
+}
+
+func TestSendJetbrainsRequestQuotaExhaustedFailover(t *testing.T) {
+	fake := &statusByTokenUpstream{statuses: map[string]int{
+		"token-a": http.StatusForbidden,
+		"token-b": http.StatusOK,
+	}}
+	b := withTokens(t, fake, "token-a", "token-b")
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Expected failover to token-b, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(fake.calls) != 2 || fake.calls[1] != "token-b" {
+		t.Fatalf("Expected retry on token-b, got calls %v", fake.calls)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(data), "from token-b") {
+		t.Errorf("Expected stream from token-b, got %q", data)
+	}
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token-a to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestSendJetbrainsRequestQuotaExhaustedAllTokens(t *testing.T) {
+	fake := &statusByTokenUpstream{statuses: map[string]int{"token-a": http.StatusForbidden}}
+	withTokens(t, fake, "token-a")
+
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); !errors.Is(err, ErrQuotaExhausted) {
+		t.Fatalf("Expected ErrQuotaExhausted, got %v", err)
+	}
+}
+
+func TestQuotaExhaustedUntilKnownReset(t *testing.T) {
+	fake := &statusByTokenUpstream{statuses: map[string]int{
+		"token-a": http.StatusOK,
+		"token-b": http.StatusOK,
+	}}
+	b := withTokens(t, fake, "token-a", "token-b")
+	t.Cleanup(func() {
+		quotaResets.Delete("token-a")
+	})
+
+	// 成功响应中的updated数据记录配额重置时间
+	resetAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	stream := `data: {"type":"Content","content":"hi"}` + "\n\n" +
+		`data: {"type":"QuotaMetadata","updated":{"until":` + strconv.FormatInt(resetAt.UnixMilli(), 10) + `},"spent":{"amount":"1"}}` + "\n\n"
+	resp := &http.Response{Body: &releasingBody{ReadCloser: io.NopCloser(strings.NewReader(stream)), token: "token-a", release: func() {}}}
+	ctx := WithResponseToken(context.Background(), resp)
+	if _, err := ResponseJetbrainsAIToClient(ctx, openai.ChatCompletionRequest{Model: "gpt-4o"}, resp.Body, "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := knownQuotaReset("token-a"); !got.Equal(resetAt) {
+		t.Fatalf("Expected known reset %v, got %v", resetAt, got)
+	}
+
+	// 之后返回403时，在重置时间之前健康检查也不会将其恢复
+	fake.statuses["token-a"] = http.StatusForbidden
+	if _, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest()); err != nil {
+		t.Fatalf("Expected failover to token-b, got %v", err)
+	}
+	b.MarkTokenHealthy("token-a")
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token-a to stay out until its quota resets, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestStateReaperDropsRemovedTokenState(t *testing.T) {
+	withTokens(t, &statusByTokenUpstream{}, "token-a")
+	future := time.Now().Add(time.Hour)
+	quotaResets.Store("token-a", future)
+	quotaResets.Store("token-removed", future)
+	quotaResets.Store("token-expired", time.Now().Add(-time.Minute))
+	t.Cleanup(func() {
+		stopStateReaper()
+		quotaResets.Delete("token-a")
+	})
+
+	startStateReaper(10 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		_, removed := quotaResets.Load("token-removed")
+		_, expired := quotaResets.Load("token-expired")
+		if !removed && !expired {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, ok := quotaResets.Load("token-removed"); ok {
+		t.Error("Expected quota state of the removed token to be reaped")
+	}
+	if _, ok := quotaResets.Load("token-expired"); ok {
+		t.Error("Expected expired quota reset to be reaped")
+	}
+	if _, ok := quotaResets.Load("token-a"); !ok {
+		t.Error("Expected quota state of a configured token to be kept")
+	}
+}
@@ -0,0 +1,60 @@
+package jetbrains
+
+import (
+	"jetbrains-ai-proxy/internal/balancer"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	reaperMutex sync.Mutex
+	// stopReaper 停止当前的状态清理任务，未启动时为nil
+	stopReaper func()
+)
+
+// startStateReaper 按interval定期清理token状态，替换之前启动的清理任务；interval<=0时只停止
+func startStateReaper(interval time.Duration) {
+	reaperMutex.Lock()
+	defer reaperMutex.Unlock()
+
+	if stopReaper != nil {
+		stopReaper()
+		stopReaper = nil
+	}
+	if interval <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if reaped := reapTokenState(getBalancer(), time.Now()); reaped > 0 {
+					log.Printf("Reaped stale state for %d entries", reaped)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	stopReaper = func() { close(done) }
+}
+
+// stopStateReaper 停止状态清理任务
+func stopStateReaper() {
+	startStateReaper(0)
+}
+
+// reapTokenState 清理一次：删除已移除token残留的状态和draining token，返回清理的条目数
+func reapTokenState(jwtBalancer balancer.JWTBalancer, now time.Time) int {
+	reaped := reapQuotaResets(jwtBalancer, now)
+	if baseBalancer, ok := jwtBalancer.(*balancer.BaseBalancer); ok {
+		reaped += baseBalancer.ReapDrained()
+	}
+	return reaped
+}
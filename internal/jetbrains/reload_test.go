@@ -0,0 +1,224 @@
+package jetbrains
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSwapBalancerPreservesHealthState(t *testing.T) {
+	SetBalancer(balancer.NewJWTBalancer([]string{"token-a", "token-b"}, config.RoundRobin))
+	getBalancer().MarkTokenUnhealthy("token-a")
+
+	cfg := &config.Config{LoadBalanceStrategy: config.RoundRobin}
+	next := swapBalancer([]config.JWTTokenConfig{{Token: "token-a"}, {Token: "token-b"}, {Token: "token-c"}}, cfg)
+
+	if getBalancer() != next {
+		t.Fatal("Expected current balancer to be replaced")
+	}
+	// 已知失效的token在重载后仍然不可用，新token默认健康
+	if healthy, total := GetBalancerStats(); healthy != 2 || total != 3 {
+		t.Errorf("Expected 2/3 healthy tokens after reload, got %d/%d", healthy, total)
+	}
+}
+
+func TestConcurrentGetTokenDuringReload(t *testing.T) {
+	SetBalancer(balancer.NewJWTBalancer([]string{"token-0"}, config.RoundRobin))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := getBalancer().GetToken(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	// 重载期间策略和token列表都在变化
+	strategies := []config.LoadBalanceStrategy{config.RoundRobin, config.Random}
+	for i := 0; i < 200; i++ {
+		tokens := []config.JWTTokenConfig{{Token: fmt.Sprintf("token-%d", i)}, {Token: fmt.Sprintf("token-%d", i+1)}}
+		swapBalancer(tokens, &config.Config{LoadBalanceStrategy: strategies[i%2], PriorityTiers: i%3 == 0})
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Unexpected error during reload: %v", err)
+	}
+}
+
+func TestReloadDrainsTokenWithActiveStream(t *testing.T) {
+	withTokens(t, &tokenScriptedUpstream{streams: map[string]string{
+		"token-a": BuildMockSSEStream("from token a"),
+	}}, "token-a")
+
+	resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 流仍在进行时重载配置移除了token-a
+	next := swapBalancer([]config.JWTTokenConfig{{Token: "token-b", Name: "B"}}, &config.Config{LoadBalanceStrategy: config.RoundRobin})
+	stats := next.(*balancer.BaseBalancer).GetTokenStats()
+	if len(stats) != 2 || stats[1].Status != "draining" || stats[1].InFlight != 1 {
+		t.Fatalf("Expected removed token to be draining with 1 in-flight request, got %+v", stats)
+	}
+	if healthy, total := GetBalancerStats(); healthy != 1 || total != 1 {
+		t.Errorf("Expected draining token to be excluded from counts, got %d/%d", healthy, total)
+	}
+	for i := 0; i < 3; i++ {
+		if token, _ := next.GetToken(); token != "token-b" {
+			t.Errorf("Expected draining token not to be selected, got %s", token)
+		}
+		next.ReleaseToken("token-b")
+	}
+
+	// 流结束后token被真正删除
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if stats := next.(*balancer.BaseBalancer).GetTokenStats(); len(stats) != 1 || stats[0].Name != "B" {
+		t.Errorf("Expected drained token to be removed, got %+v", stats)
+	}
+}
+
+func TestInFlightBalancedAcrossConcurrentReloads(t *testing.T) {
+	withTokens(t, NewScriptedMockUpstreamClient(http.StatusOK, BuildMockSSEStream("hi")), "token-a", "token-b")
+	tokens := []config.JWTTokenConfig{{Token: "token-a"}, {Token: "token-b"}}
+	cfg := &config.Config{LoadBalanceStrategy: config.RoundRobin}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				resp, err := SendJetbrainsRequest(context.Background(), testJetbrainsRequest())
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+					return
+				}
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		swapBalancer(tokens, cfg)
+	}
+	wg.Wait()
+
+	// 选择和释放落在不同实例上时在途计数会残留或变为负数
+	for _, stat := range getBalancer().(*balancer.BaseBalancer).GetTokenStats() {
+		if stat.InFlight != 0 {
+			t.Errorf("Expected no in-flight requests after all streams closed, got %+v", stat)
+		}
+	}
+}
+
+// writeTokensConfig 写入只包含指定token的配置文件
+func writeTokensConfig(t *testing.T, dir string, tokens ...string) {
+	t.Helper()
+
+	entries := make([]string, len(tokens))
+	for i, token := range tokens {
+		entries[i] = fmt.Sprintf(`{"token": %q, "name": %q}`, token, token)
+	}
+	data := fmt.Sprintf(`{"jetbrains_tokens": [%s], "bearer_token": "bearer"}`, strings.Join(entries, ","))
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+}
+
+// reloadConcurrently 同时发起n次重载，返回所有调用的错误；started在所有调用发起后关闭
+func reloadConcurrently(n int, started chan<- struct{}) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ReloadConfig()
+		}(i)
+	}
+	if started != nil {
+		close(started)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentReloadsAreCoalesced(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	writeTokensConfig(t, dir, "token-a", "token-b")
+
+	previous := configManager
+	configManager = config.NewManager()
+	t.Cleanup(func() { configManager = previous })
+	SetBalancer(balancer.NewJWTBalancer([]string{"token-0"}, config.RoundRobin))
+	buf := captureLog(t)
+
+	// 模拟一次耗时较长的重载：期间到达的调用合并为下一次重载，并读到期间写入的配置
+	const callers = 50
+	reloadRun.Lock()
+	started := make(chan struct{})
+	result := make(chan []error)
+	go func() { result <- reloadConcurrently(callers, started) }()
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	writeTokensConfig(t, dir, "token-c")
+	reloadRun.Unlock()
+
+	for _, err := range <-result {
+		if err != nil {
+			t.Fatalf("Unexpected reload error: %v", err)
+		}
+	}
+	if runs := strings.Count(buf.String(), "Config reloaded successfully"); runs != 1 {
+		t.Errorf("Expected %d concurrent calls to be coalesced into 1 reload, got %d", callers, runs)
+	}
+	if stats := getBalancer().(*balancer.BaseBalancer).GetTokenStats(); len(stats) != 1 || stats[0].Name != "token-c" {
+		t.Errorf("Expected token set from the config written before the reload ran, got %+v", stats)
+	}
+
+	// 不受控的并发重载同样不会交错，最终状态与配置文件一致
+	writeTokensConfig(t, dir, "token-d", "token-e", "token-f")
+	for _, err := range reloadConcurrently(callers, nil) {
+		if err != nil {
+			t.Fatalf("Unexpected reload error: %v", err)
+		}
+	}
+	stats := getBalancer().(*balancer.BaseBalancer).GetTokenStats()
+	names := make([]string, len(stats))
+	for i, s := range stats {
+		names[i] = s.Name
+	}
+	if strings.Join(names, ",") != "token-d,token-e,token-f" {
+		t.Errorf("Expected final token set from the latest config, got %v", names)
+	}
+}
@@ -3,10 +3,12 @@ package jetbrains
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/bytedance/sonic"
 	"github.com/sashabaranov/go-openai"
 	"io"
+	"jetbrains-ai-proxy/internal/config"
 	"jetbrains-ai-proxy/internal/utils"
 	"log"
 	"math"
@@ -20,12 +22,30 @@ const (
 	sseObject         = "chat.completion.chunk"
 	completionsObject = "chat.completions"
 	sseFinish         = "[DONE]"
+	sseDataPrefix     = "data: "
+	sseEnd            = "end"
 	initialBufferSize = 4096
-	maxBufferSize     = 1024 * 1024 // 1MB
 	flushThreshold    = 10
-	heartbeatInterval = 30 * time.Second
 )
 
+// ErrSSELineTooLong 上游单行SSE数据超出max_sse_line_size，通常是没有换行的畸形数据
+var ErrSSELineTooLong = errors.New("upstream SSE line too long")
+
+// 响应写入器不支持刷新时的处理方式
+const (
+	NonFlushingWarn   = "warn"   // 记录警告，照常以SSE响应（默认）
+	NonFlushingBuffer = "buffer" // 改为返回非流式响应
+)
+
+// 已发送部分内容后上游流空闲超时的处理方式
+const (
+	StreamTimeoutFinish = "finish" // 以 length 结束原因正常结束流，客户端保留已收到的内容（默认）
+	StreamTimeoutError  = "error"  // 发送错误事件后结束流
+)
+
+// HeaderStreamDegraded 流式请求被降级为非流式响应时设置的响应头
+const HeaderStreamDegraded = "X-Stream-Degraded"
+
 type SSEData struct {
 	Type      string       `json:"type"`
 	EventType string       `json:"event_type"`
@@ -58,10 +78,16 @@ type SpentData struct {
 // ResponseJetbrainsAIToClient 处理非流式响应
 func ResponseJetbrainsAIToClient(ctx context.Context, req openai.ChatCompletionRequest, r io.Reader, fp string) (openai.ChatCompletionResponse, error) {
 	reader := bufio.NewReader(r)
+	cfg := config.GetGlobalConfig().GetConfig()
 	var fullContent strings.Builder
+	// 预填充续写时响应以预填充内容开头
+	fullContent.WriteString(responsePrefix(ctx))
+	finishReason := openai.FinishReasonStop
+	var filterResults *openai.ContentFilterResults
+	var sseData SSEData
 
 	now := time.Now().Unix()
-	chatId := strconv.Itoa(int(now))
+	completionID := newCompletionID()
 
 	for {
 		select {
@@ -70,7 +96,7 @@ func ResponseJetbrainsAIToClient(ctx context.Context, req openai.ChatCompletionR
 		default:
 		}
 
-		line, err := reader.ReadString('\n')
+		line, err := readSSELine(reader, cfg.MaxSSELineSize)
 		if err != nil {
 			if err == io.EOF {
 				log.Printf("Reached EOF for non-streaming response")
@@ -79,118 +105,268 @@ func ResponseJetbrainsAIToClient(ctx context.Context, req openai.ChatCompletionR
 			return openai.ChatCompletionResponse{}, fmt.Errorf("读取错误: %w", err)
 		}
 
-		if !strings.HasPrefix(line, "data: ") {
+		if ok, err := parseSSELine(line, &sseData); errors.Is(err, errSSEDone) {
+			log.Printf("Received [DONE] for non-streaming response")
+			break
+		} else if err != nil {
+			log.Printf("解析SSE数据错误: %v", err)
+			continue
+		} else if !ok {
 			continue
 		}
 
-		jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data: "))
-		if jsonStr == "" || jsonStr == sseFinish || jsonStr == "end" {
-			continue
+		if streamErr := parseStreamError(sseData, nil); streamErr != nil {
+			return openai.ChatCompletionResponse{}, streamErr
 		}
 
-		var sseData SSEData
-		if err := sonic.UnmarshalString(jsonStr, &sseData); err != nil {
-			log.Printf("解析SSE数据错误: %v", err)
+		if isContentFilterEvent(sseData) {
+			log.Printf("Content filter event received: type=%s reason=%s", sseData.Type, sseData.Reason)
+			results := buildContentFilterResults(sseData)
+			filterResults = &results
 			continue
 		}
 
+		if sseData.Reason != "" {
+			finishReason = mapFinishReason(sseData.Reason, cfg.FinishReasonMapping)
+		}
+
 		if sseData.Type == "Content" {
 			fullContent.WriteString(sseData.Content)
 		}
 
 		if sseData.Type == "QuotaMetadata" {
-			var spentAmount float64
-			if sseData.Spent != nil {
-				if amount, err := strconv.ParseFloat(sseData.Spent.Amount, 64); err == nil {
-					spentAmount = amount
-				} else {
-					log.Printf("Warning: failed to parse spent amount '%s': %v", sseData.Spent.Amount, err)
-				}
-			}
-			usage := utils.CalculateJetbrainsUsage(fullContent.String(), int(math.Round(spentAmount)))
-			return createMessage(chatId, now, req, usage, fullContent.String(), fp), nil
+			recordQuotaUpdate(responseToken(ctx), sseData.Updated)
+			content, reason := truncateAtStop(fullContent.String(), req.Stop, finishReason)
+			usage := utils.CalculateJetbrainsUsage(content, int(math.Round(parseSpentAmount(sseData.Spent))))
+			return applyContentFilter(createMessage(completionID, now, req, usage, content, fp, reason), filterResults), nil
+		}
+	}
+
+	// 如果没有收到 QuotaMetadata，根据prompt和补全内容估算用量，避免报告0
+	content, reason := truncateAtStop(fullContent.String(), req.Stop, finishReason)
+	var usage openai.Usage
+	if tokens, ok := promptTokens(ctx); ok {
+		usage = utils.EstimateCompletionUsage(tokens, content)
+	} else {
+		usage = utils.EstimateUsage(req.Messages, content)
+	}
+	log.Printf("No QuotaMetadata received, reporting estimated usage: %d prompt + %d completion tokens",
+		usage.PromptTokens, usage.CompletionTokens)
+	return applyContentFilter(createMessage(completionID, now, req, usage, content, fp, reason), filterResults), nil
+}
+
+// readSSELine 读取一行（包含换行符），单行超过maxSize字节时停止缓存并返回ErrSSELineTooLong；
+// maxSize不大于0时不限制。EOF时与ReadString一样返回已读取的部分和io.EOF
+func readSSELine(reader *bufio.Reader, maxSize int) (string, error) {
+	var buf []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		if maxSize > 0 && len(buf)+len(fragment) > maxSize {
+			return "", fmt.Errorf("%w: exceeds %d bytes", ErrSSELineTooLong, maxSize)
+		}
+		if err == bufio.ErrBufferFull {
+			buf = append(buf, fragment...)
+			continue
 		}
+		if buf == nil {
+			return string(fragment), err
+		}
+		return string(append(buf, fragment...)), err
+	}
+}
+
+// errSSEDone 上游发送了 [DONE] 结束标记，之后的数据不再处理
+var errSSEDone = errors.New("upstream sent [DONE]")
+
+// isSSESentinel 判断data行的内容是否为空或结束标记（end、[DONE]），这些内容不是JSON
+func isSSESentinel(payload string) bool {
+	switch payload {
+	case "", sseEnd, sseFinish:
+		return true
+	}
+	return false
+}
+
+// parseSSELine 将一行 "data: {...}" 解析到data中，data由调用方在循环中复用以减少每行的分配；
+// 非data行、空数据和end标记不进行JSON解析，返回false；[DONE] 返回errSSEDone，调用方应当作流正常结束
+func parseSSELine(line string, data *SSEData) (bool, error) {
+	if !strings.HasPrefix(line, sseDataPrefix) {
+		return false, nil
+	}
+
+	payload := strings.TrimSpace(line[len(sseDataPrefix):])
+	if payload == sseFinish {
+		return false, errSSEDone
+	}
+	if isSSESentinel(payload) {
+		return false, nil
 	}
 
-	// 如果没有收到 QuotaMetadata，返回默认响应
-	usage := utils.CalculateJetbrainsUsage(fullContent.String(), 0)
-	return createMessage(chatId, now, req, usage, fullContent.String(), fp), nil
+	*data = SSEData{}
+	if err := sonic.UnmarshalString(payload, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyContentFilter 内容被过滤时设置结束原因和过滤结果
+func applyContentFilter(resp openai.ChatCompletionResponse, results *openai.ContentFilterResults) openai.ChatCompletionResponse {
+	if results != nil {
+		resp.Choices[0].FinishReason = openai.FinishReasonContentFilter
+		resp.Choices[0].ContentFilterResults = *results
+	}
+	return resp
+}
+
+// truncateAtStop 在停止序列处截断内容；命中停止序列时结束原因为stop，内容过滤除外
+func truncateAtStop(content string, stops []string, reason openai.FinishReason) (string, openai.FinishReason) {
+	content, matched := applyStopSequences(content, stops)
+	if matched && reason != openai.FinishReasonContentFilter {
+		reason = openai.FinishReasonStop
+	}
+	return content, reason
+}
+
+// applyStopSequences 在第一个出现的停止序列处截断内容（不包含停止序列本身）
+func applyStopSequences(content string, stops []string) (string, bool) {
+	cut := -1
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if idx := strings.Index(content, stop); idx >= 0 && (cut < 0 || idx < cut) {
+			cut = idx
+		}
+	}
+	if cut < 0 {
+		return content, false
+	}
+	return content[:cut], true
 }
 
 // StreamJetbrainsAISSEToClient 处理流式响应
 func StreamJetbrainsAISSEToClient(ctx context.Context, req openai.ChatCompletionRequest, w io.Writer, r io.Reader, fp string) error {
 	log.Printf("=== Starting SSE Stream Processing for model: %s ===", req.Model)
+	if !CanFlush(w) {
+		log.Printf("WARNING: response writer %T does not support flushing, the stream will be buffered until it completes (see non_flushing_stream_mode)", w)
+	}
 
 	reader := bufio.NewReaderSize(r, initialBufferSize)
-	writer := bufio.NewWriterSize(w, initialBufferSize)
+	counted := &countingWriter{Writer: w}
+	writer := bufio.NewWriterSize(counted, initialBufferSize)
 
 	now := time.Now().Unix()
-	chatId := strconv.Itoa(int(now))
+	completionID := newCompletionID()
 	fingerprint := fp
 
-	log.Printf("Session initialized - ChatID: %s, Fingerprint: %s", chatId, fingerprint)
+	log.Printf("Session initialized - CompletionID: %s, Fingerprint: %s", completionID, fingerprint)
 
-	var completionBuilder strings.Builder
+	state := newStreamState()
 	messageCount := 0
-	totalBufferSize := 0
+	var sseData SSEData
 
 	// 创建心跳检测器
-	heartbeat := time.NewTicker(heartbeatInterval)
+	cfg := config.GetGlobalConfig().GetConfig()
+	started := time.Now()
+	interval := cfg.HeartbeatInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	heartbeat := time.NewTimer(interval)
 	defer heartbeat.Stop()
+	state.costUpdates = cfg.StreamCostUpdates
+	state.skipEmptyContent = cfg.SkipEmptyContent
+	state.finishReasons = cfg.FinishReasonMapping
+
+	if err := sendMessage(writer, w, createRoleMessage(completionID, now, req, fingerprint)); err != nil {
+		return err
+	}
+	// 预填充续写时先发送预填充内容，客户端拼接后得到完整的回复
+	if prefix := responsePrefix(ctx); prefix != "" {
+		state.completion.WriteString(prefix)
+		if err := sendMessage(writer, w, createStreamMessage(completionID, now, req, fingerprint, prefix, "")); err != nil {
+			return err
+		}
+	}
+
+	// 在单独的协程中读取上游，等待数据时仍可发送心跳
+	done := make(chan struct{})
+	defer close(done)
+	lines := readSSELines(reader, cfg.MaxSSELineSize, done)
 
 	for {
+		var line string
+		var err error
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-heartbeat.C:
-			if err := sendHeartbeat(writer, w); err != nil {
+			comment := "keepalive"
+			if cfg.StreamProgress {
+				comment = formatProgressComment(time.Since(started), utils.CalculateTokens(state.completion.String()))
+			}
+			if err := sendHeartbeat(writer, w, comment); err != nil {
 				log.Printf("Heartbeat error: %v", err)
 			}
+			heartbeat.Reset(interval)
 			continue
-		default:
+		case next := <-lines:
+			line, err = next.line, next.err
 		}
 
-		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
 				log.Printf("Reached EOF after %d messages", messageCount)
-				return nil
+				return sendEstimatedFinish(ctx, writer, w, completionID, fingerprint, now, state, req)
+			}
+			// 单行过长说明上游数据畸形，通知客户端后结束，已发送的内容不受影响
+			if errors.Is(err, ErrSSELineTooLong) {
+				log.Printf("Upstream sent an oversized line after %d messages: %v", messageCount, err)
+				return sendStreamError(writer, w, &UpstreamStreamError{Type: "invalid_response", Message: err.Error()})
+			}
+			// 上游停滞时通知客户端，而不是直接断开连接
+			if errors.Is(err, ErrStreamIdleTimeout) {
+				log.Printf("Upstream stream stalled after %d messages", messageCount)
+				if state.completion.Len() > 0 && cfg.StreamTimeoutMode != StreamTimeoutError {
+					return sendTruncatedFinish(writer, w, completionID, fingerprint, now, state, req)
+				}
+				return sendStreamError(writer, w, &UpstreamStreamError{Type: "timeout", Message: err.Error()})
 			}
 			return fmt.Errorf("read error: %w", err)
 		}
 
 		log.Printf("Received line: %s", strings.TrimSpace(line))
 
-		// 检查缓冲区大小
-		totalBufferSize += len(line)
-		if totalBufferSize > maxBufferSize {
-			log.Printf("Buffer overflow: current size %d exceeds max size %d", totalBufferSize, maxBufferSize)
-			return fmt.Errorf("buffer overflow: exceeded maximum buffer size of %d bytes", maxBufferSize)
-		}
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data: "))
-		if jsonStr == "" || jsonStr == "end" {
-			continue
-		}
-
-		var sseData SSEData
-		if err := sonic.UnmarshalString(jsonStr, &sseData); err != nil {
+		if ok, err := parseSSELine(line, &sseData); errors.Is(err, errSSEDone) {
+			log.Printf("Received [DONE] after %d messages", messageCount)
+			return sendEstimatedFinish(ctx, writer, w, completionID, fingerprint, now, state, req)
+		} else if err != nil {
 			log.Printf("Error unmarshaling SSE data: %v", err)
 			continue
+		} else if !ok {
+			continue
 		}
 
 		log.Printf("Received SSE data: %+v", sseData)
 
+		// 内容已经开始发送后出现的上游错误无法重试，以错误事件通知客户端
+		if streamErr := parseStreamError(sseData, nil); streamErr != nil {
+			log.Printf("Upstream error mid-stream: %v", streamErr)
+			return sendStreamError(writer, w, streamErr)
+		}
+
 		messageCount++
+		recordQuotaUpdate(responseToken(ctx), sseData.Updated)
 
-		if err := processMessage(writer, w, sseData, chatId, fingerprint, now, &completionBuilder, req); err != nil {
+		sentBefore := counted.n
+		if err := processMessage(writer, w, sseData, completionID, fingerprint, now, state, req); err != nil {
 			log.Printf("Failed to process message: %v", err)
 			return err
 		}
+		// 保活心跳只在空闲时发送，向客户端发送数据后重新计时；进度注释仍按固定间隔发送
+		if !cfg.StreamProgress && counted.n != sentBefore {
+			heartbeat.Reset(interval)
+		}
 
 		// 定期刷新缓冲区
 		if messageCount >= flushThreshold {
@@ -205,35 +381,150 @@ func StreamJetbrainsAISSEToClient(ctx context.Context, req openai.ChatCompletion
 			if err := sendFinishSignal(writer, w); err != nil {
 				return fmt.Errorf("finish signal error: %w", err)
 			}
+			if state.skippedEmpty > 0 {
+				log.Printf("Skipped %d empty content events", state.skippedEmpty)
+			}
 			log.Printf("Stream completed successfully")
 			return nil
 		}
 	}
 }
 
+// sseLine 读取协程读到的一行数据或读取错误
+type sseLine struct {
+	line string
+	err  error
+}
+
+// readSSELines 在单独的协程中逐行读取上游数据，读到错误或done关闭后退出
+func readSSELines(reader *bufio.Reader, maxSize int, done <-chan struct{}) <-chan sseLine {
+	lines := make(chan sseLine)
+	go func() {
+		for {
+			line, err := readSSELine(reader, maxSize)
+			select {
+			case lines <- sseLine{line: line, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// countingWriter 统计实际写入的字节数，用于判断是否向客户端发送了数据
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamState 单次流式响应的累计状态
+type streamState struct {
+	completion    strings.Builder
+	finishReason  openai.FinishReason
+	filterResults openai.ContentFilterResults
+	costUpdates   bool // 花费数据到达时发送增量花费分片
+	// finishReasons 配置的上游结束原因映射
+	finishReasons map[string]string
+	// skipEmptyContent 不转发内容为空的Content事件，skippedEmpty记录跳过的次数
+	skipEmptyContent bool
+	skippedEmpty     int
+}
+
+// costUpdate 上游报告的花费
+type costUpdate struct {
+	Spent float64 `json:"spent"`
+}
+
+// costUpdateChunk 携带花费更新的流式分片，x_cost是非标准扩展字段，不认识的客户端会忽略它
+type costUpdateChunk struct {
+	openai.ChatCompletionStreamResponse
+	Cost costUpdate `json:"x_cost"`
+}
+
+// 用量响应头，流式响应中作为trailer发送
+const (
+	HeaderPromptTokens     = "X-Prompt-Tokens"
+	HeaderCompletionTokens = "X-Completion-Tokens"
+	HeaderTotalTokens      = "X-Total-Tokens"
+)
+
+// UsageTrailer 流式响应开始前设置到Trailer响应头的值
+const UsageTrailer = HeaderPromptTokens + ", " + HeaderCompletionTokens + ", " + HeaderTotalTokens
+
+// SetUsageHeaders 将token用量写入响应头
+func SetUsageHeaders(header http.Header, usage openai.Usage) {
+	header.Set(HeaderPromptTokens, strconv.Itoa(usage.PromptTokens))
+	header.Set(HeaderCompletionTokens, strconv.Itoa(usage.CompletionTokens))
+	header.Set(HeaderTotalTokens, strconv.Itoa(usage.TotalTokens))
+}
+
+// parseSpentAmount 解析上游报告的花费，缺失或无法解析时返回0
+func parseSpentAmount(spent *SpentData) float64 {
+	if spent == nil {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(spent.Amount, 64)
+	if err != nil {
+		log.Printf("Warning: failed to parse spent amount '%s': %v", spent.Amount, err)
+		return 0
+	}
+	return amount
+}
+
+// newStreamState 创建流式响应状态，默认结束原因为 stop
+func newStreamState() *streamState {
+	return &streamState{finishReason: openai.FinishReasonStop}
+}
+
 // processMessage 处理单个消息
-func processMessage(writer *bufio.Writer, w io.Writer, sseData SSEData, chatId, fingerprint string, now int64, completionBuilder *strings.Builder, req openai.ChatCompletionRequest) error {
+func processMessage(writer *bufio.Writer, w io.Writer, sseData SSEData, completionID, fingerprint string, now int64, state *streamState, req openai.ChatCompletionRequest) error {
+	if isContentFilterEvent(sseData) {
+		// 内容被过滤：记录过滤结果，在结束消息中报告 content_filter
+		log.Printf("Content filter event received: type=%s reason=%s", sseData.Type, sseData.Reason)
+		state.finishReason = openai.FinishReasonContentFilter
+		state.filterResults = buildContentFilterResults(sseData)
+		return nil
+	}
+
+	// 上游报告了结束原因（如长度限制）时，在结束消息中报告对应的OpenAI结束原因，内容过滤优先
+	if sseData.Reason != "" && state.finishReason != openai.FinishReasonContentFilter {
+		state.finishReason = mapFinishReason(sseData.Reason, state.finishReasons)
+	}
+
+	// 花费数据到达时立即通知客户端，而不只是在结束时报告
+	if state.costUpdates && sseData.Spent != nil {
+		chunk := costUpdateChunk{
+			ChatCompletionStreamResponse: createStreamMessage(completionID, now, req, fingerprint, "", ""),
+			Cost:                         costUpdate{Spent: parseSpentAmount(sseData.Spent)},
+		}
+		if err := sendMessage(writer, w, chunk); err != nil {
+			return err
+		}
+	}
+
 	switch sseData.Type {
 	case "Content":
-		completionBuilder.WriteString(sseData.Content)
-		sseMsg := createStreamMessage(chatId, now, req, fingerprint, sseData.Content, "")
+		if sseData.Content == "" && state.skipEmptyContent {
+			state.skippedEmpty++
+			return nil
+		}
+		state.completion.WriteString(sseData.Content)
+		sseMsg := createStreamMessage(completionID, now, req, fingerprint, sseData.Content, "")
 		return sendMessage(writer, w, sseMsg)
 
 	case "QuotaMetadata":
-		var spentAmount float64
-		if sseData.Spent != nil {
-			if amount, err := strconv.ParseFloat(sseData.Spent.Amount, 64); err == nil {
-				spentAmount = amount
-			} else {
-				log.Printf("Warning: failed to parse spent amount '%s': %v", sseData.Spent.Amount, err)
-			}
-		}
-
-		usage := utils.CalculateJetbrainsUsage(completionBuilder.String(), int(math.Round(spentAmount)))
-		sseMsg := createStreamMessage(chatId, now, req, fingerprint, "", "")
-		sseMsg.Choices[0].FinishReason = openai.FinishReasonStop
-		sseMsg.Usage = &usage
-		return sendMessage(writer, w, sseMsg)
+		usage := utils.CalculateJetbrainsUsage(state.completion.String(), int(math.Round(parseSpentAmount(sseData.Spent))))
+		return sendFinishChunk(writer, w, completionID, fingerprint, now, state, req, usage)
 
 	default:
 		// 忽略其他类型的消息
@@ -242,12 +533,60 @@ func processMessage(writer *bufio.Writer, w io.Writer, sseData SSEData, chatId,
 	}
 }
 
+// sendFinishChunk 发送带结束原因和用量的最后一个分片
+func sendFinishChunk(writer *bufio.Writer, w io.Writer, completionID, fingerprint string, now int64, state *streamState, req openai.ChatCompletionRequest, usage openai.Usage) error {
+	// 流式响应的用量以HTTP trailer形式补充在响应头中（需在开始响应前声明Trailer）
+	if rw, ok := w.(http.ResponseWriter); ok {
+		SetUsageHeaders(rw.Header(), usage)
+	}
+	sseMsg := createStreamMessage(completionID, now, req, fingerprint, "", "")
+	sseMsg.Choices[0].FinishReason = state.finishReason
+	sseMsg.Choices[0].ContentFilterResults = state.filterResults
+	sseMsg.Usage = &usage
+	return sendMessage(writer, w, sseMsg)
+}
+
+// sendEstimatedFinish 上游没有发送QuotaMetadata就正常结束时，按估算的用量补发结束分片和结束信号，
+// 与收到QuotaMetadata时的结束序列一致
+func sendEstimatedFinish(ctx context.Context, writer *bufio.Writer, w io.Writer, completionID, fingerprint string, now int64, state *streamState, req openai.ChatCompletionRequest) error {
+	content := state.completion.String()
+	var usage openai.Usage
+	if tokens, ok := promptTokens(ctx); ok {
+		usage = utils.EstimateCompletionUsage(tokens, content)
+	} else {
+		usage = utils.EstimateUsage(req.Messages, content)
+	}
+	log.Printf("Stream ended without QuotaMetadata, reporting estimated usage: %d prompt + %d completion tokens",
+		usage.PromptTokens, usage.CompletionTokens)
+
+	if err := sendFinishChunk(writer, w, completionID, fingerprint, now, state, req, usage); err != nil {
+		return err
+	}
+	return sendFinishSignal(writer, w)
+}
+
+// newCompletionID 生成补全ID：配置的前缀加随机后缀，同一请求的流式分片共用一个ID
+func newCompletionID() string {
+	cfg := config.GetGlobalConfig().GetConfig()
+	length := cfg.CompletionIDLength
+	if length <= 0 {
+		length = config.DefaultCompletionIDLength
+	}
+	return cfg.CompletionIDPrefix + utils.RandStringUsingMathRand(length)
+}
+
+// createRoleMessage 创建流式响应的第一个分片，只包含角色，之后的分片不再携带角色（与OpenAI一致）
+func createRoleMessage(completionID string, now int64, req openai.ChatCompletionRequest, fingerPrint string) openai.ChatCompletionStreamResponse {
+	sseMsg := createStreamMessage(completionID, now, req, fingerPrint, "", "")
+	sseMsg.Choices[0].Delta.Role = openai.ChatMessageRoleAssistant
+	return sseMsg
+}
+
 // createStreamMessage 创建流式消息
-func createStreamMessage(chatId string, now int64, req openai.ChatCompletionRequest, fingerPrint string, content string, reasoningContent string) openai.ChatCompletionStreamResponse {
+func createStreamMessage(completionID string, now int64, req openai.ChatCompletionRequest, fingerPrint string, content string, reasoningContent string) openai.ChatCompletionStreamResponse {
 	choice := openai.ChatCompletionStreamChoice{
 		Index: 0,
 		Delta: openai.ChatCompletionStreamChoiceDelta{
-			Role:             openai.ChatMessageRoleAssistant,
 			Content:          content,
 			ReasoningContent: reasoningContent,
 		},
@@ -256,7 +595,7 @@ func createStreamMessage(chatId string, now int64, req openai.ChatCompletionRequ
 	}
 
 	return openai.ChatCompletionStreamResponse{
-		ID:                "chatcmpl-" + chatId,
+		ID:                completionID,
 		Object:            sseObject,
 		Created:           now,
 		Model:             req.Model,
@@ -266,18 +605,18 @@ func createStreamMessage(chatId string, now int64, req openai.ChatCompletionRequ
 }
 
 // createMessage 创建非流式消息响应
-func createMessage(chatId string, now int64, req openai.ChatCompletionRequest, usage openai.Usage, content string, fp string) openai.ChatCompletionResponse {
+func createMessage(completionID string, now int64, req openai.ChatCompletionRequest, usage openai.Usage, content string, fp string, finishReason openai.FinishReason) openai.ChatCompletionResponse {
 	choice := openai.ChatCompletionChoice{
 		Index: 0,
 		Message: openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleAssistant,
 			Content: content,
 		},
-		FinishReason: openai.FinishReasonStop,
+		FinishReason: finishReason,
 	}
 
 	return openai.ChatCompletionResponse{
-		ID:                "chatcmpl-" + chatId,
+		ID:                completionID,
 		Object:            completionsObject,
 		Created:           now,
 		Model:             req.Model,
@@ -288,7 +627,7 @@ func createMessage(chatId string, now int64, req openai.ChatCompletionRequest, u
 }
 
 // sendMessage 发送消息到客户端
-func sendMessage(writer *bufio.Writer, w io.Writer, sseMsg openai.ChatCompletionStreamResponse) error {
+func sendMessage(writer *bufio.Writer, w io.Writer, sseMsg interface{}) error {
 	sendLine, err := sonic.MarshalString(sseMsg)
 	if err != nil {
 		return fmt.Errorf("marshal error: %w", err)
@@ -303,13 +642,56 @@ func sendMessage(writer *bufio.Writer, w io.Writer, sseMsg openai.ChatCompletion
 }
 
 // sendHeartbeat 发送心跳包
-func sendHeartbeat(writer *bufio.Writer, w io.Writer) error {
-	if _, err := writer.WriteString(": keepalive\n\n"); err != nil {
+func sendHeartbeat(writer *bufio.Writer, w io.Writer, comment string) error {
+	if _, err := writer.WriteString(": " + comment + "\n\n"); err != nil {
 		return fmt.Errorf("heartbeat write error: %w", err)
 	}
 	return flushWriter(writer, w)
 }
 
+// formatProgressComment 生成进度心跳注释内容
+func formatProgressComment(elapsed time.Duration, completionTokens int) string {
+	return fmt.Sprintf("progress elapsed=%ds completion_tokens=%d", int(elapsed.Seconds()), completionTokens)
+}
+
+// sendStreamError 向客户端发送错误事件并结束流
+func sendStreamError(writer *bufio.Writer, w io.Writer, streamErr *UpstreamStreamError) error {
+	payload, err := sonic.MarshalString(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": streamErr.Error(),
+			"type":    "upstream_error",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	if _, err := writer.WriteString(fmt.Sprintf("data: %s\n\n", payload)); err != nil {
+		return fmt.Errorf("write error event error: %w", err)
+	}
+	return sendFinishSignal(writer, w)
+}
+
+// sendTruncatedFinish 上游中途停滞时结束已发送部分内容的流：先以注释说明响应被截断，
+// 再发送 length 结束原因（内容过滤优先）和结束信号，客户端得到完整的结束序列
+func sendTruncatedFinish(writer *bufio.Writer, w io.Writer, completionID, fingerprint string, now int64, state *streamState, req openai.ChatCompletionRequest) error {
+	if _, err := writer.WriteString(": upstream idle timeout, response truncated\n\n"); err != nil {
+		return fmt.Errorf("write truncation note error: %w", err)
+	}
+
+	finishReason := openai.FinishReasonLength
+	if state.finishReason == openai.FinishReasonContentFilter {
+		finishReason = state.finishReason
+	}
+	sseMsg := createStreamMessage(completionID, now, req, fingerprint, "", "")
+	sseMsg.Choices[0].FinishReason = finishReason
+	sseMsg.Choices[0].ContentFilterResults = state.filterResults
+	if err := sendMessage(writer, w, sseMsg); err != nil {
+		return err
+	}
+	return sendFinishSignal(writer, w)
+}
+
 // sendFinishSignal 发送结束信号
 func sendFinishSignal(writer *bufio.Writer, w io.Writer) error {
 	finishMsg := fmt.Sprintf("data: %s\n\n", sseFinish)
@@ -319,6 +701,20 @@ func sendFinishSignal(writer *bufio.Writer, w io.Writer) error {
 	return flushWriter(writer, w)
 }
 
+// CanFlush 判断写入器能否将数据逐个刷新到客户端，包装的ResponseWriter按Unwrap逐层检查
+func CanFlush(w io.Writer) bool {
+	for {
+		if _, ok := w.(http.Flusher); !ok {
+			return false
+		}
+		wrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return true
+		}
+		w = wrapper.Unwrap()
+	}
+}
+
 // flushWriter 刷新写入器
 func flushWriter(writer *bufio.Writer, w io.Writer) error {
 	if err := writer.Flush(); err != nil {
@@ -0,0 +1,578 @@
+package jetbrains
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"jetbrains-ai-proxy/internal/config"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestApplyStopSequences(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		stops    []string
+		expected string
+		matched  bool
+	}{
+		{"no stops", "hello world", nil, "hello world", false},
+		{"no match", "hello world", []string{"xyz"}, "hello world", false},
+		{"single stop", "hello world", []string{" world"}, "hello", true},
+		{"earliest of multiple", "one two three", []string{"three", "two"}, "one ", true},
+		{"overlapping matches", "xabcd", []string{"bcd", "abc"}, "x", true},
+		{"stop is prefix of another", "foo END bar", []string{"END bar", "END"}, "foo ", true},
+		{"stop at start", "STOPhello", []string{"STOP"}, "", true},
+		{"empty stop ignored", "hello", []string{""}, "hello", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, matched := applyStopSequences(tc.content, tc.stops)
+			if got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+			if matched != tc.matched {
+				t.Errorf("Expected matched=%v, got %v", tc.matched, matched)
+			}
+		})
+	}
+}
+
+func TestResponseJetbrainsAIToClientStop(t *testing.T) {
+	// 停止序列跨越多个内容片段
+	stream := BuildMockSSEStream("Hello, ", "wor", "ld! Bye.")
+	req := openai.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Stop:  []string{"Bye", "ld!"},
+	}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(stream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := resp.Choices[0].Message.Content; got != "Hello, wor" {
+		t.Errorf("Expected truncated content %q, got %q", "Hello, wor", got)
+	}
+	if resp.Choices[0].FinishReason != openai.FinishReasonStop {
+		t.Errorf("Expected finish reason stop, got %s", resp.Choices[0].FinishReason)
+	}
+}
+
+func TestResponseJetbrainsAIToClientNoStop(t *testing.T) {
+	stream := BuildMockSSEStream("Hello, ", "world")
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(stream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := resp.Choices[0].Message.Content; got != "Hello, world" {
+		t.Errorf("Expected full content, got %q", got)
+	}
+}
+
+func TestStreamMidStreamErrorEvent(t *testing.T) {
+	stream := `data: {"type":"Content","content":"partial"}
+
+data: {"type":"Error","content":"internal failure"}
+
+data: {"type":"Content","content":"never sent"}
+
+`
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, `"type":"upstream_error"`) || !strings.Contains(output, "internal failure") {
+		t.Errorf("Expected error event in stream, got:\n%s", output)
+	}
+	if !strings.HasSuffix(output, "data: [DONE]\n\n") {
+		t.Errorf("Expected stream to end with [DONE], got:\n%s", output)
+	}
+	if strings.Contains(output, "never sent") {
+		t.Errorf("Expected stream to stop after the error event, got:\n%s", output)
+	}
+}
+
+func TestNonStreamingMidStreamErrorEvent(t *testing.T) {
+	stream := `data: {"type":"Content","content":"partial"}
+
+data: {"type":"Error","content":"internal failure"}
+
+`
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+
+	_, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(stream), "fp")
+	var streamErr *UpstreamStreamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("Expected UpstreamStreamError, got %v", err)
+	}
+}
+
+func TestNonStreamingEstimatesUsageWithoutQuotaMetadata(t *testing.T) {
+	stream := `data: {"type":"Content","content":"Hello there, how can I help?"}
+
+data: end
+
+`
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Say hello to me please"}},
+	}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(stream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	usage := resp.Usage
+	if usage.PromptTokens <= 0 || usage.CompletionTokens <= 0 {
+		t.Errorf("Expected nonzero estimated usage, got %+v", usage)
+	}
+	if usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+		t.Errorf("Expected total to equal prompt + completion, got %+v", usage)
+	}
+}
+
+func TestStreamFinishesWithoutQuotaMetadata(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Stream:   true,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Say hello to me please"}},
+	}
+
+	// 上游在EOF或 [DONE] 处结束，都没有发送QuotaMetadata
+	for name, stream := range map[string]string{
+		"eof":  `data: {"type":"Content","content":"Hello there"}` + "\n\ndata: end\n\n",
+		"done": `data: {"type":"Content","content":"Hello there"}` + "\n\ndata: [DONE]\n\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := StreamJetbrainsAISSEToClient(context.Background(), req, rec, strings.NewReader(stream), "fp"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			output := rec.Body.String()
+			if !strings.HasSuffix(output, "data: [DONE]\n\n") {
+				t.Fatalf("Expected stream to end with [DONE], got:\n%s", output)
+			}
+			events := strings.Split(strings.TrimSpace(output), "\n\n")
+			var chunk openai.ChatCompletionStreamResponse
+			if err := sonic.UnmarshalString(strings.TrimPrefix(events[len(events)-2], "data: "), &chunk); err != nil {
+				t.Fatalf("Failed to decode finish chunk: %v", err)
+			}
+			if chunk.Choices[0].FinishReason != openai.FinishReasonStop {
+				t.Errorf("Expected finish_reason stop, got %q", chunk.Choices[0].FinishReason)
+			}
+			if chunk.Usage == nil || chunk.Usage.PromptTokens <= 0 || chunk.Usage.CompletionTokens <= 0 {
+				t.Errorf("Expected estimated usage in the finish chunk, got %+v", chunk.Usage)
+			}
+			if rec.Header().Get(HeaderTotalTokens) == "" {
+				t.Error("Expected usage trailer to be set")
+			}
+		})
+	}
+}
+
+func TestStreamCostUpdates(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.StreamCostUpdates = true
+	})
+
+	// 上游在流的中途和结束时都可能带有花费数据
+	stream := `data: {"type":"Content","content":"Hello"}
+
+data: {"type":"QuotaUpdate","spent":{"amount":"3"}}
+
+data: {"type":"Content","content":" world"}
+
+data: {"type":"QuotaUpdate","spent":{"amount":"7.5"}}
+
+data: {"type":"QuotaMetadata","spent":{"amount":"12"}}
+
+`
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	matches := regexp.MustCompile(`"x_cost":\{"spent":([0-9.]+)\}`).FindAllStringSubmatch(out.String(), -1)
+	expected := []string{"3", "7.5", "12"}
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d cost updates, got %d:\n%s", len(expected), len(matches), out.String())
+	}
+	for i, match := range matches {
+		if match[1] != expected[i] {
+			t.Errorf("Cost update %d: expected %s, got %s", i, expected[i], match[1])
+		}
+	}
+
+	// 花费分片不影响正常内容
+	if !strings.Contains(out.String(), `"content":" world"`) || !strings.HasSuffix(out.String(), "data: [DONE]\n\n") {
+		t.Errorf("Expected content and [DONE] to be unaffected, got:\n%s", out.String())
+	}
+}
+
+func TestStreamCostUpdatesDisabledByDefault(t *testing.T) {
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	stream := BuildMockSSEStream("Hello")
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strings.Contains(out.String(), "x_cost") {
+		t.Errorf("Expected no cost updates by default, got:\n%s", out.String())
+	}
+}
+
+func TestStreamRoleOnlyInFirstChunk(t *testing.T) {
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(BuildMockSSEStream("Hello", " world")), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chunks := parseStreamChunks(t, out.String())
+	if len(chunks) != 4 {
+		t.Fatalf("Expected role, 2 content and finish chunks, got %d:\n%s", len(chunks), out.String())
+	}
+
+	// 第一个分片只有角色，没有内容
+	first := chunks[0].Choices[0].Delta
+	if first.Role != openai.ChatMessageRoleAssistant || first.Content != "" {
+		t.Errorf("Expected role-only first chunk, got %+v", first)
+	}
+	for i, chunk := range chunks[1:] {
+		if role := chunk.Choices[0].Delta.Role; role != "" {
+			t.Errorf("Expected chunk %d to omit the role, got %q", i+1, role)
+		}
+	}
+	if !strings.Contains(out.String(), `"delta":{"content":"Hello"}`) {
+		t.Errorf("Expected later deltas to carry only content, got:\n%s", out.String())
+	}
+}
+
+// emptyContentStream 上游流中夹杂内容为空的Content事件
+const emptyContentStream = `data: {"type":"Content","content":""}
+
+data: {"type":"Content","content":"Hello"}
+
+data: {"type":"Content","content":""}
+
+data: {"type":"Content","content":" world"}
+
+data: {"type":"QuotaMetadata","spent":{"amount":"1"}}
+
+`
+
+func TestStreamSkipsEmptyContent(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.SkipEmptyContent = true
+	})
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(emptyContentStream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 角色分片、两个非空内容分片和结束分片
+	chunks := parseStreamChunks(t, out.String())
+	if len(chunks) != 4 {
+		t.Fatalf("Expected 4 chunks with empty content skipped, got %d:\n%s", len(chunks), out.String())
+	}
+	if chunks[0].Choices[0].Delta.Role != openai.ChatMessageRoleAssistant {
+		t.Errorf("Expected role chunk first, got %+v", chunks[0].Choices[0].Delta)
+	}
+	for i, chunk := range chunks[1:3] {
+		if chunk.Choices[0].Delta.Content == "" {
+			t.Errorf("Expected content chunk %d to be non-empty", i+1)
+		}
+	}
+	if chunks[3].Choices[0].FinishReason != openai.FinishReasonStop {
+		t.Errorf("Expected finish chunk last, got %+v", chunks[3].Choices[0])
+	}
+}
+
+func TestStreamForwardsEmptyContentByDefault(t *testing.T) {
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(emptyContentStream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if chunks := parseStreamChunks(t, out.String()); len(chunks) != 6 {
+		t.Errorf("Expected empty content to be forwarded by default, got %d chunks:\n%s", len(chunks), out.String())
+	}
+}
+
+// representativeSSEStream 包含内容、配额、注释、空行、结束标记和畸形数据的上游流
+func representativeSSEStream() []string {
+	stream := BuildMockSSEStream("Hello", ", ", "", "world", " with \"quotes\" and \\n escapes") +
+		": keepalive\n" +
+		"event: message\n" +
+		"data: \n" +
+		"data: [DONE]\n" +
+		"data: {not json}\n" +
+		"data:{\"type\":\"Content\",\"content\":\"no space\"}\n" +
+		"data: {\"type\":\"Content\",\"content\":\"crlf\"}\r\n" +
+		`data: {"type":"QuotaMetadata","updated":{"license":"l","current":{"amount":"1"},"maximum":{"amount":"2"},"until":5,"quotaID":{"quotaId":"q"}}}` + "\n"
+	return strings.SplitAfter(stream, "\n")
+}
+
+// parseSSELineReference 优化前的逐行解析方式，作为语义对照
+func parseSSELineReference(line string) (SSEData, bool, error) {
+	if !strings.HasPrefix(line, "data: ") {
+		return SSEData{}, false, nil
+	}
+	jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+	if jsonStr == "" || jsonStr == sseFinish || jsonStr == "end" {
+		return SSEData{}, false, nil
+	}
+	var sseData SSEData
+	if err := sonic.UnmarshalString(jsonStr, &sseData); err != nil {
+		return SSEData{}, false, err
+	}
+	return sseData, true, nil
+}
+
+func TestParseSSELineMatchesReference(t *testing.T) {
+	// 复用同一个结构体，确保上一行的字段不会残留到下一行
+	var data SSEData
+	parsed := 0
+	for _, line := range representativeSSEStream() {
+		expected, expectedOK, expectedErr := parseSSELineReference(line)
+		ok, err := parseSSELine(line, &data)
+		// 优化前的方式跳过 [DONE]，现在它作为结束标记返回errSSEDone
+		if errors.Is(err, errSSEDone) {
+			err = nil
+		}
+
+		if (err != nil) != (expectedErr != nil) || ok != expectedOK {
+			t.Fatalf("Line %q: expected ok=%v err=%v, got ok=%v err=%v", line, expectedOK, expectedErr, ok, err)
+		}
+		if !ok {
+			continue
+		}
+		parsed++
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("Line %q: expected %+v, got %+v", line, expected, data)
+		}
+	}
+	if parsed != 8 {
+		t.Errorf("Expected 8 parsed events, got %d", parsed)
+	}
+}
+
+func BenchmarkParseSSELine(b *testing.B) {
+	lines := representativeSSEStream()
+
+	b.Run("reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				_, _, _ = parseSSELineReference(line)
+			}
+		}
+	})
+
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var data SSEData
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				_, _ = parseSSELine(line, &data)
+			}
+		}
+	})
+}
+
+func TestReadSSELine(t *testing.T) {
+	// 读缓冲区小于单行长度时按片段拼接
+	long := strings.Repeat("x", 100)
+	reader := bufio.NewReaderSize(strings.NewReader("data: "+long+"\nshort\ntail"), 16)
+
+	if line, err := readSSELine(reader, 200); err != nil || line != "data: "+long+"\n" {
+		t.Fatalf("Expected long line, got %q, %v", line, err)
+	}
+	if line, err := readSSELine(reader, 200); err != nil || line != "short\n" {
+		t.Fatalf("Expected short line, got %q, %v", line, err)
+	}
+	if line, err := readSSELine(reader, 200); err != io.EOF || line != "tail" {
+		t.Fatalf("Expected partial line with EOF, got %q, %v", line, err)
+	}
+
+	reader = bufio.NewReaderSize(strings.NewReader(long+"\n"), 16)
+	if _, err := readSSELine(reader, 50); !errors.Is(err, ErrSSELineTooLong) {
+		t.Errorf("Expected ErrSSELineTooLong, got %v", err)
+	}
+}
+
+func TestStreamLargeTotalOutputCompletes(t *testing.T) {
+	// 总输出超过单行限制的数倍，每行都在限制之内
+	chunk := strings.Repeat("a", 8*1024)
+	chunks := make([]string, 200)
+	for i := range chunks {
+		chunks[i] = chunk
+	}
+	stream := BuildMockSSEStream(chunks...)
+	if len(stream) <= 1024*1024 {
+		t.Fatalf("Expected test stream larger than 1MB, got %d bytes", len(stream))
+	}
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := out.String()
+	if strings.Contains(output, "upstream_error") || !strings.HasSuffix(output, "data: [DONE]\n\n") {
+		t.Errorf("Expected large stream to complete normally, got %d bytes ending with %q", len(output), output[len(output)-40:])
+	}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4o"}, strings.NewReader(stream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := len(resp.Choices[0].Message.Content); got != len(chunk)*len(chunks) {
+		t.Errorf("Expected %d bytes of content, got %d", len(chunk)*len(chunks), got)
+	}
+}
+
+func TestStreamRejectsOversizedLine(t *testing.T) {
+	withStreamConfig(t, func(cfg *config.Config) {
+		cfg.MaxSSELineSize = 64 * 1024
+	})
+	stream := `data: {"type":"Content","content":"before"}` + "\n\n" +
+		"data: " + strings.Repeat("z", 256*1024) + "\n\n" +
+		`data: {"type":"Content","content":"never sent"}` + "\n\n"
+
+	var out bytes.Buffer
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true}
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(stream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := out.String()
+	if !strings.Contains(output, "before") || !strings.Contains(output, `"type":"upstream_error"`) {
+		t.Errorf("Expected content followed by an error event, got:\n%.300s", output)
+	}
+	if strings.Contains(output, "never sent") || !strings.HasSuffix(output, "data: [DONE]\n\n") {
+		t.Errorf("Expected stream to end after the oversized line, got:\n%.300s", output)
+	}
+
+	_, err := ResponseJetbrainsAIToClient(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4o"}, strings.NewReader(stream), "fp")
+	if !errors.Is(err, ErrSSELineTooLong) {
+		t.Errorf("Expected ErrSSELineTooLong for non-streaming response, got %v", err)
+	}
+}
+
+// unwrappingWriter 像中间件一样包装ResponseWriter并总是声明支持Flush
+type unwrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (w unwrappingWriter) Flush() {}
+
+func (w unwrappingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// plainResponseWriter 不支持刷新的ResponseWriter
+type plainResponseWriter struct {
+	http.ResponseWriter
+}
+
+func TestCanFlush(t *testing.T) {
+	if !CanFlush(httptest.NewRecorder()) {
+		t.Error("Expected recorder to be flushable")
+	}
+	if CanFlush(&bytes.Buffer{}) {
+		t.Error("Expected buffer not to be flushable")
+	}
+	// 包装层的Flush只是转发，需要检查底层writer
+	if !CanFlush(unwrappingWriter{httptest.NewRecorder()}) {
+		t.Error("Expected wrapped recorder to be flushable")
+	}
+	if CanFlush(unwrappingWriter{plainResponseWriter{httptest.NewRecorder()}}) {
+		t.Error("Expected wrapper around a non-flushing writer not to be flushable")
+	}
+}
+
+// captureLog 捕获测试期间的日志输出
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	original := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(original)
+	})
+	return &buf
+}
+
+// sentinelStream 包含空data行、end和[DONE]，[DONE]之后的内容不应被处理
+const sentinelStream = `data: {"type":"Content","content":"Hello"}
+
+data: 
+
+data: end
+
+data: {"type":"Content","content":" world"}
+
+data: [DONE]
+
+data: {"type":"Content","content":" after done"}
+
+`
+
+func TestSSESentinelsInBothPaths(t *testing.T) {
+	buf := captureLog(t)
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+
+	resp, err := ResponseJetbrainsAIToClient(context.Background(), req, strings.NewReader(sentinelStream), "fp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := resp.Choices[0].Message.Content; got != "Hello world" {
+		t.Errorf("Non-stream: expected content up to [DONE], got %q", got)
+	}
+
+	var out bytes.Buffer
+	req.Stream = true
+	if err := StreamJetbrainsAISSEToClient(context.Background(), req, &out, strings.NewReader(sentinelStream), "fp"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var content strings.Builder
+	for _, chunk := range parseStreamChunks(t, out.String()) {
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if content.String() != "Hello world" {
+		t.Errorf("Stream: expected content up to [DONE], got %q", content.String())
+	}
+
+	// 结束标记不会被当作JSON解析
+	if strings.Contains(buf.String(), "解析SSE数据错误") || strings.Contains(buf.String(), "Error unmarshaling") {
+		t.Errorf("Expected sentinels to be skipped without parse errors, got log:\n%s", buf.String())
+	}
+}
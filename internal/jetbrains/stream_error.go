@@ -0,0 +1,96 @@
+package jetbrains
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"io"
+	"strings"
+)
+
+// maxPeekLines 检测上游错误事件时最多预读的行数
+const maxPeekLines = 64
+
+// streamErrorEventTypes JetBrains 在SSE流中返回错误时使用的事件类型
+var streamErrorEventTypes = map[string]bool{
+	"Error":         true,
+	"error":         true,
+	"ErrorMetadata": true,
+}
+
+// UpstreamStreamError 上游以200状态返回、但在SSE流中携带的错误事件
+type UpstreamStreamError struct {
+	Type      string
+	Message   string
+	Retryable bool
+}
+
+func (e *UpstreamStreamError) Error() string {
+	return fmt.Sprintf("upstream stream error (%s): %s", e.Type, e.Message)
+}
+
+// parseStreamError 识别SSE错误事件，按配置的模式判断是否可以换token重试
+func parseStreamError(sseData SSEData, retryablePatterns []string) *UpstreamStreamError {
+	if !streamErrorEventTypes[sseData.Type] {
+		return nil
+	}
+
+	message := strings.TrimSpace(strings.Join([]string{sseData.Reason, sseData.Content}, " "))
+	lowered := strings.ToLower(message)
+
+	retryable := false
+	for _, pattern := range retryablePatterns {
+		if pattern != "" && strings.Contains(lowered, strings.ToLower(pattern)) {
+			retryable = true
+			break
+		}
+	}
+
+	return &UpstreamStreamError{Type: sseData.Type, Message: message, Retryable: retryable}
+}
+
+// peekStreamError 预读流的开头直到第一个内容事件，若先遇到错误事件则返回该错误；
+// 返回的 ReadCloser 会重放已预读的数据
+func peekStreamError(body io.ReadCloser, retryablePatterns []string) (io.ReadCloser, *UpstreamStreamError, error) {
+	reader := bufio.NewReader(body)
+	var consumed bytes.Buffer
+
+	for i := 0; i < maxPeekLines; i++ {
+		line, err := reader.ReadString('\n')
+		consumed.WriteString(line)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, err
+		}
+
+		if !strings.HasPrefix(line, sseDataPrefix) {
+			continue
+		}
+		jsonStr := strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix))
+		if jsonStr == sseFinish {
+			break
+		}
+		if isSSESentinel(jsonStr) {
+			continue
+		}
+
+		var sseData SSEData
+		if err := sonic.UnmarshalString(jsonStr, &sseData); err != nil {
+			continue
+		}
+		if streamErr := parseStreamError(sseData, retryablePatterns); streamErr != nil {
+			return nil, streamErr, nil
+		}
+		if sseData.Type == "Content" || sseData.Type == "QuotaMetadata" {
+			break
+		}
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(consumed.Bytes()), reader), body}, nil, nil
+}
@@ -0,0 +1,48 @@
+package jetbrains
+
+import (
+	"context"
+	"github.com/go-resty/resty/v2"
+	"jetbrains-ai-proxy/internal/utils"
+	"net/http"
+)
+
+// UpstreamClient JetBrains AI 上游客户端接口
+type UpstreamClient interface {
+	// Post 发送请求并返回未解析的流式响应，调用方负责关闭响应体
+	Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error)
+}
+
+var upstreamClient UpstreamClient = NewRestyUpstreamClient(utils.RestySSEClient)
+
+// SetUpstreamClient 替换上游客户端，返回之前使用的客户端
+func SetUpstreamClient(client UpstreamClient) UpstreamClient {
+	prev := upstreamClient
+	upstreamClient = client
+	return prev
+}
+
+// restyUpstreamClient 基于 resty 的默认上游客户端
+type restyUpstreamClient struct {
+	client *resty.Client
+}
+
+// NewRestyUpstreamClient 使用 resty 客户端创建上游客户端
+func NewRestyUpstreamClient(client *resty.Client) UpstreamClient {
+	return &restyUpstreamClient{client: client}
+}
+
+// Post 发送请求，保留原始响应体以便流式读取
+func (c *restyUpstreamClient) Post(ctx context.Context, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetHeaders(headers).
+		SetDoNotParseResponse(true).
+		SetBody(body).
+		Post(url)
+
+	if resp == nil {
+		return nil, err
+	}
+	return resp.RawResponse, err
+}
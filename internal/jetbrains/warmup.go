@@ -0,0 +1,78 @@
+package jetbrains
+
+import (
+	"context"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmUpTimeout 单个预热请求的超时时间，各token并发预热，也是整个预热的最长等待时间
+const warmUpTimeout = 15 * time.Second
+
+// WarmUpConnections 为每个token并发发送一次最小请求，预先建立上游连接并确认认证有效。
+// 预热失败只记录日志，不影响启动，返回成功的token数量
+func WarmUpConnections(ctx context.Context, tokens []string) int {
+	var succeeded int64
+	var wg sync.WaitGroup
+	for _, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if warmUpToken(ctx, token) {
+				atomic.AddInt64(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	log.Printf("Connection warm-up completed: %d/%d tokens ready", succeeded, len(tokens))
+	return int(succeeded)
+}
+
+// warmUpToken 使用指定token发送预热请求
+func warmUpToken(ctx context.Context, token string) bool {
+	ctx, cancel := context.WithTimeout(ctx, warmUpTimeout)
+	defer cancel()
+
+	cfg := config.GetGlobalConfig().GetConfig()
+	req := &types.JetbrainsRequest{
+		Prompt:  cfg.PromptFor("gpt-4o"),
+		Profile: "openai-gpt-4o",
+		Chat: types.ChatField{
+			MessageField: []types.MessageField{{Type: "user_message", Content: "ping"}},
+		},
+	}
+
+	// 与正式请求一样附带token专属的请求头
+	jwtBalancer := getBalancer()
+	headers := map[string]string{}
+	if jwtBalancer != nil {
+		headers = tokenHeaders(jwtBalancer, token)
+	}
+	headers[types.JwtTokenKey] = token
+
+	resp, err := postToEndpoints(ctx, upstreamURLs(cfg), headers, req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusOK:
+		return true
+	case resp != nil && resp.StatusCode == http.StatusUnauthorized:
+		// 认证失败的token直接移出轮换
+		log.Printf("Warm-up: JWT token invalid (401): %s...", token[:min(len(token), 10)])
+		if jwtBalancer != nil {
+			jwtBalancer.MarkTokenUnhealthy(token)
+		}
+	case resp != nil:
+		log.Printf("Warm-up request for token %s... returned status %d", token[:min(len(token), 10)], resp.StatusCode)
+	default:
+		log.Printf("Warm-up request for token %s... failed: %v", token[:min(len(token), 10)], err)
+	}
+	return false
+}
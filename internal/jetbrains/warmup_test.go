@@ -0,0 +1,99 @@
+package jetbrains
+
+import (
+	"context"
+	"errors"
+	"jetbrains-ai-proxy/internal/balancer"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWarmUpConnectionsIssuesRequestPerToken(t *testing.T) {
+	mock := NewScriptedMockUpstreamClient(http.StatusOK, BuildMockSSEStream("pong"))
+	withTokens(t, mock, "token-a", "token-b")
+
+	if ready := WarmUpConnections(context.Background(), []string{"token-a", "token-b"}); ready != 2 {
+		t.Errorf("Expected 2 tokens ready, got %d", ready)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 warm-up requests, got %d", len(requests))
+	}
+	// 并发预热，请求顺序不固定
+	used := map[string]bool{}
+	for _, request := range requests {
+		used[request.Headers[types.JwtTokenKey]] = true
+	}
+	if !used["token-a"] || !used["token-b"] {
+		t.Errorf("Expected one warm-up request per token, got %v", used)
+	}
+}
+
+func TestWarmUpConnectionsRunsConcurrently(t *testing.T) {
+	// 上游在收到两个请求之前不响应，顺序预热会一直等到超时
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	mock := NewMockUpstreamClient(func(*types.JetbrainsRequest) (int, string) {
+		arrived <- struct{}{}
+		<-release
+		return http.StatusOK, BuildMockSSEStream("pong")
+	})
+	withTokens(t, mock, "token-a", "token-b")
+
+	done := make(chan int)
+	go func() {
+		done <- WarmUpConnections(context.Background(), []string{"token-a", "token-b"})
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(time.Second):
+			t.Fatal("Expected warm-up requests to be sent concurrently")
+		}
+	}
+	close(release)
+	if ready := <-done; ready != 2 {
+		t.Errorf("Expected 2 tokens ready, got %d", ready)
+	}
+}
+
+func TestWarmUpSendsTokenMetadataHeaders(t *testing.T) {
+	mock := NewScriptedMockUpstreamClient(http.StatusOK, BuildMockSSEStream("pong"))
+	SetBalancer(balancer.NewJWTBalancerWithStrategy([]config.JWTTokenConfig{
+		{Token: "token-a", Metadata: map[string]string{"header.X-Client-Id": "client-a"}},
+	}, balancer.NewSelectionStrategy(config.RoundRobin)))
+	prev := SetUpstreamClient(mock)
+	t.Cleanup(func() { SetUpstreamClient(prev) })
+
+	WarmUpConnections(context.Background(), []string{"token-a"})
+	if headers := mock.Requests()[0].Headers; headers["X-Client-Id"] != "client-a" || headers[types.JwtTokenKey] != "token-a" {
+		t.Errorf("Expected warm-up to send token metadata headers, got %v", headers)
+	}
+}
+
+func TestWarmUpConnectionsIsNonFatal(t *testing.T) {
+	fake := &fakeUpstreamClient{err: errors.New("connection refused")}
+	b := withTokens(t, fake, "token-a")
+
+	// 连接失败不影响token状态，交由健康检查判断
+	if ready := WarmUpConnections(context.Background(), []string{"token-a"}); ready != 0 {
+		t.Errorf("Expected no ready tokens, got %d", ready)
+	}
+	if b.GetHealthyTokenCount() != 1 {
+		t.Errorf("Expected token to stay in rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
+
+func TestWarmUpMarksUnauthorizedToken(t *testing.T) {
+	fake := &fakeUpstreamClient{statusCode: http.StatusUnauthorized}
+	b := withTokens(t, fake, "token-a")
+
+	WarmUpConnections(context.Background(), []string{"token-a"})
+	if b.GetHealthyTokenCount() != 0 {
+		t.Errorf("Expected unauthorized token to leave rotation, got %d healthy", b.GetHealthyTokenCount())
+	}
+}
@@ -15,14 +15,15 @@ func BearerAuth() echo.MiddlewareFunc {
 			auth := c.Request().Header.Get("Authorization")
 
 			if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
-				return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header")
+				return echo.NewHTTPError(http.StatusUnauthorized,
+					Localizer(c).Message("invalid_authorization_header", "invalid authorization header"))
 			}
 
 			token := strings.TrimPrefix(auth, "Bearer ")
-                        cfg := config.GetGlobalConfig().GetConfig()
-			if token != cfg.BearerToken || token == "" {
+			// 轮换Bearer token后，旧token在宽限期内仍被接受
+			if !config.GetGlobalConfig().BearerTokenValid(token) {
 				log.Printf("invalid token: %s", token)
-				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+				return echo.NewHTTPError(http.StatusUnauthorized, Localizer(c).Message("invalid_token", "invalid token"))
 			}
 
 			return next(c)
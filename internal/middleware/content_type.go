@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// 请求Content-Type的检查方式
+const (
+	ContentTypeCheckLenient = "lenient" // 默认：拒绝非JSON类型，允许缺失Content-Type
+	ContentTypeCheckStrict  = "strict"  // 要求必须声明JSON类型
+	ContentTypeCheckOff     = "off"     // 不检查
+)
+
+// JSONContentType 检查请求体声明为JSON，否则返回OpenAI格式的415错误
+func JSONContentType() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			mode := config.GetGlobalConfig().GetConfig().ContentTypeCheck
+			if mode == ContentTypeCheckOff {
+				return next(c)
+			}
+
+			contentType := c.Request().Header.Get(echo.HeaderContentType)
+			if contentType == "" && mode != ContentTypeCheckStrict {
+				return next(c)
+			}
+			if isJSONContentType(contentType) {
+				return next(c)
+			}
+
+			message := fmt.Sprintf("Unsupported Content-Type %q, expected application/json", contentType)
+			if contentType == "" {
+				message = "Missing Content-Type, expected application/json"
+			}
+			return c.JSON(http.StatusUnsupportedMediaType, types.NewOpenAIErrorResponse(types.ErrorTypeInvalidRequest,
+				"unsupported_media_type", message))
+		}
+	}
+}
+
+// isJSONContentType 判断是否为JSON类型，忽略大小写和charset等参数，也接受 +json 后缀的类型
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == echo.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withContentTypeCheck 临时设置Content-Type检查方式，测试结束后恢复
+func withContentTypeCheck(t *testing.T, mode string) {
+	t.Helper()
+
+	original := config.GetGlobalConfig().GetConfig().ContentTypeCheck
+	config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+		cfg.ContentTypeCheck = mode
+	})
+	t.Cleanup(func() {
+		config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+			cfg.ContentTypeCheck = original
+		})
+	})
+}
+
+func serveWithContentType(contentType string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.POST("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, JSONContentType())
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"model":"gpt-4o"}`))
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJSONContentTypeAccepted(t *testing.T) {
+	for _, contentType := range []string{
+		"application/json",
+		"application/json; charset=utf-8",
+		"Application/JSON;charset=UTF-8",
+		"application/vnd.api+json",
+	} {
+		if rec := serveWithContentType(contentType); rec.Code != http.StatusOK {
+			t.Errorf("Expected %q to be accepted, got %d", contentType, rec.Code)
+		}
+	}
+}
+
+func TestWrongContentTypeRejected(t *testing.T) {
+	for _, contentType := range []string{
+		"application/x-www-form-urlencoded",
+		"multipart/form-data; boundary=xyz",
+		"text/plain",
+		"not a media type;;",
+	} {
+		rec := serveWithContentType(contentType)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415 for %q, got %d", contentType, rec.Code)
+			continue
+		}
+
+		var resp types.OpenAIErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Type != types.ErrorTypeInvalidRequest {
+			t.Errorf("Expected OpenAI error envelope for %q, got %s", contentType, rec.Body.String())
+		}
+	}
+}
+
+func TestMissingContentType(t *testing.T) {
+	// 默认宽松模式允许缺失Content-Type
+	if rec := serveWithContentType(""); rec.Code != http.StatusOK {
+		t.Errorf("Expected missing Content-Type to be accepted by default, got %d", rec.Code)
+	}
+
+	withContentTypeCheck(t, ContentTypeCheckStrict)
+	rec := serveWithContentType("")
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "Missing Content-Type") {
+		t.Errorf("Expected 415 for missing Content-Type in strict mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestContentTypeCheckOff(t *testing.T) {
+	withContentTypeCheck(t, ContentTypeCheckOff)
+
+	if rec := serveWithContentType("text/plain"); rec.Code != http.StatusOK {
+		t.Errorf("Expected no check when disabled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+)
+
+// DisabledEndpoints 对配置中禁用的端点返回404，按路由模式匹配（如 /v1/models、/admin/tokens/:name/reset）
+func DisabledEndpoints() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, path := range config.GetGlobalConfig().GetConfig().DisabledEndpoints {
+				if path == c.Path() {
+					return echo.ErrNotFound
+				}
+			}
+			return next(c)
+		}
+	}
+}
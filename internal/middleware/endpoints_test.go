@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledEndpointsMatchesRoutePattern(t *testing.T) {
+	original := config.GetGlobalConfig().GetConfig().DisabledEndpoints
+	config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+		cfg.DisabledEndpoints = []string{"/items/:id"}
+	})
+	t.Cleanup(func() {
+		config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+			cfg.DisabledEndpoints = original
+		})
+	})
+
+	e := echo.New()
+	e.Use(DisabledEndpoints())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/items/:id", ok)
+	e.GET("/items", ok)
+
+	for path, expected := range map[string]int{
+		"/items/42": http.StatusNotFound,
+		"/items":    http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, path, rec.Code)
+		}
+	}
+}
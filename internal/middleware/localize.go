@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+)
+
+// Localizer 按请求的Accept-Language和配置的error_language选择错误信息的语言
+func Localizer(c echo.Context) types.Localizer {
+	cfg := config.GetGlobalConfig().GetConfig()
+	return types.NewLocalizer(c.Request().Header.Get("Accept-Language"), cfg.ErrorLanguage, cfg.ErrorMessages)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// headerPatterns 缓存已编译的header正则
+var headerPatterns sync.Map
+
+// RequiredHeaders 检查配置中要求的header，不满足时返回403（未配置时不做检查）
+func RequiredHeaders() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			matchers := config.GetGlobalConfig().GetConfig().RequiredHeaders
+			for _, matcher := range matchers {
+				if !matchHeader(c.Request().Header, matcher) {
+					log.Printf("request rejected: required header %s not satisfied", matcher.Name)
+					return echo.NewHTTPError(http.StatusForbidden, "required header missing or invalid")
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// matchHeader 判断请求header是否满足匹配规则
+func matchHeader(header http.Header, matcher config.HeaderMatcher) bool {
+	values, ok := header[http.CanonicalHeaderKey(matcher.Name)]
+	if !ok {
+		return false
+	}
+
+	for _, value := range values {
+		switch {
+		case matcher.Value != "":
+			if value == matcher.Value {
+				return true
+			}
+		case matcher.Pattern != "":
+			pattern := compileHeaderPattern(matcher.Pattern)
+			if pattern != nil && pattern.MatchString(value) {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// compileHeaderPattern 编译并缓存正则，无效的正则返回nil（视为不匹配）
+func compileHeaderPattern(pattern string) *regexp.Regexp {
+	if cached, ok := headerPatterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("invalid required header pattern %q: %v", pattern, err)
+		return nil
+	}
+	headerPatterns.Store(pattern, compiled)
+	return compiled
+}
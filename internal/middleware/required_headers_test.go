@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withRequiredHeaders 临时设置必需header规则，测试结束后恢复
+func withRequiredHeaders(t *testing.T, matchers []config.HeaderMatcher) {
+	t.Helper()
+
+	original := config.GetGlobalConfig().GetConfig().RequiredHeaders
+	config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+		cfg.RequiredHeaders = matchers
+	})
+	t.Cleanup(func() {
+		config.GetGlobalConfig().UpdateConfig(func(cfg *config.Config) {
+			cfg.RequiredHeaders = original
+		})
+	})
+}
+
+func serveWithRequiredHeaders(headers map[string]string) int {
+	e := echo.New()
+	e.Use(RequiredHeaders())
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRequiredHeadersDisabledByDefault(t *testing.T) {
+	withRequiredHeaders(t, nil)
+
+	if code := serveWithRequiredHeaders(nil); code != http.StatusOK {
+		t.Errorf("Expected 200 without required headers, got %d", code)
+	}
+}
+
+func TestRequiredHeadersMatching(t *testing.T) {
+	withRequiredHeaders(t, []config.HeaderMatcher{
+		{Name: "X-Proxy-Secret", Value: "s3cret"},
+		{Name: "User-Agent", Pattern: `^my-client/\d+`},
+		{Name: "X-Tenant"},
+	})
+
+	valid := map[string]string{
+		"X-Proxy-Secret": "s3cret",
+		"User-Agent":     "my-client/2.1",
+		"x-tenant":       "team-a",
+	}
+	if code := serveWithRequiredHeaders(valid); code != http.StatusOK {
+		t.Errorf("Expected 200 with all required headers, got %d", code)
+	}
+
+	cases := map[string]map[string]string{
+		"wrong secret":      {"X-Proxy-Secret": "guess", "User-Agent": "my-client/2.1", "X-Tenant": "a"},
+		"user agent":        {"X-Proxy-Secret": "s3cret", "User-Agent": "curl/8.0", "X-Tenant": "a"},
+		"missing header":    {"X-Proxy-Secret": "s3cret", "User-Agent": "my-client/2.1"},
+		"no headers at all": {},
+	}
+	for name, headers := range cases {
+		if code := serveWithRequiredHeaders(headers); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", name, code)
+		}
+	}
+}
+
+func TestRequiredHeadersInvalidPattern(t *testing.T) {
+	withRequiredHeaders(t, []config.HeaderMatcher{{Name: "User-Agent", Pattern: "("}})
+
+	// 无效的正则视为不匹配，而不是放行
+	if code := serveWithRequiredHeaders(map[string]string{"User-Agent": "("}); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for invalid pattern, got %d", code)
+	}
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo"
+	"jetbrains-ai-proxy/internal/config"
+	"jetbrains-ai-proxy/internal/types"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestTimeoutHeader 客户端为单个请求指定超时时间的请求头
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// SkipRequestTimeoutKey 前置中间件在echo.Context中将其设置为true时，请求不受总处理时间限制
+const SkipRequestTimeoutKey = "skip_request_timeout"
+
+// RequestTimeout 限制请求的最长处理时间，超时后取消上游请求；尚未开始响应时返回504
+func RequestTimeout() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip, _ := c.Get(SkipRequestTimeoutKey).(bool); skip {
+				return next(c)
+			}
+			timeout, err := requestTimeout(c.Request().Header.Get(RequestTimeoutHeader), config.GetGlobalConfig().GetConfig())
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, types.NewOpenAIErrorResponse(types.ErrorTypeInvalidRequest,
+					"invalid_request_timeout", err.Error()))
+			}
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			original := c.Response().Writer
+			tw := &timeoutWriter{ResponseWriter: original, header: make(http.Header), ctx: ctx}
+			c.Response().Writer = tw
+			defer func() {
+				c.Response().Writer = original
+			}()
+
+			done := make(chan error, 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						done <- fmt.Errorf("panic: %v", r)
+					}
+				}()
+				done <- next(c)
+			}()
+
+			finished := false
+			select {
+			case err = <-done:
+				finished = true
+			case <-ctx.Done():
+			}
+
+			// 处理函数可能先于本中间件感知到超时并返回，此时它的写入已被丢弃，仍需返回504
+			if ctx.Err() != context.DeadlineExceeded || !tw.timeout() {
+				if !finished {
+					err = <-done
+				}
+				return err
+			}
+
+			log.Printf("Request %s %s timed out after %v", c.Request().Method, c.Request().URL.Path, timeout)
+			writeTimeoutResponse(original, timeout, Localizer(c))
+
+			// 等待处理函数随上下文取消退出，之后才能安全地复用echo.Context
+			if !finished {
+				<-done
+			}
+			return nil
+		}
+	}
+}
+
+// requestTimeout 计算单个请求的超时时间：请求头优先于默认配置，且不超过配置的上限
+func requestTimeout(value string, cfg *config.Config) (time.Duration, error) {
+	if value == "" {
+		return cfg.RequestTimeout, nil
+	}
+
+	timeout, err := parseRequestTimeout(value)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.MaxRequestTimeout > 0 && timeout > cfg.MaxRequestTimeout {
+		timeout = cfg.MaxRequestTimeout
+	}
+	return timeout, nil
+}
+
+// parseRequestTimeout 解析超时请求头，支持秒数（如10、1.5）或时长（如30s、2m）
+func parseRequestTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("invalid %s header: %q", RequestTimeoutHeader, value)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s header: %q must be positive", RequestTimeoutHeader, value)
+	}
+	return timeout, nil
+}
+
+// writeTimeoutResponse 写入OpenAI格式的504错误
+func writeTimeoutResponse(w http.ResponseWriter, timeout time.Duration, localizer types.Localizer) {
+	body, _ := json.Marshal(localizer.ErrorResponse(types.ErrorTypeTimeout, "request_timeout",
+		"Request timed out after %v", timeout))
+	w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(body)
+}
+
+// timeoutWriter 超时后丢弃处理函数的写入；响应头在开始写入时才复制到底层writer，避免与超时响应竞争
+type timeoutWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	ctx         context.Context
+	mutex       sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+// Header 开始响应后返回底层writer的header，使处理函数可以设置trailer
+func (w *timeoutWriter) Header() http.Header {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.wroteHeader {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writeHeaderLocked(code)
+}
+
+func (w *timeoutWriter) writeHeaderLocked(code int) {
+	if w.expiredLocked() || w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	dst := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		dst[key] = values
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.writeHeaderLocked(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.expiredLocked() {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 返回底层writer，供http.ResponseController等检查底层能力
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// expiredLocked 已超时且尚未开始响应时丢弃写入，留给中间件写入504
+func (w *timeoutWriter) expiredLocked() bool {
+	if !w.wroteHeader && w.ctx.Err() == context.DeadlineExceeded {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// timeout 标记超时，返回是否还可以写入超时响应（处理函数尚未开始响应）
+func (w *timeoutWriter) timeout() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.wroteHeader {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
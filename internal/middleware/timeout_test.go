@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"jetbrains-ai-proxy/internal/config"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutFromHeader(t *testing.T) {
+	cfg := &config.Config{RequestTimeout: 5 * time.Minute, MaxRequestTimeout: 10 * time.Minute}
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		// 未指定时使用默认配置
+		{"", 5 * time.Minute},
+		{"10", 10 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"250ms", 250 * time.Millisecond},
+		{"8m", 8 * time.Minute},
+		// 超过上限时按上限处理
+		{"1h", 10 * time.Minute},
+		{"3600", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		timeout, err := requestTimeout(tt.value, cfg)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.value, err)
+			continue
+		}
+		if timeout != tt.expected {
+			t.Errorf("For %q expected %v, got %v", tt.value, tt.expected, timeout)
+		}
+	}
+}
+
+func TestRequestTimeoutHeaderWithoutMax(t *testing.T) {
+	cfg := &config.Config{RequestTimeout: 5 * time.Minute}
+
+	timeout, err := requestTimeout("1h", cfg)
+	if err != nil || timeout != time.Hour {
+		t.Errorf("Expected 1h without a configured maximum, got %v (%v)", timeout, err)
+	}
+}
+
+func TestRequestTimeoutInvalidHeader(t *testing.T) {
+	cfg := &config.Config{RequestTimeout: 5 * time.Minute, MaxRequestTimeout: 10 * time.Minute}
+
+	for _, value := range []string{"soon", "0", "-5", "-1s"} {
+		if _, err := requestTimeout(value, cfg); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
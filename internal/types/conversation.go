@@ -0,0 +1,98 @@
+package types
+
+import (
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"jetbrains-ai-proxy/internal/utils"
+)
+
+// 对话超限时的处理方式
+const (
+	ConversationLimitReject   = "reject"
+	ConversationLimitTruncate = "truncate"
+)
+
+// CountPromptTokens 统计所有消息内容的token数
+func CountPromptTokens(messages []openai.ChatCompletionMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += utils.CalculateTokens(msg.Content)
+	}
+	return total
+}
+
+// PromptTooLongError prompt的token数超出限制
+type PromptTooLongError struct {
+	Tokens int // 实际统计到的token数
+	Limit  int
+}
+
+func (e *PromptTooLongError) Error() string {
+	return fmt.Sprintf("prompt too long: %d tokens exceeds the limit of %d", e.Tokens, e.Limit)
+}
+
+// CheckConversationLimits 检查消息数量和prompt token数是否超出限制（0表示不限制）
+func CheckConversationLimits(messages []openai.ChatCompletionMessage, maxMessages, maxPromptTokens int) error {
+	if maxMessages > 0 && len(messages) > maxMessages {
+		return fmt.Errorf("too many messages: %d exceeds the limit of %d", len(messages), maxMessages)
+	}
+	_, err := CheckPromptTokens(messages, maxPromptTokens)
+	return err
+}
+
+// CheckPromptTokens 统计prompt token数并检查是否超出限制，返回的token数可供后续估算用量复用；
+// 限制为0时不统计，返回-1
+func CheckPromptTokens(messages []openai.ChatCompletionMessage, maxPromptTokens int) (int, error) {
+	if maxPromptTokens <= 0 {
+		return -1, nil
+	}
+	tokens := CountPromptTokens(messages)
+	if tokens > maxPromptTokens {
+		return tokens, &PromptTooLongError{Tokens: tokens, Limit: maxPromptTokens}
+	}
+	return tokens, nil
+}
+
+// TruncateConversation 从最早的非系统消息开始丢弃，直到满足限制；系统消息和最后一条非系统消息始终保留
+func TruncateConversation(messages []openai.ChatCompletionMessage, maxMessages, maxPromptTokens int) []openai.ChatCompletionMessage {
+	tokenCounts := make([]int, len(messages))
+	totalTokens := 0
+	for i, msg := range messages {
+		tokenCounts[i] = utils.CalculateTokens(msg.Content)
+		totalTokens += tokenCounts[i]
+	}
+
+	lastNonSystem := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != openai.ChatMessageRoleSystem {
+			lastNonSystem = i
+			break
+		}
+	}
+
+	exceeded := func(count, tokens int) bool {
+		return (maxMessages > 0 && count > maxMessages) || (maxPromptTokens > 0 && tokens > maxPromptTokens)
+	}
+
+	dropped := make([]bool, len(messages))
+	count := len(messages)
+	for i, msg := range messages {
+		if !exceeded(count, totalTokens) {
+			break
+		}
+		if msg.Role == openai.ChatMessageRoleSystem || i == lastNonSystem {
+			continue
+		}
+		dropped[i] = true
+		count--
+		totalTokens -= tokenCounts[i]
+	}
+
+	result := make([]openai.ChatCompletionMessage, 0, count)
+	for i, msg := range messages {
+		if !dropped[i] {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+	"testing"
+)
+
+func testConversation() []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are helpful."},
+		{Role: openai.ChatMessageRoleUser, Content: "first question"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "first answer"},
+		{Role: openai.ChatMessageRoleSystem, Content: "Stay concise."},
+		{Role: openai.ChatMessageRoleUser, Content: "second question"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "second answer"},
+		{Role: openai.ChatMessageRoleUser, Content: "third question"},
+	}
+}
+
+func TestCheckConversationLimits(t *testing.T) {
+	messages := testConversation()
+
+	if err := CheckConversationLimits(messages, 0, 0); err != nil {
+		t.Errorf("Expected no error without limits, got %v", err)
+	}
+	if err := CheckConversationLimits(messages, 7, 0); err != nil {
+		t.Errorf("Expected no error at the limit, got %v", err)
+	}
+
+	err := CheckConversationLimits(messages, 5, 0)
+	if err == nil || !strings.Contains(err.Error(), "too many messages") {
+		t.Errorf("Expected too many messages error, got %v", err)
+	}
+
+	err = CheckConversationLimits(messages, 0, 3)
+	if err == nil || !strings.Contains(err.Error(), "prompt too long") {
+		t.Errorf("Expected prompt too long error, got %v", err)
+	}
+}
+
+func TestCheckPromptTokens(t *testing.T) {
+	messages := testConversation()
+	total := CountPromptTokens(messages)
+
+	if tokens, err := CheckPromptTokens(messages, 0); err != nil || tokens != -1 {
+		t.Errorf("Expected no counting without a limit, got %d, %v", tokens, err)
+	}
+	if tokens, err := CheckPromptTokens(messages, total); err != nil || tokens != total {
+		t.Errorf("Expected %d tokens at the limit, got %d, %v", total, tokens, err)
+	}
+
+	tokens, err := CheckPromptTokens(messages, total-1)
+	var tooLong *PromptTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Tokens != total || tooLong.Limit != total-1 || tokens != total {
+		t.Errorf("Expected PromptTooLongError with %d tokens, got %d, %v", total, tokens, err)
+	}
+}
+
+func TestTruncateConversationByMessageCount(t *testing.T) {
+	result := TruncateConversation(testConversation(), 4, 0)
+
+	if len(result) != 4 {
+		t.Fatalf("Expected 4 messages, got %d: %+v", len(result), result)
+	}
+
+	// 两条系统消息保留，保留最近的非系统消息
+	expected := []string{"You are helpful.", "Stay concise.", "second answer", "third question"}
+	for i, content := range expected {
+		if result[i].Content != content {
+			t.Errorf("At index %d expected %q, got %q", i, content, result[i].Content)
+		}
+	}
+}
+
+func TestTruncateConversationByTokens(t *testing.T) {
+	messages := testConversation()
+	limit := CountPromptTokens(messages) - CountPromptTokens(messages[1:3])
+
+	result := TruncateConversation(messages, 0, limit)
+	if CountPromptTokens(result) > limit {
+		t.Errorf("Expected at most %d tokens, got %d", limit, CountPromptTokens(result))
+	}
+	if result[0].Role != openai.ChatMessageRoleSystem || result[len(result)-1].Content != "third question" {
+		t.Errorf("Expected system message and latest question to be kept, got %+v", result)
+	}
+	for _, msg := range result {
+		if msg.Content == "first question" {
+			t.Errorf("Expected oldest message to be dropped, got %+v", result)
+		}
+	}
+}
+
+func TestTruncateConversationKeepsSystemAndLatest(t *testing.T) {
+	// 限制无法满足时也要保留系统消息和最后一条消息
+	result := TruncateConversation(testConversation(), 1, 0)
+
+	if len(result) != 3 {
+		t.Fatalf("Expected 2 system messages and the latest message, got %+v", result)
+	}
+	if result[2].Content != "third question" {
+		t.Errorf("Expected latest message to be kept, got %q", result[2].Content)
+	}
+}
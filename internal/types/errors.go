@@ -0,0 +1,39 @@
+package types
+
+// OpenAI兼容的错误类型
+const (
+	ErrorTypeInvalidRequest = "invalid_request_error"
+	ErrorTypeAuthentication = "authentication_error"
+	ErrorTypeServer         = "server_error"
+	ErrorTypeTimeout        = "timeout_error"
+	ErrorTypeRateLimit      = "rate_limit_error"
+)
+
+// OpenAIError OpenAI兼容的错误信息
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAIErrorResponse OpenAI兼容的错误响应体
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// NewOpenAIErrorResponse 创建OpenAI兼容的错误响应体，code为空时输出null
+func NewOpenAIErrorResponse(errType, code, message string) OpenAIErrorResponse {
+	resp := OpenAIErrorResponse{Error: OpenAIError{Message: message, Type: errType}}
+	if code != "" {
+		resp.Error.Code = &code
+	}
+	return resp
+}
+
+// NewOpenAIParamErrorResponse 创建指明出错参数的OpenAI兼容错误响应体
+func NewOpenAIParamErrorResponse(errType, code, param, message string) OpenAIErrorResponse {
+	resp := NewOpenAIErrorResponse(errType, code, message)
+	resp.Error.Param = &param
+	return resp
+}
@@ -2,8 +2,14 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 )
 
 const (
@@ -12,12 +18,15 @@ const (
 	JwtTokenKey  = "grazie-authenticate-jwt"
 )
 
+// reasoningDefaults 推理模型自带的默认参数，与OpenAI省略reasoning_effort时的行为一致
+var reasoningDefaults = ModelDefaults{ReasoningEffort: "medium"}
+
 var modelMap = map[string]OpenAIModel{
 	"gpt-4o":      {Object: "model", OwnedBy: "openai", Profile: "openai-gpt-4o"},
-	"o1":          {Object: "model", OwnedBy: "openai", Profile: "openai-o1"},
-	"o3":          {Object: "model", OwnedBy: "openai", Profile: "openai-o3"},
-	"o3-mini":     {Object: "model", OwnedBy: "openai", Profile: "openai-o3-mini"},
-	"o4-mini":     {Object: "model", OwnedBy: "openai", Profile: "openai-o4-mini"},
+	"o1":          {Object: "model", OwnedBy: "openai", Profile: "openai-o1", Defaults: reasoningDefaults},
+	"o3":          {Object: "model", OwnedBy: "openai", Profile: "openai-o3", Defaults: reasoningDefaults},
+	"o3-mini":     {Object: "model", OwnedBy: "openai", Profile: "openai-o3-mini", Defaults: reasoningDefaults},
+	"o4-mini":     {Object: "model", OwnedBy: "openai", Profile: "openai-o4-mini", Defaults: reasoningDefaults},
 	"gpt4.1":      {Object: "model", OwnedBy: "openai", Profile: "openai-gpt4.1"},
 	"gpt4.1-mini": {Object: "model", OwnedBy: "openai", Profile: "openai-gpt4.1-mini"},
 	"gpt4.1-nano": {Object: "model", OwnedBy: "openai", Profile: "openai-gpt4.1-nano"},
@@ -32,11 +41,66 @@ var modelMap = map[string]OpenAIModel{
 	"claude-4-sonnet":   {Object: "model", OwnedBy: "anthropic", Profile: "anthropic-claude-4-sonnet"},
 }
 
+// builtinModelAliases 内置的常见模型别名
+var builtinModelAliases = map[string]string{
+	"gpt-4.1":      "gpt4.1",
+	"gpt-4.1-mini": "gpt4.1-mini",
+	"gpt-4.1-nano": "gpt4.1-nano",
+}
+
+// reasoningModels 支持reasoning_effort参数的推理模型
+var reasoningModels = map[string]bool{
+	"o1":      true,
+	"o3":      true,
+	"o3-mini": true,
+	"o4-mini": true,
+}
+
+// reasoningEffortLevels 允许的reasoning_effort取值
+var reasoningEffortLevels = map[string]bool{
+	"low":    true,
+	"medium": true,
+	"high":   true,
+}
+
 type OpenAIModel struct {
 	ID      string `json:"id"`
 	Object  string `json:"object"`
 	OwnedBy string `json:"owned_by"`
 	Profile string `json:"profile"`
+	// Defaults 客户端省略参数时使用的模型默认值，不出现在模型列表中
+	Defaults ModelDefaults `json:"-"`
+}
+
+// ModelDefaults 模型的默认请求参数；目前上游只接受reasoning_effort
+type ModelDefaults struct {
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+}
+
+var (
+	// modelDefaultOverrides 配置中按模型设置的默认参数，优先于模型映射中的默认值
+	modelDefaultOverrides map[string]ModelDefaults
+	modelDefaultsMutex    sync.RWMutex
+)
+
+// SetModelDefaults 设置按模型配置的默认参数，未设置的字段沿用模型映射中的默认值
+func SetModelDefaults(defaults map[string]ModelDefaults) {
+	modelDefaultsMutex.Lock()
+	defer modelDefaultsMutex.Unlock()
+	modelDefaultOverrides = defaults
+}
+
+// modelDefaults 返回模型的默认参数：配置优先，其次为模型映射中的默认值
+func modelDefaults(model OpenAIModel, name string) ModelDefaults {
+	defaults := model.Defaults
+	modelDefaultsMutex.RLock()
+	override := modelDefaultOverrides[name]
+	modelDefaultsMutex.RUnlock()
+
+	if override.ReasoningEffort != "" {
+		defaults.ReasoningEffort = override.ReasoningEffort
+	}
+	return defaults
 }
 
 type OpenAIModelList struct {
@@ -50,17 +114,66 @@ type MessageField struct {
 }
 
 type JetbrainsRequest struct {
-	Prompt  string    `json:"prompt"`
-	Profile string    `json:"profile"`
-	Chat    ChatField `json:"chat"`
+	Prompt          string    `json:"prompt"`
+	Profile         string    `json:"profile"`
+	Chat            ChatField `json:"chat"`
+	ReasoningEffort string    `json:"reasoning_effort,omitempty"`
+
+	// ExtraFields 客户端通过extra_body传入的额外字段，序列化时按ExtraBodyMode合并到请求中
+	ExtraFields   map[string]json.RawMessage `json:"-"`
+	ExtraBodyMode string                     `json:"-"`
+}
+
+// extra_body的合并方式
+const (
+	ExtraBodyOff   = "off"   // 忽略extra_body（默认）
+	ExtraBodyFirst = "first" // 先合并额外字段，代理转换的字段优先
+	ExtraBodyLast  = "last"  // 最后合并额外字段，可以覆盖reasoning_effort等可选字段
+)
+
+// requiredJetbrainsFields 无论合并方式如何都不能被extra_body覆盖的字段
+var requiredJetbrainsFields = map[string]bool{
+	"prompt":  true,
+	"profile": true,
+	"chat":    true,
+}
+
+// MarshalJSON 序列化请求并合并extra_body中的额外字段；prompt、profile和chat始终以代理转换的结果为准
+func (r JetbrainsRequest) MarshalJSON() ([]byte, error) {
+	type plain JetbrainsRequest
+	data, err := json.Marshal(plain(r))
+	if err != nil || len(r.ExtraFields) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range r.ExtraFields {
+		if requiredJetbrainsFields[name] {
+			continue
+		}
+		if _, exists := fields[name]; exists && r.ExtraBodyMode != ExtraBodyLast {
+			continue
+		}
+		fields[name] = value
+	}
+	return json.Marshal(fields)
 }
 
 type ChatField struct {
 	MessageField []MessageField `json:"messages"`
 }
 
-func ChatGPTToJetbrainsAI(chatReq openai.ChatCompletionRequest) (*JetbrainsRequest, error) {
-	messageFields, err := convertOpenAIMessagesToJetbrains(chatReq.Messages)
+// penaltyMode 为设置了frequency_penalty或presence_penalty时的处理方式（PenaltyIgnore或PenaltyReject）；
+// chatReq.Model在systemAsUserModels中时，系统消息合并到第一条用户消息中；paramFilter限制可转发给上游的参数
+func ChatGPTToJetbrainsAI(chatReq openai.ChatCompletionRequest, penaltyMode string, systemAsUserModels []string, paramFilter ParamFilter) (*JetbrainsRequest, error) {
+	if err := checkPenalties(chatReq, penaltyMode); err != nil {
+		return nil, err
+	}
+
+	messageFields, err := convertOpenAIMessagesToJetbrains(chatReq.Messages, chatReq.Model, systemAsUserModels)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert messages: %w", err)
 	}
@@ -77,6 +190,20 @@ func ChatGPTToJetbrainsAI(chatReq openai.ChatCompletionRequest) (*JetbrainsReque
 			MessageField: messageFields,
 		},
 	}
+	// 仅推理模型转发reasoning_effort，其他模型忽略该参数；客户端省略时使用模型的默认值（不允许转发时直接忽略）
+	if SupportsReasoningEffort(chatReq.Model) {
+		if chatReq.ReasoningEffort != "" {
+			forward, err := paramFilter.permit("reasoning_effort")
+			if err != nil {
+				return nil, err
+			}
+			if forward {
+				mReq.ReasoningEffort = chatReq.ReasoningEffort
+			}
+		} else if effort := modelDefaults(openaiModel, chatReq.Model).ReasoningEffort; effort != "" && paramFilter.Allows("reasoning_effort") {
+			mReq.ReasoningEffort = effort
+		}
+	}
 	if jsonData, err := json.MarshalIndent(mReq, "", "  "); err == nil {
 		fmt.Printf("mReq JSON: %s\n", string(jsonData))
 	}
@@ -84,7 +211,14 @@ func ChatGPTToJetbrainsAI(chatReq openai.ChatCompletionRequest) (*JetbrainsReque
 	return mReq, nil
 }
 
-func convertOpenAIMessagesToJetbrains(openaiMessages []openai.ChatCompletionMessage) ([]MessageField, error) {
+func convertOpenAIMessagesToJetbrains(openaiMessages []openai.ChatCompletionMessage, model string, systemAsUserModels []string) ([]MessageField, error) {
+	for _, name := range systemAsUserModels {
+		if name == model {
+			openaiMessages = mergeSystemIntoFirstUser(openaiMessages)
+			break
+		}
+	}
+
 	var messageField []MessageField
 
 	for _, msg := range openaiMessages {
@@ -108,6 +242,77 @@ func convertOpenAIMessagesToJetbrains(openaiMessages []openai.ChatCompletionMess
 	return messageField, nil
 }
 
+// mergeSystemIntoFirstUser 将所有系统消息合并后作为前缀放入第一条用户消息，用于不支持系统角色的模型；
+// 没有用户消息时系统内容作为一条用户消息发送
+func mergeSystemIntoFirstUser(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	var system []string
+	rest := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		rest = append(rest, msg)
+	}
+	if len(system) == 0 {
+		return messages
+	}
+
+	prefix := strings.Join(system, "\n\n")
+	for i, msg := range rest {
+		if msg.Role == openai.ChatMessageRoleUser {
+			rest[i].Content = prefix + "\n\n" + msg.Content
+			return rest
+		}
+	}
+	return append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prefix}}, rest...)
+}
+
+// 请求包含多条系统消息时的处理方式
+const (
+	SystemMessagesConcat      = "concat"      // 合并为一条系统消息（默认）
+	SystemMessagesFirst       = "first"       // 只保留第一条
+	SystemMessagesLast        = "last"        // 只保留最后一条
+	SystemMessagesPassthrough = "passthrough" // 原样分别转发
+)
+
+// ApplySystemMessageMode 按mode处理多条系统消息，结果放在第一条系统消息的位置；
+// mode为空时合并，只有一条系统消息时原样返回，不修改调用方的消息
+func ApplySystemMessageMode(messages []openai.ChatCompletionMessage, mode string) []openai.ChatCompletionMessage {
+	var system []int
+	for i, msg := range messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, i)
+		}
+	}
+	if len(system) < 2 || mode == SystemMessagesPassthrough {
+		return messages
+	}
+
+	kept := messages[system[0]]
+	switch mode {
+	case SystemMessagesFirst:
+	case SystemMessagesLast:
+		kept = messages[system[len(system)-1]]
+	default:
+		contents := make([]string, len(system))
+		for i, index := range system {
+			contents[i] = messages[index].Content
+		}
+		kept.Content = strings.Join(contents, "\n\n")
+	}
+
+	result := make([]openai.ChatCompletionMessage, 0, len(messages)-len(system)+1)
+	for i, msg := range messages {
+		if msg.Role != openai.ChatMessageRoleSystem {
+			result = append(result, msg)
+		} else if i == system[0] {
+			result = append(result, kept)
+		}
+	}
+	return result
+}
+
 func GetModelByName(modelName string) (OpenAIModel, error) {
 	model, exists := modelMap[modelName]
 	if !exists {
@@ -116,13 +321,164 @@ func GetModelByName(modelName string) (OpenAIModel, error) {
 	return model, nil
 }
 
+// ResolveModelName 将请求的模型名称解析为实际使用的模型：精确匹配、别名映射、大小写和空白规范化
+func ResolveModelName(modelName string, aliases map[string]string) (string, error) {
+	if _, exists := modelMap[modelName]; exists {
+		return modelName, nil
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(modelName))
+	for _, key := range []string{modelName, normalized} {
+		if target, ok := aliases[key]; ok {
+			if _, exists := modelMap[target]; exists {
+				return target, nil
+			}
+			return "", fmt.Errorf("model alias '%s' points to unknown model '%s'", modelName, target)
+		}
+		if target, ok := builtinModelAliases[key]; ok {
+			return target, nil
+		}
+	}
+
+	if _, exists := modelMap[normalized]; exists {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("model '%s' not found", modelName)
+}
+
+// SupportsReasoningEffort 判断模型是否支持reasoning_effort参数
+func SupportsReasoningEffort(modelName string) bool {
+	return reasoningModels[modelName]
+}
+
+// ValidateReasoningEffort 校验reasoning_effort取值，空值表示未设置
+func ValidateReasoningEffort(effort string) error {
+	if effort == "" || reasoningEffortLevels[effort] {
+		return nil
+	}
+	return fmt.Errorf("invalid reasoning_effort '%s', must be one of low, medium, high", effort)
+}
+
+// 请求logprobs时的处理方式
+const (
+	LogprobsReject = "reject"
+	LogprobsIgnore = "ignore"
+)
+
+// RequestsLogprobs 请求是否要求返回token对数概率
+func RequestsLogprobs(req openai.ChatCompletionRequest) bool {
+	return req.LogProbs || req.TopLogProbs > 0
+}
+
+// CheckLogprobs JetBrains AI不返回token对数概率，请求logprobs时默认拒绝；ignore模式下忽略该参数
+func CheckLogprobs(req openai.ChatCompletionRequest, mode string) error {
+	if !RequestsLogprobs(req) || mode == LogprobsIgnore {
+		return nil
+	}
+	return fmt.Errorf("logprobs and top_logprobs are not supported by this proxy")
+}
+
+// 设置了frequency_penalty或presence_penalty时的处理方式
+const (
+	PenaltyIgnore = "ignore"
+	PenaltyReject = "reject"
+)
+
+// ErrUnsupportedParameter 请求设置了上游不支持的参数
+var ErrUnsupportedParameter = errors.New("unsupported parameter")
+
+// checkPenalties JetBrains AI不接受frequency_penalty和presence_penalty：reject模式下拒绝请求，
+// 默认忽略并记录日志，避免客户端不知道参数没有生效
+func checkPenalties(req openai.ChatCompletionRequest, mode string) error {
+	var params []string
+	if req.FrequencyPenalty != 0 {
+		params = append(params, "frequency_penalty")
+	}
+	if req.PresencePenalty != 0 {
+		params = append(params, "presence_penalty")
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	if mode == PenaltyReject {
+		return fmt.Errorf("%w: %s not supported by this proxy", ErrUnsupportedParameter, strings.Join(params, " and "))
+	}
+	log.Printf("Debug: ignoring %s, not supported by JetBrains AI", strings.Join(params, " and "))
+	return nil
+}
+
+// 设置了不允许转发的参数时的处理方式
+const (
+	ParamFilterStrip  = "strip"
+	ParamFilterReject = "reject"
+)
+
+// ParamFilter 可转发给上游的模型参数：Allow非空时只转发其中的参数，Deny中的参数始终不转发；
+// Mode为ParamFilterReject时拒绝请求，默认剔除参数并记录日志
+type ParamFilter struct {
+	Allow []string
+	Deny  []string
+	Mode  string
+}
+
+// Allows 参数是否允许转发
+func (f ParamFilter) Allows(name string) bool {
+	if slices.Contains(f.Deny, name) {
+		return false
+	}
+	return len(f.Allow) == 0 || slices.Contains(f.Allow, name)
+}
+
+// permit 检查请求设置的参数能否转发：不允许时reject模式返回错误，默认剔除
+func (f ParamFilter) permit(name string) (bool, error) {
+	if f.Allows(name) {
+		return true, nil
+	}
+	if f.Mode == ParamFilterReject {
+		return false, fmt.Errorf("%w: %s is not allowed by this proxy", ErrUnsupportedParameter, name)
+	}
+	log.Printf("Debug: stripping %s, not allowed by forward_params/deny_params", name)
+	return false, nil
+}
+
+// FilterExtraFields 按同样的规则过滤extra_body中的字段，避免绕过限制；不修改传入的map
+func (f ParamFilter) FilterExtraFields(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var filtered map[string]json.RawMessage
+	for name, value := range fields {
+		forward, err := f.permit(name)
+		if err != nil {
+			return nil, err
+		}
+		if forward {
+			if filtered == nil {
+				filtered = make(map[string]json.RawMessage, len(fields))
+			}
+			filtered[name] = value
+		}
+	}
+	return filtered, nil
+}
+
 func GetSupportedModels() OpenAIModelList {
-	var modelSlice []OpenAIModel
+	return GetSupportedModelsByOwner("")
+}
+
+// GetSupportedModelsByOwner 返回指定提供方（owned_by，不区分大小写）的模型列表，owner为空时返回全部模型；
+// 结果按ID排序，没有匹配时返回空列表
+func GetSupportedModelsByOwner(owner string) OpenAIModelList {
+	modelSlice := make([]OpenAIModel, 0, len(modelMap))
 	for id, model := range modelMap {
+		if owner != "" && !strings.EqualFold(model.OwnedBy, owner) {
+			continue
+		}
 		modelWithID := model
 		modelWithID.ID = id
 		modelSlice = append(modelSlice, modelWithID)
 	}
+	sort.Slice(modelSlice, func(i, j int) bool {
+		return modelSlice[i].ID < modelSlice[j].ID
+	})
 
 	return OpenAIModelList{
 		Object: "list",
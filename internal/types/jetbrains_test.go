@@ -0,0 +1,392 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveModelName(t *testing.T) {
+	aliases := map[string]string{
+		"o1-fallback": "gpt-4o",
+		"broken":      "does-not-exist",
+	}
+
+	cases := []struct {
+		name      string
+		requested string
+		expected  string
+		wantErr   bool
+	}{
+		{"exact match", "o1", "o1", false},
+		{"configured alias", "o1-fallback", "gpt-4o", false},
+		{"builtin alias", "gpt-4.1", "gpt4.1", false},
+		{"case and whitespace normalization", "  Claude-4-Sonnet ", "claude-4-sonnet", false},
+		{"alias to unknown model", "broken", "", true},
+		{"unknown model", "gpt-5", "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ResolveModelName(tc.requested, aliases)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %q", tc.requested, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestReasoningEffortPassthrough(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}}
+
+	// 推理模型转发reasoning_effort
+	req, err := ChatGPTToJetbrainsAI(openai.ChatCompletionRequest{Model: "o3-mini", ReasoningEffort: "high", Messages: messages}, PenaltyIgnore, nil, ParamFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.ReasoningEffort != "high" {
+		t.Errorf("Expected reasoning effort high, got %q", req.ReasoningEffort)
+	}
+
+	// 非推理模型忽略该参数
+	req, err = ChatGPTToJetbrainsAI(openai.ChatCompletionRequest{Model: "gpt-4o", ReasoningEffort: "high", Messages: messages}, PenaltyIgnore, nil, ParamFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.ReasoningEffort != "" {
+		t.Errorf("Expected reasoning effort to be omitted, got %q", req.ReasoningEffort)
+	}
+	data, _ := json.Marshal(req)
+	if strings.Contains(string(data), "reasoning_effort") {
+		t.Errorf("Expected reasoning_effort to be absent from JSON, got %s", data)
+	}
+}
+
+func TestModelDefaults(t *testing.T) {
+	t.Cleanup(func() {
+		SetModelDefaults(nil)
+	})
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}}
+	convert := func(model, effort string, filter ParamFilter) *JetbrainsRequest {
+		t.Helper()
+		req, err := ChatGPTToJetbrainsAI(openai.ChatCompletionRequest{Model: model, ReasoningEffort: effort, Messages: messages}, PenaltyIgnore, nil, filter)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return req
+	}
+
+	// 模型映射中自带的默认值：推理模型省略时使用medium，其他模型没有默认值
+	for _, model := range []string{"o1", "o3", "o3-mini", "o4-mini"} {
+		if got := convert(model, "", ParamFilter{}).ReasoningEffort; got != "medium" {
+			t.Errorf("Expected model default for %s when omitted, got %q", model, got)
+		}
+	}
+	if got := convert("gpt-4o", "", ParamFilter{}).ReasoningEffort; got != "" {
+		t.Errorf("Expected no default for non-reasoning models, got %q", got)
+	}
+	if got := convert("o3", "low", ParamFilter{}).ReasoningEffort; got != "low" {
+		t.Errorf("Expected client value to win over the default, got %q", got)
+	}
+
+	// 配置的默认值优先于模型映射，只作用于对应的模型
+	SetModelDefaults(map[string]ModelDefaults{"o3": {ReasoningEffort: "high"}, "o1": {ReasoningEffort: "low"}})
+	if got := convert("o3", "", ParamFilter{}).ReasoningEffort; got != "high" {
+		t.Errorf("Expected configured default, got %q", got)
+	}
+	if got := convert("o4-mini", "", ParamFilter{}).ReasoningEffort; got != "medium" {
+		t.Errorf("Expected other models to keep their mapped default, got %q", got)
+	}
+	if got := convert("o1", "medium", ParamFilter{}).ReasoningEffort; got != "medium" {
+		t.Errorf("Expected client value to win over the configured default, got %q", got)
+	}
+
+	// 不允许转发的默认值直接忽略，即使是reject模式也不拒绝请求
+	if got := convert("o3", "", ParamFilter{Deny: []string{"reasoning_effort"}, Mode: ParamFilterReject}).ReasoningEffort; got != "" {
+		t.Errorf("Expected denied default to be dropped, got %q", got)
+	}
+}
+
+func TestParamFilter(t *testing.T) {
+	chatReq := openai.ChatCompletionRequest{
+		Model:           "o3-mini",
+		ReasoningEffort: "low",
+		Messages:        []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}},
+	}
+
+	// 默认剔除被禁止的参数
+	req, err := ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, nil, ParamFilter{Deny: []string{"reasoning_effort"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.ReasoningEffort != "" {
+		t.Errorf("Expected denied reasoning_effort to be stripped, got %q", req.ReasoningEffort)
+	}
+
+	// 允许列表非空时只转发其中的参数
+	req, err = ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, nil, ParamFilter{Allow: []string{"temperature"}})
+	if err != nil || req.ReasoningEffort != "" {
+		t.Errorf("Expected reasoning_effort outside the allowlist to be stripped, got %q (%v)", req.ReasoningEffort, err)
+	}
+	req, err = ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, nil, ParamFilter{Allow: []string{"reasoning_effort"}})
+	if err != nil || req.ReasoningEffort != "low" {
+		t.Errorf("Expected allowed reasoning_effort to be forwarded, got %q (%v)", req.ReasoningEffort, err)
+	}
+
+	// reject模式下拒绝请求并指明参数
+	_, err = ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, nil, ParamFilter{Deny: []string{"reasoning_effort"}, Mode: ParamFilterReject})
+	if !errors.Is(err, ErrUnsupportedParameter) || !strings.Contains(err.Error(), "reasoning_effort") {
+		t.Errorf("Expected ErrUnsupportedParameter naming reasoning_effort, got %v", err)
+	}
+
+	// extra_body中的字段按同样的规则过滤
+	extra := map[string]json.RawMessage{"reasoning_effort": json.RawMessage(`"high"`), "seed": json.RawMessage(`1`)}
+	filtered, err := ParamFilter{Deny: []string{"reasoning_effort"}}.FilterExtraFields(extra)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := filtered["reasoning_effort"]; ok || len(filtered) != 1 || len(extra) != 2 {
+		t.Errorf("Expected only the denied extra field to be stripped, got %v", filtered)
+	}
+	if _, err := (ParamFilter{Deny: []string{"reasoning_effort"}, Mode: ParamFilterReject}).FilterExtraFields(extra); !errors.Is(err, ErrUnsupportedParameter) {
+		t.Errorf("Expected denied extra field to be rejected, got %v", err)
+	}
+}
+
+func TestPenaltyModes(t *testing.T) {
+	chatReq := openai.ChatCompletionRequest{
+		Model:            "gpt-4o",
+		Messages:         []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}},
+		FrequencyPenalty: 0.5,
+		PresencePenalty:  -0.2,
+	}
+
+	// reject模式下拒绝请求并指明参数
+	_, err := ChatGPTToJetbrainsAI(chatReq, PenaltyReject, nil, ParamFilter{})
+	if !errors.Is(err, ErrUnsupportedParameter) {
+		t.Fatalf("Expected ErrUnsupportedParameter, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "frequency_penalty and presence_penalty") {
+		t.Errorf("Expected error to name both parameters, got %v", err)
+	}
+
+	// 默认忽略这些参数
+	for _, mode := range []string{"", PenaltyIgnore} {
+		if req, err := ChatGPTToJetbrainsAI(chatReq, mode, nil, ParamFilter{}); err != nil || req == nil {
+			t.Errorf("Expected penalties to be ignored in mode %q, got %v", mode, err)
+		}
+	}
+
+	// 未设置（为0）时即使是reject模式也不拒绝
+	chatReq.FrequencyPenalty, chatReq.PresencePenalty = 0, 0
+	if _, err := ChatGPTToJetbrainsAI(chatReq, PenaltyReject, nil, ParamFilter{}); err != nil {
+		t.Errorf("Expected zero penalties to be accepted, got %v", err)
+	}
+}
+
+func TestApplySystemMessageMode(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: "user", Content: "hi"},
+		{Role: "system", Content: "Be brief."},
+		{Role: "assistant", Content: "hello"},
+		{Role: "system", Content: "Answer in French."},
+		{Role: "user", Content: "bye"},
+	}
+	system := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: "system", Content: content}
+	}
+	rest := func(kept openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+		return []openai.ChatCompletionMessage{messages[0], kept, messages[2], messages[4]}
+	}
+
+	cases := []struct {
+		mode     string
+		expected []openai.ChatCompletionMessage
+	}{
+		{"", rest(system("Be brief.\n\nAnswer in French."))},
+		{SystemMessagesConcat, rest(system("Be brief.\n\nAnswer in French."))},
+		{SystemMessagesFirst, rest(system("Be brief."))},
+		{SystemMessagesLast, rest(system("Answer in French."))},
+		{SystemMessagesPassthrough, messages},
+	}
+	for _, tc := range cases {
+		if got := ApplySystemMessageMode(messages, tc.mode); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Mode %q: expected %+v, got %+v", tc.mode, tc.expected, got)
+		}
+	}
+	if messages[1].Content != "Be brief." || len(messages) != 5 {
+		t.Errorf("Expected original messages to be unchanged, got %+v", messages)
+	}
+
+	// 只有一条系统消息时原样返回
+	single := messages[:3]
+	if got := ApplySystemMessageMode(single, SystemMessagesLast); !reflect.DeepEqual(got, single) {
+		t.Errorf("Expected a single system message to be kept as is, got %+v", got)
+	}
+}
+
+func TestSystemMessagesForModelsWithoutSystemRole(t *testing.T) {
+	chatReq := openai.ChatCompletionRequest{
+		Model: "o1",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "system", Content: "Answer in French."},
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "salut"},
+			{Role: "user", Content: "bye"},
+		},
+	}
+
+	// 默认单独发送系统消息
+	req, err := ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, []string{"gpt-4o"}, ParamFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages := req.Chat.MessageField; len(messages) != 5 || messages[0].Type != "system_message" || messages[2].Content != "hi" {
+		t.Errorf("Expected separate system messages, got %+v", messages)
+	}
+
+	// 配置的模型将系统内容合并到第一条用户消息
+	req, err = ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, []string{"o1"}, ParamFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []MessageField{
+		{Type: "user_message", Content: "Be brief.\n\nAnswer in French.\n\nhi"},
+		{Type: "assistant_message", Content: "salut"},
+		{Type: "user_message", Content: "bye"},
+	}
+	if !reflect.DeepEqual(req.Chat.MessageField, expected) {
+		t.Errorf("Expected merged messages %+v, got %+v", expected, req.Chat.MessageField)
+	}
+	// 不修改调用方的消息
+	if chatReq.Messages[2].Content != "hi" {
+		t.Errorf("Expected original messages to be unchanged, got %q", chatReq.Messages[2].Content)
+	}
+
+	// 没有用户消息时系统内容作为用户消息发送
+	chatReq.Messages = []openai.ChatCompletionMessage{{Role: "system", Content: "Say hello."}}
+	req, _ = ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, []string{"o1"}, ParamFilter{})
+	if messages := req.Chat.MessageField; len(messages) != 1 || messages[0].Type != "user_message" || messages[0].Content != "Say hello." {
+		t.Errorf("Expected system content as user message, got %+v", messages)
+	}
+}
+
+func TestJetbrainsRequestExtraFields(t *testing.T) {
+	newRequest := func(mode string) JetbrainsRequest {
+		return JetbrainsRequest{
+			Prompt:          PROMPT,
+			Profile:         "openai-o3-mini",
+			Chat:            ChatField{MessageField: []MessageField{{Type: "user_message", Content: "hi"}}},
+			ReasoningEffort: "low",
+			ExtraFields: map[string]json.RawMessage{
+				"prompt":           json.RawMessage(`"other"`),
+				"profile":          json.RawMessage(`"other"`),
+				"chat":             json.RawMessage(`{}`),
+				"reasoning_effort": json.RawMessage(`"high"`),
+				"parameters":       json.RawMessage(`{"temperature":0.2}`),
+			},
+			ExtraBodyMode: mode,
+		}
+	}
+	decode := func(req JetbrainsRequest) map[string]json.RawMessage {
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("Invalid JSON %s: %v", data, err)
+		}
+		return fields
+	}
+
+	for _, mode := range []string{ExtraBodyFirst, ExtraBodyLast} {
+		fields := decode(newRequest(mode))
+		// 必需字段不能被覆盖
+		if string(fields["prompt"]) != `"`+PROMPT+`"` || string(fields["profile"]) != `"openai-o3-mini"` ||
+			!strings.Contains(string(fields["chat"]), "user_message") {
+			t.Errorf("%s: expected required fields to be kept, got %v", mode, fields)
+		}
+		if string(fields["parameters"]) != `{"temperature":0.2}` {
+			t.Errorf("%s: expected extra field to be added, got %s", mode, fields["parameters"])
+		}
+	}
+
+	// first模式下代理转换的字段优先，last模式下额外字段可以覆盖可选字段
+	if got := string(decode(newRequest(ExtraBodyFirst))["reasoning_effort"]); got != `"low"` {
+		t.Errorf("Expected first mode to keep reasoning_effort, got %s", got)
+	}
+	if got := string(decode(newRequest(ExtraBodyLast))["reasoning_effort"]); got != `"high"` {
+		t.Errorf("Expected last mode to override reasoning_effort, got %s", got)
+	}
+
+	// 没有额外字段时与原有序列化结果一致
+	plain := newRequest("")
+	plain.ExtraFields = nil
+	data, _ := json.Marshal(plain)
+	if string(data) != `{"prompt":"`+PROMPT+`","profile":"openai-o3-mini","chat":{"messages":[{"type":"user_message","content":"hi"}]},"reasoning_effort":"low"}` {
+		t.Errorf("Unexpected JSON without extra fields: %s", data)
+	}
+}
+
+func TestValidateReasoningEffort(t *testing.T) {
+	for _, effort := range []string{"", "low", "medium", "high"} {
+		if err := ValidateReasoningEffort(effort); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", effort, err)
+		}
+	}
+	if err := ValidateReasoningEffort("extreme"); err == nil {
+		t.Error("Expected error for invalid reasoning effort")
+	}
+}
+
+func TestGetSupportedModelsByOwner(t *testing.T) {
+	all := GetSupportedModels()
+	counts := make(map[string]int)
+	for _, model := range all.Data {
+		if model.OwnedBy == "" {
+			t.Errorf("Expected owned_by to be set for %s", model.ID)
+		}
+		counts[model.OwnedBy]++
+	}
+
+	for _, owner := range []string{"openai", "google", "anthropic"} {
+		list := GetSupportedModelsByOwner(owner)
+		if len(list.Data) == 0 || len(list.Data) != counts[owner] {
+			t.Errorf("Expected %d models for %s, got %d", counts[owner], owner, len(list.Data))
+		}
+		for _, model := range list.Data {
+			if model.OwnedBy != owner {
+				t.Errorf("Expected only %s models, got %s owned by %s", owner, model.ID, model.OwnedBy)
+			}
+		}
+	}
+
+	// 过滤不区分大小写
+	if list := GetSupportedModelsByOwner("Anthropic"); len(list.Data) != counts["anthropic"] {
+		t.Errorf("Expected case-insensitive match, got %d models", len(list.Data))
+	}
+
+	// 未知提供方返回空列表而不是null
+	list := GetSupportedModelsByOwner("unknown")
+	if len(list.Data) != 0 {
+		t.Errorf("Expected no models for unknown owner, got %d", len(list.Data))
+	}
+	data, _ := json.Marshal(list)
+	if !strings.Contains(string(data), `"data":[]`) {
+		t.Errorf("Expected empty data array, got %s", data)
+	}
+}
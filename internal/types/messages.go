@@ -0,0 +1,112 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage 错误信息的默认语言，英文信息由调用方直接给出
+const DefaultLanguage = "en"
+
+// messageCatalog 内置的错误信息翻译，按语言和错误码索引；%s、%v等占位符与英文信息一致
+var messageCatalog = map[string]map[string]string{
+	"zh": {
+		"invalid_authorization_header": "无效的Authorization请求头",
+		"invalid_token":                "无效的token",
+		"model_not_available":          "没有上游token可以使用请求的模型",
+		"model_not_found":              "不支持模型 '%s'",
+		"model_rate_limit_exceeded":    "模型 '%s' 超出速率限制，请稍后重试",
+		"no_healthy_tokens":            "当前没有可用的上游token，请稍后重试",
+		"non_streaming_disabled":       "非流式补全已禁用，请将stream设置为true",
+		"request_timeout":              "请求在 %v 后超时",
+	},
+}
+
+// Localizer 按语言查找错误信息，配置的自定义信息优先于内置翻译
+type Localizer struct {
+	Lang   string
+	Custom map[string]map[string]string
+}
+
+// NewLocalizer 按Accept-Language的优先级选择第一个有翻译的语言（如zh-CN匹配zh），
+// 都不支持时使用defaultLang，defaultLang为空时使用英文
+func NewLocalizer(acceptLanguage, defaultLang string, custom map[string]map[string]string) Localizer {
+	l := Localizer{Lang: defaultLang, Custom: custom}
+	if l.Lang == "" {
+		l.Lang = DefaultLanguage
+	}
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, lang := range []string{tag, primary} {
+			if l.supports(lang) {
+				l.Lang = lang
+				return l
+			}
+		}
+	}
+	return l
+}
+
+// supports 是否有该语言的信息，英文总是支持
+func (l Localizer) supports(lang string) bool {
+	if lang == DefaultLanguage {
+		return true
+	}
+	_, builtin := messageCatalog[lang]
+	_, custom := l.Custom[lang]
+	return builtin || custom
+}
+
+// Message 返回code在当前语言下的信息，没有翻译时使用英文format；args填充信息中的占位符
+func (l Localizer) Message(code, format string, args ...interface{}) string {
+	if custom, ok := l.Custom[l.Lang][code]; ok {
+		format = custom
+	} else if builtin, ok := messageCatalog[l.Lang][code]; ok {
+		format = builtin
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// ErrorResponse 创建本地化的OpenAI兼容错误响应体
+func (l Localizer) ErrorResponse(errType, code, format string, args ...interface{}) OpenAIErrorResponse {
+	return NewOpenAIErrorResponse(errType, code, l.Message(code, format, args...))
+}
+
+// parseAcceptLanguage 按q值从高到低返回Accept-Language中的语言标签（小写），忽略q=0和通配符
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
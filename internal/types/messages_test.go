@@ -0,0 +1,53 @@
+package types
+
+import "testing"
+
+func TestNewLocalizerLanguage(t *testing.T) {
+	custom := map[string]map[string]string{"fr": {"invalid_token": "jeton invalide"}}
+	cases := []struct {
+		acceptLanguage string
+		defaultLang    string
+		expected       string
+	}{
+		{"", "", "en"},
+		{"", "zh", "zh"},
+		{"zh-CN,zh;q=0.9,en;q=0.8", "", "zh"},
+		{"de-DE, fr;q=0.5, zh;q=0.7", "", "zh"},
+		{"de, fr;q=0.5", "en", "fr"},
+		{"de, zh;q=0", "en", "en"},
+		{"en-US", "zh", "en"},
+	}
+	for _, tc := range cases {
+		if got := NewLocalizer(tc.acceptLanguage, tc.defaultLang, custom).Lang; got != tc.expected {
+			t.Errorf("Accept-Language %q with default %q: expected %s, got %s", tc.acceptLanguage, tc.defaultLang, tc.expected, got)
+		}
+	}
+}
+
+func TestLocalizerMessage(t *testing.T) {
+	zh := NewLocalizer("zh-CN", "", nil)
+	if got := zh.Message("model_not_found", "Model '%s' not supported", "gpt-5"); got != "不支持模型 'gpt-5'" {
+		t.Errorf("Expected translated message, got %q", got)
+	}
+	// 没有翻译的错误码使用英文信息
+	if got := zh.Message("unknown_code", "Something failed"); got != "Something failed" {
+		t.Errorf("Expected English fallback, got %q", got)
+	}
+
+	// 自定义信息优先于内置翻译，也可以覆盖英文信息
+	custom := map[string]map[string]string{
+		"zh": {"invalid_token": "令牌无效"},
+		"en": {"invalid_token": "Bad API key"},
+	}
+	if got := NewLocalizer("zh", "", custom).Message("invalid_token", "invalid token"); got != "令牌无效" {
+		t.Errorf("Expected custom zh message, got %q", got)
+	}
+	if got := NewLocalizer("", "", custom).Message("invalid_token", "invalid token"); got != "Bad API key" {
+		t.Errorf("Expected custom en message, got %q", got)
+	}
+
+	resp := zh.ErrorResponse(ErrorTypeRateLimit, "model_rate_limit_exceeded", "Rate limit exceeded for model '%s', please retry later", "o1")
+	if resp.Error.Message != "模型 'o1' 超出速率限制，请稍后重试" || *resp.Error.Code != "model_rate_limit_exceeded" {
+		t.Errorf("Unexpected localized error response: %+v", resp.Error)
+	}
+}
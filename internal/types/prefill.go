@@ -0,0 +1,34 @@
+package types
+
+import "github.com/sashabaranov/go-openai"
+
+// 末尾assistant消息（预填充）的处理方式
+const (
+	PrefillOff      = "off"      // 作为普通的assistant消息转发（默认）
+	PrefillContinue = "continue" // 要求模型从预填充内容处续写，响应只包含续写部分
+	PrefillEcho     = "echo"     // 同continue，但响应以预填充内容开头
+)
+
+// PrefillInstruction 追加在预填充消息之后的提示，让模型把预填充当作回复的开头而不是已完成的回复
+const PrefillInstruction = "Continue your previous message exactly from where it ends. " +
+	"Output only the continuation, without repeating any of it and without any preamble."
+
+// TrailingPrefill 返回末尾assistant消息的内容，最后一条消息不是非空的assistant消息时返回空字符串
+func TrailingPrefill(messages []openai.ChatCompletionMessage) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	last := messages[len(messages)-1]
+	if last.Role != openai.ChatMessageRoleAssistant {
+		return ""
+	}
+	return last.Content
+}
+
+// ApplyPrefill 在转换后的预填充assistant消息之后追加续写提示
+func ApplyPrefill(req *JetbrainsRequest) {
+	req.Chat.MessageField = append(req.Chat.MessageField, MessageField{
+		Type:    "user_message",
+		Content: PrefillInstruction,
+	})
+}
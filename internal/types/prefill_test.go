@@ -0,0 +1,52 @@
+package types
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"testing"
+)
+
+func TestTrailingPrefill(t *testing.T) {
+	cases := []struct {
+		name     string
+		messages []openai.ChatCompletionMessage
+		expected string
+	}{
+		{"empty", nil, ""},
+		{"ends with user", []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}}, ""},
+		{"empty assistant", []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}, {Role: "assistant"}}, ""},
+		{"prefill", []openai.ChatCompletionMessage{{Role: "user", Content: "List colors"}, {Role: "assistant", Content: "1. Red"}}, "1. Red"},
+		{"assistant before user", []openai.ChatCompletionMessage{{Role: "assistant", Content: "hello"}, {Role: "user", Content: "hi"}}, ""},
+	}
+	for _, tc := range cases {
+		if got := TrailingPrefill(tc.messages); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestApplyPrefill(t *testing.T) {
+	chatReq := openai.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "user", Content: "List three colors"},
+			{Role: "assistant", Content: "1. Red\n2."},
+		},
+	}
+	req, err := ChatGPTToJetbrainsAI(chatReq, PenaltyIgnore, nil, ParamFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ApplyPrefill(req)
+
+	// 预填充作为assistant消息保留，其后是续写提示
+	messages := req.Chat.MessageField
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %+v", messages)
+	}
+	if messages[1].Type != "assistant_message" || messages[1].Content != "1. Red\n2." {
+		t.Errorf("Expected prefill to be kept as assistant message, got %+v", messages[1])
+	}
+	if messages[2].Type != "user_message" || messages[2].Content != PrefillInstruction {
+		t.Errorf("Expected continuation instruction last, got %+v", messages[2])
+	}
+}
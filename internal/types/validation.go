@@ -0,0 +1,81 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// validMessageRoles OpenAI接受的消息角色；上游只使用system、user和assistant，其他角色的消息会被忽略
+var validMessageRoles = map[string]bool{
+	openai.ChatMessageRoleSystem:    true,
+	openai.ChatMessageRoleDeveloper: true,
+	openai.ChatMessageRoleUser:      true,
+	openai.ChatMessageRoleAssistant: true,
+	openai.ChatMessageRoleTool:      true,
+	openai.ChatMessageRoleFunction:  true,
+}
+
+// ValidationError 请求体字段校验错误，Param为出错字段的路径（如 messages[1].role）
+type ValidationError struct {
+	Param   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Param, e.Message)
+}
+
+// ValidateChatRequest 绑定后校验请求体，返回指明出错字段的错误，便于客户端定位问题；
+// present为请求体中显式设置的字段，用于区分省略和零值
+func ValidateChatRequest(req openai.ChatCompletionRequest, present map[string]bool) error {
+	if req.Model == "" {
+		return &ValidationError{Param: "model", Message: "is required"}
+	}
+	if err := validateTokenLimit("max_tokens", req.MaxTokens, present["max_tokens"]); err != nil {
+		return err
+	}
+	if err := validateTokenLimit("max_completion_tokens", req.MaxCompletionTokens, present["max_completion_tokens"]); err != nil {
+		return err
+	}
+	if len(req.Messages) == 0 {
+		return &ValidationError{Param: "messages", Message: "must contain at least one message"}
+	}
+
+	for i, msg := range req.Messages {
+		param := fmt.Sprintf("messages[%d]", i)
+		if msg.Role == "" {
+			return &ValidationError{Param: param + ".role", Message: "is required"}
+		}
+		if !validMessageRoles[msg.Role] {
+			return &ValidationError{Param: param + ".role", Message: fmt.Sprintf(
+				"invalid role '%s', must be one of system, developer, user, assistant, tool, function", msg.Role)}
+		}
+
+		hasContent := msg.Content != "" || len(msg.MultiContent) > 0
+		switch msg.Role {
+		case openai.ChatMessageRoleAssistant:
+			// 助手消息可以只包含工具调用
+			if !hasContent && len(msg.ToolCalls) == 0 && msg.FunctionCall == nil {
+				return &ValidationError{Param: param + ".content", Message: "is required unless tool_calls or function_call is set"}
+			}
+		case openai.ChatMessageRoleTool:
+			if msg.ToolCallID == "" {
+				return &ValidationError{Param: param + ".tool_call_id", Message: "is required for tool messages"}
+			}
+		default:
+			if !hasContent {
+				return &ValidationError{Param: param + ".content", Message: fmt.Sprintf("is required for %s messages", msg.Role)}
+			}
+		}
+	}
+	return nil
+}
+
+// validateTokenLimit 输出token上限必须为正整数，省略时不限制
+func validateTokenLimit(param string, value int, present bool) error {
+	if value < 0 || (value == 0 && present) {
+		return &ValidationError{Param: param, Message: fmt.Sprintf("must be a positive integer, got %d", value)}
+	}
+	return nil
+}
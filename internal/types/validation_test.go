@@ -0,0 +1,129 @@
+package types
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestValidateChatRequest(t *testing.T) {
+	user := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hi"}
+
+	cases := []struct {
+		name    string
+		req     openai.ChatCompletionRequest
+		param   string
+		message string
+	}{
+		{
+			name:    "missing model",
+			req:     openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{user}},
+			param:   "model",
+			message: "model: is required",
+		},
+		{
+			name:    "empty messages",
+			req:     openai.ChatCompletionRequest{Model: "gpt-4o"},
+			param:   "messages",
+			message: "messages: must contain at least one message",
+		},
+		{
+			name: "missing role",
+			req: openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+				user, {Content: "no role"},
+			}},
+			param:   "messages[1].role",
+			message: "messages[1].role: is required",
+		},
+		{
+			name: "invalid role",
+			req: openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+				{Role: "bot", Content: "hi"},
+			}},
+			param:   "messages[0].role",
+			message: "messages[0].role: invalid role 'bot', must be one of system, developer, user, assistant, tool, function",
+		},
+		{
+			name: "user without content",
+			req: openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser},
+			}},
+			param:   "messages[0].content",
+			message: "messages[0].content: is required for user messages",
+		},
+		{
+			name: "assistant without content or tool calls",
+			req: openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+				user, {Role: openai.ChatMessageRoleAssistant},
+			}},
+			param:   "messages[1].content",
+			message: "messages[1].content: is required unless tool_calls or function_call is set",
+		},
+		{
+			name: "tool without tool_call_id",
+			req: openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+				user, {Role: openai.ChatMessageRoleTool, Content: "42"},
+			}},
+			param:   "messages[1].tool_call_id",
+			message: "messages[1].tool_call_id: is required for tool messages",
+		},
+	}
+
+	for _, tc := range cases {
+		err := ValidateChatRequest(tc.req, nil)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected ValidationError, got %v", tc.name, err)
+			continue
+		}
+		if validationErr.Param != tc.param || err.Error() != tc.message {
+			t.Errorf("%s: expected %q (%s), got %q (%s)", tc.name, tc.message, tc.param, err.Error(), validationErr.Param)
+		}
+	}
+}
+
+func TestValidateChatRequestAcceptsValidMessages(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "be brief"},
+		{Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: "hi"}}},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{{ID: "call_1"}}},
+		{Role: openai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "42"},
+	}}
+	if err := ValidateChatRequest(req, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateTokenLimits(t *testing.T) {
+	base := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "hi"},
+	}}
+
+	cases := []struct {
+		name    string
+		mutate  func(req *openai.ChatCompletionRequest)
+		present map[string]bool
+		message string
+	}{
+		{"omitted", func(req *openai.ChatCompletionRequest) {}, nil, ""},
+		{"positive", func(req *openai.ChatCompletionRequest) { req.MaxTokens = 256 }, map[string]bool{"max_tokens": true}, ""},
+		{"zero", func(req *openai.ChatCompletionRequest) {}, map[string]bool{"max_tokens": true}, "max_tokens: must be a positive integer, got 0"},
+		{"negative", func(req *openai.ChatCompletionRequest) { req.MaxTokens = -1 }, map[string]bool{"max_tokens": true}, "max_tokens: must be a positive integer, got -1"},
+		{"negative max_completion_tokens", func(req *openai.ChatCompletionRequest) { req.MaxCompletionTokens = -5 }, map[string]bool{"max_completion_tokens": true}, "max_completion_tokens: must be a positive integer, got -5"},
+	}
+	for _, tc := range cases {
+		req := base
+		tc.mutate(&req)
+		err := ValidateChatRequest(req, tc.present)
+		if tc.message == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.message {
+			t.Errorf("%s: expected %q, got %v", tc.name, tc.message, err)
+		}
+	}
+}
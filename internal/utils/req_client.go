@@ -4,13 +4,18 @@ import (
 	"crypto/tls"
 	"fmt"
 	"github.com/go-resty/resty/v2"
+	"net"
+	"net/http"
 	"time"
 )
 
+// defaultConnectTimeout 连接上游的默认超时时间
+const defaultConnectTimeout = 30 * time.Second
+
 var (
+	// RestySSEClient 不设置整体超时，长时间的流由读取空闲超时控制
 	RestySSEClient = resty.New().
-		SetTimeout(1 * time.Minute).
-		SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true}).
+		SetTransport(newSSETransport(defaultConnectTimeout, tls.VersionTLS12)).
 		SetDoNotParseResponse(true).
 		SetHeaders(map[string]string{
 			"Content-Type": "application/json",
@@ -23,3 +28,40 @@ var (
 			return nil
 		})
 )
+
+// ConfigureUpstreamTransport 设置连接上游（拨号、TLS握手、等待响应头）的超时时间和最低TLS版本
+func ConfigureUpstreamTransport(timeout time.Duration, minTLSVersion uint16) {
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	if minTLSVersion == 0 {
+		minTLSVersion = tls.VersionTLS12
+	}
+	RestySSEClient.SetTransport(newSSETransport(timeout, minTLSVersion))
+}
+
+// SetUpstreamUserAgent 设置请求上游时的User-Agent，为空时使用resty默认值；需在发送请求前调用
+func SetUpstreamUserAgent(userAgent string) {
+	if userAgent == "" {
+		RestySSEClient.Header.Del("User-Agent")
+		return
+	}
+	RestySSEClient.SetHeader("User-Agent", userAgent)
+}
+
+// newSSETransport 创建只限制连接阶段耗时的Transport
+func newSSETransport(connectTimeout time.Duration, minTLSVersion uint16) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true, MinVersion: minTLSVersion},
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: connectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigureUpstreamTransport(t *testing.T) {
+	t.Cleanup(func() {
+		ConfigureUpstreamTransport(0, 0)
+	})
+
+	minVersion := func() uint16 {
+		transport, ok := RestySSEClient.GetClient().Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Expected *http.Transport, got %T", RestySSEClient.GetClient().Transport)
+		}
+		return transport.TLSClientConfig.MinVersion
+	}
+
+	if got := minVersion(); got != tls.VersionTLS12 {
+		t.Errorf("Expected default minimum TLS 1.2, got %x", got)
+	}
+
+	ConfigureUpstreamTransport(10*time.Second, tls.VersionTLS13)
+	if got := minVersion(); got != tls.VersionTLS13 {
+		t.Errorf("Expected configured minimum TLS 1.3, got %x", got)
+	}
+
+	// 未设置时回退到TLS 1.2
+	ConfigureUpstreamTransport(10*time.Second, 0)
+	if got := minVersion(); got != tls.VersionTLS12 {
+		t.Errorf("Expected fallback to TLS 1.2, got %x", got)
+	}
+}
+
+func TestSetUpstreamUserAgent(t *testing.T) {
+	t.Cleanup(func() {
+		SetUpstreamUserAgent("")
+	})
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	SetUpstreamUserAgent("JetBrains-IDE/2025.1")
+	resp, err := RestySSEClient.R().Post(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.RawBody().Close()
+	if userAgent != "JetBrains-IDE/2025.1" {
+		t.Errorf("Expected configured User-Agent, got %q", userAgent)
+	}
+
+	// 清空后恢复resty默认值
+	SetUpstreamUserAgent("")
+	resp, err = RestySSEClient.R().Post(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.RawBody().Close()
+	if userAgent == "JetBrains-IDE/2025.1" || userAgent == "" {
+		t.Errorf("Expected resty default User-Agent, got %q", userAgent)
+	}
+}
@@ -2,17 +2,24 @@ package utils
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
-var randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+var (
+	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+	// randMutex rand.Rand不是并发安全的，并发请求共用randSource时需要加锁
+	randMutex sync.Mutex
+)
 
 func RandStringUsingMathRand(n int) string {
 	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 	result := make([]rune, n)
+	randMutex.Lock()
 	for i := 0; i < n; i++ {
 		result[i] = letters[randSource.Intn(len(letters))]
 	}
+	randMutex.Unlock()
 	return string(result)
 }
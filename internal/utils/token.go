@@ -1,18 +1,72 @@
 package utils
 
 import (
-	"fmt"
 	"github.com/pkoukk/tiktoken-go"
 	"github.com/sashabaranov/go-openai"
+	"log"
+	"sync"
+	"unicode/utf8"
 )
 
-func CalculateTokens(text string) int {
-	encoding := "cl100k_base"
-	tke, err := tiktoken.GetEncoding(encoding)
-	if err != nil {
-		err = fmt.Errorf("getEncoding: %v", err)
+// DefaultTokenEncoding 默认的tiktoken编码
+const DefaultTokenEncoding = "cl100k_base"
+
+// getEncoding 加载tiktoken编码（测试中可替换）
+var getEncoding = tiktoken.GetEncoding
+
+// tokenEncoder 缓存已加载的编码器；加载失败时记录错误，改用估算并只警告一次
+var tokenEncoder = struct {
+	sync.Mutex
+	name   string
+	tke    *tiktoken.Tiktoken
+	loaded bool
+}{name: DefaultTokenEncoding}
+
+// SetTokenEncoding 设置计算token使用的编码，为空时使用默认编码
+func SetTokenEncoding(name string) {
+	if name == "" {
+		name = DefaultTokenEncoding
+	}
+
+	tokenEncoder.Lock()
+	defer tokenEncoder.Unlock()
+
+	if tokenEncoder.name != name {
+		tokenEncoder.name = name
+		tokenEncoder.tke = nil
+		tokenEncoder.loaded = false
+	}
+}
+
+// loadEncoder 获取当前编码器，不可用时返回nil
+func loadEncoder() *tiktoken.Tiktoken {
+	tokenEncoder.Lock()
+	defer tokenEncoder.Unlock()
+
+	if !tokenEncoder.loaded {
+		tokenEncoder.loaded = true
+		tke, err := getEncoding(tokenEncoder.name)
+		if err != nil {
+			log.Printf("Warning: token encoding %s unavailable, estimating token counts from text length: %v", tokenEncoder.name, err)
+		}
+		tokenEncoder.tke = tke
+	}
+	return tokenEncoder.tke
+}
+
+// EstimateTokens 按每4个字符约1个token估算token数
+func EstimateTokens(text string) int {
+	if text == "" {
 		return 0
 	}
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func CalculateTokens(text string) int {
+	tke := loadEncoder()
+	if tke == nil {
+		return EstimateTokens(text)
+	}
 	token := tke.Encode(text, nil, nil)
 	return len(token)
 }
@@ -25,3 +79,22 @@ func CalculateJetbrainsUsage(completionText string, spent int) openai.Usage {
 		TotalTokens:      spent,
 	}
 }
+
+// EstimateUsage 上游未返回用量时，根据请求消息和补全内容的token数估算用量
+func EstimateUsage(messages []openai.ChatCompletionMessage, completionText string) openai.Usage {
+	promptTokens := 0
+	for _, msg := range messages {
+		promptTokens += CalculateTokens(msg.Content)
+	}
+	return EstimateCompletionUsage(promptTokens, completionText)
+}
+
+// EstimateCompletionUsage 使用已统计的prompt token数和补全内容的token数估算用量
+func EstimateCompletionUsage(promptTokens int, completionText string) openai.Usage {
+	completionTokens := CalculateTokens(completionText)
+	return openai.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
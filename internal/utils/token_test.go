@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"errors"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"testing"
+)
+
+// withFailingEncoding 强制编码器加载失败，测试结束后恢复
+func withFailingEncoding(t *testing.T) *int {
+	t.Helper()
+
+	loads := 0
+	prev := getEncoding
+	getEncoding = func(name string) (*tiktoken.Tiktoken, error) {
+		loads++
+		return nil, errors.New("encoding unavailable")
+	}
+	SetTokenEncoding("test_encoding")
+	t.Cleanup(func() {
+		getEncoding = prev
+		SetTokenEncoding(DefaultTokenEncoding)
+	})
+	return &loads
+}
+
+func TestCalculateTokensFallback(t *testing.T) {
+	loads := withFailingEncoding(t)
+
+	cases := map[string]int{
+		"":             0,
+		"hi":           1,
+		"hello world!": 3,
+		"你好，世界":        2,
+	}
+	for text, expected := range cases {
+		if got := CalculateTokens(text); got != expected {
+			t.Errorf("Expected %d tokens for %q, got %d", expected, text, got)
+		}
+	}
+
+	// 加载失败只尝试一次，之后直接使用估算
+	if *loads != 1 {
+		t.Errorf("Expected encoding to be loaded once, got %d", *loads)
+	}
+}
+
+func TestSetTokenEncodingReloads(t *testing.T) {
+	loads := withFailingEncoding(t)
+
+	CalculateTokens("x")
+	SetTokenEncoding("test_encoding")
+	CalculateTokens("x")
+	if *loads != 1 {
+		t.Errorf("Expected unchanged encoding not to reload, got %d loads", *loads)
+	}
+
+	SetTokenEncoding("other_encoding")
+	CalculateTokens("x")
+	if *loads != 2 {
+		t.Errorf("Expected new encoding to be loaded, got %d loads", *loads)
+	}
+}
+
+func TestCalculateJetbrainsUsageFallback(t *testing.T) {
+	withFailingEncoding(t)
+
+	usage := CalculateJetbrainsUsage("12345678", 10)
+	if usage.CompletionTokens == 10 || usage.TotalTokens != 10 {
+		t.Errorf("Expected completion tokens to be estimated, got %+v", usage)
+	}
+}
+
+func TestEstimateUsage(t *testing.T) {
+	withFailingEncoding(t)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: "system", Content: "12345678"},
+		{Role: "user", Content: "1234"},
+	}
+	usage := EstimateUsage(messages, "123456789012")
+	if usage.PromptTokens != 3 || usage.CompletionTokens != 3 || usage.TotalTokens != 6 {
+		t.Errorf("Expected 3 + 3 = 6 tokens, got %+v", usage)
+	}
+}
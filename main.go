@@ -4,8 +4,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/labstack/echo"
-	"github.com/labstack/echo/middleware"
 	"jetbrains-ai-proxy/internal/apiserver"
 	"jetbrains-ai-proxy/internal/config"
 	"jetbrains-ai-proxy/internal/jetbrains"
@@ -23,9 +21,10 @@ func main() {
 	host := flag.String("h", "", "服务器监听地址 (覆盖配置文件)")
 	jwtTokens := flag.String("c", "", "JWT Tokens值，多个token用逗号分隔 (覆盖配置文件)")
 	bearerToken := flag.String("k", "", "Bearer Token值 (覆盖配置文件)")
-	loadBalanceStrategy := flag.String("s", "", "负载均衡策略: round_robin 或 random (覆盖配置文件)")
+	loadBalanceStrategy := flag.String("s", "", "负载均衡策略: round_robin、random、consistent_hash 或 least_latency (覆盖配置文件)")
 	generateConfig := flag.Bool("generate-config", false, "生成示例配置文件")
 	printConfig := flag.Bool("print-config", false, "打印当前配置信息")
+	strictConfig := flag.Bool("strict-config", false, "配置文件存在但无效时终止启动，而不是忽略配置文件继续运行")
 
 	flag.Usage = func() {
 		fmt.Printf("用法: %s [选项]\n\n", flag.CommandLine.Name())
@@ -53,6 +52,8 @@ func main() {
 		fmt.Println("负载均衡策略:")
 		fmt.Println("  round_robin: 轮询策略（默认）")
 		fmt.Println("  random: 随机策略")
+		fmt.Println("  consistent_hash: 一致性哈希，按请求的 user 字段固定分配token")
+		fmt.Println("  least_latency: 延迟优先，选择最近首字节延迟最低的token")
 	}
 
 	flag.Parse()
@@ -72,15 +73,23 @@ func main() {
 	if *configFile != "" {
 		os.Setenv("CONFIG_FILE", *configFile)
 	}
+	if *strictConfig {
+		os.Setenv("STRICT_CONFIG", "true")
+	}
 
 	// 加载配置
 	if err := configManager.LoadConfig(); err != nil {
+		if errors.Is(err, config.ErrInvalidConfigFile) {
+			log.Fatalf("Failed to load config: %v", err)
+		}
 		log.Printf("Warning: %v", err)
 		log.Println("Continuing with command line arguments and environment variables...")
 	}
 
 	// 应用命令行参数覆盖
-	applyCommandLineOverrides(configManager, port, host, jwtTokens, bearerToken, loadBalanceStrategy)
+	configManager.ApplyOverrides(config.SourceFlag, func() {
+		applyCommandLineOverrides(configManager, port, host, jwtTokens, bearerToken, loadBalanceStrategy)
+	})
 
 	// 打印配置信息
 	if *printConfig {
@@ -109,22 +118,26 @@ func main() {
 	// 启动配置文件监控
 	discovery := config.NewConfigDiscovery(configManager)
 	discovery.WatchConfig()
+	// 轮询远程配置，变化时按手动重载的流程生效
+	configManager.WatchRemoteConfig(jetbrains.ReloadConfig)
 
-	// 创建Echo实例
-	e := echo.New()
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
+	// 创建API服务和（可选的）独立管理服务
+	e, admin := apiserver.NewServers(configManager)
 
-	// 添加管理端点
-	setupManagementEndpoints(e, configManager)
-
-	// 注册API路由
-	apiserver.RegisterRoutes(e)
+	if admin != nil {
+		adminAddr := fmt.Sprintf("%s:%d", cfg.AdminHost, cfg.AdminPort)
+		log.Printf("Admin server starting on %s", adminAddr)
+		go func() {
+			if err := admin.Start(adminAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("start admin server error: %v", err)
+			}
+		}()
+	}
 
 	// 启动服务器
 	addr := fmt.Sprintf("%s:%d", cfg.ServerHost, cfg.ServerPort)
 	log.Printf("Server starting on %s", addr)
-	configManager.PrintConfig()
+	configManager.PrintSummary()
 
 	if err := e.Start(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("start server error: %v", err)
@@ -151,7 +164,7 @@ JWT_TOKENS=eyJ0eXAiOiJKV1QiLCJhbGciOiJIUzI1NiJ9...,eyJ0eXAiOiJKV1QiLCJhbGciOiJIU
 # Bearer token for API authentication
 BEARER_TOKEN=your_bearer_token_here
 
-# Load balancing strategy: round_robin or random
+# Load balancing strategy: round_robin, random, consistent_hash or least_latency
 LOAD_BALANCE_STRATEGY=round_robin
 
 # Server configuration
@@ -193,77 +206,21 @@ func applyCommandLineOverrides(manager *config.Manager, port *int, host, jwtToke
 	}
 
 	// 覆盖服务器配置
-	cfg := manager.GetConfig()
 	if *port > 0 {
-		cfg.ServerPort = *port
+		manager.UpdateConfig(func(cfg *config.Config) {
+			cfg.ServerPort = *port
+		})
 		log.Printf("Server port overridden by command line: %d", *port)
 	}
 
 	if *host != "" {
-		cfg.ServerHost = *host
+		manager.UpdateConfig(func(cfg *config.Config) {
+			cfg.ServerHost = *host
+		})
 		log.Printf("Server host overridden by command line: %s", *host)
 	}
 }
 
-// setupManagementEndpoints 设置管理端点
-func setupManagementEndpoints(e *echo.Echo, manager *config.Manager) {
-	// 健康检查端点
-	e.GET("/health", func(c echo.Context) error {
-		healthy, total := jetbrains.GetBalancerStats()
-		cfg := manager.GetConfig()
-
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"status":         "ok",
-			"healthy_tokens": healthy,
-			"total_tokens":   total,
-			"strategy":       cfg.LoadBalanceStrategy,
-			"server_info": map[string]interface{}{
-				"host": cfg.ServerHost,
-				"port": cfg.ServerPort,
-			},
-		})
-	})
-
-	// 配置信息端点
-	e.GET("/config", func(c echo.Context) error {
-		discovery := config.NewConfigDiscovery(manager)
-		summary := discovery.GetConfigSummary()
-		return c.JSON(http.StatusOK, summary)
-	})
-
-	// 重载配置端点
-	e.POST("/reload", func(c echo.Context) error {
-		if err := jetbrains.ReloadConfig(); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-				"error": err.Error(),
-			})
-		}
-
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"message": "Configuration reloaded successfully",
-		})
-	})
-
-	// 负载均衡器统计端点
-	e.GET("/stats", func(c echo.Context) error {
-		healthy, total := jetbrains.GetBalancerStats()
-		cfg := manager.GetConfig()
-
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"balancer": map[string]interface{}{
-				"healthy_tokens": healthy,
-				"total_tokens":   total,
-				"strategy":       cfg.LoadBalanceStrategy,
-			},
-			"config": map[string]interface{}{
-				"health_check_interval": cfg.HealthCheckInterval.String(),
-				"server_host":           cfg.ServerHost,
-				"server_port":           cfg.ServerPort,
-			},
-		})
-	})
-}
-
 // setupGracefulShutdown 设置优雅关闭
 func setupGracefulShutdown() {
 	c := make(chan os.Signal, 1)